@@ -18,7 +18,6 @@ import (
 	"github.com/bbengfort/cosmos/pkg/cosmos"
 	"github.com/bbengfort/cosmos/pkg/db"
 	"github.com/bbengfort/cosmos/pkg/db/models"
-	"github.com/jmoiron/sqlx"
 	"github.com/joho/godotenv"
 	"github.com/oklog/ulid/v2"
 	confire "github.com/rotationalio/confire/usage"
@@ -99,6 +98,20 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:     "auth:unlock",
+			Usage:    "clear failed logins and unlock a user account",
+			Category: "utility",
+			Action:   authUnlock,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "email",
+					Aliases:  []string{"e"},
+					Usage:    "The email of the user to unlock",
+					Required: true,
+				},
+			},
+		},
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -176,6 +189,10 @@ func authCreateSuperUser(c *cli.Context) (err error) {
 		return cli.Exit(err, 1)
 	}
 
+	if err = auth.SetDerivedKeyParams(auth.NewDerivedKeyParams(conf.Auth)); err != nil {
+		return cli.Exit(err, 1)
+	}
+
 	user := &models.User{
 		Name:  sql.NullString{Valid: true, String: c.String("name")},
 		Email: c.String("email"),
@@ -191,19 +208,43 @@ func authCreateSuperUser(c *cli.Context) (err error) {
 		return cli.Exit(err, 1)
 	}
 
-	// Update the user role in the database
-	var tx *sqlx.Tx
-	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+	// Promote the user to the admin role
+	if err = user.SetRole(ctx, "Admin"); err != nil {
 		return cli.Exit(err, 1)
 	}
-	defer tx.Rollback()
+	return nil
+}
 
-	if _, err = tx.Exec("UPDATE users SET role_id=1 WHERE id=$1", user.ID); err != nil {
+func authUnlock(c *cli.Context) (err error) {
+	var conf config.Config
+	if conf, err = config.New(); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	if err = db.Connect(conf.Database); err != nil {
 		return cli.Exit(err, 1)
 	}
 
-	if err = tx.Commit(); err != nil {
+	ctx := context.Background()
+	var user *models.User
+	if user, err = models.GetUser(ctx, c.String("email")); err != nil {
 		return cli.Exit(err, 1)
 	}
+
+	if err = models.ResetLoginAttempts(ctx, models.UserLoginKey(user.ID)); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	event := &models.AuditEvent{
+		UserID: sql.NullInt64{Valid: true, Int64: user.ID},
+		Action: models.AuditAccountUnlocked,
+		Detail: sql.NullString{Valid: true, String: "unlocked from the command line"},
+	}
+
+	if err = models.CreateAuditEvent(ctx, event); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Printf("unlocked account for %s\n", user.Email)
 	return nil
 }
@@ -0,0 +1,25 @@
+/*
+Package dbtest connects the db package to a sqlmock database so that the modules that
+use the database can be unit tested without a Postgres server.
+*/
+package dbtest
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+// Connect connects the db package to a sqlmock database until the test is complete and
+// returns the mock that expectations of the database queries are set on.
+func Connect(t testing.TB) sqlmock.Sqlmock {
+	mdb, mock, err := sqlmock.New()
+	require.NoError(t, err, "could not create mock database")
+
+	require.NoError(t, db.ConnectDB(sqlx.NewDb(mdb, "postgres")), "could not connect to mock database")
+	t.Cleanup(func() { db.Close() })
+	return mock
+}
@@ -1,20 +1,30 @@
 package api
 
+import "encoding/json"
+
 //===========================================================================
 // Top Level Requests and Responses
 //===========================================================================
 
 // Reply contains standard fields that are embedded in most API responses
 type Reply struct {
-	Success bool   `json:"success"`
-	Error   string `json:"error,omitempty" yaml:"error,omitempty"`
+	Success   bool             `json:"success"`
+	Error     string           `json:"error,omitempty" yaml:"error,omitempty"`
+	Code      string           `json:"code,omitempty" yaml:"code,omitempty"`
+	RequestID string           `json:"request_id,omitempty" yaml:"request_id,omitempty"`
+	Fields    ValidationErrors `json:"fields,omitempty" yaml:"fields,omitempty"`
 }
 
-// StatusReply is returned on status requests and heartbeats
+// StatusReply is returned on status requests and heartbeats. The version is the version
+// of the server and the api version is the version of the API that handled the request;
+// the RFC3339 deprecation and sunset dates are only set if the api version is deprecated.
 type StatusReply struct {
-	Status  string `json:"status"`
-	Uptime  string `json:"uptime,omitempty"`
-	Version string `json:"version,omitempty"`
+	Status     string `json:"status"`
+	Uptime     string `json:"uptime,omitempty"`
+	Version    string `json:"version,omitempty"`
+	APIVersion string `json:"api_version,omitempty"`
+	Deprecated string `json:"deprecated,omitempty"`
+	Sunset     string `json:"sunset,omitempty"`
 }
 
 //===========================================================================
@@ -22,9 +32,9 @@ type StatusReply struct {
 //===========================================================================
 
 type RegisterRequest struct {
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Name     string `json:"name" validate:"required,max=255"`
+	Email    string `json:"email" validate:"required,max=255,mailaddr"`
+	Password string `json:"password" validate:"required,min=8"`
 }
 
 type RegisterReply struct {
@@ -35,15 +45,443 @@ type RegisterReply struct {
 }
 
 type LoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string `json:"username" validate:"required"`
+	Password string `json:"password" validate:"required"`
 }
 
+// LoginReply contains the access and refresh tokens for an authenticated user. If the
+// user has enrolled a second factor, no tokens are returned; instead MFARequired is set
+// and the MFA token must be exchanged along with a code via the MFA login endpoint. If
+// the role of the user requires a second factor that the user has not enrolled, then
+// MFAEnrollmentRequired is set and the tokens can only be used to enroll one.
 type LoginReply struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+	AccessToken           string `json:"access_token"`
+	RefreshToken          string `json:"refresh_token"`
+	MFARequired           bool   `json:"mfa_required,omitempty"`
+	MFAToken              string `json:"mfa_token,omitempty"`
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required,omitempty"`
 }
 
 type ReauthenticateRequest struct {
 	RefreshToken string `json:"refresh_token"`
 }
+
+// MFALoginRequest completes a two-step login; the code may either be a TOTP code from
+// the user's authenticator or one of their single-use recovery codes.
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+// APIKeyLoginRequest exchanges API key credentials for an access token.
+type APIKeyLoginRequest struct {
+	ClientID     string `json:"client_id" validate:"required"`
+	ClientSecret string `json:"client_secret" validate:"required"`
+}
+
+// TokenRequest identifies a token to introspect (RFC 7662) or revoke (RFC 7009). The
+// request may be form encoded as described by the RFCs or JSON encoded.
+type TokenRequest struct {
+	Token         string `json:"token" form:"token" validate:"required"`
+	TokenTypeHint string `json:"token_type_hint,omitempty" form:"token_type_hint" validate:"omitempty,oneof=access_token refresh_token"`
+}
+
+// IntrospectReply describes the state of a token as described by RFC 7662. If the token
+// is not active then no other fields are returned.
+type IntrospectReply struct {
+	Active      bool     `json:"active"`
+	Scope       string   `json:"scope,omitempty"`
+	ClientID    string   `json:"client_id,omitempty"`
+	Subject     string   `json:"sub,omitempty"`
+	Role        string   `json:"role,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	ExpiresAt   int64    `json:"exp,omitempty"`
+	IssuedAt    int64    `json:"iat,omitempty"`
+	NotBefore   int64    `json:"nbf,omitempty"`
+	Audience    []string `json:"aud,omitempty"`
+	Issuer      string   `json:"iss,omitempty"`
+	TokenID     string   `json:"jti,omitempty"`
+	Actor       *Actor   `json:"act,omitempty"`
+}
+
+// Actor identifies the administrator that is impersonating the subject of a token (the
+// act claim of RFC 8693).
+type Actor struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+}
+
+// IdentityProviderList contains the names of the external identity providers that users
+// can sign in with via the OIDC login endpoint.
+type IdentityProviderList struct {
+	Providers []string `json:"providers"`
+}
+
+// LinkIdentityRequest requires the user's password to link an external identity
+// provider to their account unless they signed up with an external identity provider.
+type LinkIdentityRequest struct {
+	Password string `json:"password,omitempty"`
+}
+
+// LinkIdentityReply contains the authorization URL of the identity provider; the user
+// agent must be navigated to it to complete linking the provider in the callback.
+type LinkIdentityReply struct {
+	URL string `json:"url"`
+}
+
+//===========================================================================
+// Profile Requests and Responses
+//===========================================================================
+
+// Profile describes the account of the authenticated user. The pending email is set
+// when the user has requested an email change that has not yet been verified.
+type Profile struct {
+	ID           int64    `json:"user_id"`
+	Name         string   `json:"name"`
+	Email        string   `json:"email"`
+	PendingEmail string   `json:"pending_email,omitempty"`
+	Role         string   `json:"role"`
+	Permissions  []string `json:"permissions"`
+	HasPassword  bool     `json:"has_password"`
+	MFAEnrolled  bool     `json:"mfa_enrolled"`
+	LastLogin    string   `json:"last_login,omitempty"`
+	Created      string   `json:"created,omitempty"`
+}
+
+// UpdateProfileRequest updates the name and email address of the user; empty fields
+// are not changed. Email changes take effect once the new address has been verified.
+type UpdateProfileRequest struct {
+	Name  string `json:"name,omitempty" validate:"required_without=Email,max=255"`
+	Email string `json:"email,omitempty" validate:"omitempty,max=255,mailaddr"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ChangePasswordRequest requires the user's current password unless they signed up
+// with an external identity provider and have never set a password.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password,omitempty"`
+	NewPassword     string `json:"new_password" validate:"required,min=8"`
+}
+
+// DeleteAccountRequest requires the user's password to confirm the account deletion
+// unless they signed up with an external identity provider.
+type DeleteAccountRequest struct {
+	Password string `json:"password,omitempty"`
+}
+
+//===========================================================================
+// Multi-Factor Authentication Requests and Responses
+//===========================================================================
+
+type MFAStatusReply struct {
+	Enrolled      bool  `json:"enrolled"`
+	Required      bool  `json:"required"`
+	RecoveryCodes int64 `json:"recovery_codes_remaining"`
+}
+
+// TOTPEnrollReply contains the secret to add to an authenticator app; the provisioning
+// URI can be rendered as a QR code for the user to scan.
+type TOTPEnrollReply struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type TOTPVerifyRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// TOTPVerifyReply contains the recovery codes generated when the enrollment is
+// verified. Recovery codes are only ever returned once.
+type TOTPVerifyReply struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+//===========================================================================
+// API Key Requests and Responses
+//===========================================================================
+
+// APIKey describes an API key owned by the user. The client secret is only returned
+// when the API key is created and cannot be retrieved afterward.
+type APIKey struct {
+	ID           int64    `json:"id"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	Name         string   `json:"name"`
+	Permissions  []string `json:"permissions"`
+	Expires      string   `json:"expires,omitempty"`
+	LastUsed     string   `json:"last_used,omitempty"`
+	Created      string   `json:"created,omitempty"`
+}
+
+type APIKeyList struct {
+	APIKeys []*APIKey `json:"api_keys"`
+}
+
+// CreateAPIKeyRequest creates a new API key with a subset of the user's permissions.
+// The expiration is optional and must be an RFC3339 timestamp in the future.
+type CreateAPIKeyRequest struct {
+	Name        string   `json:"name" validate:"required,max=255"`
+	Permissions []string `json:"permissions" validate:"required,min=1,dive,required"`
+	Expires     string   `json:"expires,omitempty" validate:"omitempty,future"`
+}
+
+//===========================================================================
+// Administration Requests and Responses
+//===========================================================================
+
+// User describes a user account for administrators.
+type User struct {
+	ID        int64  `json:"user_id"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	Disabled  bool   `json:"disabled"`
+	LastLogin string `json:"last_login,omitempty"`
+	Created   string `json:"created,omitempty"`
+}
+
+type UserList struct {
+	Users []*User `json:"users"`
+}
+
+// UserQuery searches the users by email or name and filters them by role. Results are
+// ordered by user ID; a limit of zero returns the default number of users.
+type UserQuery struct {
+	Search string `form:"q"`
+	Role   string `form:"role"`
+	Limit  int    `form:"limit" validate:"gte=0,lte=500"`
+	Offset int    `form:"offset" validate:"gte=0"`
+}
+
+// ImpersonateReply contains a short-lived access token that allows an administrator to
+// act as the user; the token cannot be refreshed.
+type ImpersonateReply struct {
+	AccessToken string `json:"access_token"`
+	ExpiresAt   string `json:"expires_at"`
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role" validate:"required,max=255"`
+}
+
+// Role describes a role and the permissions granted to users assigned to the role.
+type Role struct {
+	ID          int64    `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	IsDefault   bool     `json:"is_default"`
+	RequireMFA  bool     `json:"require_mfa"`
+	Permissions []string `json:"permissions"`
+	Created     string   `json:"created,omitempty"`
+}
+
+type RoleList struct {
+	Roles []*Role `json:"roles"`
+}
+
+// CreateRoleRequest creates a custom role with the specified permissions.
+type CreateRoleRequest struct {
+	Title       string   `json:"title" validate:"required,max=255"`
+	Description string   `json:"description,omitempty" validate:"max=512"`
+	RequireMFA  bool     `json:"require_mfa"`
+	Permissions []string `json:"permissions"`
+}
+
+// RolePermissionsRequest replaces all of the permissions of a role.
+type RolePermissionsRequest struct {
+	Permissions []string `json:"permissions"`
+}
+
+type Permission struct {
+	ID          int64  `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+type PermissionList struct {
+	Permissions []*Permission `json:"permissions"`
+}
+
+//===========================================================================
+// Galaxy Requests and Responses
+//===========================================================================
+
+// Galaxy describes a game and its current state.
+type Galaxy struct {
+	ID         int64  `json:"id"`
+	Name       string `json:"name"`
+	Turn       int64  `json:"turn"`
+	Size       string `json:"size"`
+	MaxPlayers int16  `json:"max_players"`
+	MaxTurns   int64  `json:"max_turns"`
+	JoinCode   string `json:"join_code"`
+	State      string `json:"state"`
+	Created    string `json:"created,omitempty"`
+	Modified   string `json:"modified,omitempty"`
+}
+
+// GalaxyQuery filters the galaxies of the user by state and size; multiple states or
+// sizes may be specified by repeating the parameter or as a comma separated list. If
+// no states are specified then completed galaxies are not returned. Galaxies can be
+// ordered by created, modified, name, or turn; prefix the field with a minus sign for
+// descending order. The page token of the previous page must be used with the same
+// filters and order.
+type GalaxyQuery struct {
+	State     []string `json:"state,omitempty" form:"state" validate:"dive,oneof=pending playing completed"`
+	Size      []string `json:"size,omitempty" form:"size" validate:"dive,oneof=small medium large galactic cosmic"`
+	OrderBy   string   `json:"order_by,omitempty" form:"order_by"`
+	PageSize  int      `json:"page_size,omitempty" form:"page_size" validate:"gte=0"`
+	PageToken string   `json:"page_token,omitempty" form:"page_token"`
+}
+
+// CreateGalaxyRequest creates a new pending galaxy with the user as its admin; the
+// medium size is used if no size is specified.
+type CreateGalaxyRequest struct {
+	Name string `json:"name" validate:"required,max=255"`
+	Size string `json:"size,omitempty" validate:"omitempty,oneof=small medium large galactic cosmic"`
+}
+
+// UpdateGalaxyRequest changes the name or the maximum number of turns of the galaxy;
+// empty fields are not changed.
+type UpdateGalaxyRequest struct {
+	Name     string `json:"name,omitempty" validate:"required_without=MaxTurns,max=255"`
+	MaxTurns int64  `json:"max_turns,omitempty" validate:"gte=0"`
+}
+
+type GalaxyList struct {
+	Galaxies      []*Galaxy `json:"galaxies"`
+	NextPageToken string    `json:"next_page_token,omitempty"`
+}
+
+// Player describes a user's player in a galaxy and their galaxy role, which determines
+// what the player is permitted to do in the galaxy.
+type Player struct {
+	UserID    int64  `json:"user_id"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	Faction   string `json:"faction"`
+	Character string `json:"character"`
+	Joined    string `json:"joined,omitempty"`
+}
+
+type PlayerList struct {
+	Players []*Player `json:"players"`
+}
+
+// Types of the events that are sent on the event stream of a galaxy.
+const (
+	EventTurnAdvanced = "turn_advanced"
+	EventStateChanged = "state_changed"
+	EventPlayerJoined = "player_joined"
+	EventPlayerLeft   = "player_left"
+	EventBattle       = "battle"
+)
+
+// GalaxyEvent is sent on the event stream of a galaxy as a server-sent event whose id
+// and event fields are the ID and type of the event. Pass the ID of the last event that
+// was received in the Last-Event-ID header to resume the stream. The data depends on
+// the type of the event, e.g. a TurnAdvancedEvent for turn_advanced events.
+type GalaxyEvent struct {
+	ID       int64           `json:"id"`
+	GalaxyID int64           `json:"galaxy_id"`
+	Type     string          `json:"type"`
+	Data     json.RawMessage `json:"data"`
+	Created  string          `json:"created"`
+}
+
+type TurnAdvancedEvent struct {
+	Turn int64 `json:"turn"`
+}
+
+type StateChangedEvent struct {
+	State string `json:"state"`
+}
+
+// PlayerEvent is the data of player_joined and player_left events.
+type PlayerEvent struct {
+	UserID int64  `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+// Webhook delivers galaxy events to the URL as they happen: the events of a galaxy if
+// the galaxy ID is specified, otherwise the events of every galaxy the user is playing
+// in. If events are specified then only events of those types are delivered. The
+// secret is used to verify the signature of deliveries; it is only returned when the
+// webhook is created and cannot be retrieved afterward.
+type Webhook struct {
+	ID       int64    `json:"id"`
+	URL      string   `json:"url"`
+	GalaxyID int64    `json:"galaxy_id,omitempty"`
+	Events   []string `json:"events"`
+	Secret   string   `json:"secret,omitempty"`
+	Created  string   `json:"created,omitempty"`
+}
+
+type WebhookList struct {
+	Webhooks []*Webhook `json:"webhooks"`
+}
+
+// CreateWebhookRequest subscribes a URL to the events of a galaxy the user is playing
+// in or, if no galaxy is specified, to the events of all of the user's galaxies. All
+// event types are delivered if no events are specified.
+type CreateWebhookRequest struct {
+	URL      string   `json:"url" validate:"required,max=2048,http_url"`
+	GalaxyID int64    `json:"galaxy_id,omitempty" validate:"gte=0"`
+	Events   []string `json:"events,omitempty" validate:"dive,oneof=turn_advanced state_changed player_joined player_left battle"`
+}
+
+// WebhookDeliveryQuery fetches a page of the delivery log of a webhook, most recent
+// deliveries first.
+type WebhookDeliveryQuery struct {
+	PageSize  int    `form:"page_size" validate:"gte=0"`
+	PageToken string `form:"page_token"`
+}
+
+// WebhookDelivery describes the delivery of an event to a webhook. Pending deliveries
+// are retried at the next attempt with exponential backoff until they are delivered or
+// fail too many times. The response status and error are of the most recent attempt.
+type WebhookDelivery struct {
+	ID             int64  `json:"id"`
+	EventID        int64  `json:"event_id"`
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	NextAttempt    string `json:"next_attempt,omitempty"`
+	ResponseStatus int    `json:"response_status,omitempty"`
+	Error          string `json:"error,omitempty"`
+	Delivered      string `json:"delivered,omitempty"`
+	Created        string `json:"created"`
+}
+
+type WebhookDeliveryList struct {
+	Deliveries    []*WebhookDelivery `json:"deliveries"`
+	NextPageToken string             `json:"next_page_token,omitempty"`
+}
+
+// GraphQLRequest executes a GraphQL query against the galaxies of the user; the field
+// names follow the GraphQL over HTTP convention rather than the rest of the API.
+type GraphQLRequest struct {
+	Query         string                 `json:"query" validate:"required"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// GraphQLReply contains the data of a GraphQL query and the errors of the fields that
+// could not be resolved; the data is omitted if the query could not be executed.
+type GraphQLReply struct {
+	Data   interface{}     `json:"data,omitempty"`
+	Errors []*GraphQLError `json:"errors,omitempty"`
+}
+
+type GraphQLError struct {
+	Message   string            `json:"message"`
+	Locations []GraphQLLocation `json:"locations,omitempty"`
+	Path      []interface{}     `json:"path,omitempty"`
+}
+
+type GraphQLLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
@@ -0,0 +1,517 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+// CosmosClient is a typed client for the v1 API of the cosmos server. Authenticated
+// requests use the access token of the last successful login; the access token is
+// refreshed automatically before it expires.
+type CosmosClient interface {
+	Status(context.Context) (*StatusReply, error)
+
+	Register(context.Context, *RegisterRequest) (*RegisterReply, error)
+	Login(context.Context, *LoginRequest) (*LoginReply, error)
+	LoginMFA(context.Context, *MFALoginRequest) (*LoginReply, error)
+	Reauthenticate(context.Context, *ReauthenticateRequest) (*LoginReply, error)
+	Logout(context.Context) error
+
+	ListGalaxies(context.Context, *GalaxyQuery) (*GalaxyList, error)
+	CreateGalaxy(context.Context, *CreateGalaxyRequest) (*Galaxy, error)
+	GetGalaxy(_ context.Context, galaxyID int64) (*Galaxy, error)
+	DeleteGalaxy(_ context.Context, galaxyID int64) error
+	ListPlayers(_ context.Context, galaxyID int64) (*PlayerList, error)
+	SetPlayerRole(_ context.Context, galaxyID, userID int64, in *SetRoleRequest) (*Player, error)
+	RemovePlayer(_ context.Context, galaxyID, userID int64) error
+}
+
+// RefreshBuffer is how long before the access token expires that the client refreshes
+// the access token using the refresh token.
+const RefreshBuffer = 30 * time.Second
+
+var ErrUnauthenticated = errors.New("client has no access token, login to continue")
+
+// New creates an HTTP client for the v1 API of the server at the endpoint, e.g.
+// https://api.cosmos.example.com. By default the client uses a cookie jar so that the
+// authentication cookies set by the server are stored, and it times out after 30s.
+func New(endpoint string, opts ...ClientOption) (_ CosmosClient, err error) {
+	c := &APIv1{}
+	if c.endpoint, err = url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("could not parse endpoint: %w", err)
+	}
+
+	for _, opt := range opts {
+		if err = opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.client == nil {
+		c.client = &http.Client{Timeout: 30 * time.Second}
+		if c.client.Jar, err = cookiejar.New(nil); err != nil {
+			return nil, fmt.Errorf("could not create cookie jar: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// ClientOption configures the client when it is created.
+type ClientOption func(c *APIv1) error
+
+// WithClient uses the specified http client, e.g. to configure TLS or timeouts. Set a
+// cookie jar on the client if the authentication cookies should be handled.
+func WithClient(client *http.Client) ClientOption {
+	return func(c *APIv1) error {
+		c.client = client
+		return nil
+	}
+}
+
+// WithTokens authenticates the client with tokens from a previous login, e.g. tokens
+// from the environment of a bot. The refresh token is optional.
+func WithTokens(accessToken, refreshToken string) ClientOption {
+	return func(c *APIv1) error {
+		c.accessToken = accessToken
+		c.refreshToken = refreshToken
+		return nil
+	}
+}
+
+// APIv1 implements the CosmosClient interface using HTTP requests.
+type APIv1 struct {
+	sync.Mutex
+	endpoint     *url.URL
+	client       *http.Client
+	accessToken  string
+	refreshToken string
+}
+
+// Ensure the APIv1 implements the CosmosClient interface
+var _ CosmosClient = &APIv1{}
+
+//===========================================================================
+// Client Methods
+//===========================================================================
+
+func (s *APIv1) Status(ctx context.Context) (out *StatusReply, err error) {
+	var req *http.Request
+	if req, err = s.NewRequest(ctx, http.MethodGet, "/v1/status", nil, nil); err != nil {
+		return nil, err
+	}
+
+	out = &StatusReply{}
+	if _, err = s.Do(req, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *APIv1) Register(ctx context.Context, in *RegisterRequest) (out *RegisterReply, err error) {
+	var req *http.Request
+	if req, err = s.NewRequest(ctx, http.MethodPost, "/v1/register", in, nil); err != nil {
+		return nil, err
+	}
+
+	out = &RegisterReply{}
+	if _, err = s.Do(req, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Login authenticates the client with the access and refresh tokens of the user unless
+// a second factor is required, in which case LoginMFA must be called with the MFA
+// token and a code.
+func (s *APIv1) Login(ctx context.Context, in *LoginRequest) (out *LoginReply, err error) {
+	var req *http.Request
+	if req, err = s.NewRequest(ctx, http.MethodPost, "/v1/login", in, nil); err != nil {
+		return nil, err
+	}
+	return s.login(req)
+}
+
+func (s *APIv1) LoginMFA(ctx context.Context, in *MFALoginRequest) (out *LoginReply, err error) {
+	var req *http.Request
+	if req, err = s.NewRequest(ctx, http.MethodPost, "/v1/login/mfa", in, nil); err != nil {
+		return nil, err
+	}
+	return s.login(req)
+}
+
+// Reauthenticate exchanges the refresh token for new access and refresh tokens. If the
+// refresh token is not specified, the refresh token of the client is used. Clients do
+// not normally have to call this method since tokens are refreshed automatically.
+func (s *APIv1) Reauthenticate(ctx context.Context, in *ReauthenticateRequest) (out *LoginReply, err error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.reauthenticate(ctx, in)
+}
+
+// Logout clears the authentication cookies and the tokens of the client.
+func (s *APIv1) Logout(ctx context.Context) (err error) {
+	var req *http.Request
+	if req, err = s.NewRequest(ctx, http.MethodPost, "/v1/logout", nil, nil); err != nil {
+		return err
+	}
+
+	if _, err = s.Do(req, nil); err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.accessToken, s.refreshToken = "", ""
+	s.Unlock()
+	return nil
+}
+
+func (s *APIv1) ListGalaxies(ctx context.Context, in *GalaxyQuery) (out *GalaxyList, err error) {
+	var params url.Values
+	if in != nil {
+		params = url.Values{}
+		for _, state := range in.State {
+			params.Add("state", state)
+		}
+		for _, size := range in.Size {
+			params.Add("size", size)
+		}
+		if in.OrderBy != "" {
+			params.Set("order_by", in.OrderBy)
+		}
+		if in.PageSize != 0 {
+			params.Set("page_size", strconv.Itoa(in.PageSize))
+		}
+		if in.PageToken != "" {
+			params.Set("page_token", in.PageToken)
+		}
+	}
+
+	var req *http.Request
+	if req, err = s.NewAuthenticatedRequest(ctx, http.MethodGet, "/v1/galaxy/", nil, params); err != nil {
+		return nil, err
+	}
+
+	out = &GalaxyList{}
+	if _, err = s.Do(req, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *APIv1) CreateGalaxy(ctx context.Context, in *CreateGalaxyRequest) (out *Galaxy, err error) {
+	var req *http.Request
+	if req, err = s.NewAuthenticatedRequest(ctx, http.MethodPost, "/v1/galaxy/", in, nil); err != nil {
+		return nil, err
+	}
+
+	out = &Galaxy{}
+	if _, err = s.Do(req, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *APIv1) GetGalaxy(ctx context.Context, galaxyID int64) (out *Galaxy, err error) {
+	var req *http.Request
+	if req, err = s.NewAuthenticatedRequest(ctx, http.MethodGet, galaxyPath(galaxyID), nil, nil); err != nil {
+		return nil, err
+	}
+
+	out = &Galaxy{}
+	if _, err = s.Do(req, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *APIv1) DeleteGalaxy(ctx context.Context, galaxyID int64) (err error) {
+	var req *http.Request
+	if req, err = s.NewAuthenticatedRequest(ctx, http.MethodDelete, galaxyPath(galaxyID), nil, nil); err != nil {
+		return err
+	}
+
+	_, err = s.Do(req, nil)
+	return err
+}
+
+func (s *APIv1) ListPlayers(ctx context.Context, galaxyID int64) (out *PlayerList, err error) {
+	var req *http.Request
+	if req, err = s.NewAuthenticatedRequest(ctx, http.MethodGet, galaxyPath(galaxyID, "players"), nil, nil); err != nil {
+		return nil, err
+	}
+
+	out = &PlayerList{}
+	if _, err = s.Do(req, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *APIv1) SetPlayerRole(ctx context.Context, galaxyID, userID int64, in *SetRoleRequest) (out *Player, err error) {
+	var req *http.Request
+	path := galaxyPath(galaxyID, "players", strconv.FormatInt(userID, 10), "role")
+	if req, err = s.NewAuthenticatedRequest(ctx, http.MethodPut, path, in, nil); err != nil {
+		return nil, err
+	}
+
+	out = &Player{}
+	if _, err = s.Do(req, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *APIv1) RemovePlayer(ctx context.Context, galaxyID, userID int64) (err error) {
+	var req *http.Request
+	path := galaxyPath(galaxyID, "players", strconv.FormatInt(userID, 10))
+	if req, err = s.NewAuthenticatedRequest(ctx, http.MethodDelete, path, nil, nil); err != nil {
+		return err
+	}
+
+	_, err = s.Do(req, nil)
+	return err
+}
+
+func galaxyPath(galaxyID int64, segments ...string) string {
+	path := "/v1/galaxy/" + strconv.FormatInt(galaxyID, 10)
+	for _, segment := range segments {
+		path += "/" + url.PathEscape(segment)
+	}
+	return path
+}
+
+//===========================================================================
+// Authentication
+//===========================================================================
+
+// login executes a login request and stores the tokens in the reply.
+func (s *APIv1) login(req *http.Request) (out *LoginReply, err error) {
+	out = &LoginReply{}
+	if _, err = s.Do(req, out); err != nil {
+		return nil, err
+	}
+
+	if out.AccessToken != "" {
+		s.Lock()
+		s.accessToken, s.refreshToken = out.AccessToken, out.RefreshToken
+		s.Unlock()
+	}
+	return out, nil
+}
+
+// reauthenticate must be called while holding the lock.
+func (s *APIv1) reauthenticate(ctx context.Context, in *ReauthenticateRequest) (out *LoginReply, err error) {
+	if in == nil {
+		in = &ReauthenticateRequest{}
+	}
+
+	if in.RefreshToken == "" {
+		in.RefreshToken = s.refreshToken
+	}
+
+	var req *http.Request
+	if req, err = s.NewRequest(ctx, http.MethodPost, "/v1/reauthenticate", in, nil); err != nil {
+		return nil, err
+	}
+
+	// The server matches the refresh token to the (possibly expired) access token
+	if s.accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.accessToken)
+	}
+
+	out = &LoginReply{}
+	if _, err = s.Do(req, out); err != nil {
+		return nil, err
+	}
+
+	s.accessToken, s.refreshToken = out.AccessToken, out.RefreshToken
+	return out, nil
+}
+
+// credentials returns the access token of the client, refreshing it first if it will
+// expire within the refresh buffer and the refresh token can be used.
+func (s *APIv1) credentials(ctx context.Context) (_ string, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.accessToken == "" {
+		return "", ErrUnauthenticated
+	}
+
+	if s.refreshToken == "" {
+		return s.accessToken, nil
+	}
+
+	var expires time.Time
+	if expires, err = tokenExpiresAt(s.accessToken); err != nil {
+		return "", fmt.Errorf("could not parse access token: %w", err)
+	}
+
+	if time.Until(expires) > RefreshBuffer {
+		return s.accessToken, nil
+	}
+
+	// The refresh token cannot be used until its not before time
+	var notBefore time.Time
+	if notBefore, err = tokenNotBefore(s.refreshToken); err != nil {
+		return "", fmt.Errorf("could not parse refresh token: %w", err)
+	}
+
+	if time.Now().Before(notBefore) {
+		return s.accessToken, nil
+	}
+
+	if _, err = s.reauthenticate(ctx, nil); err != nil {
+		return "", fmt.Errorf("could not refresh access token: %w", err)
+	}
+	return s.accessToken, nil
+}
+
+// Used to extract expiration and not before timestamps without having to use public keys.
+// NOTE: this mirrors auth.ExpiresAt and auth.NotBefore, which cannot be used here since
+// the auth package imports the api package.
+var tsparser = &jwt.Parser{SkipClaimsValidation: true}
+
+func tokenExpiresAt(tks string) (_ time.Time, err error) {
+	claims := &jwt.RegisteredClaims{}
+	if _, _, err = tsparser.ParseUnverified(tks, claims); err != nil {
+		return time.Time{}, err
+	}
+
+	if claims.ExpiresAt == nil {
+		return time.Time{}, errors.New("token does not have an exp claim")
+	}
+	return claims.ExpiresAt.Time, nil
+}
+
+func tokenNotBefore(tks string) (_ time.Time, err error) {
+	claims := &jwt.RegisteredClaims{}
+	if _, _, err = tsparser.ParseUnverified(tks, claims); err != nil {
+		return time.Time{}, err
+	}
+
+	if claims.NotBefore == nil {
+		return time.Time{}, nil
+	}
+	return claims.NotBefore.Time, nil
+}
+
+//===========================================================================
+// Client Helpers
+//===========================================================================
+
+const (
+	userAgent   = "Cosmos API Client/v1"
+	accept      = "application/json"
+	acceptLang  = "en-US,en"
+	contentType = "application/json; charset=utf-8"
+	csrfCookie  = "csrf_token"
+	csrfHeader  = "X-CSRF-TOKEN"
+)
+
+// NewAuthenticatedRequest creates a request with the access token of the client in the
+// Authorization header, refreshing the access token if necessary.
+func (s *APIv1) NewAuthenticatedRequest(ctx context.Context, method, path string, data interface{}, params url.Values) (req *http.Request, err error) {
+	var accessToken string
+	if accessToken, err = s.credentials(ctx); err != nil {
+		return nil, err
+	}
+
+	if req, err = s.NewRequest(ctx, method, path, data, params); err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	return req, nil
+}
+
+// NewRequest creates a request to the endpoint with the data encoded as JSON. Requests
+// that are authenticated by cookies include the CSRF token from the cookie jar.
+func (s *APIv1) NewRequest(ctx context.Context, method, path string, data interface{}, params url.Values) (req *http.Request, err error) {
+	endpoint := s.endpoint.ResolveReference(&url.URL{Path: path})
+	if len(params) > 0 {
+		endpoint.RawQuery = params.Encode()
+	}
+
+	var body io.ReadWriter
+	if data != nil {
+		body = &bytes.Buffer{}
+		if err = json.NewEncoder(body).Encode(data); err != nil {
+			return nil, fmt.Errorf("could not serialize request data as json: %w", err)
+		}
+	}
+
+	if req, err = http.NewRequestWithContext(ctx, method, endpoint.String(), body); err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", accept)
+	req.Header.Set("Accept-Language", acceptLang)
+	req.Header.Set("Content-Type", contentType)
+
+	if method != http.MethodGet && method != http.MethodHead && s.client.Jar != nil {
+		for _, cookie := range s.client.Jar.Cookies(endpoint) {
+			if cookie.Name == csrfCookie {
+				req.Header.Set(csrfHeader, cookie.Value)
+			}
+		}
+	}
+	return req, nil
+}
+
+// Do executes the request and decodes the JSON response into data. If the response is
+// not successful, the error Reply is decoded into a StatusError instead.
+func (s *APIv1) Do(req *http.Request, data interface{}) (rep *http.Response, err error) {
+	if rep, err = s.client.Do(req); err != nil {
+		return rep, fmt.Errorf("could not execute request: %w", err)
+	}
+	defer rep.Body.Close()
+
+	if rep.StatusCode < 200 || rep.StatusCode >= 300 {
+		serr := &StatusError{StatusCode: rep.StatusCode}
+		if err = json.NewDecoder(rep.Body).Decode(&serr.Reply); err != nil || serr.Reply.Error == "" {
+			serr.Reply.Error = http.StatusText(rep.StatusCode)
+		}
+		return rep, serr
+	}
+
+	if data != nil && rep.StatusCode != http.StatusNoContent {
+		if err = json.NewDecoder(rep.Body).Decode(data); err != nil {
+			return rep, fmt.Errorf("could not deserialize response data: %w", err)
+		}
+	}
+	return rep, nil
+}
+
+// StatusError is returned by the client when the server responds with an error reply.
+// The reply contains the error code, which can be checked with errors.As.
+type StatusError struct {
+	StatusCode int
+	Reply      Reply
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("[%d] %s", e.StatusCode, e.Reply.Error)
+}
+
+// Code returns the error code of the reply or the code of the status if the reply did
+// not contain an error code.
+func (e *StatusError) Code() string {
+	if e.Reply.Code != "" {
+		return e.Reply.Code
+	}
+	return StatusCode(e.StatusCode)
+}
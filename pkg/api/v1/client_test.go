@@ -0,0 +1,111 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/api/v1/mock"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(api.Reply{Error: "resource not found", Code: api.CodeNotFound, RequestID: "req42"})
+	}))
+	defer srv.Close()
+
+	client, err := api.New(srv.URL)
+	require.NoError(t, err)
+
+	_, err = client.Status(context.Background())
+	require.EqualError(t, err, "[404] resource not found")
+
+	var serr *api.StatusError
+	require.ErrorAs(t, err, &serr)
+	require.Equal(t, http.StatusNotFound, serr.StatusCode)
+	require.Equal(t, api.CodeNotFound, serr.Code())
+	require.Equal(t, "req42", serr.Reply.RequestID)
+
+	// Authenticated requests require a login
+	_, err = client.GetGalaxy(context.Background(), 42)
+	require.ErrorIs(t, err, api.ErrUnauthenticated)
+}
+
+func TestClientRefresh(t *testing.T) {
+	// The first access token expires within the refresh buffer
+	expiring := token(t, "first", time.Now().Add(api.RefreshBuffer/2), time.Now().Add(-time.Minute))
+	refresh := token(t, "first", time.Now().Add(time.Hour), time.Now().Add(-time.Minute))
+	refreshed := token(t, "second", time.Now().Add(time.Hour), time.Now().Add(-time.Minute))
+
+	var reauthenticated int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/login":
+			json.NewEncoder(w).Encode(&api.LoginReply{AccessToken: expiring, RefreshToken: refresh})
+		case "/v1/reauthenticate":
+			reauthenticated++
+			in := &api.ReauthenticateRequest{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(in))
+			require.Equal(t, refresh, in.RefreshToken)
+			require.Equal(t, "Bearer "+expiring, r.Header.Get("Authorization"))
+			json.NewEncoder(w).Encode(&api.LoginReply{AccessToken: refreshed, RefreshToken: refresh})
+		case "/v1/galaxy/42":
+			require.Equal(t, "Bearer "+refreshed, r.Header.Get("Authorization"))
+			json.NewEncoder(w).Encode(&api.Galaxy{ID: 42, Name: "Andromeda"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := api.New(srv.URL)
+	require.NoError(t, err)
+
+	_, err = client.Login(context.Background(), &api.LoginRequest{Username: "jdoe", Password: "supersecret"})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		galaxy, err := client.GetGalaxy(context.Background(), 42)
+		require.NoError(t, err)
+		require.Equal(t, "Andromeda", galaxy.Name)
+	}
+	require.Equal(t, 1, reauthenticated, "expected the access token to be refreshed once")
+}
+
+func TestMock(t *testing.T) {
+	client := &mock.Client{
+		OnGetGalaxy: func(_ context.Context, galaxyID int64) (*api.Galaxy, error) {
+			return &api.Galaxy{ID: galaxyID}, nil
+		},
+	}
+
+	galaxy, err := client.GetGalaxy(context.Background(), 42)
+	require.NoError(t, err)
+	require.Equal(t, int64(42), galaxy.ID)
+	require.Equal(t, 1, client.Calls("GetGalaxy"))
+
+	_, err = client.Status(context.Background())
+	require.ErrorIs(t, err, mock.ErrUnhandled)
+	require.Equal(t, 1, client.Calls("Status"))
+}
+
+func token(t *testing.T, id string, expires, notBefore time.Time) string {
+	claims := &jwt.RegisteredClaims{
+		ID:        id,
+		ExpiresAt: jwt.NewNumericDate(expires),
+		NotBefore: jwt.NewNumericDate(notBefore),
+	}
+
+	tks, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("supersecretsigningkey"))
+	require.NoError(t, err)
+	return tks
+}
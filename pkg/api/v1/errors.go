@@ -5,14 +5,47 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Error codes are stable, machine-readable identifiers of the cause of an error that
+// clients can rely on; unlike error messages they do not change between releases. Every
+// error reply has a code: errors created with NewError have their own code, invalid
+// requests have the invalid_request code, and all other errors have the code of their
+// HTTP status.
+const (
+	CodeBadRequest       = "bad_request"        // 400: the request could not be parsed
+	CodeInvalidRequest   = "invalid_request"    // 400: one or more fields are invalid, see fields
+	CodeUnauthenticated  = "unauthenticated"    // 401: the request must be authenticated
+	CodeStalePermissions = "stale_permissions"  // 401: reauthenticate to refresh permissions
+	CodeAPIKeyRequired   = "api_key_required"   // 401: authenticate with api key credentials
+	CodeForbidden        = "forbidden"          // 403: the request is not authorized
+	CodeMFARequired      = "mfa_required"       // 403: login with multi-factor authentication
+	CodeCSRFFailed       = "csrf_failed"        // 403: the csrf token header does not match
+	CodeImpersonation    = "impersonation"      // 403: not allowed while impersonating a user
+	CodeAccountDisabled  = "account_disabled"   // 403: the user account has been disabled
+	CodeNotFound         = "not_found"          // 404: the resource or route does not exist
+	CodeMethodNotAllowed = "method_not_allowed" // 405: the route does not allow the method
+	CodeConflict         = "conflict"           // 409: the request conflicts with a resource
+	CodeInProgress       = "in_progress"        // 409: a request with the idempotency key is in progress
+	CodePrecondition     = "precondition"       // 412: the resource does not match the If-Match header
+	CodeKeyReused        = "key_reused"         // 422: the idempotency key was used for another request
+	CodeRateLimited      = "rate_limited"       // 429: too many requests, see the Retry-After header
+	CodeInternal         = "internal_error"     // 500: an unhandled error or panic occurred
+	CodeUnavailable      = "unavailable"        // 503: the server is unhealthy or not ready
+	CodeMaintenance      = "maintenance"        // 503: the server is in maintenance mode
+)
+
+// RequestIDHeader identifies the request in the response and in the server logs; error
+// replies also include the request ID so that it can be included in bug reports.
+const RequestIDHeader = "X-Request-ID"
+
 var (
 	unsuccessful = Reply{Success: false}
-	notFound     = Reply{Success: false, Error: "resource not found"}
-	notAllowed   = Reply{Success: false, Error: "method not allowed"}
+	notFound     = NewError(CodeNotFound, "resource not found")
+	notAllowed   = NewError(CodeMethodNotAllowed, "method not allowed")
 )
 
 var (
@@ -24,10 +57,110 @@ var (
 	ErrModelIDMismatch   = errors.New("resource id does not match id of endpoint")
 	ErrUnparsable        = errors.New("could not parse request")
 	ErrUnknownUserRole   = errors.New("unknown user role")
-	ErrWeakPassword      = errors.New("password must be at least 8 characters")
+	ErrInvalidRequest    = errors.New("invalid request")
+	ErrInternal          = NewError(CodeInternal, "an internal error occurred")
+	ErrMaintenance       = NewError(CodeMaintenance, "server is in maintenance mode")
+	ErrRateLimited       = NewError(CodeRateLimited, "too many requests, try again later")
+	ErrInProgress        = NewError(CodeInProgress, "a request with the idempotency key is in progress, try again later")
+	ErrKeyReused         = NewError(CodeKeyReused, "the idempotency key has already been used for a different request")
+	ErrPrecondition      = NewError(CodePrecondition, "the resource has been modified, fetch it again before updating it")
 )
 
-// Construct a new response for an error or simply return unsuccessful.
+// CodedError is an error whose code is returned to the client instead of the code of
+// the HTTP status of the reply.
+type CodedError struct {
+	code    string
+	message string
+}
+
+// NewError creates an error with the specified error code.
+func NewError(code, message string) *CodedError {
+	return &CodedError{code: code, message: message}
+}
+
+func (e *CodedError) Error() string {
+	return e.message
+}
+
+func (e *CodedError) Code() string {
+	return e.code
+}
+
+// FieldError describes why a field of a request is invalid. Nested fields are
+// identified with dots and indices, e.g. permissions[0].
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// ValidationErrors lists every invalid field of a request. When returned to the client
+// in an ErrorResponse the fields are included in the reply.
+type ValidationErrors []*FieldError
+
+// InvalidField returns validation errors for a single field.
+func InvalidField(field, reason string) ValidationErrors {
+	return ValidationErrors{{Field: field, Reason: reason}}
+}
+
+func (e ValidationErrors) Error() string {
+	if len(e) == 0 {
+		return ErrInvalidRequest.Error()
+	}
+
+	fields := make([]string, 0, len(e))
+	for _, field := range e {
+		fields = append(fields, field.Field+" "+field.Reason)
+	}
+	return ErrInvalidRequest.Error() + ": " + strings.Join(fields, "; ")
+}
+
+func (e ValidationErrors) Code() string {
+	return CodeInvalidRequest
+}
+
+// Error writes an error reply with the specified status and aborts the request. The
+// reply includes the error code, which is the code of the status unless the error has
+// its own code, and the request ID so that every error has the same JSON envelope.
+func Error(c *gin.Context, status int, err interface{}) {
+	rep := ErrorResponse(err)
+	if rep.Code == "" {
+		rep.Code = StatusCode(status)
+	}
+	rep.RequestID = c.Writer.Header().Get(RequestIDHeader)
+	c.AbortWithStatusJSON(status, rep)
+}
+
+// StatusCode returns the error code of an HTTP status.
+func StatusCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthenticated
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusPreconditionFailed:
+		return CodePrecondition
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+
+	if status >= 500 {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
+
+// Construct a new response for an error or simply return unsuccessful. If the error is
+// caused by invalid fields in the request then the fields are included in the reply.
 func ErrorResponse(err interface{}) Reply {
 	if err == nil {
 		return unsuccessful
@@ -37,6 +170,11 @@ func ErrorResponse(err interface{}) Reply {
 	switch err := err.(type) {
 	case error:
 		rep.Error = err.Error()
+		rep.Code = errorCode(err)
+		rep.Fields = fieldErrors(err)
+		if rep.Fields != nil && rep.Code == "" {
+			rep.Code = CodeInvalidRequest
+		}
 	case string:
 		rep.Error = err
 	case fmt.Stringer:
@@ -54,15 +192,49 @@ func ErrorResponse(err interface{}) Reply {
 	return rep
 }
 
+// errorCode returns the code of errors that have one or an empty string.
+func errorCode(err error) string {
+	var coded interface{ Code() string }
+	if errors.As(err, &coded) {
+		return coded.Code()
+	}
+	return ""
+}
+
+// fieldErrors returns the invalid fields of validation errors or of errors caused by
+// request fields that could not be parsed.
+func fieldErrors(err error) ValidationErrors {
+	var (
+		verrs   ValidationErrors
+		typeErr *json.UnmarshalTypeError
+	)
+
+	switch {
+	case errors.As(err, &verrs):
+		return verrs
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return InvalidField(typeErr.Field, "must be of type "+typeErr.Type.String())
+	default:
+		return nil
+	}
+}
+
 // NotFound returns a JSON 404 response for the API.
 // NOTE: we know it's weird to put server-side handlers like NotFound and NotAllowed
 // here in the client/api side package but it unifies where we keep our error handling
 // mechanisms.
 func NotFound(c *gin.Context) {
-	c.JSON(http.StatusNotFound, notFound)
+	Error(c, http.StatusNotFound, notFound)
 }
 
 // NotAllowed returns a JSON 405 response for the API.
 func NotAllowed(c *gin.Context) {
-	c.JSON(http.StatusMethodNotAllowed, notAllowed)
+	Error(c, http.StatusMethodNotAllowed, notAllowed)
+}
+
+// Recovery returns a JSON 500 response for the API when a handler panics. It is used
+// with gin.CustomRecovery, which logs the panic and the stack trace.
+func Recovery(c *gin.Context, recovered interface{}) {
+	c.Error(fmt.Errorf("panic: %v", recovered))
+	Error(c, http.StatusInternalServerError, ErrInternal)
 }
@@ -0,0 +1,160 @@
+/*
+Package mock provides a mock implementation of the CosmosClient interface for unit
+tests. Set the On* handler of each method that the test expects to be called; calls
+to methods without a handler return ErrUnhandled. Every call is counted so that tests
+can assert how many times each method was called.
+*/
+package mock
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+)
+
+var ErrUnhandled = errors.New("mock client method was called without a handler")
+
+// Client implements the CosmosClient interface by calling the On* handlers.
+type Client struct {
+	sync.Mutex
+	calls map[string]int
+
+	OnStatus         func(ctx context.Context) (*api.StatusReply, error)
+	OnRegister       func(ctx context.Context, in *api.RegisterRequest) (*api.RegisterReply, error)
+	OnLogin          func(ctx context.Context, in *api.LoginRequest) (*api.LoginReply, error)
+	OnLoginMFA       func(ctx context.Context, in *api.MFALoginRequest) (*api.LoginReply, error)
+	OnReauthenticate func(ctx context.Context, in *api.ReauthenticateRequest) (*api.LoginReply, error)
+	OnLogout         func(ctx context.Context) error
+	OnListGalaxies   func(ctx context.Context, in *api.GalaxyQuery) (*api.GalaxyList, error)
+	OnCreateGalaxy   func(ctx context.Context, in *api.CreateGalaxyRequest) (*api.Galaxy, error)
+	OnGetGalaxy      func(ctx context.Context, galaxyID int64) (*api.Galaxy, error)
+	OnDeleteGalaxy   func(ctx context.Context, galaxyID int64) error
+	OnListPlayers    func(ctx context.Context, galaxyID int64) (*api.PlayerList, error)
+	OnSetPlayerRole  func(ctx context.Context, galaxyID, userID int64, in *api.SetRoleRequest) (*api.Player, error)
+	OnRemovePlayer   func(ctx context.Context, galaxyID, userID int64) error
+}
+
+// Ensure the mock implements the CosmosClient interface
+var _ api.CosmosClient = &Client{}
+
+// Calls returns the number of times the named method was called.
+func (c *Client) Calls(method string) int {
+	c.Lock()
+	defer c.Unlock()
+	return c.calls[method]
+}
+
+func (c *Client) incr(method string) {
+	c.Lock()
+	defer c.Unlock()
+	if c.calls == nil {
+		c.calls = make(map[string]int)
+	}
+	c.calls[method]++
+}
+
+func (c *Client) Status(ctx context.Context) (*api.StatusReply, error) {
+	c.incr("Status")
+	if c.OnStatus != nil {
+		return c.OnStatus(ctx)
+	}
+	return nil, ErrUnhandled
+}
+
+func (c *Client) Register(ctx context.Context, in *api.RegisterRequest) (*api.RegisterReply, error) {
+	c.incr("Register")
+	if c.OnRegister != nil {
+		return c.OnRegister(ctx, in)
+	}
+	return nil, ErrUnhandled
+}
+
+func (c *Client) Login(ctx context.Context, in *api.LoginRequest) (*api.LoginReply, error) {
+	c.incr("Login")
+	if c.OnLogin != nil {
+		return c.OnLogin(ctx, in)
+	}
+	return nil, ErrUnhandled
+}
+
+func (c *Client) LoginMFA(ctx context.Context, in *api.MFALoginRequest) (*api.LoginReply, error) {
+	c.incr("LoginMFA")
+	if c.OnLoginMFA != nil {
+		return c.OnLoginMFA(ctx, in)
+	}
+	return nil, ErrUnhandled
+}
+
+func (c *Client) Reauthenticate(ctx context.Context, in *api.ReauthenticateRequest) (*api.LoginReply, error) {
+	c.incr("Reauthenticate")
+	if c.OnReauthenticate != nil {
+		return c.OnReauthenticate(ctx, in)
+	}
+	return nil, ErrUnhandled
+}
+
+func (c *Client) Logout(ctx context.Context) error {
+	c.incr("Logout")
+	if c.OnLogout != nil {
+		return c.OnLogout(ctx)
+	}
+	return ErrUnhandled
+}
+
+func (c *Client) ListGalaxies(ctx context.Context, in *api.GalaxyQuery) (*api.GalaxyList, error) {
+	c.incr("ListGalaxies")
+	if c.OnListGalaxies != nil {
+		return c.OnListGalaxies(ctx, in)
+	}
+	return nil, ErrUnhandled
+}
+
+func (c *Client) CreateGalaxy(ctx context.Context, in *api.CreateGalaxyRequest) (*api.Galaxy, error) {
+	c.incr("CreateGalaxy")
+	if c.OnCreateGalaxy != nil {
+		return c.OnCreateGalaxy(ctx, in)
+	}
+	return nil, ErrUnhandled
+}
+
+func (c *Client) GetGalaxy(ctx context.Context, galaxyID int64) (*api.Galaxy, error) {
+	c.incr("GetGalaxy")
+	if c.OnGetGalaxy != nil {
+		return c.OnGetGalaxy(ctx, galaxyID)
+	}
+	return nil, ErrUnhandled
+}
+
+func (c *Client) DeleteGalaxy(ctx context.Context, galaxyID int64) error {
+	c.incr("DeleteGalaxy")
+	if c.OnDeleteGalaxy != nil {
+		return c.OnDeleteGalaxy(ctx, galaxyID)
+	}
+	return ErrUnhandled
+}
+
+func (c *Client) ListPlayers(ctx context.Context, galaxyID int64) (*api.PlayerList, error) {
+	c.incr("ListPlayers")
+	if c.OnListPlayers != nil {
+		return c.OnListPlayers(ctx, galaxyID)
+	}
+	return nil, ErrUnhandled
+}
+
+func (c *Client) SetPlayerRole(ctx context.Context, galaxyID, userID int64, in *api.SetRoleRequest) (*api.Player, error) {
+	c.incr("SetPlayerRole")
+	if c.OnSetPlayerRole != nil {
+		return c.OnSetPlayerRole(ctx, galaxyID, userID, in)
+	}
+	return nil, ErrUnhandled
+}
+
+func (c *Client) RemovePlayer(ctx context.Context, galaxyID, userID int64) error {
+	c.incr("RemovePlayer")
+	if c.OnRemovePlayer != nil {
+		return c.OnRemovePlayer(ctx, galaxyID, userID)
+	}
+	return ErrUnhandled
+}
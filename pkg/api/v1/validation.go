@@ -1,21 +1,286 @@
 package api
 
 import (
+	"errors"
+	"net/mail"
+	"reflect"
 	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
 )
 
+// Requests are validated by the rules in the validate tags of their fields after they
+// have been normalized by their Validate methods. In addition to the built-in rules of
+// the validator package, the following rules are defined:
+//
+//	mailaddr: the string is a bare email address such as jdoe@example.com
+//	future: the string is an RFC 3339 timestamp that is in the future
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(fieldName)
+	v.RegisterValidation("mailaddr", isMailAddress)
+	v.RegisterValidation("future", isFuture)
+	return v
+}
+
+// validateStruct checks the validate tags of the request and returns ValidationErrors
+// describing each invalid field.
+func validateStruct(req interface{}) error {
+	err := validate.Struct(req)
+	if err == nil {
+		return nil
+	}
+
+	var fields validator.ValidationErrors
+	if !errors.As(err, &fields) {
+		return err
+	}
+
+	verrs := make(ValidationErrors, 0, len(fields))
+	for _, field := range fields {
+		verrs = append(verrs, &FieldError{Field: fieldPath(field), Reason: reason(field)})
+	}
+	return verrs
+}
+
+// fieldName identifies fields by the name used in the request rather than the name of
+// the struct field.
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "form"} {
+		if name, _, _ := strings.Cut(field.Tag.Get(tag), ","); name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// fieldPath removes the name of the request struct from the namespace of the field.
+func fieldPath(field validator.FieldError) string {
+	_, path, _ := strings.Cut(field.Namespace(), ".")
+	return path
+}
+
+// reason returns a human readable description of the rule the field violated.
+func reason(field validator.FieldError) string {
+	param := field.Param()
+	switch field.Tag() {
+	case "required":
+		return "is required"
+	case "required_without":
+		return "is required unless " + strings.ToLower(param) + " is set"
+	case "min", "max":
+		limit := "at least "
+		if field.Tag() == "max" {
+			limit = "at most "
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			return "must be " + limit + param + " characters"
+		case reflect.Slice, reflect.Map:
+			return "must have " + limit + param + " items"
+		default:
+			return "must be " + limit + param
+		}
+	case "gte":
+		return "must be greater than or equal to " + param
+	case "lte":
+		return "must be less than or equal to " + param
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(param, " ", ", ")
+	case "mailaddr":
+		return "must be a valid email address"
+	case "future":
+		return "must be an RFC 3339 timestamp in the future"
+	case "http_url":
+		return "must be an http or https url"
+	default:
+		return "is invalid"
+	}
+}
+
+func isMailAddress(fl validator.FieldLevel) bool {
+	email := fl.Field().String()
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+func isFuture(fl validator.FieldLevel) bool {
+	ts, err := time.Parse(time.RFC3339, fl.Field().String())
+	return err == nil && ts.After(time.Now())
+}
+
 func (r *RegisterRequest) Validate() error {
 	r.Name = strings.TrimSpace(r.Name)
 	r.Email = strings.TrimSpace(r.Email)
 	r.Password = strings.TrimSpace(r.Password)
+	return validateStruct(r)
+}
+
+func (r *LoginRequest) Validate() error {
+	r.Username = strings.TrimSpace(r.Username)
+	return validateStruct(r)
+}
+
+func (r *MFALoginRequest) Validate() error {
+	r.MFAToken = strings.TrimSpace(r.MFAToken)
+	r.Code = strings.TrimSpace(r.Code)
+	return validateStruct(r)
+}
+
+func (r *APIKeyLoginRequest) Validate() error {
+	r.ClientID = strings.TrimSpace(r.ClientID)
+	r.ClientSecret = strings.TrimSpace(r.ClientSecret)
+	return validateStruct(r)
+}
 
-	if r.Name == "" || r.Email == "" || r.Password == "" {
-		return ErrMissingField
+func (r *TokenRequest) Validate() error {
+	r.Token = strings.TrimSpace(r.Token)
+	r.TokenTypeHint = strings.TrimSpace(r.TokenTypeHint)
+	return validateStruct(r)
+}
+
+func (q *GalaxyQuery) Validate() error {
+	q.State = splitValues(q.State)
+	q.Size = splitValues(q.Size)
+	q.OrderBy = strings.TrimSpace(q.OrderBy)
+	q.PageToken = strings.TrimSpace(q.PageToken)
+	return validateStruct(q)
+}
+
+// splitValues splits comma separated query parameters, removes empty values, and
+// converts the values to lower case.
+func splitValues(params []string) []string {
+	values := make([]string, 0, len(params))
+	for _, param := range params {
+		for _, value := range strings.Split(param, ",") {
+			if value = strings.ToLower(strings.TrimSpace(value)); value != "" {
+				values = append(values, value)
+			}
+		}
 	}
+	return values
+}
 
-	if len(r.Password) < 8 {
-		return ErrWeakPassword
+func (r *CreateGalaxyRequest) Validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+	r.Size = strings.ToLower(strings.TrimSpace(r.Size))
+	return validateStruct(r)
+}
+
+func (r *UpdateGalaxyRequest) Validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+	return validateStruct(r)
+}
+
+func (r *UpdateProfileRequest) Validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+	r.Email = strings.TrimSpace(r.Email)
+	return validateStruct(r)
+}
+
+func (r *VerifyEmailRequest) Validate() error {
+	r.Token = strings.TrimSpace(r.Token)
+	return validateStruct(r)
+}
+
+func (r *ChangePasswordRequest) Validate() error {
+	r.CurrentPassword = strings.TrimSpace(r.CurrentPassword)
+	r.NewPassword = strings.TrimSpace(r.NewPassword)
+	return validateStruct(r)
+}
+
+func (r *LinkIdentityRequest) Validate() error {
+	r.Password = strings.TrimSpace(r.Password)
+	return validateStruct(r)
+}
+
+func (r *DeleteAccountRequest) Validate() error {
+	r.Password = strings.TrimSpace(r.Password)
+	return validateStruct(r)
+}
+
+func (r *TOTPVerifyRequest) Validate() error {
+	r.Code = strings.TrimSpace(r.Code)
+	return validateStruct(r)
+}
+
+func (r *CreateAPIKeyRequest) Validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+	r.Expires = strings.TrimSpace(r.Expires)
+	for i := range r.Permissions {
+		r.Permissions[i] = strings.TrimSpace(r.Permissions[i])
+	}
+	return validateStruct(r)
+}
+
+func (r *CreateWebhookRequest) Validate() error {
+	r.URL = strings.TrimSpace(r.URL)
+	r.Events = splitValues(r.Events)
+	return validateStruct(r)
+}
+
+func (q *WebhookDeliveryQuery) Validate() error {
+	q.PageToken = strings.TrimSpace(q.PageToken)
+	return validateStruct(q)
+}
+
+const (
+	DefaultUserQueryLimit = 50
+	MaxUserQueryLimit     = 500
+)
+
+func (r *UserQuery) Validate() (err error) {
+	r.Search = strings.TrimSpace(r.Search)
+	r.Role = strings.TrimSpace(r.Role)
+
+	if err = validateStruct(r); err != nil {
+		return err
 	}
 
+	if r.Limit == 0 {
+		r.Limit = DefaultUserQueryLimit
+	}
 	return nil
 }
+
+func (r *SetRoleRequest) Validate() error {
+	r.Role = strings.TrimSpace(r.Role)
+	return validateStruct(r)
+}
+
+func (r *CreateRoleRequest) Validate() error {
+	r.Title = strings.TrimSpace(r.Title)
+	r.Description = strings.TrimSpace(r.Description)
+	r.Permissions = uniquePermissions(r.Permissions)
+	return validateStruct(r)
+}
+
+func (r *RolePermissionsRequest) Validate() error {
+	r.Permissions = uniquePermissions(r.Permissions)
+	return validateStruct(r)
+}
+
+func (r *GraphQLRequest) Validate() error {
+	r.Query = strings.TrimSpace(r.Query)
+	r.OperationName = strings.TrimSpace(r.OperationName)
+	return validateStruct(r)
+}
+
+// uniquePermissions removes blank and duplicate permissions, preserving order.
+func uniquePermissions(permissions []string) []string {
+	seen := make(map[string]struct{}, len(permissions))
+	unique := make([]string, 0, len(permissions))
+	for _, perm := range permissions {
+		perm = strings.TrimSpace(perm)
+		if _, ok := seen[perm]; ok || perm == "" {
+			continue
+		}
+		seen[perm] = struct{}{}
+		unique = append(unique, perm)
+	}
+	return unique
+}
@@ -0,0 +1,106 @@
+package api_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidation(t *testing.T) {
+	testCases := []struct {
+		req      interface{ Validate() error }
+		expected api.ValidationErrors
+	}{
+		{
+			&api.LoginRequest{Username: "  "},
+			api.ValidationErrors{{Field: "username", Reason: "is required"}, {Field: "password", Reason: "is required"}},
+		},
+		{
+			&api.UpdateProfileRequest{},
+			api.InvalidField("name", "is required unless email is set"),
+		},
+		{
+			&api.RegisterRequest{Name: strings.Repeat("a", 256), Email: "jane@example.com", Password: "short"},
+			api.ValidationErrors{{Field: "name", Reason: "must be at most 255 characters"}, {Field: "password", Reason: "must be at least 8 characters"}},
+		},
+		{
+			&api.RegisterRequest{Name: "Jane", Email: "Jane Doe <jane@example.com>", Password: "supersecret"},
+			api.InvalidField("email", "must be a valid email address"),
+		},
+		{
+			&api.RegisterRequest{Name: "Jane", Email: "jane", Password: "supersecret"},
+			api.InvalidField("email", "must be a valid email address"),
+		},
+		{
+			&api.CreateAPIKeyRequest{Name: "bot", Permissions: []string{}},
+			api.InvalidField("permissions", "must have at least 1 items"),
+		},
+		{
+			&api.CreateAPIKeyRequest{Name: "bot", Permissions: []string{"galaxy:play", " "}},
+			api.InvalidField("permissions[1]", "is required"),
+		},
+		{
+			&api.CreateAPIKeyRequest{Name: "bot", Permissions: []string{"galaxy:play"}, Expires: time.Now().Add(-time.Hour).Format(time.RFC3339)},
+			api.InvalidField("expires", "must be an RFC 3339 timestamp in the future"),
+		},
+		{
+			&api.CreateAPIKeyRequest{Name: "bot", Permissions: []string{"galaxy:play"}, Expires: "tomorrow"},
+			api.InvalidField("expires", "must be an RFC 3339 timestamp in the future"),
+		},
+		{
+			&api.UserQuery{Limit: 501, Offset: -1},
+			api.ValidationErrors{{Field: "limit", Reason: "must be less than or equal to 500"}, {Field: "offset", Reason: "must be greater than or equal to 0"}},
+		},
+		{
+			&api.GalaxyQuery{State: []string{"playing,paused"}},
+			api.InvalidField("state[1]", "must be one of pending, playing, completed"),
+		},
+		{
+			&api.CreateWebhookRequest{URL: "ftp://example.com/hook"},
+			api.InvalidField("url", "must be an http or https url"),
+		},
+	}
+
+	for i, tc := range testCases {
+		err := tc.req.Validate()
+		require.Error(t, err, "expected test case %d to be invalid", i)
+		require.Equal(t, tc.expected, err, "unexpected fields in test case %d", i)
+	}
+
+	// Valid requests are not rejected by the custom rules
+	valid := []interface{ Validate() error }{
+		&api.RegisterRequest{Name: "Jane", Email: " jane@example.com ", Password: "supersecret"},
+		&api.UpdateProfileRequest{Email: "jane@example.com"},
+		&api.CreateAPIKeyRequest{Name: "bot", Permissions: []string{"galaxy:play"}, Expires: time.Now().Add(time.Hour).Format(time.RFC3339)},
+		&api.UserQuery{Limit: 500},
+		&api.CreateWebhookRequest{URL: "https://example.com/hook", Events: []string{"Turn_Advanced"}},
+	}
+
+	for i, req := range valid {
+		require.NoError(t, req.Validate(), "expected request %d to be valid", i)
+	}
+}
+
+func TestValidationErrorResponse(t *testing.T) {
+	err := (&api.CreateAPIKeyRequest{Permissions: []string{"", "galaxy:play"}}).Validate()
+	require.EqualError(t, err, "invalid request: name is required; permissions[0] is required")
+
+	rep := api.ErrorResponse(err)
+	require.Equal(t, api.CodeInvalidRequest, rep.Code)
+
+	data, err := json.Marshal(rep)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"success": false,
+		"error": "invalid request: name is required; permissions[0] is required",
+		"code": "invalid_request",
+		"fields": [
+			{"field": "name", "reason": "is required"},
+			{"field": "permissions[0]", "reason": "is required"}
+		]
+	}`, string(data))
+}
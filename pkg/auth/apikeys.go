@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	clientIDLen     = 10 // number of random bytes in a client id (16 base32 characters)
+	clientSecretLen = 32 // number of random bytes in a client secret
+)
+
+// KeyAuthenticator verifies API key credentials from the client IP address and returns
+// the claims of the key. Verifying a secret is deliberately expensive, so implementations
+// must throttle failed attempts before verifying the secret.
+type KeyAuthenticator interface {
+	AuthenticateKey(ctx context.Context, clientIP, clientID, secret string) (*Claims, error)
+}
+
+// NewAPIKeyCredentials generates a random client ID and secret for a new API key.
+func NewAPIKeyCredentials() (clientID, secret string, err error) {
+	cid := make([]byte, clientIDLen)
+	if _, err = rand.Read(cid); err != nil {
+		return "", "", fmt.Errorf("could not generate client id: %w", err)
+	}
+
+	sec := make([]byte, clientSecretLen)
+	if _, err = rand.Read(sec); err != nil {
+		return "", "", fmt.Errorf("could not generate client secret: %w", err)
+	}
+
+	clientID = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(cid)
+	secret = base64.RawURLEncoding.EncodeToString(sec)
+	return clientID, secret, nil
+}
+
+// APIKeys authenticates API key credentials against the database. Verifying the
+// derived key of the secret is deliberately expensive, so verified credentials are
+// cached in memory for the cache TTL. This means that a deleted key may continue to be
+// accepted by other replicas until their cache entry expires.
+type APIKeys struct {
+	sync.RWMutex
+	ttl      time.Duration
+	cache    map[string]*verifiedKey
+	versions VersionChecker
+}
+
+type verifiedKey struct {
+	digest  [sha256.Size]byte
+	claims  *Claims
+	expires time.Time
+}
+
+func NewAPIKeys(ttl time.Duration) *APIKeys {
+	return &APIKeys{ttl: ttl, cache: make(map[string]*verifiedKey)}
+}
+
+// Cached returns the claims for the API key if the secret was recently verified, which
+// does not require the secret to be verified again. Returns false if the key is not
+// cached, if the secret does not match, or if the claims of the key are stale.
+func (k *APIKeys) Cached(ctx context.Context, clientID, secret string) (*Claims, bool) {
+	digest := sha256.Sum256([]byte(secret))
+
+	k.RLock()
+	cached, ok := k.cache[clientID]
+	k.RUnlock()
+
+	if !ok || !time.Now().Before(cached.expires) || subtle.ConstantTimeCompare(digest[:], cached.digest[:]) != 1 {
+		return nil, false
+	}
+
+	// Cached claims are recreated if the owner's permissions have changed
+	if current, err := k.current(ctx, cached.claims); err != nil || !current {
+		return nil, false
+	}
+
+	claims := *cached.claims
+	return &claims, true
+}
+
+// AuthenticateKey returns the claims for the API key if the secret is valid. Failed
+// attempts are not throttled, so callers must throttle clients before verifying keys.
+func (k *APIKeys) AuthenticateKey(ctx context.Context, clientID, secret string) (_ *Claims, err error) {
+	digest := sha256.Sum256([]byte(secret))
+
+	k.RLock()
+	cached, ok := k.cache[clientID]
+	k.RUnlock()
+
+	if ok && time.Now().Before(cached.expires) {
+		if subtle.ConstantTimeCompare(digest[:], cached.digest[:]) != 1 {
+			return nil, ErrInvalidAPIKey
+		}
+
+		// Cached claims are recreated if the owner's permissions have changed
+		var current bool
+		if current, err = k.current(ctx, cached.claims); err != nil {
+			return nil, err
+		}
+
+		if current {
+			claims := *cached.claims
+			return &claims, nil
+		}
+	}
+
+	var key *models.APIKey
+	if key, err = models.GetAPIKey(ctx, clientID); err != nil {
+		if errors.Is(db.Check(err), db.ErrNotFound) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	if key.Expired() {
+		return nil, ErrExpiredAPIKey
+	}
+
+	var verified bool
+	if verified, _, err = VerifyDerivedKey(key.Secret, secret); err != nil {
+		return nil, err
+	}
+
+	if !verified {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var claims *Claims
+	if claims, err = NewClaimsForAPIKey(ctx, key); err != nil {
+		return nil, err
+	}
+
+	// Last used is only updated when the cache is refreshed to limit database writes
+	if err = key.Used(ctx); err != nil {
+		log.Warn().Err(err).Str("client_id", clientID).Msg("could not update api key last used timestamp")
+	}
+
+	// Do not cache the key beyond its expiration
+	expires := time.Now().Add(k.ttl)
+	if key.Expires.Valid && key.Expires.Time.Before(expires) {
+		expires = key.Expires.Time
+	}
+
+	k.Lock()
+	k.cache[clientID] = &verifiedKey{digest: digest, claims: claims, expires: expires}
+	k.Unlock()
+
+	out := *claims
+	return &out, nil
+}
+
+// UseVersions configures the cache to recreate the claims of cached API keys when the
+// permissions of the API key's owner have changed.
+func (k *APIKeys) UseVersions(versions VersionChecker) {
+	k.versions = versions
+}
+
+func (k *APIKeys) current(ctx context.Context, claims *Claims) (bool, error) {
+	if k.versions == nil {
+		return true, nil
+	}
+	return k.versions.Current(ctx, claims)
+}
+
+// Revoke removes the API key from the cache so that it is immediately rejected by this
+// replica once it has been deleted from the database.
+func (k *APIKeys) Revoke(clientID string) {
+	k.Lock()
+	delete(k.cache, clientID)
+	k.Unlock()
+}
@@ -0,0 +1,35 @@
+package auth_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAPIKeyCredentials(t *testing.T) {
+	clientID, secret, err := NewAPIKeyCredentials()
+	require.NoError(t, err, "could not generate api key credentials")
+	require.Len(t, clientID, 16)
+	require.Len(t, secret, 43)
+
+	// Credentials must be usable with HTTP basic authentication
+	require.NotContains(t, clientID, ":")
+	require.Equal(t, strings.ToUpper(clientID), clientID)
+
+	// Credentials should be random
+	clientID2, secret2, err := NewAPIKeyCredentials()
+	require.NoError(t, err, "could not generate api key credentials")
+	require.NotEqual(t, clientID, clientID2)
+	require.NotEqual(t, secret, secret2)
+
+	// The secret should be storable as a derived key
+	dk, err := CreateDerivedKey(secret)
+	require.NoError(t, err, "could not create derived key from secret")
+
+	verified, outdated, err := VerifyDerivedKey(dk, secret)
+	require.NoError(t, err)
+	require.True(t, verified)
+	require.False(t, outdated)
+}
@@ -1,11 +1,14 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"regexp"
 	"time"
 
 	api "github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/logger"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
 )
@@ -15,6 +18,8 @@ const (
 	ContextUserClaims  = "user_claims"
 	ContextAccessToken = "access_token"
 	ContextRequestID   = "request_id"
+	contextVersions    = "permission_versions"
+	contextEnrollment  = "allow_mfa_enrollment"
 	AccessTokenCookie  = "access_token"
 	RefreshTokenCookie = "refresh_token"
 )
@@ -24,7 +29,11 @@ var (
 	bearer = regexp.MustCompile(`^\s*[Bb]earer\s+([a-zA-Z0-9_\-\.]+)\s*$`)
 )
 
-func Authenticate(issuer *ClaimsIssuer) gin.HandlerFunc {
+// Authenticate ensures that the request has a valid access token, adding the claims of
+// the token to the request context. If keys is not nil, requests may alternatively be
+// authenticated with API key credentials using HTTP basic authentication, where the
+// username is the client ID and the password is the client secret.
+func Authenticate(issuer *ClaimsIssuer, keys KeyAuthenticator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var (
 			err         error
@@ -32,37 +41,204 @@ func Authenticate(issuer *ClaimsIssuer) gin.HandlerFunc {
 			claims      *Claims
 		)
 
+		// Authenticate API key credentials if they are present in the request.
+		if clientID, secret, ok := c.Request.BasicAuth(); ok && keys != nil {
+			if claims, err = keys.AuthenticateKey(c.Request.Context(), c.ClientIP(), clientID, secret); err != nil {
+				log.Warn().Err(err).Str("client_id", clientID).Msg("invalid api key credentials in request")
+				api.Error(c, http.StatusUnauthorized, ErrAuthRequired)
+				return
+			}
+
+			setAuthContext(c, issuer, claims)
+			c.Next()
+			return
+		}
+
 		// Fetch access token from the request, if no access token is available, reject.
 		if accessToken, err = GetAccessToken(c); err != nil {
 			log.Debug().Err(err).Msg("no access token in authenticated request")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, api.ErrorResponse(ErrAuthRequired))
+			api.Error(c, http.StatusUnauthorized, ErrAuthRequired)
 			return
 		}
 
 		if claims, err = issuer.Verify(accessToken); err != nil {
 			log.Warn().Err(err).Msg("invalid access token in request")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, api.ErrorResponse(ErrAuthRequired))
+			api.Error(c, http.StatusUnauthorized, ErrAuthRequired)
+			return
+		}
+
+		// Requests authenticated by cookies must also pass the double submit cookie check
+		if CookieAuthenticated(c) {
+			if err = VerifyCSRF(c); err != nil {
+				log.Debug().Err(err).Msg("csrf verification failed")
+				api.Error(c, http.StatusForbidden, err)
+				return
+			}
+		}
+
+		// Claims restricted to enrolling a second factor are only allowed on the routes
+		// that are used to enroll.
+		if claims.Enrollment && !c.GetBool(contextEnrollment) {
+			log.Debug().Str("subject", claims.Subject).Msg("mfa enrollment token used on restricted endpoint")
+			api.Error(c, http.StatusForbidden, ErrMFARequired)
 			return
 		}
 
 		// Add claims to context fo ruse in downstream processing
-		c.Set(ContextUserClaims, claims)
+		setAuthContext(c, issuer, claims)
+		c.Next()
+	}
+}
+
+// AllowMFAEnrollment allows claims that are restricted to enrolling a second factor to
+// be used on the routes of the group; it must be used before Authenticate.
+func AllowMFAEnrollment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(contextEnrollment, true)
 		c.Next()
 	}
 }
 
+// setAuthContext adds the claims to the context along with the version checker of the
+// issuer so that Authorize can check that the permissions in the claims are current.
+// If an administrator is impersonating the user, they are added to the request logs.
+func setAuthContext(c *gin.Context, issuer *ClaimsIssuer, claims *Claims) {
+	c.Set(ContextUserClaims, claims)
+	if claims.Impersonated() {
+		c.Set(logger.ContextActor, claims.Actor.Subject)
+	}
+	if issuer != nil && issuer.versions != nil {
+		c.Set(contextVersions, issuer.versions)
+	}
+}
+
+// Authorize ensures that the user has all of the specified permissions; it must be used
+// after Authenticate. If the permissions of the user have changed since the claims were
+// issued the request is rejected as unauthenticated so that the client reauthenticates.
 func Authorize(permissions ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		claims, err := GetClaims(c)
 		if err != nil {
 			log.Warn().Err(err).Msg("no claims in request")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, api.ErrorResponse(ErrNotAuthorized))
+			api.Error(c, http.StatusUnauthorized, ErrNotAuthorized)
+			return
+		}
+
+		// Check if the claims are stale first since the user may have been granted the
+		// permissions since the claims were issued.
+		if err = checkVersions(c, claims); err != nil {
+			if errors.Is(err, ErrStalePermissions) {
+				log.Debug().Str("subject", claims.Subject).Msg("stale permissions in claims")
+				api.Error(c, http.StatusUnauthorized, ErrStalePermissions)
+				return
+			}
+
+			log.Error().Err(err).Msg("could not check permission versions")
+			api.Error(c, http.StatusInternalServerError, "could not authorize request")
 			return
 		}
 
 		if !claims.HasAllPermissions(permissions...) {
 			log.Warn().Err(err).Msg("user does not have required permissions")
-			c.AbortWithStatusJSON(http.StatusForbidden, api.ErrorResponse(ErrNotAuthorized))
+			api.Error(c, http.StatusForbidden, ErrNotAuthorized)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// checkVersions returns ErrStalePermissions if the permission versions in the claims
+// are not current; if no version checker is configured the claims are always current.
+func checkVersions(c *gin.Context, claims *Claims) (err error) {
+	return CheckVersions(c.Request.Context(), contextVersionChecker(c), claims)
+}
+
+// CheckVersions returns ErrStalePermissions if the permission versions in the claims
+// are not current according to the version checker; if the version checker is nil the
+// claims are always current.
+func CheckVersions(ctx context.Context, versions VersionChecker, claims *Claims) (err error) {
+	if versions == nil {
+		return nil
+	}
+
+	var current bool
+	if current, err = versions.Current(ctx, claims); err != nil {
+		return err
+	}
+
+	if !current {
+		return ErrStalePermissions
+	}
+	return nil
+}
+
+// contextVersionChecker returns the version checker added to the context by
+// Authenticate or nil if the issuer does not check permission versions.
+func contextVersionChecker(c *gin.Context) VersionChecker {
+	if val, ok := c.Get(contextVersions); ok {
+		return val.(VersionChecker)
+	}
+	return nil
+}
+
+// RequireAPIKey ensures that the request was authenticated with API key credentials,
+// e.g. for endpoints used by companion services; it must be used after Authenticate.
+func RequireAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := GetClaims(c)
+		if err != nil {
+			log.Warn().Err(err).Msg("no claims in request")
+			api.Error(c, http.StatusUnauthorized, ErrNotAuthorized)
+			return
+		}
+
+		if claims.ClientID == "" {
+			log.Debug().Msg("request was not authenticated with api key credentials")
+			api.Error(c, http.StatusUnauthorized, ErrAPIKeyRequired)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// DenyImpersonation rejects requests made by administrators that are impersonating a
+// user, e.g. for endpoints that change the user's credentials; it must be used after
+// Authenticate.
+func DenyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := GetClaims(c)
+		if err != nil {
+			log.Warn().Err(err).Msg("no claims in request")
+			api.Error(c, http.StatusUnauthorized, ErrNotAuthorized)
+			return
+		}
+
+		if claims.Impersonated() {
+			log.Debug().Str("actor", claims.Actor.Subject).Msg("impersonation token used on restricted endpoint")
+			api.Error(c, http.StatusForbidden, ErrImpersonation)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireMFA ensures that the user authenticated with a second factor before allowing
+// access to sensitive endpoints; it must be used after Authenticate.
+func RequireMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := GetClaims(c)
+		if err != nil {
+			log.Warn().Err(err).Msg("no claims in request")
+			api.Error(c, http.StatusUnauthorized, ErrNotAuthorized)
+			return
+		}
+
+		if !claims.HasMFA() {
+			log.Debug().Msg("user did not authenticate with multiple factors")
+			api.Error(c, http.StatusForbidden, ErrMFARequired)
 			return
 		}
 
@@ -115,7 +291,9 @@ func GetClaims(c *gin.Context) (*Claims, error) {
 // SetAuthCookies is a helper function to set authentication cookies on a gin request.
 // The access token cookie (access_token) is an http only cookie that expires when the
 // access token expires. The refresh token cookie is not an http only cookie (it can be
-// accessed by client-side scripts) and it expires when the refresh token expires. Both
+// accessed by client-side scripts) and it expires when the refresh token expires. A new
+// CSRF token cookie that expires with the refresh token is also set so that requests
+// authenticated by these cookies can be protected from cross-site request forgery. All
 // cookies require https and will not be set (silently) over http connections.
 func SetAuthCookies(c *gin.Context, accessToken, refreshToken, domain string) (err error) {
 	// Parse access token to get expiration time
@@ -137,7 +315,7 @@ func SetAuthCookies(c *gin.Context, accessToken, refreshToken, domain string) (e
 	// Set the refresh token cookie: httpOnly is false; can be accessed by Javascript
 	refreshMaxAge := int((time.Until(refreshExpires.Add(600 * time.Second))).Seconds())
 	c.SetCookie(RefreshTokenCookie, refreshToken, refreshMaxAge, "/", domain, true, false)
-	return nil
+	return SetCSRFCookie(c, refreshExpires, domain)
 }
 
 // ClearAuthCookies is a helper function to clear authentication cookies on a gin
@@ -145,4 +323,5 @@ func SetAuthCookies(c *gin.Context, accessToken, refreshToken, domain string) (e
 func ClearAuthCookies(c *gin.Context, domain string) {
 	c.SetCookie(AccessTokenCookie, "", -1, "/", domain, true, true)
 	c.SetCookie(RefreshTokenCookie, "", -1, "/", domain, true, false)
+	c.SetCookie(CSRFCookie, "", -1, "/", domain, true, false)
 }
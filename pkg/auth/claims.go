@@ -11,12 +11,35 @@ import (
 
 type Claims struct {
 	jwt.RegisteredClaims
-	Name        string   `json:"name,omitempty"`
-	Email       string   `json:"email,omitempty"`
-	Role        string   `json:"role,omitempty"`
-	Permissions []string `json:"permissions,omitempty"`
+	Name        string           `json:"name,omitempty"`
+	Email       string           `json:"email,omitempty"`
+	Role        string           `json:"role,omitempty"`
+	Permissions []string         `json:"permissions,omitempty"`
+	AMR         []string         `json:"amr,omitempty"`
+	ClientID    string           `json:"client_id,omitempty"`
+	AuthTime    *jwt.NumericDate `json:"auth_time,omitempty"`
+	UserVersion int64            `json:"uver,omitempty"`
+	RoleVersion int64            `json:"rver,omitempty"`
+	Actor       *Actor           `json:"act,omitempty"`
+	Enrollment  bool             `json:"mfa_enroll,omitempty"`
+	Refresh     bool             `json:"refresh,omitempty"`
 }
 
+// Actor identifies the administrator that is acting as the subject of the claims when
+// they are impersonating a user (the act claim of RFC 8693).
+type Actor struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+}
+
+// Authentication method references (RFC 8176) for the amr claim.
+const (
+	AMRPassword  = "pwd"
+	AMROTP       = "otp"
+	AMRMFA       = "mfa"
+	AMRFederated = "fed" // not registered by RFC 8176; signed in with an external identity provider
+)
+
 func NewClaimsForUser(ctx context.Context, u *models.User) (claims *Claims, err error) {
 	claims = &Claims{
 		Name:  u.Name.String,
@@ -31,6 +54,11 @@ func NewClaimsForUser(ctx context.Context, u *models.User) (claims *Claims, err
 	}
 	claims.Role = role.Title
 
+	// The versions must be fetched before the permissions so that the claims are
+	// considered stale if the permissions change while the claims are being created.
+	claims.UserVersion = u.Version
+	claims.RoleVersion = role.Version
+
 	var perms []*models.Permission
 	if perms, err = u.Permissions(ctx); err != nil {
 		return nil, err
@@ -44,6 +72,34 @@ func NewClaimsForUser(ctx context.Context, u *models.User) (claims *Claims, err
 	return claims, nil
 }
 
+// NewClaimsForAPIKey creates claims for the owner of the API key, limited to the
+// permissions granted to the key that the owner still has.
+func NewClaimsForAPIKey(ctx context.Context, key *models.APIKey) (claims *Claims, err error) {
+	var user *models.User
+	if user, err = models.GetUser(ctx, key.UserID); err != nil {
+		return nil, err
+	}
+
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+
+	if claims, err = NewClaimsForUser(ctx, user); err != nil {
+		return nil, err
+	}
+
+	scoped := make([]string, 0, len(key.Permissions()))
+	for _, perm := range key.Permissions() {
+		if claims.HasPermission(perm) {
+			scoped = append(scoped, perm)
+		}
+	}
+
+	claims.Permissions = scoped
+	claims.ClientID = key.ClientID
+	return claims, nil
+}
+
 func (c *Claims) SetSubjectID(uid int64) {
 	c.Subject = strconv.FormatInt(uid, 36)
 }
@@ -61,6 +117,48 @@ func (c Claims) HasPermission(required string) bool {
 	return false
 }
 
+// HasAMR returns true if the specified authentication method was used to authenticate.
+func (c Claims) HasAMR(method string) bool {
+	for _, amr := range c.AMR {
+		if amr == method {
+			return true
+		}
+	}
+	return false
+}
+
+// HasMFA returns true if the user authenticated with multiple factors.
+func (c Claims) HasMFA() bool {
+	return c.HasAMR(AMRMFA)
+}
+
+// AuthenticatedWithin returns true if the user logged in (rather than reauthenticated)
+// within the specified duration, e.g. to confirm sensitive changes to their account.
+func (c Claims) AuthenticatedWithin(d time.Duration) bool {
+	return c.AuthTime != nil && time.Since(c.AuthTime.Time) <= d
+}
+
+// RestrictToMFAEnrollment limits the claims to enrolling a second factor, e.g. when the
+// role of the user requires multi-factor authentication but the user has not enrolled.
+// The permissions of the user are removed and Authenticate rejects the claims except
+// on the routes that allow enrollment.
+func (c *Claims) RestrictToMFAEnrollment() {
+	c.Permissions = nil
+	c.Enrollment = true
+}
+
+// IsRefreshToken returns true if the claims are the claims of a refresh token, which
+// can only be exchanged for new tokens and cannot be used to access resources.
+func (c Claims) IsRefreshToken() bool {
+	return c.Refresh
+}
+
+// Impersonated returns true if the claims were issued to an administrator acting as
+// the subject rather than to the subject themselves.
+func (c Claims) Impersonated() bool {
+	return c.Actor != nil
+}
+
 func (c Claims) HasAllPermissions(required ...string) bool {
 	for _, perm := range required {
 		if !c.HasPermission(perm) {
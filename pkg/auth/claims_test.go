@@ -5,6 +5,7 @@ import (
 	"time"
 
 	. "github.com/bbengfort/cosmos/pkg/auth"
+	jwt "github.com/golang-jwt/jwt/v4"
 	"github.com/stretchr/testify/require"
 )
 
@@ -53,3 +54,12 @@ func TestNotBefore(t *testing.T) {
 	_, err = NotBefore("notarealtoken")
 	require.Error(t, err, "should not be able to parse a bad token")
 }
+
+func TestAuthenticatedWithin(t *testing.T) {
+	claims := &Claims{}
+	require.False(t, claims.AuthenticatedWithin(time.Hour), "claims without an auth time are not recent")
+
+	claims.AuthTime = jwt.NewNumericDate(time.Now().Add(-5 * time.Minute))
+	require.True(t, claims.AuthenticatedWithin(10*time.Minute))
+	require.False(t, claims.AuthenticatedWithin(time.Minute))
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	api "github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	CSRFCookie = "csrf_token"
+	CSRFHeader = "X-CSRF-TOKEN"
+	csrfLength = 32
+)
+
+// CSRF protects requests that are authenticated by cookies rather than by the
+// authorization header using the double submit cookie pattern: state changing requests
+// must include the value of the csrf_token cookie in the X-CSRF-TOKEN header. Because a
+// cross-site attacker cannot read the cookie, they cannot set the header. Authenticate
+// performs the same check, so this middleware is only needed on routes that read the
+// auth cookies without being authenticated, e.g. reauthenticate and logout.
+func CSRF() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if CookieAuthenticated(c) {
+			if err := VerifyCSRF(c); err != nil {
+				log.Debug().Err(err).Msg("csrf verification failed")
+				api.Error(c, http.StatusForbidden, err)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// CookieAuthenticated returns true if the request is a state changing request that
+// carries authentication cookies and no authorization header, e.g. a request made by a
+// browser on behalf of a web application.
+func CookieAuthenticated(c *gin.Context) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+
+	if c.GetHeader(authorization) != "" {
+		return false
+	}
+
+	for _, name := range []string{AccessTokenCookie, RefreshTokenCookie} {
+		if _, err := c.Cookie(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyCSRF returns ErrCSRFVerification unless the X-CSRF-TOKEN header of the request
+// matches the csrf_token cookie.
+func VerifyCSRF(c *gin.Context) error {
+	cookie, err := c.Cookie(CSRFCookie)
+	if err != nil || cookie == "" {
+		return ErrCSRFVerification
+	}
+
+	header := c.GetHeader(CSRFHeader)
+	if subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) != 1 {
+		return ErrCSRFVerification
+	}
+	return nil
+}
+
+// SetCSRFCookie sets a new random CSRF token cookie that expires at the specified time.
+// The cookie is not http only since the web application must read it to set the header.
+func SetCSRFCookie(c *gin.Context, expires time.Time, domain string) (err error) {
+	var token string
+	if token, err = CSRFToken(); err != nil {
+		return err
+	}
+
+	maxAge := int((time.Until(expires.Add(600 * time.Second))).Seconds())
+	c.SetCookie(CSRFCookie, token, maxAge, "/", domain, true, false)
+	return nil
+}
+
+// CSRFToken generates a new random CSRF token.
+func CSRFToken() (string, error) {
+	token := make([]byte, csrfLength)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
@@ -0,0 +1,68 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSRF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CSRF())
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	token, err := CSRFToken()
+	require.NoError(t, err)
+
+	testCases := []struct {
+		method   string
+		cookies  map[string]string
+		headers  map[string]string
+		expected int
+	}{
+		// Safe methods are not checked
+		{http.MethodGet, map[string]string{AccessTokenCookie: "tks"}, nil, http.StatusOK},
+		// Requests without auth cookies are not checked
+		{http.MethodPost, nil, nil, http.StatusOK},
+		{http.MethodPost, map[string]string{CSRFCookie: token}, nil, http.StatusOK},
+		// Requests authenticated by header are not checked
+		{http.MethodPost, map[string]string{AccessTokenCookie: "tks"}, map[string]string{"Authorization": "Bearer tks"}, http.StatusOK},
+		// Cookie authenticated requests require a matching header
+		{http.MethodPost, map[string]string{AccessTokenCookie: "tks"}, nil, http.StatusForbidden},
+		{http.MethodPost, map[string]string{RefreshTokenCookie: "tks", CSRFCookie: token}, nil, http.StatusForbidden},
+		{http.MethodPost, map[string]string{AccessTokenCookie: "tks"}, map[string]string{CSRFHeader: token}, http.StatusForbidden},
+		{http.MethodPost, map[string]string{AccessTokenCookie: "tks", CSRFCookie: token}, map[string]string{CSRFHeader: "foo"}, http.StatusForbidden},
+		{http.MethodPost, map[string]string{AccessTokenCookie: "tks", CSRFCookie: token}, map[string]string{CSRFHeader: token}, http.StatusOK},
+		{http.MethodPost, map[string]string{RefreshTokenCookie: "tks", CSRFCookie: token}, map[string]string{CSRFHeader: token}, http.StatusOK},
+	}
+
+	for i, tc := range testCases {
+		req := httptest.NewRequest(tc.method, "/", nil)
+		for name, value := range tc.cookies {
+			req.AddCookie(&http.Cookie{Name: name, Value: value})
+		}
+		for name, value := range tc.headers {
+			req.Header.Set(name, value)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, tc.expected, w.Code, "test case %d failed", i)
+	}
+}
+
+func TestCSRFToken(t *testing.T) {
+	a, err := CSRFToken()
+	require.NoError(t, err)
+	require.Len(t, a, 43)
+
+	b, err := CSRFToken()
+	require.NoError(t, err)
+	require.NotEqual(t, a, b)
+}
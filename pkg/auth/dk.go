@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"sync"
 
+	"github.com/bbengfort/cosmos/pkg/config"
 	"golang.org/x/crypto/argon2"
 )
 
@@ -19,19 +21,64 @@ import (
 // Argon2 constants for the derived key (dk) algorithm
 // See: https://cryptobook.nakov.com/mac-and-key-derivation/argon2
 const (
-	dkAlg  = "argon2id"        // the derived key algorithm
-	dkTime = uint32(1)         // draft RFC recommends time = 1
-	dkMem  = uint32(64 * 1024) // draft RFC recommends memory as ~64MB (or as much as possible)
-	dkProc = uint8(2)          // can be set to the number of available CPUs
-	dkSLen = 16                // the length of the salt to generate per user
-	dkKLen = uint32(32)        // the length of the derived key (32 bytes is the required key size for AES-256)
+	dkAlg  = "argon2id" // the derived key algorithm
+	dkSLen = 16         // the length of the salt to generate per user
+	dkKLen = uint32(32) // the length of the derived key (32 bytes is the required key size for AES-256)
 )
 
 // Argon2 variables for the derived key (dk) algorithm
 var (
-	dkParse = regexp.MustCompile(`^\$(?P<alg>[\w\d]+)\$v=(?P<ver>\d+)\$m=(?P<mem>\d+),t=(?P<time>\d+),p=(?P<procs>\d+)\$(?P<salt>[\+\/\=a-zA-Z0-9]+)\$(?P<key>[\+\/\=a-zA-Z0-9]+)$`)
+	dkParse  = regexp.MustCompile(`^\$(?P<alg>[\w\d]+)\$v=(?P<ver>\d+)\$m=(?P<mem>\d+),t=(?P<time>\d+),p=(?P<procs>\d+)\$(?P<salt>[\+\/\=a-zA-Z0-9]+)\$(?P<key>[\+\/\=a-zA-Z0-9]+)$`)
+	dkParams = DefaultDerivedKeyParams
+	dkDummy  string
+	dkMu     sync.RWMutex
 )
 
+// DerivedKeyParams are the Argon2 cost parameters used to create new derived keys. The
+// parameters are encoded into every derived key so that keys created with older
+// parameters can still be verified and identified as needing to be rehashed.
+type DerivedKeyParams struct {
+	Time    uint32 // the number of passes over the memory
+	Memory  uint32 // the amount of memory used in KiB
+	Threads uint8  // the number of threads used
+}
+
+// DefaultDerivedKeyParams are used if no parameters are configured.
+var DefaultDerivedKeyParams = DerivedKeyParams{
+	Time:    1,         // draft RFC recommends time = 1
+	Memory:  64 * 1024, // draft RFC recommends memory as ~64MB (or as much as possible)
+	Threads: 2,         // can be set to the number of available CPUs
+}
+
+// NewDerivedKeyParams returns the parameters specified by the auth configuration.
+func NewDerivedKeyParams(conf config.AuthConfig) DerivedKeyParams {
+	return DerivedKeyParams{
+		Time:    conf.Argon2Time,
+		Memory:  conf.Argon2Memory,
+		Threads: conf.Argon2Threads,
+	}
+}
+
+// SetDerivedKeyParams updates the parameters used to create new derived keys.
+func SetDerivedKeyParams(params DerivedKeyParams) error {
+	if params.Time == 0 || params.Memory == 0 || params.Threads == 0 {
+		return errors.New("derived key time, memory, and threads must be greater than zero")
+	}
+
+	dkMu.Lock()
+	dkParams = params
+	dkDummy = ""
+	dkMu.Unlock()
+	return nil
+}
+
+// GetDerivedKeyParams returns the parameters currently used to create derived keys.
+func GetDerivedKeyParams() DerivedKeyParams {
+	dkMu.RLock()
+	defer dkMu.RUnlock()
+	return dkParams
+}
+
 // CreateDerivedKey creates an encoded derived key with a random hash for the password.
 func CreateDerivedKey(password string) (_ string, err error) {
 	if password == "" {
@@ -43,25 +90,57 @@ func CreateDerivedKey(password string) (_ string, err error) {
 		return "", fmt.Errorf("could not generate %d length salt: %s", dkSLen, err)
 	}
 
-	dk := argon2.IDKey([]byte(password), salt, dkTime, dkMem, dkProc, dkKLen)
+	params := GetDerivedKeyParams()
+	dk := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, dkKLen)
 	b64salt := base64.StdEncoding.EncodeToString(salt)
 	b64dk := base64.StdEncoding.EncodeToString(dk)
-	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", dkAlg, argon2.Version, dkMem, dkTime, dkProc, b64salt, b64dk), nil
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", dkAlg, argon2.Version, params.Memory, params.Time, params.Threads, b64salt, b64dk), nil
 }
 
-// VerifyDerivedKey checks that the submitted password matches the derived key.
-func VerifyDerivedKey(dk, password string) (_ bool, err error) {
+// DummyDerivedKey returns a derived key created with the current parameters that does
+// not belong to any user. Passwords are verified against it when an account does not
+// exist so that the response takes as long as it would if the account existed, which
+// prevents accounts from being enumerated by timing logins.
+func DummyDerivedKey() string {
+	dkMu.RLock()
+	dummy := dkDummy
+	dkMu.RUnlock()
+
+	if dummy == "" {
+		var err error
+		if dummy, err = CreateDerivedKey("cosmos-dummy-password"); err != nil {
+			panic(fmt.Errorf("could not create dummy derived key: %w", err))
+		}
+
+		dkMu.Lock()
+		dkDummy = dummy
+		dkMu.Unlock()
+	}
+	return dummy
+}
+
+// VerifyDerivedKey checks that the submitted password matches the derived key. If the
+// password is verified but the derived key was created with parameters that differ
+// from the current parameters then outdated is true and the caller should create a new
+// derived key from the password to replace the stored key.
+func VerifyDerivedKey(dk, password string) (verified, outdated bool, err error) {
 	if dk == "" || password == "" {
-		return false, errors.New("cannot verify empty derived key or password")
+		return false, false, errors.New("cannot verify empty derived key or password")
 	}
 
 	dkb, salt, t, m, p, err := ParseDerivedKey(dk)
 	if err != nil {
-		return false, err
+		return false, false, err
 	}
 
 	vdk := argon2.IDKey([]byte(password), salt, t, m, p, uint32(len(dkb)))
-	return bytes.Equal(dkb, vdk), nil
+	if !bytes.Equal(dkb, vdk) {
+		return false, false, nil
+	}
+
+	params := GetDerivedKeyParams()
+	outdated = t != params.Time || m != params.Memory || p != params.Threads || len(salt) != dkSLen || uint32(len(dkb)) != dkKLen
+	return true, outdated, nil
 }
 
 // ParseDerivedKey returns the parts of the encoded derived key string.
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	. "github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/config"
 	"github.com/stretchr/testify/require"
 )
 
@@ -16,13 +17,15 @@ func TestDerivedKey(t *testing.T) {
 	passwd, err := CreateDerivedKey("theeaglefliesatmidnight")
 	require.NoError(t, err)
 
-	verified, err := VerifyDerivedKey(passwd, "theeaglefliesatmidnight")
+	verified, outdated, err := VerifyDerivedKey(passwd, "theeaglefliesatmidnight")
 	require.NoError(t, err)
 	require.True(t, verified)
+	require.False(t, outdated)
 
-	verified, err = VerifyDerivedKey(passwd, "thesearentthedroidsyourelookingfor")
+	verified, outdated, err = VerifyDerivedKey(passwd, "thesearentthedroidsyourelookingfor")
 	require.NoError(t, err)
 	require.False(t, verified)
+	require.False(t, outdated)
 
 	// Create a derived key from a password
 	passwd2, err := CreateDerivedKey("lightning")
@@ -33,52 +36,110 @@ func TestDerivedKey(t *testing.T) {
 func TestDerivedKeyDetail(t *testing.T) {
 	// Cannot verify empty derived key or password
 	errmsg := "cannot verify empty derived key or password"
-	_, err := VerifyDerivedKey("", "foo")
+	_, _, err := VerifyDerivedKey("", "foo")
 	require.EqualError(t, err, errmsg)
-	_, err = VerifyDerivedKey("foo", "")
+	_, _, err = VerifyDerivedKey("foo", "")
 	require.EqualError(t, err, errmsg)
 
 	// Parse failures
 	errmsg = "cannot parse encoded derived key, does not match regular expression"
-	_, err = VerifyDerivedKey("notarealkey", "supersecretpassword")
+	_, _, err = VerifyDerivedKey("notarealkey", "supersecretpassword")
 	require.EqualError(t, err, errmsg)
 
 	dk := "$pbkdf2$v=19$m=65536,t=1,p=2$FrAEw4rWRDpyIZXR/QSzpg==$chQikgApfQfSaPZ7idk6caqBk79xRalpPUs4Ro/hywM="
 	errmsg = "current code only works with the the dk protcol \"argon2id\" not \"pbkdf2\""
-	_, err = VerifyDerivedKey(dk, "supersecretpassword")
+	_, _, err = VerifyDerivedKey(dk, "supersecretpassword")
 	require.EqualError(t, err, errmsg)
 
 	dk = "$argon2id$v=13212$m=65536,t=1,p=2$FrAEw4rWRDpyIZXR/QSzpg==$chQikgApfQfSaPZ7idk6caqBk79xRalpPUs4Ro/hywM="
 	errmsg = "expected argon2id version 19 got \"13212\""
-	_, err = VerifyDerivedKey(dk, "supersecretpassword")
+	_, _, err = VerifyDerivedKey(dk, "supersecretpassword")
 	require.EqualError(t, err, errmsg)
 
 	dk = "$argon2id$v=19$m=65536,t=999999999999999999,p=2$FrAEw4rWRDpyIZXR/QSzpg==$chQikgApfQfSaPZ7idk6caqBk79xRalpPUs4Ro/hywM="
 	errmsg = "could not parse time \"999999999999999999\": strconv.ParseUint: parsing \"999999999999999999\": value out of range"
-	_, err = VerifyDerivedKey(dk, "supersecretpassword")
+	_, _, err = VerifyDerivedKey(dk, "supersecretpassword")
 	require.EqualError(t, err, errmsg)
 
 	dk = "$argon2id$v=19$m=999999999999999999,t=1,p=2$FrAEw4rWRDpyIZXR/QSzpg==$chQikgApfQfSaPZ7idk6caqBk79xRalpPUs4Ro/hywM="
 	errmsg = "could not parse memory \"999999999999999999\": strconv.ParseUint: parsing \"999999999999999999\": value out of range"
-	_, err = VerifyDerivedKey(dk, "supersecretpassword")
+	_, _, err = VerifyDerivedKey(dk, "supersecretpassword")
 	require.EqualError(t, err, errmsg)
 
 	dk = "$argon2id$v=19$m=65536,t=1,p=999999999999999999$FrAEw4rWRDpyIZXR/QSzpg==$chQikgApfQfSaPZ7idk6caqBk79xRalpPUs4Ro/hywM="
 	errmsg = "could not parse threads \"999999999999999999\": strconv.ParseUint: parsing \"999999999999999999\": value out of range"
-	_, err = VerifyDerivedKey(dk, "supersecretpassword")
+	_, _, err = VerifyDerivedKey(dk, "supersecretpassword")
 	require.EqualError(t, err, errmsg)
 
 	dk = "$argon2id$v=19$m=65536,t=1,p=2$==FrAEw4rWRDpyIZXR/QSzpg==$chQikgApfQfSaPZ7idk6caqBk79xRalpPUs4Ro/hywM="
 	errmsg = "could not parse salt: illegal base64 data at input byte 0"
-	_, err = VerifyDerivedKey(dk, "supersecretpassword")
+	_, _, err = VerifyDerivedKey(dk, "supersecretpassword")
 	require.EqualError(t, err, errmsg)
 
 	dk = "$argon2id$v=19$m=65536,t=1,p=2$FrAEw4rWRDpyIZXR/QSzpg==$==chQikgApfQfSaPZ7idk6caqBk79xRalpPUs4Ro/hywM="
 	errmsg = "could not parse derived key: illegal base64 data at input byte 0"
-	_, err = VerifyDerivedKey(dk, "supersecretpassword")
+	_, _, err = VerifyDerivedKey(dk, "supersecretpassword")
 	require.EqualError(t, err, errmsg)
 }
 
+func TestDerivedKeyParams(t *testing.T) {
+	// Restore the default parameters when the test is complete
+	t.Cleanup(func() { SetDerivedKeyParams(DefaultDerivedKeyParams) })
+
+	// Parameters must be greater than zero
+	require.Error(t, SetDerivedKeyParams(DerivedKeyParams{Time: 0, Memory: 1024, Threads: 1}))
+	require.Error(t, SetDerivedKeyParams(DerivedKeyParams{Time: 1, Memory: 0, Threads: 1}))
+	require.Error(t, SetDerivedKeyParams(DerivedKeyParams{Time: 1, Memory: 1024, Threads: 0}))
+
+	// Parameters are specified by the auth configuration
+	conf := config.AuthConfig{Argon2Time: 3, Argon2Memory: 4096, Argon2Threads: 4}
+	require.Equal(t, DerivedKeyParams{Time: 3, Memory: 4096, Threads: 4}, NewDerivedKeyParams(conf))
+
+	// Create a derived key with weak parameters
+	weak := DerivedKeyParams{Time: 1, Memory: 1024, Threads: 1}
+	require.NoError(t, SetDerivedKeyParams(weak))
+	require.Equal(t, weak, GetDerivedKeyParams())
+
+	passwd, err := CreateDerivedKey("theeaglefliesatmidnight")
+	require.NoError(t, err)
+	require.Contains(t, passwd, "$m=1024,t=1,p=1$")
+
+	verified, outdated, err := VerifyDerivedKey(passwd, "theeaglefliesatmidnight")
+	require.NoError(t, err)
+	require.True(t, verified)
+	require.False(t, outdated, "key created with the current parameters should not be outdated")
+
+	// Upgrade the parameters; the key should still verify but be outdated
+	require.NoError(t, SetDerivedKeyParams(DerivedKeyParams{Time: 2, Memory: 2048, Threads: 1}))
+	verified, outdated, err = VerifyDerivedKey(passwd, "theeaglefliesatmidnight")
+	require.NoError(t, err)
+	require.True(t, verified)
+	require.True(t, outdated, "key created with old parameters should be outdated")
+
+	// An incorrect password is never reported as outdated
+	verified, outdated, err = VerifyDerivedKey(passwd, "thesearentthedroidsyourelookingfor")
+	require.NoError(t, err)
+	require.False(t, verified)
+	require.False(t, outdated)
+
+	// The dummy key is created with the current parameters so that it takes as long to
+	// verify as the keys of users and never verifies a password
+	require.Contains(t, DummyDerivedKey(), "$m=2048,t=2,p=1$")
+	verified, _, err = VerifyDerivedKey(DummyDerivedKey(), "theeaglefliesatmidnight")
+	require.NoError(t, err)
+	require.False(t, verified)
+
+	// Rehashing the password creates a key with the current parameters
+	passwd, err = CreateDerivedKey("theeaglefliesatmidnight")
+	require.NoError(t, err)
+	require.Contains(t, passwd, "$m=2048,t=2,p=1$")
+
+	verified, outdated, err = VerifyDerivedKey(passwd, "theeaglefliesatmidnight")
+	require.NoError(t, err)
+	require.True(t, verified)
+	require.False(t, outdated)
+}
+
 func TestIsDerivedKey(t *testing.T) {
 	testCases := []struct {
 		input  string
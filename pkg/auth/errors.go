@@ -1,6 +1,10 @@
 package auth
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+)
 
 var (
 	ErrUnknownSigningKey = errors.New("unknown signing key")
@@ -19,4 +23,17 @@ var (
 	ErrParseBearer       = errors.New("could not parse Bearer token from Authorization header")
 	ErrNoAuthorization   = errors.New("no authorization header in request")
 	ErrNoRefreshToken    = errors.New("cannot reauthenticate no refresh token in request")
+	ErrMFARequired       = api.NewError(api.CodeMFARequired, "this endpoint requires multi-factor authentication")
+	ErrInvalidAPIKey     = errors.New("invalid api key credentials")
+	ErrExpiredAPIKey     = errors.New("api key has expired")
+	ErrAccountDisabled   = api.NewError(api.CodeAccountDisabled, "account has been disabled")
+	ErrTokenRevoked      = errors.New("token has been revoked")
+	ErrStalePermissions  = api.NewError(api.CodeStalePermissions, "permissions have changed, reauthenticate to continue")
+	ErrGalaxyNotFound    = errors.New("galaxy not found")
+	ErrNoGalaxy          = errors.New("no galaxy found on the request context")
+	ErrNoPlayer          = errors.New("no player found on the request context")
+	ErrCSRFVerification  = api.NewError(api.CodeCSRFFailed, "csrf verification failed")
+	ErrNoTokenID         = errors.New("token does not have a jti claim")
+	ErrAPIKeyRequired    = api.NewError(api.CodeAPIKeyRequired, "this endpoint requires api key credentials")
+	ErrImpersonation     = api.NewError(api.CodeImpersonation, "this endpoint cannot be used while impersonating a user")
 )
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	api "github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	ContextPlayer = "galaxy_player"
+	ContextGalaxy = "galaxy_id"
+
+	// Users with this global permission can moderate every galaxy on the server.
+	ManageGalaxies = "games:manage"
+)
+
+// AuthorizeGalaxy ensures that the user is a player in the galaxy identified by the id
+// route parameter and that the galaxy role of their player grants all of the specified
+// permissions; it must be used after Authenticate. The player is added to the context.
+// Users who are not players are told that the galaxy does not exist so that galaxies
+// cannot be discovered, unless they have permission to manage all galaxies. API keys
+// must also have been granted the galaxy permissions to be authorized.
+func AuthorizeGalaxy(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var (
+			err      error
+			galaxyID int64
+			claims   *Claims
+			player   *models.Player
+		)
+
+		if claims, err = GetClaims(c); err != nil {
+			log.Warn().Err(err).Msg("no claims in request")
+			api.Error(c, http.StatusUnauthorized, ErrNotAuthorized)
+			return
+		}
+
+		if galaxyID, err = strconv.ParseInt(c.Param("id"), 10, 64); err != nil {
+			api.Error(c, http.StatusBadRequest, api.ErrMissingID)
+			return
+		}
+
+		if player, err = AuthorizePlayer(c.Request.Context(), contextVersionChecker(c), claims, galaxyID, permissions...); err != nil {
+			switch {
+			case errors.Is(err, ErrNotAuthorized):
+				api.Error(c, http.StatusForbidden, ErrNotAuthorized)
+			case errors.Is(err, ErrGalaxyNotFound):
+				api.Error(c, http.StatusNotFound, ErrGalaxyNotFound)
+			case errors.Is(err, ErrStalePermissions):
+				api.Error(c, http.StatusUnauthorized, ErrStalePermissions)
+			default:
+				log.Error().Err(err).Msg("could not authorize galaxy request")
+				api.Error(c, http.StatusInternalServerError, "could not authorize request")
+			}
+			return
+		}
+
+		setGalaxyContext(c, galaxyID, player)
+		c.Next()
+	}
+}
+
+// AuthorizePlayer checks that the user of the claims is authorized to access the
+// galaxy with the specified permissions as described by AuthorizeGalaxy and returns
+// their player, which is nil for server moderators who are not players in the galaxy.
+// Returns ErrNotAuthorized, ErrGalaxyNotFound, or ErrStalePermissions if the user is
+// not authorized. The version checker is used to check the claims of moderators.
+func AuthorizePlayer(ctx context.Context, versions VersionChecker, claims *Claims, galaxyID int64, permissions ...string) (player *models.Player, err error) {
+	var userID int64
+	if userID, err = claims.SubjectID(); err != nil {
+		log.Warn().Err(err).Msg("could not parse user ID from claims")
+		return nil, ErrNotAuthorized
+	}
+
+	// API keys are limited to the permissions they were granted
+	if claims.ClientID != "" && !claims.HasAllPermissions(permissions...) {
+		log.Debug().Str("client_id", claims.ClientID).Msg("api key does not have required galaxy permissions")
+		return nil, ErrNotAuthorized
+	}
+
+	if player, err = models.GetPlayer(ctx, galaxyID, userID); err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			return nil, fmt.Errorf("could not fetch player from database: %w", err)
+		}
+		player = nil
+	}
+
+	if err = CheckPlayer(ctx, versions, claims, galaxyID, player, permissions...); err != nil {
+		return nil, err
+	}
+	return player, nil
+}
+
+// CheckPlayer authorizes the user of the claims like AuthorizePlayer with their player
+// in the galaxy, which has already been fetched and is nil if the user is not a player,
+// so that the user can be authorized in many galaxies without a query per galaxy.
+func CheckPlayer(ctx context.Context, versions VersionChecker, claims *Claims, galaxyID int64, player *models.Player, permissions ...string) (err error) {
+	var userID int64
+	if userID, err = claims.SubjectID(); err != nil {
+		log.Warn().Err(err).Msg("could not parse user ID from claims")
+		return ErrNotAuthorized
+	}
+
+	// API keys are limited to the permissions they were granted
+	if claims.ClientID != "" && !claims.HasAllPermissions(permissions...) {
+		log.Debug().Str("client_id", claims.ClientID).Msg("api key does not have required galaxy permissions")
+		return ErrNotAuthorized
+	}
+
+	// Server moderators are authorized for all galaxies but their global claims must
+	// be current since their galaxy permissions are granted by the claims.
+	if claims.HasPermission(ManageGalaxies) {
+		return CheckVersions(ctx, versions, claims)
+	}
+
+	if player == nil {
+		return ErrGalaxyNotFound
+	}
+
+	for _, permission := range permissions {
+		var ok bool
+		if ok, err = player.HasPermission(ctx, permission); err != nil {
+			return fmt.Errorf("could not fetch player permissions from database: %w", err)
+		}
+
+		if !ok {
+			log.Debug().Int64("galaxy_id", galaxyID).Int64("user_id", userID).Str("permission", permission).Msg("player does not have required galaxy permission")
+			return ErrNotAuthorized
+		}
+	}
+	return nil
+}
+
+func setGalaxyContext(c *gin.Context, galaxyID int64, player *models.Player) {
+	c.Set(ContextGalaxy, galaxyID)
+	if player != nil {
+		c.Set(ContextPlayer, player)
+	}
+}
+
+// GetGalaxyID returns the ID of the galaxy authorized by AuthorizeGalaxy.
+func GetGalaxyID(c *gin.Context) (int64, error) {
+	galaxyID, ok := c.Get(ContextGalaxy)
+	if !ok {
+		return 0, ErrNoGalaxy
+	}
+	return galaxyID.(int64), nil
+}
+
+// GetPlayer returns the player of the authenticated user in the galaxy authorized by
+// AuthorizeGalaxy. Users that manage all galaxies may not be players in the galaxy.
+func GetPlayer(c *gin.Context) (*models.Player, error) {
+	player, ok := c.Get(ContextPlayer)
+	if !ok {
+		return nil, ErrNoPlayer
+	}
+	return player.(*models.Player), nil
+}
@@ -0,0 +1,112 @@
+package auth_test
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bbengfort/cosmos/internal/dbtest"
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorizeGalaxy(t *testing.T) {
+	tm, err := auth.NewIssuer(config.AuthConfig{
+		Keys:           map[string]string{"01GE62EXXR0X0561XD53RDFBQJ": "testdata/01GE62EXXR0X0561XD53RDFBQJ.pem"},
+		Audience:       "http://localhost:3000",
+		Issuer:         "http://localhost:3000",
+		AccessTokenTTL: time.Hour,
+	})
+	require.NoError(t, err, "could not initialize token manager")
+
+	versions := auth.NewPermissionVersions(100)
+	versions.Update(42, models.PermissionVersion{UserVersion: 2, Role: "Admin", RoleVersion: 1})
+	tm.UseVersions(versions)
+
+	mock := dbtest.Connect(t)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/galaxy/:id", auth.Authenticate(tm, nil), auth.AuthorizeGalaxy("galaxy:play"), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	})
+
+	request := func(claims *auth.Claims) *httptest.ResponseRecorder {
+		claims.SetSubjectID(42)
+		tks, _, err := tm.CreateTokens(claims)
+		require.NoError(t, err, "could not create access token")
+
+		req := httptest.NewRequest(http.MethodGet, "/galaxy/7", nil)
+		req.Header.Set("Authorization", "Bearer "+tks)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Users who are not players are told that the galaxy does not exist
+	expectPlayer(mock, nil)
+	w := request(&auth.Claims{Permissions: []string{"galaxy:play"}})
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Contains(t, w.Body.String(), auth.ErrGalaxyNotFound.Error())
+
+	// Players whose galaxy role does not grant the permission are forbidden
+	expectPlayer(mock, []string{"galaxy:observe"})
+	w = request(&auth.Claims{})
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	// Players whose galaxy role grants the permission are authorized
+	expectPlayer(mock, []string{"galaxy:observe", "galaxy:play"})
+	w = request(&auth.Claims{})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Moderators are authorized for every galaxy only with current claims
+	expectPlayer(mock, nil)
+	w = request(&auth.Claims{Permissions: []string{auth.ManageGalaxies}, Role: "Admin", UserVersion: 1, RoleVersion: 1})
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Contains(t, w.Body.String(), api.CodeStalePermissions)
+
+	expectPlayer(mock, nil)
+	w = request(&auth.Claims{Permissions: []string{auth.ManageGalaxies}, Role: "Admin", UserVersion: 2, RoleVersion: 1})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// API keys must be granted the galaxy permission even if the player has it
+	w = request(&auth.Claims{ClientID: "bot", Permissions: []string{"galaxy:observe"}})
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// expectPlayer expects the player to be fetched with a galaxy role that grants the
+// permissions; if permissions is nil the user is not a player in the galaxy.
+func expectPlayer(mock sqlmock.Sqlmock, permissions []string) {
+	now := time.Now()
+	mock.ExpectBegin()
+	if permissions == nil {
+		mock.ExpectQuery("SELECT \\* FROM players").WithArgs(7, 42).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+		return
+	}
+
+	mock.ExpectQuery("SELECT \\* FROM players").WithArgs(7, 42).WillReturnRows(
+		sqlmock.NewRows([]string{"galaxy_id", "player_id", "role_id", "home_system_id", "name", "faction", "character", "created", "modified"}).
+			AddRow(7, 42, 3, nil, "Kate", []byte("harmony"), []byte("benevolent"), now, now),
+	)
+	mock.ExpectQuery("FROM roles WHERE id=").WithArgs(3).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "title", "description", "is_default", "require_mfa", "version", "created", "modified"}).
+			AddRow(3, "Player", nil, true, false, 1, now, now),
+	)
+
+	rows := sqlmock.NewRows([]string{"id", "title", "description", "created", "modified"})
+	for i, permission := range permissions {
+		rows.AddRow(i+1, permission, nil, now, now)
+	}
+	mock.ExpectQuery("FROM role_permissions").WithArgs(3).WillReturnRows(rows)
+	mock.ExpectCommit()
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/rs/zerolog/log"
+)
+
+// RevocationChecker determines if the claims of an otherwise valid token have been
+// revoked, e.g. because the user changed their password or deleted their account,
+// because the API key the token was issued to was deleted, or because the token itself
+// was revoked.
+type RevocationChecker interface {
+	Revoked(claims *Claims) bool
+}
+
+// Revocations caches the token revocations stored in the database so that tokens can
+// be checked without a database query on every request. Revocations made on this
+// replica take effect immediately; revocations made by other replicas take effect when
+// the cache is next refreshed.
+type Revocations struct {
+	sync.RWMutex
+	maxAge  time.Duration
+	revoked map[int64]time.Time
+	tokens  map[string]time.Time
+	clients map[string]time.Time
+}
+
+var _ RevocationChecker = &Revocations{}
+
+// NewRevocations creates a revocation cache; maxAge should be the maximum lifetime of
+// any token so that revocations can be discarded once all revoked tokens have expired.
+func NewRevocations(maxAge time.Duration) *Revocations {
+	return &Revocations{maxAge: maxAge, revoked: make(map[int64]time.Time), tokens: make(map[string]time.Time), clients: make(map[string]time.Time)}
+}
+
+// Revoked returns true if the token or the API key it was issued to was revoked or if
+// the claims were issued before the subject's tokens were revoked. Tokens issued in the same second as the revocation are
+// not revoked, since tokens issued to the user after the revocation may be issued in
+// the same second.
+func (r *Revocations) Revoked(claims *Claims) bool {
+	r.RLock()
+	_, revoked := r.tokens[claims.ID]
+	if !revoked && claims.ClientID != "" {
+		_, revoked = r.clients[claims.ClientID]
+	}
+	r.RUnlock()
+
+	if revoked {
+		return true
+	}
+
+	userID, err := claims.SubjectID()
+	if err != nil {
+		return false
+	}
+
+	r.RLock()
+	before, ok := r.revoked[userID]
+	r.RUnlock()
+
+	if !ok {
+		return false
+	}
+
+	if claims.IssuedAt == nil {
+		return true
+	}
+	return claims.IssuedAt.Time.Before(before)
+}
+
+// Revoke all tokens issued to the user up to now, storing the revocation in the database.
+func (r *Revocations) Revoke(ctx context.Context, userID int64) (err error) {
+	before := time.Now().Truncate(time.Second)
+	if err = models.RevokeTokens(ctx, userID, before); err != nil {
+		return err
+	}
+
+	r.Add(userID, before)
+	return nil
+}
+
+// RevokeToken revokes the token and any tokens issued with it (access and refresh tokens
+// share an ID), storing the revocation in the database until the tokens expire.
+func (r *Revocations) RevokeToken(ctx context.Context, claims *Claims) (err error) {
+	var userID int64
+	if userID, err = claims.SubjectID(); err != nil {
+		return err
+	}
+
+	if claims.ID == "" {
+		return ErrNoTokenID
+	}
+
+	// The refresh token issued with the token expires at most maxAge after issuance
+	expires := time.Now().Add(r.maxAge)
+	if claims.IssuedAt != nil {
+		expires = claims.IssuedAt.Add(r.maxAge)
+	}
+
+	if err = models.RevokeToken(ctx, claims.ID, userID, expires); err != nil {
+		return err
+	}
+
+	r.AddToken(claims.ID, expires)
+	return nil
+}
+
+// RevokeClient revokes every token issued to the API key with the client ID, storing the
+// revocation in the database until the tokens expire. Client IDs are not reused, so it
+// is used when the API key is deleted.
+func (r *Revocations) RevokeClient(ctx context.Context, userID int64, clientID string) (err error) {
+	expires := time.Now().Add(r.maxAge)
+	if err = models.RevokeClient(ctx, clientID, userID, expires); err != nil {
+		return err
+	}
+
+	r.AddClient(clientID, expires)
+	return nil
+}
+
+// AddClient adds a revoked API key to the cache without storing it in the database.
+func (r *Revocations) AddClient(clientID string, expires time.Time) {
+	r.Lock()
+	r.clients[clientID] = expires
+	r.Unlock()
+}
+
+// AddToken adds a revoked token to the cache without storing it in the database.
+func (r *Revocations) AddToken(tokenID string, expires time.Time) {
+	r.Lock()
+	r.tokens[tokenID] = expires
+	r.Unlock()
+}
+
+// Add a revocation to the cache without storing it in the database.
+func (r *Revocations) Add(userID int64, before time.Time) {
+	r.Lock()
+	if prev, ok := r.revoked[userID]; !ok || before.After(prev) {
+		r.revoked[userID] = before
+	}
+	r.Unlock()
+}
+
+// Apply a token revocations notification payload to the cache; the payload is the user
+// ID and the unix timestamp that tokens issued before are revoked, e.g. "42:1700000000"
+// the ID of a revoked token and the unix timestamp it expires, e.g. "jti:ID:1700000000",
+// or the client ID of a revoked API key and the unix timestamp its tokens expire, e.g.
+// "client:ID:1700000000".
+func (r *Revocations) Apply(payload string) (err error) {
+	if client, ok := strings.CutPrefix(payload, "client:"); ok {
+		var expires int64
+		sep := strings.LastIndex(client, ":")
+		if sep <= 0 {
+			return fmt.Errorf("could not parse client revocation %q", payload)
+		}
+
+		if expires, err = strconv.ParseInt(client[sep+1:], 10, 64); err != nil {
+			return fmt.Errorf("could not parse client revocation %q", payload)
+		}
+
+		r.AddClient(client[:sep], time.Unix(expires, 0))
+		return nil
+	}
+
+	if token, ok := strings.CutPrefix(payload, "jti:"); ok {
+		var expires int64
+		id, ts, _ := strings.Cut(token, ":")
+		if expires, err = strconv.ParseInt(ts, 10, 64); err != nil || id == "" {
+			return fmt.Errorf("could not parse token revocation %q", payload)
+		}
+
+		r.AddToken(id, time.Unix(expires, 0))
+		return nil
+	}
+
+	var userID, before int64
+	id, ts, _ := strings.Cut(payload, ":")
+
+	if userID, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return fmt.Errorf("could not parse token revocation %q: %w", payload, err)
+	}
+
+	if before, err = strconv.ParseInt(ts, 10, 64); err != nil {
+		return fmt.Errorf("could not parse token revocation %q: %w", payload, err)
+	}
+
+	r.Add(userID, time.Unix(before, 0))
+	return nil
+}
+
+// Refresh replaces the cache with the revocations stored in the database.
+func (r *Revocations) Refresh(ctx context.Context) (err error) {
+	var revoked map[int64]time.Time
+	if revoked, err = models.TokenRevocations(ctx, time.Now().Add(-r.maxAge)); err != nil {
+		return err
+	}
+
+	var tokens map[string]time.Time
+	if tokens, err = models.RevokedTokens(ctx); err != nil {
+		return err
+	}
+
+	var clients map[string]time.Time
+	if clients, err = models.RevokedClients(ctx); err != nil {
+		return err
+	}
+
+	r.Lock()
+	r.revoked = revoked
+	r.tokens = tokens
+	r.clients = clients
+	r.Unlock()
+	return nil
+}
+
+// Run refreshes the cache at the specified interval until the context is canceled.
+func (r *Revocations) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Refresh(ctx); err != nil && ctx.Err() == nil {
+			log.Warn().Err(err).Msg("could not refresh token revocations")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package auth_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/bbengfort/cosmos/pkg/auth"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevocations(t *testing.T) {
+	revocations := NewRevocations(48 * time.Hour)
+	now := time.Now().Truncate(time.Second)
+
+	claims := &Claims{}
+	claims.SetSubjectID(42)
+	claims.IssuedAt = jwt.NewNumericDate(now.Add(-time.Hour))
+	require.False(t, revocations.Revoked(claims), "no revocations for the user")
+
+	revocations.Add(42, now)
+	require.True(t, revocations.Revoked(claims), "tokens issued before revocation should be revoked")
+
+	// Tokens issued in the same second or after the revocation are not revoked
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	require.False(t, revocations.Revoked(claims))
+
+	claims.IssuedAt = jwt.NewNumericDate(now.Add(time.Minute))
+	require.False(t, revocations.Revoked(claims))
+
+	// Earlier revocations do not replace later revocations
+	revocations.Add(42, now.Add(-2*time.Hour))
+	claims.IssuedAt = jwt.NewNumericDate(now.Add(-time.Hour))
+	require.True(t, revocations.Revoked(claims))
+
+	// Tokens without an issued at timestamp are revoked
+	claims.IssuedAt = nil
+	require.True(t, revocations.Revoked(claims))
+
+	// Other users are not affected
+	other := &Claims{}
+	other.SetSubjectID(7)
+	other.IssuedAt = jwt.NewNumericDate(now.Add(-time.Hour))
+	require.False(t, revocations.Revoked(other))
+}
+
+func TestRevocationsApply(t *testing.T) {
+	revocations := NewRevocations(48 * time.Hour)
+	now := time.Now().Truncate(time.Second)
+
+	claims := &Claims{}
+	claims.SetSubjectID(42)
+	claims.IssuedAt = jwt.NewNumericDate(now.Add(-time.Minute))
+
+	require.NoError(t, revocations.Apply(fmt.Sprintf("42:%d", now.Unix())))
+	require.True(t, revocations.Revoked(claims))
+
+	require.Error(t, revocations.Apply("42"))
+	require.Error(t, revocations.Apply("foo:1700000000"))
+	require.Error(t, revocations.Apply("42:foo"))
+
+	// Revoked tokens are identified by their ID
+	token := &Claims{}
+	token.SetSubjectID(7)
+	token.ID = "01HGW6ZQ2V1AXNSM4C8TK6QZ3E"
+	token.IssuedAt = jwt.NewNumericDate(now)
+	require.False(t, revocations.Revoked(token))
+
+	require.NoError(t, revocations.Apply(fmt.Sprintf("jti:%s:%d", token.ID, now.Add(time.Hour).Unix())))
+	require.True(t, revocations.Revoked(token))
+
+	other := &Claims{}
+	other.SetSubjectID(7)
+	other.ID = "01HGW70B3N5QXK0Y7T9VZ2M8JD"
+	other.IssuedAt = jwt.NewNumericDate(now)
+	require.False(t, revocations.Revoked(other), "other tokens of the user should not be revoked")
+
+	require.Error(t, revocations.Apply("jti::1700000000"))
+	require.Error(t, revocations.Apply("jti:01HGW70B3N5QXK0Y7T9VZ2M8JD:foo"))
+
+	// Revoked API keys are identified by their client ID
+	bot := &Claims{ClientID: "bot"}
+	bot.SetSubjectID(7)
+	bot.ID = "01HGW72D8M4PQX6J3WCN0R5T9B"
+	bot.IssuedAt = jwt.NewNumericDate(now)
+	require.False(t, revocations.Revoked(bot))
+
+	require.NoError(t, revocations.Apply(fmt.Sprintf("client:bot:%d", now.Add(time.Hour).Unix())))
+	require.True(t, revocations.Revoked(bot))
+	require.False(t, revocations.Revoked(other), "tokens of the user without the client id should not be revoked")
+
+	require.Error(t, revocations.Apply("client::1700000000"))
+	require.Error(t, revocations.Apply("client:bot"))
+	require.Error(t, revocations.Apply("client:bot:foo"))
+}
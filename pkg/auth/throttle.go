@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+)
+
+// LoginThrottle implements brute-force protection for logins. Each consecutive failed
+// login doubles the amount of time that must pass before another attempt is allowed,
+// and user accounts are temporarily locked after too many consecutive failures. Client
+// addresses are never locked (since many users may share an IP address) but are subject
+// to the same exponential backoff. Failures are forgotten once the lockout duration has
+// passed without another failed attempt.
+type LoginThrottle struct {
+	attempts int
+	lockout  time.Duration
+	backoff  time.Duration
+	maxDelay time.Duration
+}
+
+func NewLoginThrottle(conf config.AuthConfig) *LoginThrottle {
+	return &LoginThrottle{
+		attempts: conf.LoginAttempts,
+		lockout:  conf.LockoutDuration,
+		backoff:  conf.LoginBackoff,
+		maxDelay: conf.MaxLoginBackoff,
+	}
+}
+
+// Allowed returns true if a login may be attempted, e.g. the key is not locked and the
+// backoff period since the last failed attempt has passed.
+func (t *LoginThrottle) Allowed(attempts *models.LoginAttempts) bool {
+	now := time.Now()
+	if attempts.LockedUntil.Valid && attempts.LockedUntil.Time.After(now) {
+		return false
+	}
+
+	if attempts.NextAttempt.Valid && attempts.NextAttempt.Time.After(now) {
+		return false
+	}
+	return true
+}
+
+// Failed computes the next time a login can be attempted after a failed login and
+// locks user accounts that have exceeded the maximum number of consecutive failures.
+// The failures of the attempts must already include the failed login, which is counted
+// by the database so that concurrent failures are not lost (see Forget). Returns true
+// if this failure caused the account to be locked.
+func (t *LoginThrottle) Failed(attempts *models.LoginAttempts) (locked bool) {
+	now := time.Now()
+	attempts.NextAttempt = sql.NullTime{Valid: true, Time: now.Add(t.Delay(attempts.Failures))}
+
+	if attempts.IsUser() && t.attempts > 0 && attempts.Failures >= int64(t.attempts) && !attempts.Locked() {
+		attempts.LockedUntil = sql.NullTime{Valid: true, Time: now.Add(t.lockout)}
+		return true
+	}
+	return false
+}
+
+// Forget returns the timestamp before which failures are forgotten; if the last failure
+// of the attempts is before it, failures are counted from one again.
+func (t *LoginThrottle) Forget() time.Time {
+	return time.Now().Add(-t.lockout)
+}
+
+// Delay returns the amount of time that must pass after the specified number of
+// consecutive failures before another login can be attempted.
+func (t *LoginThrottle) Delay(failures int64) time.Duration {
+	if failures <= 0 || t.backoff <= 0 {
+		return 0
+	}
+
+	delay := t.backoff
+	for i := int64(1); i < failures; i++ {
+		delay *= 2
+		if t.maxDelay > 0 && delay >= t.maxDelay {
+			return t.maxDelay
+		}
+	}
+
+	if t.maxDelay > 0 && delay > t.maxDelay {
+		return t.maxDelay
+	}
+	return delay
+}
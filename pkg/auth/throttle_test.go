@@ -0,0 +1,93 @@
+package auth_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	. "github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+var throttleConf = config.AuthConfig{
+	LoginAttempts:   3,
+	LockoutDuration: 15 * time.Minute,
+	LoginBackoff:    time.Second,
+	MaxLoginBackoff: 5 * time.Second,
+}
+
+func TestThrottleDelay(t *testing.T) {
+	throttle := NewLoginThrottle(throttleConf)
+
+	testCases := []struct {
+		failures int64
+		expected time.Duration
+	}{
+		{0, 0},
+		{1, 1 * time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{64, 5 * time.Second},
+	}
+
+	for i, tc := range testCases {
+		require.Equal(t, tc.expected, throttle.Delay(tc.failures), "test case %d failed", i)
+	}
+}
+
+func TestThrottleLockout(t *testing.T) {
+	throttle := NewLoginThrottle(throttleConf)
+	attempts := &models.LoginAttempts{Key: models.UserLoginKey(42)}
+	require.True(t, throttle.Allowed(attempts), "expected empty attempts to be allowed")
+
+	// Failures are counted by the database before the throttle is applied
+	fail := func() bool {
+		attempts.Failures++
+		attempts.LastFailure = sql.NullTime{Valid: true, Time: time.Now()}
+		return throttle.Failed(attempts)
+	}
+
+	// The first failures should backoff but not lock the account
+	for i := 0; i < throttleConf.LoginAttempts-1; i++ {
+		require.False(t, fail(), "account locked too early")
+		require.False(t, throttle.Allowed(attempts), "expected backoff after failure")
+		require.False(t, attempts.Locked())
+
+		// Simulate waiting for the backoff period to pass
+		attempts.NextAttempt.Time = time.Now().Add(-1 * time.Second)
+		require.True(t, throttle.Allowed(attempts), "expected login allowed after backoff")
+	}
+
+	// The next failure should lock the account
+	require.True(t, fail(), "expected account to be locked")
+	require.True(t, attempts.Locked())
+	attempts.NextAttempt.Time = time.Now().Add(-1 * time.Second)
+	require.False(t, throttle.Allowed(attempts), "expected locked account not to be allowed")
+
+	// Additional failures while locked should not report a new lockout
+	require.False(t, fail(), "expected account to already be locked")
+	require.Equal(t, int64(throttleConf.LoginAttempts+1), attempts.Failures)
+	require.Equal(t, 5*time.Second, time.Until(attempts.NextAttempt.Time).Round(time.Second), "the backoff is computed from the counted failures")
+
+	// Failures before the lockout duration are forgotten
+	forget := throttle.Forget()
+	require.WithinDuration(t, time.Now().Add(-throttleConf.LockoutDuration), forget, time.Second)
+	require.True(t, time.Now().Add(-20*time.Minute).Before(forget))
+	require.False(t, time.Now().Add(-5*time.Minute).Before(forget))
+}
+
+func TestThrottleClient(t *testing.T) {
+	throttle := NewLoginThrottle(throttleConf)
+	attempts := &models.LoginAttempts{Key: models.ClientLoginKey("127.0.0.1")}
+
+	// Client addresses are never locked, only backed off
+	for i := 0; i < throttleConf.LoginAttempts*2; i++ {
+		attempts.Failures++
+		require.False(t, throttle.Failed(attempts), "client addresses should not be locked")
+		require.False(t, attempts.Locked())
+		require.False(t, throttle.Allowed(attempts), "expected backoff after failure")
+	}
+}
@@ -24,10 +24,12 @@ var (
 )
 
 type ClaimsIssuer struct {
-	conf       config.AuthConfig
-	keyID      ulid.ULID
-	key        *rsa.PrivateKey
-	publicKeys map[ulid.ULID]*rsa.PublicKey
+	conf        config.AuthConfig
+	keyID       ulid.ULID
+	key         *rsa.PrivateKey
+	publicKeys  map[ulid.ULID]*rsa.PublicKey
+	revocations RevocationChecker
+	versions    VersionChecker
 }
 
 func NewIssuer(conf config.AuthConfig) (_ *ClaimsIssuer, err error) {
@@ -87,12 +89,34 @@ func (tm *ClaimsIssuer) Verify(tks string) (claims *Claims, err error) {
 			return nil, ErrInvalidIssuer
 		}
 
+		if tm.revocations != nil && tm.revocations.Revoked(claims) {
+			return nil, ErrTokenRevoked
+		}
+
 		return claims, nil
 	}
 
 	return nil, ErrUnparsableClaims
 }
 
+// UseRevocations configures the issuer to reject revoked tokens when they are verified.
+func (tm *ClaimsIssuer) UseRevocations(revocations RevocationChecker) {
+	tm.revocations = revocations
+}
+
+// UseVersions configures Authorize to reject the claims of access tokens issued by the
+// issuer whose permission versions are no longer current.
+func (tm *ClaimsIssuer) UseVersions(versions VersionChecker) {
+	tm.versions = versions
+}
+
+// Versions returns the version checker of the issuer, which is nil if the issuer does
+// not check permission versions. It is used to authorize requests that are not made
+// through gin, e.g. with CheckVersions and AuthorizePlayer.
+func (tm *ClaimsIssuer) Versions() VersionChecker {
+	return tm.versions
+}
+
 // Parse an access or refresh token verifying its signature but without verifying its
 // claims. This ensures that valid JWT tokens are still accepted but claims can be
 // handled on a case-by-case basis; for example by validating an expired access token
@@ -144,6 +168,7 @@ func (tm *ClaimsIssuer) CreateRefreshToken(accessToken *jwt.Token) (_ *jwt.Token
 			NotBefore: jwt.NewNumericDate(accessClaims.ExpiresAt.Add(tm.conf.TokenOverlap)),
 			ExpiresAt: jwt.NewNumericDate(accessClaims.IssuedAt.Add(tm.conf.RefreshTokenTTL)),
 		},
+		Refresh: true,
 	}
 
 	return jwt.NewWithClaims(signingMethod, claims), nil
@@ -172,6 +197,79 @@ func (tm *ClaimsIssuer) CreateTokens(claims *Claims) (signedAccessToken, signedR
 	return signedAccessToken, signedRefreshToken, nil
 }
 
+// CreateImpersonationToken creates and signs a short-lived access token for the claims
+// of a user that names the administrator in the actor claim. No refresh token is issued
+// so the token cannot be refreshed once it expires.
+func (tm *ClaimsIssuer) CreateImpersonationToken(claims *Claims, actor *Actor) (_ string, expires time.Time, err error) {
+	var token *jwt.Token
+	if token, err = tm.CreateAccessToken(claims); err != nil {
+		return "", expires, err
+	}
+
+	expires = claims.IssuedAt.Add(tm.conf.ImpersonateTTL)
+	claims.ExpiresAt = jwt.NewNumericDate(expires)
+	claims.Actor = actor
+
+	// Impersonation does not authenticate the user so the tokens must not appear to
+	// have been issued after a login with a second factor.
+	claims.AMR = nil
+	claims.AuthTime = nil
+
+	var tks string
+	if tks, err = tm.Sign(token); err != nil {
+		return "", expires, err
+	}
+	return tks, expires, nil
+}
+
+// MFA tokens are issued after a user's password has been verified but before they have
+// submitted their second factor. They use a distinct audience so that they cannot be
+// used as access tokens and must be exchanged along with a valid code for tokens.
+const mfaAudience = "urn:cosmos:mfa"
+
+// CreateMFAToken creates and signs a short-lived token for the specified subject that
+// can be exchanged for access and refresh tokens with a second factor. The amr claim
+// records how the user completed the first step of their login.
+func (tm *ClaimsIssuer) CreateMFAToken(subject string, amr ...string) (_ string, err error) {
+	now := time.Now()
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        newULID().String(),
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{mfaAudience},
+			Issuer:    tm.conf.Issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(tm.conf.MFATokenTTL)),
+		},
+		AMR: amr,
+	}
+	return tm.Sign(jwt.NewWithClaims(signingMethod, claims))
+}
+
+// VerifyMFAToken verifies a token created by CreateMFAToken and returns its claims.
+func (tm *ClaimsIssuer) VerifyMFAToken(tks string) (claims *Claims, err error) {
+	var token *jwt.Token
+	if token, err = jwt.ParseWithClaims(tks, &Claims{}, tm.keyFunc); err != nil {
+		return nil, err
+	}
+
+	var ok bool
+	if claims, ok = token.Claims.(*Claims); ok && token.Valid {
+		if !claims.VerifyAudience(mfaAudience, true) {
+			return nil, ErrInvalidAudience
+		}
+
+		if !claims.VerifyIssuer(tm.conf.Issuer, true) {
+			return nil, ErrInvalidIssuer
+		}
+
+		return claims, nil
+	}
+
+	return nil, ErrUnparsableClaims
+}
+
 // Keys returns the map of ulid to public key for use externally.
 func (tm *ClaimsIssuer) Keys() map[ulid.ULID]*rsa.PublicKey {
 	return tm.publicKeys
@@ -3,12 +3,16 @@ package auth_test
 import (
 	"crypto/rand"
 	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/bbengfort/cosmos/pkg/api/v1"
 	"github.com/bbengfort/cosmos/pkg/auth"
 	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/gin-gonic/gin"
 	jwt "github.com/golang-jwt/jwt/v4"
 	"github.com/oklog/ulid/v2"
 	"github.com/stretchr/testify/suite"
@@ -86,6 +90,8 @@ func (s *TokenTestSuite) TestTokenManager() {
 	require.True(rc.ExpiresAt.After(rc.NotBefore.Time))
 	require.Empty(rc.Email)
 	require.Empty(rc.Name)
+	require.True(rc.IsRefreshToken(), "refresh tokens must be distinguishable from access tokens")
+	require.False(ac.IsRefreshToken())
 
 	// Verify relative nbf and exp claims of access and refresh tokens
 	require.True(ac.IssuedAt.Equal(rc.IssuedAt.Time), "access and refresh tokens do not have same iss timestamp")
@@ -340,6 +346,177 @@ func (s *TokenTestSuite) TestParseExpiredToken() {
 	require.Empty(claims, "bad signature token returned non-empty claims")
 }
 
+// Test that MFA tokens cannot be used as access tokens and vice versa.
+func (s *TokenTestSuite) TestMFAToken() {
+	require := s.Require()
+	conf := config.AuthConfig{
+		Keys:            s.testdata,
+		Audience:        "http://localhost:3000",
+		Issuer:          "http://localhost:3001",
+		CookieDomain:    "localhost",
+		AccessTokenTTL:  1 * time.Hour,
+		RefreshTokenTTL: 2 * time.Hour,
+		TokenOverlap:    -15 * time.Minute,
+		MFATokenTTL:     5 * time.Minute,
+	}
+
+	tm, err := auth.NewIssuer(conf)
+	require.NoError(err, "could not initialize token manager")
+
+	tks, err := tm.CreateMFAToken("1a", auth.AMRPassword)
+	require.NoError(err, "could not create mfa token")
+
+	claims, err := tm.VerifyMFAToken(tks)
+	require.NoError(err, "could not verify mfa token")
+	require.Equal("1a", claims.Subject)
+	require.Equal([]string{auth.AMRPassword}, claims.AMR)
+	require.False(claims.HasMFA())
+	require.Equal(5*time.Minute, claims.ExpiresAt.Sub(claims.IssuedAt.Time))
+
+	// The MFA token cannot be used as an access token
+	_, err = tm.Verify(tks)
+	require.ErrorIs(err, auth.ErrInvalidAudience)
+
+	// An access token cannot be used as an MFA token
+	atks, _, err := tm.CreateTokens(&auth.Claims{Email: "kate@rotational.io"})
+	require.NoError(err, "could not create access token")
+
+	_, err = tm.VerifyMFAToken(atks)
+	require.ErrorIs(err, auth.ErrInvalidAudience)
+}
+
+// Test that revoked access and refresh tokens are rejected by Verify.
+func (s *TokenTestSuite) TestRevokedTokens() {
+	require := s.Require()
+	conf := config.AuthConfig{
+		Keys:            s.testdata,
+		Audience:        "http://localhost:3000",
+		Issuer:          "http://localhost:3001",
+		CookieDomain:    "localhost",
+		AccessTokenTTL:  1 * time.Hour,
+		RefreshTokenTTL: 2 * time.Hour,
+		TokenOverlap:    -15 * time.Minute,
+	}
+
+	tm, err := auth.NewIssuer(conf)
+	require.NoError(err, "could not initialize token manager")
+
+	revocations := auth.NewRevocations(conf.RefreshTokenTTL)
+	tm.UseRevocations(revocations)
+
+	claims := &auth.Claims{Email: "kate@rotational.io"}
+	claims.SetSubjectID(42)
+
+	atks, rtks, err := tm.CreateTokens(claims)
+	require.NoError(err, "could not create tokens")
+
+	_, err = tm.Verify(atks)
+	require.NoError(err, "access token should be valid before revocation")
+
+	revocations.Add(42, time.Now().Add(time.Second))
+	_, err = tm.Verify(atks)
+	require.ErrorIs(err, auth.ErrTokenRevoked)
+
+	// The refresh token is not yet valid but is also revoked once it is
+	_, err = tm.Verify(rtks)
+	require.Error(err)
+}
+
+// Test that impersonation tokens are short-lived and name the administrator.
+func (s *TokenTestSuite) TestImpersonationToken() {
+	require := s.Require()
+	conf := config.AuthConfig{
+		Keys:            s.testdata,
+		Audience:        "http://localhost:3000",
+		Issuer:          "http://localhost:3001",
+		CookieDomain:    "localhost",
+		AccessTokenTTL:  1 * time.Hour,
+		RefreshTokenTTL: 2 * time.Hour,
+		TokenOverlap:    -15 * time.Minute,
+		ImpersonateTTL:  15 * time.Minute,
+	}
+
+	tm, err := auth.NewIssuer(conf)
+	require.NoError(err, "could not initialize token manager")
+
+	claims := &auth.Claims{Email: "kate@rotational.io", AMR: []string{auth.AMRPassword, auth.AMROTP, auth.AMRMFA}}
+	claims.SetSubjectID(42)
+	claims.AuthTime = jwt.NewNumericDate(time.Now())
+
+	tks, expires, err := tm.CreateImpersonationToken(claims, &auth.Actor{Subject: "1", Email: "admin@example.com"})
+	require.NoError(err, "could not create impersonation token")
+
+	verified, err := tm.Verify(tks)
+	require.NoError(err, "could not verify impersonation token")
+	require.True(verified.Impersonated())
+	require.Equal("1", verified.Actor.Subject)
+	require.Equal("admin@example.com", verified.Actor.Email)
+	require.Equal(claims.Subject, verified.Subject)
+	require.Equal(expires.Unix(), verified.ExpiresAt.Unix())
+	require.Equal(15*time.Minute, verified.ExpiresAt.Sub(verified.IssuedAt.Time))
+
+	// Impersonation does not satisfy multi-factor or recent login requirements
+	require.False(verified.HasMFA())
+	require.False(verified.AuthenticatedWithin(time.Hour))
+
+	// Regular access tokens do not have an actor
+	atks, _, err := tm.CreateTokens(&auth.Claims{Email: "kate@rotational.io"})
+	require.NoError(err, "could not create access token")
+
+	verified, err = tm.Verify(atks)
+	require.NoError(err, "could not verify access token")
+	require.False(verified.Impersonated())
+}
+
+func (s *TokenTestSuite) TestMFAEnrollmentToken() {
+	require := s.Require()
+	conf := config.AuthConfig{
+		Keys:            s.testdata,
+		Audience:        "http://localhost:3000",
+		Issuer:          "http://localhost:3001",
+		CookieDomain:    "localhost",
+		AccessTokenTTL:  1 * time.Hour,
+		RefreshTokenTTL: 2 * time.Hour,
+		TokenOverlap:    -15 * time.Minute,
+	}
+
+	tm, err := auth.NewIssuer(conf)
+	require.NoError(err, "could not initialize token manager")
+
+	claims := &auth.Claims{Email: "kate@rotational.io", Permissions: []string{"games:read", "games:manage"}, AMR: []string{auth.AMRPassword}}
+	claims.SetSubjectID(42)
+	claims.RestrictToMFAEnrollment()
+	require.Empty(claims.Permissions, "enrollment claims should not have the permissions of the user")
+
+	tks, _, err := tm.CreateTokens(claims)
+	require.NoError(err, "could not create enrollment token")
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"success": true}) }
+	router.GET("/mfa", auth.AllowMFAEnrollment(), auth.Authenticate(tm, nil), handler)
+	router.GET("/galaxy", auth.Authenticate(tm, nil), handler)
+
+	request := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+tks)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Enrollment tokens can only be used on the routes that allow enrollment
+	require.Equal(http.StatusOK, request("/mfa").Code)
+	w := request("/galaxy")
+	require.Equal(http.StatusForbidden, w.Code)
+	require.Contains(w.Body.String(), api.CodeMFARequired)
+
+	// Regular access tokens are allowed on every route
+	tks, _, err = tm.CreateTokens(&auth.Claims{Email: "kate@rotational.io"})
+	require.NoError(err, "could not create access token")
+	require.Equal(http.StatusOK, request("/galaxy").Code)
+}
+
 // Execute suite as a go test.
 func TestTokenTestSuite(t *testing.T) {
 	suite.Run(t, new(TokenTestSuite))
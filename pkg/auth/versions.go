@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+)
+
+// VersionChecker determines if the permissions in the claims are still current, e.g.
+// the user's role has not changed and the permissions of the role have not changed.
+type VersionChecker interface {
+	Current(ctx context.Context, claims *Claims) (bool, error)
+}
+
+// PermissionVersions caches the permission versions of users and roles so that the
+// versions in the claims can be checked without a database query on every request.
+// The cache must be invalidated when versions change, which is signaled by database
+// notifications; the cache is cleared when it grows beyond its maximum size.
+type PermissionVersions struct {
+	sync.RWMutex
+	maxSize int
+	gen     uint64 // incremented on invalidation so that stale loads are not cached
+	users   map[int64]models.PermissionVersion
+	roles   map[string]int64
+}
+
+var _ VersionChecker = &PermissionVersions{}
+
+func NewPermissionVersions(maxSize int) *PermissionVersions {
+	return &PermissionVersions{
+		maxSize: maxSize,
+		users:   make(map[int64]models.PermissionVersion),
+		roles:   make(map[string]int64),
+	}
+}
+
+// Current returns true if the user and role versions in the claims match the current
+// versions of the user and their role. Claims of users that no longer exist are never
+// current.
+func (v *PermissionVersions) Current(ctx context.Context, claims *Claims) (_ bool, err error) {
+	var userID int64
+	if userID, err = claims.SubjectID(); err != nil {
+		return false, err
+	}
+
+	v.RLock()
+	gen := v.gen
+	user, ok := v.users[userID]
+	v.RUnlock()
+
+	if !ok {
+		var version *models.PermissionVersion
+		if version, err = models.GetPermissionVersion(ctx, userID); err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+
+		user = *version
+		v.update(gen, userID, user)
+	}
+
+	// The user version changes whenever the user's role changes so the role version
+	// only needs to be compared if the user version is current.
+	if claims.UserVersion != user.UserVersion || claims.Role != user.Role {
+		return false, nil
+	}
+
+	v.RLock()
+	gen = v.gen
+	role, ok := v.roles[claims.Role]
+	v.RUnlock()
+
+	if !ok {
+		if role, err = models.GetRoleVersion(ctx, claims.Role); err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+		v.updateRole(gen, claims.Role, role)
+	}
+
+	return claims.RoleVersion == role, nil
+}
+
+// Update caches the current permission version of the user and their role.
+func (v *PermissionVersions) Update(userID int64, version models.PermissionVersion) {
+	v.Lock()
+	v.setUser(userID, version)
+	v.Unlock()
+}
+
+// UpdateRole caches the current permission version of the role.
+func (v *PermissionVersions) UpdateRole(title string, version int64) {
+	v.Lock()
+	v.setRole(title, version)
+	v.Unlock()
+}
+
+// update caches a version loaded from the database unless the cache was invalidated
+// while it was being loaded, in which case the loaded version may already be stale.
+func (v *PermissionVersions) update(gen uint64, userID int64, version models.PermissionVersion) {
+	v.Lock()
+	if v.gen == gen {
+		v.setUser(userID, version)
+	}
+	v.Unlock()
+}
+
+func (v *PermissionVersions) updateRole(gen uint64, title string, version int64) {
+	v.Lock()
+	if v.gen == gen {
+		v.setRole(title, version)
+	}
+	v.Unlock()
+}
+
+func (v *PermissionVersions) setUser(userID int64, version models.PermissionVersion) {
+	if len(v.users) >= v.maxSize {
+		v.users = make(map[int64]models.PermissionVersion)
+	}
+	v.users[userID] = version
+	v.setRole(version.Role, version.RoleVersion)
+}
+
+func (v *PermissionVersions) setRole(title string, version int64) {
+	if len(v.roles) >= v.maxSize {
+		v.roles = make(map[string]int64)
+	}
+	v.roles[title] = version
+}
+
+// Invalidate removes the user or role identified by a permission versions notification
+// payload from the cache, e.g. "user:42" or "role:Admin". The entire cache is cleared
+// if the payload cannot be parsed.
+func (v *PermissionVersions) Invalidate(payload string) {
+	kind, id, _ := strings.Cut(payload, ":")
+
+	v.Lock()
+	defer v.Unlock()
+	v.gen++
+
+	switch kind {
+	case "user":
+		if userID, err := strconv.ParseInt(id, 10, 64); err == nil {
+			delete(v.users, userID)
+			return
+		}
+	case "role":
+		delete(v.roles, id)
+		return
+	}
+
+	v.users = make(map[int64]models.PermissionVersion)
+	v.roles = make(map[string]int64)
+}
+
+// Reset clears the cache, e.g. when notifications may have been missed.
+func (v *PermissionVersions) Reset() {
+	v.Lock()
+	v.gen++
+	v.users = make(map[int64]models.PermissionVersion)
+	v.roles = make(map[string]int64)
+	v.Unlock()
+}
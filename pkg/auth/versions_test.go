@@ -0,0 +1,73 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermissionVersions(t *testing.T) {
+	ctx := context.Background()
+	versions := NewPermissionVersions(100)
+	versions.Update(42, models.PermissionVersion{UserVersion: 3, Role: "Player", RoleVersion: 7})
+
+	claims := &Claims{Role: "Player", UserVersion: 3, RoleVersion: 7}
+	claims.SetSubjectID(42)
+
+	current, err := versions.Current(ctx, claims)
+	require.NoError(t, err)
+	require.True(t, current, "claims with the cached versions should be current")
+
+	stale := []*Claims{
+		{RegisteredClaims: claims.RegisteredClaims, Role: "Player", UserVersion: 2, RoleVersion: 7},
+		{RegisteredClaims: claims.RegisteredClaims, Role: "Player", UserVersion: 3, RoleVersion: 6},
+		{RegisteredClaims: claims.RegisteredClaims, Role: "Admin", UserVersion: 3, RoleVersion: 7},
+		{RegisteredClaims: claims.RegisteredClaims, Role: "Player"},
+	}
+
+	for i, claims := range stale {
+		current, err = versions.Current(ctx, claims)
+		require.NoError(t, err)
+		require.False(t, current, "expected claims %d to be stale", i)
+	}
+
+	// Role versions are cached independently of users
+	versions.UpdateRole("Player", 8)
+	current, err = versions.Current(ctx, claims)
+	require.NoError(t, err)
+	require.False(t, current, "role permissions have changed")
+
+	claims.RoleVersion = 8
+	current, err = versions.Current(ctx, claims)
+	require.NoError(t, err)
+	require.True(t, current)
+
+	// Invalidated versions must be loaded from the database which is not connected
+	versions.Invalidate("role:Player")
+	_, err = versions.Current(ctx, claims)
+	require.Error(t, err, "expected the role version to be loaded from the database")
+
+	versions.UpdateRole("Player", 8)
+	versions.Invalidate("user:42")
+	_, err = versions.Current(ctx, claims)
+	require.Error(t, err, "expected the user version to be loaded from the database")
+
+	versions.Update(42, models.PermissionVersion{UserVersion: 3, Role: "Player", RoleVersion: 8})
+	versions.Invalidate("user:43")
+	current, err = versions.Current(ctx, claims)
+	require.NoError(t, err, "other users should not be invalidated")
+	require.True(t, current)
+
+	// Unparsable payloads clear the entire cache
+	versions.Invalidate("foo")
+	_, err = versions.Current(ctx, claims)
+	require.Error(t, err, "expected the cache to be cleared")
+
+	versions.Update(42, models.PermissionVersion{UserVersion: 3, Role: "Player", RoleVersion: 8})
+	versions.Reset()
+	_, err = versions.Current(ctx, claims)
+	require.Error(t, err, "expected the cache to be cleared")
+}
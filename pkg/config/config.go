@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -13,15 +14,23 @@ import (
 const Prefix = "cosmos"
 
 type Config struct {
-	Maintenance  bool                `default:"false" desc:"sets the server to maintenance mode if true"`
-	BindAddr     string              `split_words:"true" default:":8888" desc:"the ip address and port to bind the server to"`
-	Mode         string              `default:"release" desc:"one of debug, test, or release"`
-	LogLevel     logger.LevelDecoder `split_words:"true" default:"info" desc:"the verbosity of logging"`
-	ConsoleLog   bool                `split_words:"true" default:"false" desc:"human readable instead of json logging"`
-	AllowOrigins []string            `split_words:"true" default:"http://localhost:3000" desc:"origin of website accessing API"`
-	Database     DatabaseConfig      `desc:"database configuration"`
-	Auth         AuthConfig          `desc:"authentication and claims issuer configuration"`
-	processed    bool                // set when the config is properly processed from the environment
+	Maintenance    bool                `default:"false" desc:"sets the server to maintenance mode if true"`
+	BindAddr       string              `split_words:"true" default:":8888" desc:"the ip address and port to bind the server to"`
+	Mode           string              `default:"release" desc:"one of debug, test, or release"`
+	LogLevel       logger.LevelDecoder `split_words:"true" default:"info" desc:"the verbosity of logging"`
+	ConsoleLog     bool                `split_words:"true" default:"false" desc:"human readable instead of json logging"`
+	AllowOrigins   []string            `split_words:"true" default:"http://localhost:3000" desc:"origin of website accessing API"`
+	TrustedProxies []string            `split_words:"true" desc:"ip addresses or cidrs of the proxies whose forwarded headers identify the client ip"`
+	Database       DatabaseConfig      `desc:"database configuration"`
+	Auth           AuthConfig          `desc:"authentication and claims issuer configuration"`
+	OIDC           OIDCConfig          `desc:"external identity provider configuration"`
+	Mail           MailConfig          `desc:"outgoing email configuration"`
+	Webhooks       WebhooksConfig      `desc:"webhook delivery configuration"`
+	RPC            RPCConfig           `desc:"grpc api configuration"`
+	GraphQL        GraphQLConfig       `desc:"graphql api configuration"`
+	RateLimit      RateLimitConfig     `desc:"rate limiting configuration"`
+	Idempotency    IdempotencyConfig   `desc:"idempotent request configuration"`
+	processed      bool                // set when the config is properly processed from the environment
 }
 
 type DatabaseConfig struct {
@@ -38,6 +47,96 @@ type AuthConfig struct {
 	AccessTokenTTL  time.Duration     `split_words:"true" default:"24h" desc:"the amount of time before an access token expires"`
 	RefreshTokenTTL time.Duration     `split_words:"true" default:"48h" desc:"the amount of time before a refresh token expires"`
 	TokenOverlap    time.Duration     `split_words:"true" default:"-1h" desc:"the amount of overlap between the access and refresh token"`
+	LoginAttempts   int               `split_words:"true" default:"5" desc:"the number of consecutive failed logins before an account is temporarily locked"`
+	LockoutDuration time.Duration     `split_words:"true" default:"15m" desc:"the amount of time an account is locked after too many failed logins"`
+	LoginBackoff    time.Duration     `split_words:"true" default:"1s" desc:"the delay after a failed login, doubled for each consecutive failure"`
+	MaxLoginBackoff time.Duration     `split_words:"true" default:"5m" desc:"the maximum delay between failed logins from the same account or client"`
+	MFATokenTTL     time.Duration     `split_words:"true" default:"5m" desc:"the amount of time a user has to submit a second factor after their password"`
+	TOTPIssuer      string            `split_words:"true" default:"Cosmos" desc:"the issuer name displayed by authenticator apps"`
+	APIKeyCacheTTL  time.Duration     `split_words:"true" default:"5m" desc:"the amount of time verified api key credentials are cached"`
+	Argon2Time      uint32            `split_words:"true" default:"1" desc:"the number of passes used to create password derived keys"`
+	Argon2Memory    uint32            `split_words:"true" default:"65536" desc:"the amount of memory in KiB used to create password derived keys"`
+	Argon2Threads   uint8             `split_words:"true" default:"2" desc:"the number of threads used to create password derived keys"`
+	RevocationSync  time.Duration     `split_words:"true" default:"1m" desc:"the interval at which token revocations made by other replicas are loaded"`
+	VersionCache    int               `split_words:"true" default:"10000" desc:"the maximum number of user and role permission versions cached in memory"`
+	RecentLogin     time.Duration     `split_words:"true" default:"10m" desc:"users without a password must have logged in this recently to confirm sensitive changes"`
+	VerifyEmailTTL  time.Duration     `split_words:"true" default:"24h" desc:"the amount of time a user has to verify a change to their email address"`
+	ImpersonateTTL  time.Duration     `split_words:"true" default:"15m" desc:"the amount of time before an access token issued to an administrator impersonating a user expires"`
+}
+
+// OIDCConfig specifies the external OpenID Connect providers that users can sign in
+// with. Providers are specified as a JSON array since issuer URLs cannot be specified
+// in a map from the environment.
+type OIDCConfig struct {
+	Providers   IdentityProviders `desc:"json array of providers with name, issuer, client_id, and client_secret"`
+	RedirectURL string            `split_words:"true" default:"http://localhost:8888/v1/oidc" desc:"base url of the callback endpoint; the provider name and /callback are appended"`
+	Scopes      []string          `default:"openid,email,profile" desc:"the scopes requested from the identity provider"`
+	StateTTL    time.Duration     `split_words:"true" default:"10m" desc:"the amount of time a user has to complete sign in with the identity provider"`
+}
+
+// MailConfig specifies how emails such as email address verifications are sent. The
+// console backend logs emails rather than sending them, for development and testing.
+type MailConfig struct {
+	Backend        string `default:"console" desc:"one of console or smtp"`
+	From           string `default:"Cosmos <noreply@localhost>" desc:"the sender of all outgoing email"`
+	SMTPHost       string `split_words:"true" desc:"the host of the smtp server"`
+	SMTPPort       int    `split_words:"true" default:"587" desc:"the port of the smtp server"`
+	SMTPUsername   string `split_words:"true" desc:"the username to authenticate with the smtp server"`
+	SMTPPassword   string `split_words:"true" desc:"the password to authenticate with the smtp server"`
+	VerifyEmailURL string `split_words:"true" default:"http://localhost:3000/verify-email" desc:"the web page that verifies email changes; the token is added as a query parameter"`
+}
+
+// WebhooksConfig specifies how galaxy events are delivered to webhooks. Failed
+// deliveries are retried with exponential backoff starting at the backoff interval
+// until the maximum number of attempts is reached. Webhooks cannot be delivered to
+// loopback, link-local, private or unspecified addresses unless private addresses are
+// allowed.
+type WebhooksConfig struct {
+	Workers      int           `default:"4" desc:"the number of deliveries that are sent concurrently"`
+	BatchSize    int           `split_words:"true" default:"50" desc:"the maximum number of deliveries claimed from the queue at a time"`
+	PollInterval time.Duration `split_words:"true" default:"30s" desc:"the interval at which the queue is checked for retries that are due"`
+	Timeout      time.Duration `default:"10s" desc:"the amount of time a webhook receiver has to respond to a delivery"`
+	MaxAttempts  int           `split_words:"true" default:"8" desc:"the number of attempts before a delivery is marked as failed"`
+	Backoff      time.Duration `default:"30s" desc:"the delay before the first retry of a failed delivery, doubled for each attempt"`
+	MaxBackoff   time.Duration `split_words:"true" default:"6h" desc:"the maximum delay between attempts to deliver a webhook"`
+	AllowPrivate bool          `split_words:"true" default:"false" desc:"allow deliveries to loopback and private addresses, which should only be set for development"`
+}
+
+// RPCConfig specifies the gRPC API, which is served alongside the v1 REST API on its
+// own bind address so that it can be exposed separately from the REST API.
+type RPCConfig struct {
+	Enabled  bool   `default:"true" desc:"serve the grpc api in addition to the rest api"`
+	BindAddr string `split_words:"true" default:":9999" desc:"the ip address and port to bind the grpc server to"`
+}
+
+// GraphQLConfig limits the queries of the GraphQL API. The complexity of a query is the
+// number of fields it requests with the fields of lists multiplied by their estimated
+// number of items; queries that are more complex than the maximum are rejected.
+type GraphQLConfig struct {
+	MaxComplexity int `split_words:"true" default:"10000" desc:"the maximum estimated complexity of a graphql query"`
+}
+
+// RateLimitConfig specifies the quotas of the route groups of the v1 API. Requests are
+// limited per user when they are authenticated and per client IP address otherwise.
+// Authenticated routes are also limited per client IP address before the credentials of
+// the request are verified so that invalid credentials are limited.
+// Buckets are kept in memory by each replica unless they are shared, in which case they
+// are stored in Postgres so that the quotas apply across all replicas.
+type RateLimitConfig struct {
+	Enabled bool      `default:"true" desc:"limit the rate of requests to the api"`
+	Shared  bool      `default:"false" desc:"store the rate limit buckets in postgres to share them between replicas"`
+	Auth    RateLimit `default:"20/1m" desc:"the quota of the login, registration, and sign in routes per client"`
+	Galaxy  RateLimit `default:"10/1s:30" desc:"the quota of the galaxy routes per user"`
+	GraphQL RateLimit `default:"2/1s:10" desc:"the quota of graphql queries per user"`
+	Default RateLimit `default:"20/1s:40" desc:"the quota of all other routes per user or client"`
+	Client  RateLimit `default:"50/1s:100" desc:"the quota of the authenticated routes per client ip address, which is checked before credentials are verified"`
+}
+
+// IdempotencyConfig specifies how long the first response to a request with an
+// Idempotency-Key header is stored so that it can be replayed when the request is retried.
+type IdempotencyConfig struct {
+	TTL   time.Duration `default:"24h" desc:"the amount of time the response to a request with an idempotency key is replayed"`
+	Lease time.Duration `default:"1m" desc:"the amount of time retries are rejected while the first request is in progress before it is assumed to be abandoned"`
 }
 
 func New() (conf Config, err error) {
@@ -65,6 +164,112 @@ func (c Config) Validate() (err error) {
 	if c.Mode != gin.ReleaseMode && c.Mode != gin.DebugMode && c.Mode != gin.TestMode {
 		return fmt.Errorf("invalid configuration: %q is not a valid gin mode", c.Mode)
 	}
+
+	if err = c.OIDC.Validate(); err != nil {
+		return err
+	}
+
+	if err = c.Mail.Validate(); err != nil {
+		return err
+	}
+
+	if err = c.Webhooks.Validate(); err != nil {
+		return err
+	}
+
+	if err = c.RPC.Validate(); err != nil {
+		return err
+	}
+
+	if err = c.GraphQL.Validate(); err != nil {
+		return err
+	}
+
+	if err = c.RateLimit.Validate(); err != nil {
+		return err
+	}
+
+	if err = c.Idempotency.Validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c OIDCConfig) Validate() error {
+	names := make(map[string]struct{}, len(c.Providers))
+	for _, provider := range c.Providers {
+		if provider.Name == "" || provider.Issuer == "" || provider.ClientID == "" {
+			return errors.New("invalid configuration: identity providers require a name, issuer, and client id")
+		}
+
+		if _, ok := names[provider.Name]; ok {
+			return fmt.Errorf("invalid configuration: duplicate identity provider %q", provider.Name)
+		}
+		names[provider.Name] = struct{}{}
+	}
+	return nil
+}
+
+func (c MailConfig) Validate() error {
+	switch c.Backend {
+	case "console":
+		return nil
+	case "smtp":
+		if c.SMTPHost == "" {
+			return errors.New("invalid configuration: smtp host is required for the smtp mail backend")
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid configuration: %q is not a valid mail backend", c.Backend)
+	}
+}
+
+func (c WebhooksConfig) Validate() error {
+	if c.Workers < 1 || c.BatchSize < 1 || c.MaxAttempts < 1 {
+		return errors.New("invalid configuration: webhook workers, batch size, and max attempts must be positive")
+	}
+
+	if c.PollInterval <= 0 || c.Timeout <= 0 || c.Backoff <= 0 || c.MaxBackoff < c.Backoff {
+		return errors.New("invalid configuration: webhook intervals must be positive and max backoff must not be less than backoff")
+	}
+	return nil
+}
+
+func (c RPCConfig) Validate() error {
+	if c.Enabled && c.BindAddr == "" {
+		return errors.New("invalid configuration: a bind addr is required to serve the grpc api")
+	}
+	return nil
+}
+
+func (c GraphQLConfig) Validate() error {
+	if c.MaxComplexity < 1 {
+		return errors.New("invalid configuration: graphql max complexity must be positive")
+	}
+	return nil
+}
+
+func (c RateLimitConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	for _, limit := range []RateLimit{c.Auth, c.Galaxy, c.GraphQL, c.Default, c.Client} {
+		if err := limit.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c IdempotencyConfig) Validate() error {
+	if c.TTL <= 0 {
+		return errors.New("invalid configuration: idempotency ttl must be positive")
+	}
+
+	if c.Lease <= 0 || c.Lease > c.TTL {
+		return errors.New("invalid configuration: idempotency lease must be positive and no longer than the ttl")
+	}
 	return nil
 }
 
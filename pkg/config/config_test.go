@@ -3,6 +3,7 @@ package config_test
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/bbengfort/cosmos/pkg/config"
 	"github.com/rs/zerolog"
@@ -10,12 +11,22 @@ import (
 )
 
 var testEnv = map[string]string{
-	"COSMOS_MAINTENANCE":   "true",
-	"COSMOS_BIND_ADDR":     ":9090",
-	"COSMOS_MODE":          "test",
-	"COSMOS_LOG_LEVEL":     "debug",
-	"COSMOS_CONSOLE_LOG":   "true",
-	"COSMOS_ALLOW_ORIGINS": "http://localhost:9090,http://127.0.0.1:9090",
+	"COSMOS_MAINTENANCE":            "true",
+	"COSMOS_BIND_ADDR":              ":9090",
+	"COSMOS_MODE":                   "test",
+	"COSMOS_LOG_LEVEL":              "debug",
+	"COSMOS_CONSOLE_LOG":            "true",
+	"COSMOS_ALLOW_ORIGINS":          "http://localhost:9090,http://127.0.0.1:9090",
+	"COSMOS_TRUSTED_PROXIES":        "10.0.0.0/8",
+	"COSMOS_OIDC_PROVIDERS":         `[{"name": "google", "issuer": "https://accounts.google.com", "client_id": "cosmos", "client_secret": "supersecret"}]`,
+	"COSMOS_MAIL_BACKEND":           "smtp",
+	"COSMOS_MAIL_SMTP_HOST":         "smtp.example.com",
+	"COSMOS_WEBHOOKS_MAX_ATTEMPTS":  "3",
+	"COSMOS_RPC_BIND_ADDR":          ":4443",
+	"COSMOS_GRAPHQL_MAX_COMPLEXITY": "5000",
+	"COSMOS_RATELIMIT_AUTH":         "5/m:10",
+	"COSMOS_IDEMPOTENCY_TTL":        "1h",
+	"COSMOS_IDEMPOTENCY_LEASE":      "30s",
 }
 
 func TestConfig(t *testing.T) {
@@ -34,6 +45,127 @@ func TestConfig(t *testing.T) {
 	require.Equal(t, zerolog.DebugLevel, conf.GetLogLevel())
 	require.True(t, conf.ConsoleLog)
 	require.Len(t, conf.AllowOrigins, 2)
+	require.Equal(t, []string{"10.0.0.0/8"}, conf.TrustedProxies)
+	require.Len(t, conf.OIDC.Providers, 1)
+	require.Equal(t, config.IdentityProvider{Name: "google", Issuer: "https://accounts.google.com", ClientID: "cosmos", ClientSecret: "supersecret"}, conf.OIDC.Providers[0])
+	require.Equal(t, testEnv["COSMOS_MAIL_BACKEND"], conf.Mail.Backend)
+	require.Equal(t, testEnv["COSMOS_MAIL_SMTP_HOST"], conf.Mail.SMTPHost)
+	require.Equal(t, 587, conf.Mail.SMTPPort)
+	require.Equal(t, 3, conf.Webhooks.MaxAttempts)
+	require.Equal(t, 4, conf.Webhooks.Workers)
+	require.True(t, conf.RPC.Enabled)
+	require.Equal(t, ":4443", conf.RPC.BindAddr)
+	require.Equal(t, 5000, conf.GraphQL.MaxComplexity)
+	require.True(t, conf.RateLimit.Enabled)
+	require.Equal(t, config.RateLimit{Rate: 5.0 / 60, Burst: 10}, conf.RateLimit.Auth)
+	require.Equal(t, config.RateLimit{Rate: 10, Burst: 30}, conf.RateLimit.Galaxy)
+	require.Equal(t, time.Hour, conf.Idempotency.TTL)
+	require.Equal(t, 30*time.Second, conf.Idempotency.Lease)
+}
+
+func TestOIDCConfig(t *testing.T) {
+	var providers config.IdentityProviders
+	require.NoError(t, providers.Decode(""))
+	require.Empty(t, providers)
+	require.Error(t, providers.Decode("google:https://accounts.google.com"), "providers must be json")
+
+	conf := config.OIDCConfig{Providers: config.IdentityProviders{{Name: "google", Issuer: "https://accounts.google.com", ClientID: "cosmos"}}}
+	require.NoError(t, conf.Validate())
+
+	conf.Providers = append(conf.Providers, config.IdentityProvider{Name: "google", Issuer: "https://example.com", ClientID: "cosmos"})
+	require.Error(t, conf.Validate(), "duplicate provider names are not allowed")
+
+	conf.Providers = config.IdentityProviders{{Name: "google", ClientID: "cosmos"}}
+	require.Error(t, conf.Validate(), "issuer is required")
+}
+
+func TestMailConfig(t *testing.T) {
+	conf := config.MailConfig{Backend: "console"}
+	require.NoError(t, conf.Validate())
+
+	conf.Backend = "smtp"
+	require.Error(t, conf.Validate(), "smtp host is required")
+
+	conf.SMTPHost = "smtp.example.com"
+	require.NoError(t, conf.Validate())
+
+	conf.Backend = "carrier-pigeon"
+	require.Error(t, conf.Validate(), "unknown backends are not allowed")
+}
+
+func TestWebhooksConfig(t *testing.T) {
+	conf := config.WebhooksConfig{Workers: 4, BatchSize: 50, PollInterval: time.Minute, Timeout: time.Second, MaxAttempts: 8, Backoff: time.Second, MaxBackoff: time.Hour}
+	require.NoError(t, conf.Validate())
+
+	conf.Workers = 0
+	require.Error(t, conf.Validate(), "at least one worker is required")
+
+	conf.Workers = 4
+	conf.MaxBackoff = time.Millisecond
+	require.Error(t, conf.Validate(), "max backoff cannot be less than backoff")
+}
+
+func TestRPCConfig(t *testing.T) {
+	conf := config.RPCConfig{Enabled: true, BindAddr: ":9999"}
+	require.NoError(t, conf.Validate())
+
+	conf.BindAddr = ""
+	require.Error(t, conf.Validate(), "a bind addr is required when the grpc api is enabled")
+
+	conf.Enabled = false
+	require.NoError(t, conf.Validate())
+}
+
+func TestGraphQLConfig(t *testing.T) {
+	conf := config.GraphQLConfig{MaxComplexity: 10000}
+	require.NoError(t, conf.Validate())
+
+	conf.MaxComplexity = 0
+	require.Error(t, conf.Validate(), "the max complexity must be positive")
+}
+
+func TestIdempotencyConfig(t *testing.T) {
+	conf := config.IdempotencyConfig{TTL: 24 * time.Hour, Lease: time.Minute}
+	require.NoError(t, conf.Validate())
+
+	conf.Lease = 0
+	require.Error(t, conf.Validate(), "the lease must be positive")
+
+	conf.Lease = 48 * time.Hour
+	require.Error(t, conf.Validate(), "the lease must not be longer than the ttl")
+
+	conf.TTL = 0
+	require.Error(t, conf.Validate(), "the ttl must be positive")
+}
+
+func TestRateLimit(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected config.RateLimit
+	}{
+		{"10/s", config.RateLimit{Rate: 10, Burst: 10}},
+		{"10/1s:30", config.RateLimit{Rate: 10, Burst: 30}},
+		{" 120/2m : 5 ", config.RateLimit{Rate: 1, Burst: 5}},
+		{"1/500ms", config.RateLimit{Rate: 2, Burst: 1}},
+	}
+
+	for i, tc := range testCases {
+		var limit config.RateLimit
+		require.NoError(t, limit.Decode(tc.value), "test case %d failed", i)
+		require.Equal(t, tc.expected, limit, "test case %d failed", i)
+		require.NoError(t, limit.Validate(), "test case %d failed", i)
+	}
+
+	for _, value := range []string{"", "10", "0/s", "ten/s", "10/", "10/-1s", "10/s:0", "10/s:x"} {
+		var limit config.RateLimit
+		require.Error(t, limit.Decode(value), "expected %q to be invalid", value)
+	}
+
+	conf := config.RateLimitConfig{Enabled: true}
+	require.Error(t, conf.Validate(), "enabled rate limits must have quotas")
+
+	conf.Enabled = false
+	require.NoError(t, conf.Validate())
 }
 
 // Returns the current environment for the specified keys, or if no keys are specified
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// IdentityProvider describes how to connect to an external OpenID Connect provider.
+type IdentityProvider struct {
+	Name         string `json:"name"`
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+// IdentityProviders deserializes a JSON array of identity providers from a config string.
+type IdentityProviders []IdentityProvider
+
+// Decode implements confire Decoder interface.
+func (p *IdentityProviders) Decode(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		*p = nil
+		return nil
+	}
+	return json.Unmarshal([]byte(value), (*[]IdentityProvider)(p))
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimit is a token bucket quota: tokens are added to the bucket at the rate up to
+// the burst and each request takes one token from the bucket. It is decoded from a
+// string of the form "<requests>/<period>" with an optional ":<burst>", e.g. "20/1m" or
+// "10/s:30"; if no burst is specified the burst is the number of requests.
+type RateLimit struct {
+	Rate  float64 // tokens added per second
+	Burst int     // the maximum number of tokens in the bucket
+}
+
+// Decode implements confire Decoder interface.
+func (r *RateLimit) Decode(value string) (err error) {
+	value = strings.TrimSpace(value)
+	quota, burst, hasBurst := strings.Cut(value, ":")
+	requests, period, ok := strings.Cut(quota, "/")
+	if !ok {
+		return fmt.Errorf("could not parse rate limit %q: expected <requests>/<period>", value)
+	}
+
+	var n int
+	if n, err = strconv.Atoi(strings.TrimSpace(requests)); err != nil || n < 1 {
+		return fmt.Errorf("could not parse rate limit %q: requests must be a positive integer", value)
+	}
+
+	// Allow periods without a quantity such as s or m
+	period = strings.TrimSpace(period)
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+
+	var interval time.Duration
+	if interval, err = time.ParseDuration(period); err != nil || interval <= 0 {
+		return fmt.Errorf("could not parse rate limit %q: period must be a positive duration", value)
+	}
+
+	r.Rate = float64(n) / interval.Seconds()
+	r.Burst = n
+	if hasBurst {
+		if r.Burst, err = strconv.Atoi(strings.TrimSpace(burst)); err != nil || r.Burst < 1 {
+			return fmt.Errorf("could not parse rate limit %q: burst must be a positive integer", value)
+		}
+	}
+	return nil
+}
+
+func (r RateLimit) Validate() error {
+	if r.Rate <= 0 || r.Burst < 1 {
+		return errors.New("invalid configuration: rate limits must have a positive rate and burst")
+	}
+	return nil
+}
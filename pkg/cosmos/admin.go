@@ -0,0 +1,571 @@
+package cosmos
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// ListUsers searches the users on the server, optionally filtered by role.
+func (s *Server) ListUsers(c *gin.Context) {
+	var (
+		err   error
+		query *api.UserQuery
+		users []*models.User
+	)
+
+	query = &api.UserQuery{}
+	if err = c.BindQuery(query); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = query.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if users, err = models.ListUsers(c.Request.Context(), &models.UserQuery{
+		Search: query.Search,
+		Role:   query.Role,
+		Limit:  query.Limit,
+		Offset: query.Offset,
+	}); err != nil {
+		log.Error().Err(err).Msg("could not list users from the database")
+		api.Error(c, http.StatusInternalServerError, "could not list users")
+		return
+	}
+
+	out := &api.UserList{Users: make([]*api.User, 0, len(users))}
+	for _, user := range users {
+		out.Users = append(out.Users, userReply(c, user))
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// GetUser returns the user with the specified ID.
+func (s *Server) GetUser(c *gin.Context) {
+	var (
+		err  error
+		user *models.User
+	)
+
+	if user, err = s.adminUser(c, "could not get user"); err != nil {
+		return
+	}
+	c.JSON(http.StatusOK, userReply(c, user))
+}
+
+// SetUserRole assigns the user to the specified role. Administrators cannot change
+// their own role to prevent them from accidentally locking themselves out.
+func (s *Server) SetUserRole(c *gin.Context) {
+	var (
+		err     error
+		in      *api.SetRoleRequest
+		actorID int64
+		user    *models.User
+		prev    *models.Role
+	)
+
+	in = &api.SetRoleRequest{}
+	if err = c.BindJSON(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if actorID, err = subjectID(c); err != nil {
+		log.Warn().Err(err).Msg("could not identify administrator to set user role")
+		api.Error(c, http.StatusInternalServerError, "could not set user role")
+		return
+	}
+
+	if user, err = s.adminUser(c, "could not set user role"); err != nil {
+		return
+	}
+
+	if user.ID == actorID {
+		api.Error(c, http.StatusForbidden, "administrators cannot change their own role")
+		return
+	}
+
+	ctx := c.Request.Context()
+	if prev, err = user.Role(ctx); err != nil {
+		log.Error().Err(err).Msg("could not fetch user role from database")
+		api.Error(c, http.StatusInternalServerError, "could not set user role")
+		return
+	}
+
+	if err = user.SetRole(ctx, in.Role); err != nil {
+		if errors.Is(db.Check(err), db.ErrNotFound) {
+			api.Error(c, http.StatusBadRequest, api.ErrUnknownUserRole)
+			return
+		}
+
+		log.Error().Err(err).Msg("could not update user role")
+		api.Error(c, http.StatusInternalServerError, "could not set user role")
+		return
+	}
+
+	s.audit(c, models.AuditRoleChanged, actorID, user.ID, prev.Title+" -> "+in.Role)
+	c.JSON(http.StatusOK, userReply(c, user))
+}
+
+// ImpersonateUser issues a short-lived access token that allows the administrator to
+// see exactly what the user sees. The token names the administrator in its actor claim
+// so that their requests are logged and it cannot be refreshed or used to change the
+// user's credentials. Administrators cannot impersonate disabled users or themselves.
+func (s *Server) ImpersonateUser(c *gin.Context) {
+	var (
+		err     error
+		claims  *auth.Claims
+		actorID int64
+		user    *models.User
+		target  *auth.Claims
+		out     *api.ImpersonateReply
+		expires time.Time
+	)
+
+	if claims, err = auth.GetClaims(c); err != nil {
+		log.Warn().Err(err).Msg("could not get claims to impersonate user")
+		api.Error(c, http.StatusInternalServerError, "could not impersonate user")
+		return
+	}
+
+	if actorID, err = claims.SubjectID(); err != nil {
+		log.Warn().Err(err).Msg("could not identify administrator to impersonate user")
+		api.Error(c, http.StatusInternalServerError, "could not impersonate user")
+		return
+	}
+
+	if user, err = s.adminUser(c, "could not impersonate user"); err != nil {
+		return
+	}
+
+	if user.ID == actorID {
+		api.Error(c, http.StatusBadRequest, "administrators cannot impersonate themselves")
+		return
+	}
+
+	if user.Disabled {
+		api.Error(c, http.StatusBadRequest, auth.ErrAccountDisabled)
+		return
+	}
+
+	if target, err = auth.NewClaimsForUser(c.Request.Context(), user); err != nil {
+		log.Error().Err(err).Msg("could not create claims to impersonate user")
+		api.Error(c, http.StatusInternalServerError, "could not impersonate user")
+		return
+	}
+
+	out = &api.ImpersonateReply{}
+	if out.AccessToken, expires, err = s.auth.CreateImpersonationToken(target, &auth.Actor{Subject: claims.Subject, Email: claims.Email}); err != nil {
+		log.Error().Err(err).Msg("could not create impersonation token")
+		api.Error(c, http.StatusInternalServerError, "could not impersonate user")
+		return
+	}
+	out.ExpiresAt = expires.Format(time.RFC3339)
+
+	s.audit(c, models.AuditImpersonation, actorID, user.ID, target.ID)
+	c.JSON(http.StatusOK, out)
+}
+
+// DisableUser prevents the user from logging in, reauthenticating, or using their API
+// keys. Tokens that have already been issued to the user are revoked.
+func (s *Server) DisableUser(c *gin.Context) {
+	s.setUserDisabled(c, true)
+}
+
+// EnableUser allows a previously disabled user to login again.
+func (s *Server) EnableUser(c *gin.Context) {
+	s.setUserDisabled(c, false)
+}
+
+func (s *Server) setUserDisabled(c *gin.Context, disabled bool) {
+	var (
+		err     error
+		actorID int64
+		user    *models.User
+		keys    []*models.APIKey
+	)
+
+	if actorID, err = subjectID(c); err != nil {
+		log.Warn().Err(err).Msg("could not identify administrator to disable user")
+		api.Error(c, http.StatusInternalServerError, "could not update user")
+		return
+	}
+
+	if user, err = s.adminUser(c, "could not update user"); err != nil {
+		return
+	}
+
+	if user.ID == actorID {
+		api.Error(c, http.StatusForbidden, "administrators cannot disable their own account")
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err = user.SetDisabled(ctx, disabled); err != nil {
+		log.Error().Err(err).Msg("could not update user disabled state")
+		api.Error(c, http.StatusInternalServerError, "could not update user")
+		return
+	}
+
+	if disabled {
+		// Revoke the tokens of the user so that they are rejected on every route rather
+		// than only by the routes that check permission versions.
+		if err = s.revocations.Revoke(ctx, user.ID); err != nil {
+			log.Error().Err(err).Int64("user_id", user.ID).Msg("could not revoke tokens of disabled user")
+			api.Error(c, http.StatusInternalServerError, "could not update user")
+			return
+		}
+
+		// Ensure that cached API key credentials of the user are rejected immediately
+		if keys, err = models.ListAPIKeys(ctx, user.ID); err != nil {
+			log.Warn().Err(err).Msg("could not list api keys of disabled user")
+		}
+
+		for _, key := range keys {
+			s.apikeys.Revoke(key.ClientID)
+		}
+		s.audit(c, models.AuditAccountDisabled, actorID, user.ID, "")
+	} else {
+		s.audit(c, models.AuditAccountEnabled, actorID, user.ID, "")
+	}
+
+	c.JSON(http.StatusOK, userReply(c, user))
+}
+
+// UnlockUser clears any failed logins and lockouts on the specified user's account so
+// that they can login again without waiting for the lockout to expire.
+func (s *Server) UnlockUser(c *gin.Context) {
+	var (
+		err     error
+		actorID int64
+		user    *models.User
+	)
+
+	if actorID, err = subjectID(c); err != nil {
+		log.Warn().Err(err).Msg("could not identify administrator to unlock user")
+		api.Error(c, http.StatusInternalServerError, "could not unlock user")
+		return
+	}
+
+	if user, err = s.adminUser(c, "could not unlock user"); err != nil {
+		return
+	}
+
+	if err = models.ResetLoginAttempts(c.Request.Context(), models.UserLoginKey(user.ID)); err != nil {
+		log.Error().Err(err).Msg("could not reset user login attempts")
+		api.Error(c, http.StatusInternalServerError, "could not unlock user")
+		return
+	}
+
+	s.audit(c, models.AuditAccountUnlocked, actorID, user.ID, "")
+	c.JSON(http.StatusOK, &api.Reply{Success: true})
+}
+
+// ListRoles returns all roles and their permissions.
+func (s *Server) ListRoles(c *gin.Context) {
+	var (
+		err   error
+		roles []*models.Role
+	)
+
+	if roles, err = models.ListRoles(c.Request.Context()); err != nil {
+		log.Error().Err(err).Msg("could not list roles from the database")
+		api.Error(c, http.StatusInternalServerError, "could not list roles")
+		return
+	}
+
+	out := &api.RoleList{Roles: make([]*api.Role, 0, len(roles))}
+	for _, role := range roles {
+		out.Roles = append(out.Roles, roleReply(c, role))
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// CreateRole creates a custom role with the specified permissions.
+func (s *Server) CreateRole(c *gin.Context) {
+	var (
+		err     error
+		in      *api.CreateRoleRequest
+		actorID int64
+		role    *models.Role
+	)
+
+	in = &api.CreateRoleRequest{}
+	if err = c.BindJSON(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if actorID, err = subjectID(c); err != nil {
+		log.Warn().Err(err).Msg("could not identify administrator to create role")
+		api.Error(c, http.StatusInternalServerError, "could not create role")
+		return
+	}
+
+	role = &models.Role{Title: in.Title, RequireMFA: in.RequireMFA}
+	role.Description.Valid, role.Description.String = in.Description != "", in.Description
+
+	if err = models.CreateRole(c.Request.Context(), role, in.Permissions); err != nil {
+		switch {
+		case errors.Is(err, models.ErrUnknownPermission):
+			api.Error(c, http.StatusBadRequest, err)
+		case errors.Is(db.Check(err), db.ErrAlreadyExists):
+			api.Error(c, http.StatusConflict, "role already exists")
+		default:
+			log.Error().Err(err).Msg("could not create role")
+			api.Error(c, http.StatusInternalServerError, "could not create role")
+		}
+		return
+	}
+
+	s.audit(c, models.AuditRoleCreated, actorID, 0, roleDetail(role.Title, in.Permissions))
+	c.JSON(http.StatusCreated, roleReply(c, role))
+}
+
+// SetRolePermissions replaces the permissions of the role. Administrators cannot remove
+// the users:manage permission from their own role to prevent them being locked out.
+func (s *Server) SetRolePermissions(c *gin.Context) {
+	var (
+		err     error
+		in      *api.RolePermissionsRequest
+		actorID int64
+		actor   *models.User
+		role    *models.Role
+	)
+
+	in = &api.RolePermissionsRequest{}
+	if err = c.BindJSON(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if actorID, err = subjectID(c); err != nil {
+		log.Warn().Err(err).Msg("could not identify administrator to update role")
+		api.Error(c, http.StatusInternalServerError, "could not update role")
+		return
+	}
+
+	if role, err = s.adminRole(c, "could not update role"); err != nil {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if actor, err = models.GetUser(ctx, actorID); err != nil {
+		log.Error().Err(err).Msg("could not fetch administrator from database")
+		api.Error(c, http.StatusInternalServerError, "could not update role")
+		return
+	}
+
+	if actor.RoleID.Int64 == role.ID && !contains(in.Permissions, "users:manage") {
+		api.Error(c, http.StatusForbidden, "administrators cannot remove users:manage from their own role")
+		return
+	}
+
+	if err = role.SetPermissions(ctx, in.Permissions); err != nil {
+		if errors.Is(err, models.ErrUnknownPermission) {
+			api.Error(c, http.StatusBadRequest, err)
+			return
+		}
+
+		log.Error().Err(err).Msg("could not update role permissions")
+		api.Error(c, http.StatusInternalServerError, "could not update role")
+		return
+	}
+
+	s.audit(c, models.AuditRoleUpdated, actorID, 0, roleDetail(role.Title, in.Permissions))
+	c.JSON(http.StatusOK, roleReply(c, role))
+}
+
+// DeleteRole deletes a custom role. The default role and roles that are assigned to
+// users or galaxy players cannot be deleted.
+func (s *Server) DeleteRole(c *gin.Context) {
+	var (
+		err     error
+		actorID int64
+		role    *models.Role
+	)
+
+	if actorID, err = subjectID(c); err != nil {
+		log.Warn().Err(err).Msg("could not identify administrator to delete role")
+		api.Error(c, http.StatusInternalServerError, "could not delete role")
+		return
+	}
+
+	if role, err = s.adminRole(c, "could not delete role"); err != nil {
+		return
+	}
+
+	if role.IsDefault {
+		api.Error(c, http.StatusConflict, "the default role cannot be deleted")
+		return
+	}
+
+	if err = models.DeleteRole(c.Request.Context(), role.ID); err != nil {
+		if errors.Is(db.Check(err), db.ErrInUse) {
+			api.Error(c, http.StatusConflict, "role is in use by users or galaxy players")
+			return
+		}
+
+		log.Error().Err(err).Msg("could not delete role")
+		api.Error(c, http.StatusInternalServerError, "could not delete role")
+		return
+	}
+
+	s.audit(c, models.AuditRoleDeleted, actorID, 0, role.Title)
+	c.JSON(http.StatusOK, &api.Reply{Success: true})
+}
+
+// ListPermissions returns all permissions that can be assigned to roles.
+func (s *Server) ListPermissions(c *gin.Context) {
+	var (
+		err         error
+		permissions []*models.Permission
+	)
+
+	if permissions, err = models.ListPermissions(c.Request.Context()); err != nil {
+		log.Error().Err(err).Msg("could not list permissions from the database")
+		api.Error(c, http.StatusInternalServerError, "could not list permissions")
+		return
+	}
+
+	out := &api.PermissionList{Permissions: make([]*api.Permission, 0, len(permissions))}
+	for _, perm := range permissions {
+		out.Permissions = append(out.Permissions, &api.Permission{
+			ID:          perm.ID,
+			Title:       perm.Title,
+			Description: perm.Description.String,
+		})
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// adminUser fetches the user identified by the id parameter of the request. If the
+// user cannot be fetched an error response is written and an error is returned.
+func (s *Server) adminUser(c *gin.Context, msg string) (user *models.User, err error) {
+	var userID int64
+	if userID, err = strconv.ParseInt(c.Param("id"), 10, 64); err != nil {
+		api.Error(c, http.StatusBadRequest, api.ErrMissingID)
+		return nil, err
+	}
+
+	if user, err = models.GetUser(c.Request.Context(), userID); err != nil {
+		if errors.Is(db.Check(err), db.ErrNotFound) {
+			api.Error(c, http.StatusNotFound, "user not found")
+			return nil, err
+		}
+
+		log.Error().Err(err).Msg("could not fetch user from database")
+		api.Error(c, http.StatusInternalServerError, msg)
+		return nil, err
+	}
+	return user, nil
+}
+
+// adminRole fetches the role identified by the id parameter of the request. If the
+// role cannot be fetched an error response is written and an error is returned.
+func (s *Server) adminRole(c *gin.Context, msg string) (role *models.Role, err error) {
+	var roleID int64
+	if roleID, err = strconv.ParseInt(c.Param("id"), 10, 64); err != nil {
+		api.Error(c, http.StatusBadRequest, api.ErrMissingID)
+		return nil, err
+	}
+
+	if role, err = models.GetRole(c.Request.Context(), roleID); err != nil {
+		if errors.Is(db.Check(err), db.ErrNotFound) {
+			api.Error(c, http.StatusNotFound, "role not found")
+			return nil, err
+		}
+
+		log.Error().Err(err).Msg("could not fetch role from database")
+		api.Error(c, http.StatusInternalServerError, msg)
+		return nil, err
+	}
+	return role, nil
+}
+
+// subjectID returns the ID of the authenticated user from the claims on the request.
+func subjectID(c *gin.Context) (_ int64, err error) {
+	var claims *auth.Claims
+	if claims, err = auth.GetClaims(c); err != nil {
+		return 0, err
+	}
+	return claims.SubjectID()
+}
+
+func userReply(c *gin.Context, user *models.User) *api.User {
+	out := &api.User{
+		ID:       user.ID,
+		Name:     user.Name.String,
+		Email:    user.Email,
+		Disabled: user.Disabled,
+		Created:  user.Created.Format(time.RFC3339),
+	}
+
+	if title, err := user.RoleTitle(c.Request.Context()); err == nil {
+		out.Role = title
+	}
+
+	if user.LastLogin.Valid {
+		out.LastLogin = user.LastLogin.Time.Format(time.RFC3339)
+	}
+	return out
+}
+
+func roleReply(c *gin.Context, role *models.Role) *api.Role {
+	out := &api.Role{
+		ID:          role.ID,
+		Title:       role.Title,
+		Description: role.Description.String,
+		IsDefault:   role.IsDefault,
+		RequireMFA:  role.RequireMFA,
+		Permissions: make([]string, 0),
+		Created:     role.Created.Format(time.RFC3339),
+	}
+
+	if perms, err := role.Permissions(c.Request.Context()); err == nil {
+		for _, perm := range perms {
+			out.Permissions = append(out.Permissions, perm.Title)
+		}
+	}
+	return out
+}
+
+func roleDetail(title string, permissions []string) string {
+	return title + ": " + strings.Join(permissions, ",")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
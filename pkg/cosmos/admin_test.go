@@ -0,0 +1,127 @@
+package cosmos
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bbengfort/cosmos/internal/dbtest"
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListUsers(t *testing.T) {
+	t.Setenv("COSMOS_MODE", "test")
+	t.Setenv("COSMOS_DATABASE_TESTING", "true")
+	t.Setenv("COSMOS_RATELIMIT_ENABLED", "false")
+	conf, err := config.New()
+	require.NoError(t, err)
+
+	s, err := New(conf)
+	require.NoError(t, err)
+	s.SetStatus(true, true)
+
+	mock := dbtest.Connect(t)
+
+	claims := &auth.Claims{Role: "Admin", Permissions: []string{"users:manage"}, AMR: []string{auth.AMRMFA}, UserVersion: 1, RoleVersion: 1}
+	claims.SetSubjectID(1)
+	s.versions.Update(1, models.PermissionVersion{UserVersion: 1, Role: "Admin", RoleVersion: 1})
+	token, _, err := s.auth.CreateTokens(claims)
+	require.NoError(t, err)
+
+	// The roles of the users are joined by the list query rather than fetched per user
+	created := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	columns := []string{"id", "name", "email", "password", "role_id", "last_login", "disabled", "has_password", "perms_version", "created", "modified", "role_title"}
+	mock.ExpectBegin()
+	mock.ExpectQuery("LEFT JOIN roles r ON u.role_id=r.id WHERE r.title=\\$1").WithArgs("Player", 50, 0).WillReturnRows(
+		sqlmock.NewRows(columns).
+			AddRow(2, "Jane", "jane@example.com", "", 3, nil, false, true, 1, created, created, "Player").
+			AddRow(3, "Bob", "bob@example.com", "", 3, nil, true, true, 1, created, created, "Player").
+			AddRow(4, nil, "kate@example.com", "", nil, nil, false, false, 1, created, created, nil),
+	)
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/admin/users?role=Player", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	out := &api.UserList{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), out))
+	require.Len(t, out.Users, 3)
+	require.Equal(t, "Player", out.Users[0].Role)
+	require.Equal(t, "Player", out.Users[1].Role)
+	require.True(t, out.Users[1].Disabled)
+	require.Empty(t, out.Users[2].Role)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDisableUser(t *testing.T) {
+	t.Setenv("COSMOS_MODE", "test")
+	t.Setenv("COSMOS_DATABASE_TESTING", "true")
+	t.Setenv("COSMOS_RATELIMIT_ENABLED", "false")
+	conf, err := config.New()
+	require.NoError(t, err)
+
+	s, err := New(conf)
+	require.NoError(t, err)
+	s.SetStatus(true, true)
+
+	mock := dbtest.Connect(t)
+
+	claims := &auth.Claims{Role: "Admin", Permissions: []string{"users:manage"}, AMR: []string{auth.AMRMFA}, UserVersion: 1, RoleVersion: 1}
+	claims.SetSubjectID(1)
+	s.versions.Update(1, models.PermissionVersion{UserVersion: 1, Role: "Admin", RoleVersion: 1})
+	token, _, err := s.auth.CreateTokens(claims)
+	require.NoError(t, err)
+
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM users WHERE id=").WithArgs(int64(2)).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "name", "email", "password", "role_id", "last_login", "disabled", "has_password", "perms_version", "created", "modified"}).
+			AddRow(2, "Jane", "jane@example.com", "", 3, nil, false, true, 1, now, now),
+	)
+	mock.ExpectQuery("FROM roles WHERE id=").WithArgs(3).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "title", "description", "is_default", "require_mfa", "version", "created", "modified"}).
+			AddRow(3, "Player", nil, true, false, 1, now, now),
+	)
+	mock.ExpectQuery("FROM role_permissions").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "created", "modified"}))
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE users SET disabled=").WithArgs(true, int64(2)).WillReturnRows(sqlmock.NewRows([]string{"perms_version"}).AddRow(2))
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO token_revocations").WithArgs(int64(2), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM api_keys WHERE user_id=").WithArgs(int64(2)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO audit_log").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/admin/users/2/disable", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// Tokens issued to the user before they were disabled are rejected
+	issued := &auth.Claims{}
+	issued.SetSubjectID(2)
+	issued.IssuedAt = jwt.NewNumericDate(now.Add(-time.Minute))
+	require.True(t, s.revocations.Revoked(issued))
+}
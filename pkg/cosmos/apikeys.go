@@ -0,0 +1,324 @@
+package cosmos
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// ListAPIKeys returns the API keys owned by the user without their secrets.
+func (s *Server) ListAPIKeys(c *gin.Context) {
+	var (
+		err    error
+		userID int64
+		claims *auth.Claims
+		keys   []*models.APIKey
+	)
+
+	if claims, err = auth.GetClaims(c); err != nil {
+		log.Warn().Err(err).Msg("could not get claims from request")
+		api.Error(c, http.StatusInternalServerError, "could not list api keys")
+		return
+	}
+
+	if userID, err = claims.SubjectID(); err != nil {
+		log.Warn().Err(err).Msg("could not parse user ID from claims")
+		api.Error(c, http.StatusInternalServerError, "could not list api keys")
+		return
+	}
+
+	if keys, err = models.ListAPIKeys(c.Request.Context(), userID); err != nil {
+		log.Error().Err(err).Msg("could not fetch api keys from the database")
+		api.Error(c, http.StatusInternalServerError, "could not list api keys")
+		return
+	}
+
+	out := &api.APIKeyList{APIKeys: make([]*api.APIKey, 0, len(keys))}
+	for _, key := range keys {
+		out.APIKeys = append(out.APIKeys, apiKeyReply(key))
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// CreateAPIKey creates a new API key for the user with a subset of their permissions.
+// The client secret is returned in the response and cannot be retrieved again.
+func (s *Server) CreateAPIKey(c *gin.Context) {
+	var (
+		err    error
+		in     *api.CreateAPIKeyRequest
+		out    *api.APIKey
+		userID int64
+		secret string
+		claims *auth.Claims
+		key    *models.APIKey
+	)
+
+	in = &api.CreateAPIKeyRequest{}
+	if err = c.BindJSON(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if claims, err = auth.GetClaims(c); err != nil {
+		log.Warn().Err(err).Msg("could not get claims from request")
+		api.Error(c, http.StatusInternalServerError, "could not create api key")
+		return
+	}
+
+	if userID, err = claims.SubjectID(); err != nil {
+		log.Warn().Err(err).Msg("could not parse user ID from claims")
+		api.Error(c, http.StatusInternalServerError, "could not create api key")
+		return
+	}
+
+	// API keys cannot be used to create other API keys
+	if claims.ClientID != "" {
+		api.Error(c, http.StatusForbidden, auth.ErrNotAuthorized)
+		return
+	}
+
+	// API keys can only be granted permissions that the user has
+	if !claims.HasAllPermissions(in.Permissions...) {
+		api.Error(c, http.StatusForbidden, "cannot grant permissions that the user does not have")
+		return
+	}
+
+	key = &models.APIKey{Name: in.Name, UserID: userID}
+	if in.Expires != "" {
+		expires, _ := time.Parse(time.RFC3339, in.Expires)
+		key.Expires.Valid, key.Expires.Time = true, expires
+	}
+
+	if key.ClientID, secret, err = auth.NewAPIKeyCredentials(); err != nil {
+		log.Error().Err(err).Msg("could not generate api key credentials")
+		api.Error(c, http.StatusInternalServerError, "could not create api key")
+		return
+	}
+
+	if key.Secret, err = auth.CreateDerivedKey(secret); err != nil {
+		log.Error().Err(err).Msg("could not create derived key for api key secret")
+		api.Error(c, http.StatusInternalServerError, "could not create api key")
+		return
+	}
+
+	if err = models.CreateAPIKey(c.Request.Context(), key, in.Permissions); err != nil {
+		if errors.Is(err, models.ErrUnknownPermission) {
+			api.Error(c, http.StatusBadRequest, err)
+			return
+		}
+
+		log.Error().Err(err).Msg("could not create api key")
+		api.Error(c, http.StatusInternalServerError, "could not create api key")
+		return
+	}
+
+	s.audit(c, models.AuditAPIKeyCreated, userID, userID, key.ClientID)
+
+	out = apiKeyReply(key)
+	out.ClientSecret = secret
+	c.JSON(http.StatusCreated, out)
+}
+
+// DeleteAPIKey deletes one of the user's API keys and revokes the tokens issued to it.
+func (s *Server) DeleteAPIKey(c *gin.Context) {
+	var (
+		err      error
+		keyID    int64
+		userID   int64
+		clientID string
+		claims   *auth.Claims
+	)
+
+	if keyID, err = strconv.ParseInt(c.Param("id"), 10, 64); err != nil {
+		api.Error(c, http.StatusBadRequest, api.ErrMissingID)
+		return
+	}
+
+	if claims, err = auth.GetClaims(c); err != nil {
+		log.Warn().Err(err).Msg("could not get claims from request")
+		api.Error(c, http.StatusInternalServerError, "could not delete api key")
+		return
+	}
+
+	if userID, err = claims.SubjectID(); err != nil {
+		log.Warn().Err(err).Msg("could not parse user ID from claims")
+		api.Error(c, http.StatusInternalServerError, "could not delete api key")
+		return
+	}
+
+	if clientID, err = models.DeleteAPIKey(c.Request.Context(), userID, keyID); err != nil {
+		if errors.Is(db.Check(err), db.ErrNotFound) {
+			api.Error(c, http.StatusNotFound, "api key not found")
+			return
+		}
+
+		log.Error().Err(err).Msg("could not delete api key")
+		api.Error(c, http.StatusInternalServerError, "could not delete api key")
+		return
+	}
+
+	// Evict the cached credentials of the key and revoke the tokens that were issued to
+	// it so that the key cannot be used on any route once it has been deleted.
+	s.apikeys.Revoke(clientID)
+	if err = s.revocations.RevokeClient(c.Request.Context(), userID, clientID); err != nil {
+		log.Error().Err(err).Str("client_id", clientID).Msg("could not revoke tokens of deleted api key")
+		api.Error(c, http.StatusInternalServerError, "could not delete api key")
+		return
+	}
+
+	s.audit(c, models.AuditAPIKeyDeleted, userID, userID, clientID)
+	c.JSON(http.StatusOK, &api.Reply{Success: true})
+}
+
+var errAuthenticationFailed = errors.New("authentication failed")
+
+// The server authenticates the API key credentials of requests with the login throttle.
+var _ auth.KeyAuthenticator = &Server{}
+
+// APIKeyLogin exchanges API key credentials for an access token with the permissions
+// of the API key. No refresh token is issued; clients should exchange their
+// credentials again when the access token expires.
+func (s *Server) APIKeyLogin(c *gin.Context) {
+	var (
+		err error
+		in  *api.APIKeyLoginRequest
+		out *api.LoginReply
+	)
+
+	in = &api.APIKeyLoginRequest{}
+	if err = c.BindJSON(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if out, err = s.authenticateKey(c.Request.Context(), c.ClientIP(), in); err != nil {
+		switch {
+		case errors.Is(err, errAuthenticationFailed):
+			api.Error(c, http.StatusForbidden, "authentication failed")
+		case errors.Is(err, auth.ErrAccountDisabled):
+			api.Error(c, http.StatusForbidden, err)
+		default:
+			api.Error(c, http.StatusInternalServerError, "authentication failed")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+// authenticateKey exchanges validated API key credentials from the client IP address
+// for an access token. Returns errAuthenticationFailed if the credentials are invalid
+// or the client is throttled and auth.ErrAccountDisabled if the owner of the API key
+// is disabled; any other error is an internal error that has already been logged.
+func (s *Server) authenticateKey(ctx context.Context, clientIP string, in *api.APIKeyLoginRequest) (out *api.LoginReply, err error) {
+	var (
+		claims *auth.Claims
+		token  *jwt.Token
+	)
+
+	if claims, err = s.AuthenticateKey(ctx, clientIP, in.ClientID, in.ClientSecret); err != nil {
+		return nil, err
+	}
+
+	if token, err = s.auth.CreateAccessToken(claims); err != nil {
+		log.Error().Err(err).Msg("could not create access token for api key")
+		return nil, err
+	}
+
+	out = &api.LoginReply{}
+	if out.AccessToken, err = s.auth.Sign(token); err != nil {
+		log.Error().Err(err).Msg("could not sign access token for api key")
+		return nil, err
+	}
+	return out, nil
+}
+
+// AuthenticateKey verifies API key credentials from the client IP address, which are
+// subject to the same brute-force protection as passwords: failures are throttled by
+// client IP address and by client ID before the secret is verified. Credentials that
+// were recently verified are accepted without checking the throttle so that requests
+// authenticated with API keys do not query the database. Returns the same errors as
+// authenticateKey.
+func (s *Server) AuthenticateKey(ctx context.Context, clientIP, clientID, secret string) (claims *auth.Claims, err error) {
+	var ok bool
+	if claims, ok = s.apikeys.Cached(ctx, clientID, secret); ok {
+		return claims, nil
+	}
+
+	var client, key *models.LoginAttempts
+	if client, err = models.GetLoginAttempts(ctx, models.ClientLoginKey(clientIP)); err != nil {
+		log.Error().Err(err).Msg("could not fetch client login attempts from database")
+		return nil, err
+	}
+
+	if key, err = models.GetLoginAttempts(ctx, models.APIKeyLoginKey(clientID)); err != nil {
+		log.Error().Err(err).Msg("could not fetch api key login attempts from database")
+		return nil, err
+	}
+
+	if !s.throttle.Allowed(client) || !s.throttle.Allowed(key) {
+		s.auditEvent(ctx, clientIP, models.AuditLoginThrottled, 0, 0, clientID)
+		return nil, errAuthenticationFailed
+	}
+
+	if claims, err = s.apikeys.AuthenticateKey(ctx, clientID, secret); err != nil {
+		if errors.Is(err, auth.ErrInvalidAPIKey) || errors.Is(err, auth.ErrExpiredAPIKey) {
+			s.loginFailure(ctx, clientIP, nil, clientID, client, key)
+			return nil, errAuthenticationFailed
+		}
+
+		if errors.Is(err, auth.ErrAccountDisabled) {
+			return nil, err
+		}
+
+		log.Error().Err(err).Msg("could not authenticate api key")
+		return nil, err
+	}
+
+	// Clear any failed attempts with the client ID now that the key has been verified
+	if key.Failures > 0 {
+		if err = models.ResetLoginAttempts(ctx, key.Key); err != nil {
+			log.Warn().Err(err).Msg("could not reset api key login attempts")
+		}
+	}
+	return claims, nil
+}
+
+func apiKeyReply(key *models.APIKey) *api.APIKey {
+	out := &api.APIKey{
+		ID:          key.ID,
+		ClientID:    key.ClientID,
+		Name:        key.Name,
+		Permissions: key.Permissions(),
+		Created:     key.Created.Format(time.RFC3339),
+	}
+
+	if key.Expires.Valid {
+		out.Expires = key.Expires.Time.Format(time.RFC3339)
+	}
+
+	if key.LastUsed.Valid {
+		out.LastUsed = key.LastUsed.Time.Format(time.RFC3339)
+	}
+	return out
+}
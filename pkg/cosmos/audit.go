@@ -0,0 +1,42 @@
+package cosmos
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// audit records a security sensitive action in the audit log and in the server logs.
+// The actorID is the user who performed the action and the userID is the account that
+// was acted upon; a zero ID is recorded as null. Failing to write to the audit log is
+// logged but does not cause the request to fail.
+func (s *Server) audit(c *gin.Context, action string, actorID, userID int64, detail string) {
+	s.auditEvent(c.Request.Context(), c.ClientIP(), action, actorID, userID, detail)
+}
+
+// auditEvent records an action in the audit log for callers that are not handling an
+// http request, e.g. calls to the grpc api.
+func (s *Server) auditEvent(ctx context.Context, clientIP, action string, actorID, userID int64, detail string) {
+	event := &models.AuditEvent{
+		ActorID:  sql.NullInt64{Valid: actorID > 0, Int64: actorID},
+		UserID:   sql.NullInt64{Valid: userID > 0, Int64: userID},
+		ClientIP: clientIP,
+		Action:   action,
+		Detail:   sql.NullString{Valid: detail != "", String: detail},
+	}
+
+	log.Info().
+		Str("audit", action).
+		Int64("actor_id", actorID).
+		Int64("user_id", userID).
+		Str("client_ip", event.ClientIP).
+		Str("detail", detail).
+		Msg("audit event")
+
+	if err := models.CreateAuditEvent(ctx, event); err != nil {
+		log.Error().Err(err).Str("audit", action).Msg("could not write to the audit log")
+	}
+}
@@ -1,15 +1,20 @@
 package cosmos
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/bbengfort/cosmos/pkg/api/v1"
 	"github.com/bbengfort/cosmos/pkg/auth"
 	"github.com/bbengfort/cosmos/pkg/db"
 	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/bbengfort/cosmos/pkg/otp"
 	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v4"
 	"github.com/rs/zerolog/log"
 )
 
@@ -22,12 +27,12 @@ func (s *Server) Register(c *gin.Context) {
 
 	in = &api.RegisterRequest{}
 	if err = c.BindJSON(in); err != nil {
-		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		api.Error(c, http.StatusBadRequest, err)
 		return
 	}
 
 	if err = in.Validate(); err != nil {
-		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		api.Error(c, http.StatusBadRequest, err)
 		return
 	}
 
@@ -38,18 +43,18 @@ func (s *Server) Register(c *gin.Context) {
 
 	if user.Password, err = auth.CreateDerivedKey(in.Password); err != nil {
 		log.Warn().Err(err).Msg("could not create derived key for password")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not complete registration"))
+		api.Error(c, http.StatusInternalServerError, "could not complete registration")
 		return
 	}
 
 	if err = models.CreateUser(c.Request.Context(), user); err != nil {
 		if errors.Is(db.Check(err), db.ErrAlreadyExists) {
-			c.JSON(http.StatusBadRequest, api.ErrorResponse("user already exists"))
+			api.Error(c, http.StatusBadRequest, "user already exists")
 			return
 		}
 
 		log.Error().Err(err).Msg("could not create new user in database")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not complete registration"))
+		api.Error(c, http.StatusInternalServerError, "could not complete registration")
 		return
 	}
 
@@ -68,71 +73,429 @@ func (s *Server) Register(c *gin.Context) {
 
 func (s *Server) Login(c *gin.Context) {
 	var (
-		err    error
-		in     *api.LoginRequest
-		out    *api.LoginReply
-		user   *models.User
-		claims *auth.Claims
+		err      error
+		in       *api.LoginRequest
+		user     *models.User
+		client   *models.LoginAttempts
+		attempts *models.LoginAttempts
 	)
 
 	in = &api.LoginRequest{}
 	if err = c.BindJSON(in); err != nil {
-		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// Check if the client has been throttled before doing any expensive work.
+	// NOTE: all failures must return the same response to prevent account enumeration.
+	ctx := c.Request.Context()
+	if client, err = models.GetLoginAttempts(ctx, models.ClientLoginKey(c.ClientIP())); err != nil {
+		log.Error().Err(err).Msg("could not fetch client login attempts from database")
+		api.Error(c, http.StatusInternalServerError, "authentication failed")
+		return
+	}
+
+	if !s.throttle.Allowed(client) {
+		s.audit(c, models.AuditLoginThrottled, 0, 0, in.Username)
+		api.Error(c, http.StatusForbidden, "authentication failed")
 		return
 	}
 
 	// Fetch the user from the database to authenticate (username is the user's email)
-	if user, err = models.GetUser(c.Request.Context(), in.Username); err != nil {
+	if user, err = models.GetUser(ctx, in.Username); err != nil {
 		if errors.Is(db.Check(err), db.ErrNotFound) {
-			c.JSON(http.StatusForbidden, api.ErrorResponse("authentication failed"))
+			// Verify the password anyway so that unknown accounts take as long to fail
+			auth.VerifyDerivedKey(auth.DummyDerivedKey(), in.Password)
+			s.loginFailed(c, nil, in.Username, client)
+			api.Error(c, http.StatusForbidden, "authentication failed")
 			return
 		}
 
 		log.Error().Err(err).Msg("could not fetch user from database")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse("authentication failed"))
+		api.Error(c, http.StatusInternalServerError, "authentication failed")
+		return
+	}
+
+	// Check if the account has been locked or throttled before verifying the password
+	if attempts, err = models.GetLoginAttempts(ctx, models.UserLoginKey(user.ID)); err != nil {
+		log.Error().Err(err).Msg("could not fetch user login attempts from database")
+		api.Error(c, http.StatusInternalServerError, "authentication failed")
+		return
+	}
+
+	if !s.throttle.Allowed(attempts) {
+		s.audit(c, models.AuditLoginThrottled, 0, user.ID, in.Username)
+		api.Error(c, http.StatusForbidden, "authentication failed")
 		return
 	}
 
 	// Authenticate the user with their password
-	var verified bool
-	if verified, err = auth.VerifyDerivedKey(user.Password, in.Password); err != nil {
+	var verified, outdated bool
+	if verified, outdated, err = auth.VerifyDerivedKey(user.Password, in.Password); err != nil {
 		log.Error().Err(err).Msg("could not verify derived key")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse("authentication failed"))
+		api.Error(c, http.StatusInternalServerError, "authentication failed")
 		return
 	}
 
 	// Wrong password
 	if !verified {
-		c.JSON(http.StatusForbidden, api.ErrorResponse("authentication failed"))
+		s.loginFailed(c, user, in.Username, client, attempts)
+		api.Error(c, http.StatusForbidden, "authentication failed")
+		return
+	}
+
+	// Upgrade the derived key if it was created with outdated parameters; this is the
+	// only time the plaintext password is available to create a new derived key.
+	if outdated {
+		s.rehashPassword(c, user, in.Password)
+	}
+
+	// Only reveal that the account is disabled once the password has been verified
+	if s.accountDisabled(c, user) {
+		return
+	}
+
+	if s.secondFactorRequired(c, user, auth.AMRPassword) {
+		return
+	}
+	s.completeLogin(c, user, attempts, auth.AMRPassword)
+}
+
+// secondFactorRequired checks if the user has enrolled a second factor, in which case
+// they must submit a code before tokens are issued. If so, a short-lived MFA token that
+// can be exchanged along with the code is returned to the user and true is returned.
+// True is also returned if a response was written because of an error.
+func (s *Server) secondFactorRequired(c *gin.Context, user *models.User, amr ...string) bool {
+	var (
+		err  error
+		totp *models.TOTP
+	)
+
+	if totp, err = models.GetTOTP(c.Request.Context(), user.ID); err != nil && !errors.Is(err, db.ErrNotFound) {
+		log.Error().Err(err).Msg("could not fetch user totp enrollment")
+		api.Error(c, http.StatusInternalServerError, "authentication failed")
+		return true
+	}
+
+	if totp == nil || !totp.Verified {
+		return false
+	}
+
+	claims := &auth.Claims{}
+	claims.SetSubjectID(user.ID)
+
+	out := &api.LoginReply{MFARequired: true}
+	if out.MFAToken, err = s.auth.CreateMFAToken(claims.Subject, amr...); err != nil {
+		log.Error().Err(err).Msg("could not create mfa token for user")
+		api.Error(c, http.StatusInternalServerError, "authentication failed")
+		return true
+	}
+
+	c.JSON(http.StatusOK, out)
+	return true
+}
+
+// LoginMFA completes a two-step login by exchanging the MFA token returned by Login (or
+// by an external identity provider callback) along with a TOTP or recovery code for
+// access and refresh tokens.
+func (s *Server) LoginMFA(c *gin.Context) {
+	var (
+		err      error
+		in       *api.MFALoginRequest
+		userID   int64
+		user     *models.User
+		claims   *auth.Claims
+		totp     *models.TOTP
+		client   *models.LoginAttempts
+		attempts *models.LoginAttempts
+	)
+
+	in = &api.MFALoginRequest{}
+	if err = c.BindJSON(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
 		return
 	}
 
+	// The MFA token ensures the first step of the login was completed recently
+	if claims, err = s.auth.VerifyMFAToken(in.MFAToken); err != nil {
+		log.Debug().Err(err).Msg("invalid mfa token")
+		api.Error(c, http.StatusForbidden, "authentication failed")
+		return
+	}
+
+	if userID, err = claims.SubjectID(); err != nil {
+		log.Warn().Err(err).Msg("could not parse user ID from mfa claims")
+		api.Error(c, http.StatusForbidden, "authentication failed")
+		return
+	}
+
+	// MFA tokens can only be exchanged once
+	if s.revocations.Revoked(claims) {
+		log.Debug().Str("jti", claims.ID).Msg("mfa token has already been used")
+		api.Error(c, http.StatusForbidden, "authentication failed")
+		return
+	}
+
+	ctx := c.Request.Context()
+	if user, err = models.GetUser(ctx, userID); err != nil {
+		log.Error().Err(err).Msg("could not fetch user from database")
+		api.Error(c, http.StatusInternalServerError, "authentication failed")
+		return
+	}
+
+	if s.accountDisabled(c, user) {
+		return
+	}
+
+	// Codes are subject to the same brute-force protection as passwords
+	if client, err = models.GetLoginAttempts(ctx, models.ClientLoginKey(c.ClientIP())); err != nil {
+		log.Error().Err(err).Msg("could not fetch client login attempts from database")
+		api.Error(c, http.StatusInternalServerError, "authentication failed")
+		return
+	}
+
+	if attempts, err = models.GetLoginAttempts(ctx, models.UserLoginKey(user.ID)); err != nil {
+		log.Error().Err(err).Msg("could not fetch user login attempts from database")
+		api.Error(c, http.StatusInternalServerError, "authentication failed")
+		return
+	}
+
+	if !s.throttle.Allowed(client) || !s.throttle.Allowed(attempts) {
+		s.audit(c, models.AuditLoginThrottled, 0, user.ID, user.Email)
+		api.Error(c, http.StatusForbidden, "authentication failed")
+		return
+	}
+
+	if totp, err = models.GetTOTP(ctx, user.ID); err != nil || !totp.Verified {
+		log.Warn().Err(err).Int64("user_id", user.ID).Msg("mfa login without verified totp enrollment")
+		api.Error(c, http.StatusForbidden, "authentication failed")
+		return
+	}
+
+	// Attempt to verify the code as a TOTP code, preventing the replay of codes.
+	var counter uint64
+	if counter, err = otp.Validate(totp.Secret, in.Code, time.Now()); err == nil {
+		var unused bool
+		if unused, err = totp.UseCounter(ctx, int64(counter)); err != nil {
+			log.Error().Err(err).Msg("could not update totp counter")
+			api.Error(c, http.StatusInternalServerError, "authentication failed")
+			return
+		}
+
+		if unused {
+			if s.exchangeMFAToken(c, claims, user) {
+				s.completeLogin(c, user, attempts, secondFactorAMR(claims.AMR, auth.AMROTP)...)
+			}
+			return
+		}
+	}
+
+	// Otherwise attempt to use the code as a recovery code.
+	var recovered bool
+	if recovered, err = models.UseRecoveryCode(ctx, user.ID, otp.HashRecoveryCode(in.Code)); err != nil {
+		log.Error().Err(err).Msg("could not check recovery code")
+		api.Error(c, http.StatusInternalServerError, "authentication failed")
+		return
+	}
+
+	if recovered {
+		s.audit(c, models.AuditRecoveryUsed, user.ID, user.ID, "")
+		if s.exchangeMFAToken(c, claims, user) {
+			s.completeLogin(c, user, attempts, secondFactorAMR(claims.AMR)...)
+		}
+		return
+	}
+
+	s.audit(c, models.AuditMFAFailed, 0, user.ID, "")
+	s.loginFailed(c, user, user.Email, client, attempts)
+	api.Error(c, http.StatusForbidden, "authentication failed")
+}
+
+// exchangeMFAToken revokes the MFA token so that it cannot be exchanged again, e.g. by
+// a concurrent request with another valid code. Returns false after writing an error
+// reply if the token has already been exchanged.
+func (s *Server) exchangeMFAToken(c *gin.Context, claims *auth.Claims, user *models.User) bool {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		log.Warn().Int64("user_id", user.ID).Msg("mfa token does not have an id or expiration")
+		api.Error(c, http.StatusForbidden, "authentication failed")
+		return false
+	}
+
+	unused, err := models.UseToken(c.Request.Context(), claims.ID, user.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		log.Error().Err(err).Msg("could not revoke mfa token")
+		api.Error(c, http.StatusInternalServerError, "authentication failed")
+		return false
+	}
+
+	if !unused {
+		log.Debug().Str("jti", claims.ID).Msg("mfa token has already been used")
+		api.Error(c, http.StatusForbidden, "authentication failed")
+		return false
+	}
+
+	s.revocations.AddToken(claims.ID, claims.ExpiresAt.Time)
+	return true
+}
+
+// completeLogin issues access and refresh tokens to a fully authenticated user, with
+// the specified authentication methods recorded in the amr claim.
+func (s *Server) completeLogin(c *gin.Context, user *models.User, attempts *models.LoginAttempts, amr ...string) {
+	var (
+		err    error
+		out    *api.LoginReply
+		claims *auth.Claims
+	)
+
 	// The user has been authenticated at this point: create access and refresh tokens
-	if claims, err = auth.NewClaimsForUser(c.Request.Context(), user); err != nil {
+	ctx := c.Request.Context()
+	if claims, err = auth.NewClaimsForUser(ctx, user); err != nil {
 		log.Error().Err(err).Msg("could not create claims for user")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse("authentication failed"))
+		api.Error(c, http.StatusInternalServerError, "authentication failed")
 		return
 	}
+	claims.AMR = amr
+	claims.AuthTime = jwt.NewNumericDate(time.Now())
 
+	// Users whose role requires a second factor can only enroll one until they do
 	out = &api.LoginReply{}
+	if out.MFAEnrollmentRequired, err = requireMFAEnrollment(ctx, user, claims); err != nil {
+		log.Error().Err(err).Msg("could not fetch user role from database")
+		api.Error(c, http.StatusInternalServerError, "authentication failed")
+		return
+	}
+
 	if out.AccessToken, out.RefreshToken, err = s.auth.CreateTokens(claims); err != nil {
 		log.Error().Err(err).Msg("could not create access and refresh tokens for user")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse("authentication failed"))
+		api.Error(c, http.StatusInternalServerError, "authentication failed")
 		return
 	}
 
 	// Update the last login timestamp for user tracking
-	if err = user.LoggedIn(c.Request.Context()); err != nil {
+	if err = user.LoggedIn(ctx); err != nil {
 		log.Error().Err(err).Msg("could not update last login timestamp")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse("authentication failed"))
+		api.Error(c, http.StatusInternalServerError, "authentication failed")
 		return
 	}
 
+	// Clear any failed logins for the account now that the user has authenticated
+	if attempts.Failures > 0 {
+		if err = models.ResetLoginAttempts(ctx, attempts.Key); err != nil {
+			log.Warn().Err(err).Msg("could not reset user login attempts")
+		}
+	}
+	s.audit(c, models.AuditLogin, user.ID, user.ID, strings.Join(amr, ","))
+
 	// Set credentials on cookies for web based applications
 	auth.SetAuthCookies(c, out.AccessToken, out.RefreshToken, s.conf.Auth.CookieDomain)
 	c.JSON(http.StatusOK, out)
 }
 
+// requireMFAEnrollment restricts the claims to enrolling a second factor if the role of
+// the user requires multi-factor authentication and the user did not authenticate with
+// a second factor, so that the permissions of the role cannot be used until the user
+// enrolls and logs in again. Returns true if the claims were restricted.
+func requireMFAEnrollment(ctx context.Context, user *models.User, claims *auth.Claims) (_ bool, err error) {
+	if claims.HasMFA() {
+		return false, nil
+	}
+
+	var role *models.Role
+	if role, err = user.Role(ctx); err != nil {
+		return false, err
+	}
+
+	if role.RequireMFA {
+		claims.RestrictToMFAEnrollment()
+		return true, nil
+	}
+	return false, nil
+}
+
+// secondFactorAMR returns the authentication methods of a completed two-step login:
+// the methods of the first step recorded in the MFA token and the second factor.
+func secondFactorAMR(first []string, methods ...string) []string {
+	amr := make([]string, 0, len(first)+len(methods)+1)
+	amr = append(amr, first...)
+	amr = append(amr, methods...)
+	return append(amr, auth.AMRMFA)
+}
+
+// accountDisabled returns true if the user's account has been disabled by an
+// administrator, writing a forbidden response and recording the failed login.
+func (s *Server) accountDisabled(c *gin.Context, user *models.User) bool {
+	if !user.Disabled {
+		return false
+	}
+
+	s.audit(c, models.AuditLoginFailed, 0, user.ID, auth.ErrAccountDisabled.Error())
+	api.Error(c, http.StatusForbidden, auth.ErrAccountDisabled)
+	return true
+}
+
+// rehashPassword creates a new derived key for the user's password using the current
+// derived key parameters. Failures are logged but do not prevent the user from logging
+// in, since the existing derived key is still valid.
+func (s *Server) rehashPassword(c *gin.Context, user *models.User, password string) {
+	var (
+		err error
+		dk  string
+	)
+
+	if dk, err = auth.CreateDerivedKey(password); err != nil {
+		log.Warn().Err(err).Msg("could not create derived key to rehash password")
+		return
+	}
+
+	if err = user.UpdatePassword(c.Request.Context(), dk); err != nil {
+		log.Warn().Err(err).Int64("user_id", user.ID).Msg("could not save rehashed password")
+		return
+	}
+
+	log.Info().Int64("user_id", user.ID).Msg("password rehashed with current derived key parameters")
+}
+
+// loginFailed records a failed login against the client and user login attempts so
+// that subsequent logins are throttled, and records the failure in the audit log. If
+// the failure causes the account to be locked then the lockout is also audited.
+func (s *Server) loginFailed(c *gin.Context, user *models.User, username string, attempts ...*models.LoginAttempts) {
+	s.loginFailure(c.Request.Context(), c.ClientIP(), user, username, attempts...)
+}
+
+// loginFailure records a failed login from the client IP address; see loginFailed.
+func (s *Server) loginFailure(ctx context.Context, clientIP string, user *models.User, username string, attempts ...*models.LoginAttempts) {
+	var userID int64
+	if user != nil {
+		userID = user.ID
+	}
+
+	s.auditEvent(ctx, clientIP, models.AuditLoginFailed, 0, userID, username)
+	for _, attempt := range attempts {
+		var locked bool
+		recorded, err := models.RecordLoginFailure(ctx, attempt.Key, s.throttle.Forget(), func(a *models.LoginAttempts) {
+			locked = s.throttle.Failed(a)
+		})
+		if err != nil {
+			log.Error().Err(err).Str("key", attempt.Key).Msg("could not save failed login attempt")
+			continue
+		}
+
+		if locked {
+			s.auditEvent(ctx, clientIP, models.AuditAccountLocked, 0, userID, recorded.LockedUntil.Time.Format(time.RFC3339))
+		}
+	}
+}
+
 func (s *Server) Logout(c *gin.Context) {
 	auth.ClearAuthCookies(c, s.conf.Auth.CookieDomain)
 	c.JSON(http.StatusOK, &api.Reply{Success: true})
@@ -158,7 +521,7 @@ func (s *Server) Reauthenticate(c *gin.Context) {
 		// from the cookies in the header of the request.
 		if in.RefreshToken, err = auth.GetRefreshToken(c); err != nil || in.RefreshToken == "" {
 			log.Debug().Err(err).Msg("could not get refresh token from request")
-			c.JSON(http.StatusBadRequest, api.ErrorResponse("no reauthentication credentials"))
+			api.Error(c, http.StatusBadRequest, "no reauthentication credentials")
 			return
 		}
 	}
@@ -167,62 +530,83 @@ func (s *Server) Reauthenticate(c *gin.Context) {
 	// NOTE: this will also validate the not before and not after claims
 	if refreshClaims, err = s.auth.Verify(in.RefreshToken); err != nil {
 		log.Debug().Err(err).Msg("invalid refresh token")
-		c.JSON(http.StatusForbidden, api.ErrorResponse("reauthentication failed"))
+		api.Error(c, http.StatusForbidden, "reauthentication failed")
 		return
 	}
 
 	// Fetch the access token from the request
 	if accessToken, err = auth.GetAccessToken(c); err != nil || accessToken == "" {
 		log.Debug().Err(err).Msg("no access token in reauthenticate request")
-		c.JSON(http.StatusForbidden, api.ErrorResponse("reauthentication failed"))
+		api.Error(c, http.StatusForbidden, "reauthentication failed")
 		return
 	}
 
 	// Get the access token claims
 	if accessClaims, err = s.auth.Parse(accessToken); err != nil {
 		log.Debug().Err(err).Msg("invalid access token")
-		c.JSON(http.StatusForbidden, api.ErrorResponse("reauthentication failed"))
+		api.Error(c, http.StatusForbidden, "reauthentication failed")
+		return
+	}
+
+	// Impersonation tokens cannot be refreshed
+	if accessClaims.Impersonated() {
+		log.Debug().Str("actor", accessClaims.Actor.Subject).Msg("cannot reauthenticate impersonation token")
+		api.Error(c, http.StatusForbidden, "reauthentication failed")
 		return
 	}
 
 	// Ensure the access and refresh token match
 	if accessClaims.ID != refreshClaims.ID || accessClaims.Subject != refreshClaims.Subject {
 		log.Debug().Msg("access token claims do not match refresh token claims")
-		c.JSON(http.StatusForbidden, api.ErrorResponse("reauthentication failed"))
+		api.Error(c, http.StatusForbidden, "reauthentication failed")
 		return
 	}
 
 	if userID, err = refreshClaims.SubjectID(); err != nil {
 		log.Error().Err(err).Str("subject", refreshClaims.Subject).Msg("could not parse user ID from refresh claims")
-		c.JSON(http.StatusForbidden, api.ErrorResponse("reauthentication failed"))
+		api.Error(c, http.StatusForbidden, "reauthentication failed")
 		return
 	}
 
 	// Fetch the user to get the most up to date claims (do not rely on old claims)
 	if user, err = models.GetUser(c.Request.Context(), userID); err != nil {
 		log.Error().Err(err).Msg("could not create access and refresh tokens for user")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse("reauthentication failed"))
+		api.Error(c, http.StatusInternalServerError, "reauthentication failed")
+		return
+	}
+
+	if s.accountDisabled(c, user) {
 		return
 	}
 
 	// The user has been reauthenticated at this point: create access and refresh tokens
 	if claims, err = auth.NewClaimsForUser(c.Request.Context(), user); err != nil {
 		log.Error().Err(err).Msg("could not create claims for user")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse("reauthentication failed"))
+		api.Error(c, http.StatusInternalServerError, "reauthentication failed")
 		return
 	}
 
+	// Preserve how and when the user originally authenticated
+	claims.AMR = accessClaims.AMR
+	claims.AuthTime = accessClaims.AuthTime
+
 	out = &api.LoginReply{}
+	if out.MFAEnrollmentRequired, err = requireMFAEnrollment(c.Request.Context(), user, claims); err != nil {
+		log.Error().Err(err).Msg("could not fetch user role from database")
+		api.Error(c, http.StatusInternalServerError, "reauthentication failed")
+		return
+	}
+
 	if out.AccessToken, out.RefreshToken, err = s.auth.CreateTokens(claims); err != nil {
 		log.Error().Err(err).Msg("could not create access and refresh tokens for user")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse("reauthentication failed"))
+		api.Error(c, http.StatusInternalServerError, "reauthentication failed")
 		return
 	}
 
 	// Update the last login timestamp for user tracking
 	if err = user.LoggedIn(c.Request.Context()); err != nil {
 		log.Error().Err(err).Msg("could not update last login timestamp")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse("reauthentication failed"))
+		api.Error(c, http.StatusInternalServerError, "reauthentication failed")
 		return
 	}
 
@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -16,10 +17,18 @@ import (
 	"github.com/bbengfort/cosmos/pkg/auth"
 	"github.com/bbengfort/cosmos/pkg/config"
 	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/bbengfort/cosmos/pkg/graph"
+	"github.com/bbengfort/cosmos/pkg/idempotency"
 	"github.com/bbengfort/cosmos/pkg/logger"
+	"github.com/bbengfort/cosmos/pkg/mail"
+	"github.com/bbengfort/cosmos/pkg/oidc"
+	"github.com/bbengfort/cosmos/pkg/pubsub"
+	"github.com/bbengfort/cosmos/pkg/ratelimit"
+	"github.com/bbengfort/cosmos/pkg/webhooks"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
 )
 
 func init() {
@@ -55,11 +64,75 @@ func New(conf config.Config) (s *Server, err error) {
 		ready:   false,
 	}
 
+	// Configure the parameters used to create password derived keys
+	if err = auth.SetDerivedKeyParams(auth.NewDerivedKeyParams(conf.Auth)); err != nil {
+		return nil, err
+	}
+
 	// Create the authentication issuer
 	if s.auth, err = auth.NewIssuer(conf.Auth); err != nil {
 		return nil, err
 	}
 
+	// Revoke tokens when users change their password or delete their account; the
+	// revocations only need to be kept as long as the longest lived token.
+	s.revocations = auth.NewRevocations(conf.Auth.RefreshTokenTTL)
+	s.auth.UseRevocations(s.revocations)
+
+	// Reject access tokens and cached API keys whose permissions have since changed
+	s.versions = auth.NewPermissionVersions(conf.Auth.VersionCache)
+	s.auth.UseVersions(s.versions)
+
+	// Create the mailer to send email verifications
+	if s.mailer, err = mail.New(conf.Mail); err != nil {
+		return nil, err
+	}
+
+	// Create the login throttle for brute-force protection
+	s.throttle = auth.NewLoginThrottle(conf.Auth)
+
+	// Create the api key authenticator for bots and service accounts
+	s.apikeys = auth.NewAPIKeys(conf.Auth.APIKeyCacheTTL)
+	s.apikeys.UseVersions(s.versions)
+
+	// Notifies the event streams of galaxies when events are recorded by any replica
+	s.events = pubsub.New()
+	s.webhooks = webhooks.NewDispatcher(conf.Webhooks)
+
+	// Rate limit buckets are shared in the database unless the replica is read-only
+	if conf.RateLimit.Enabled {
+		if conf.RateLimit.Shared && !conf.Database.ReadOnly {
+			s.limiter = ratelimit.NewPostgres()
+		} else {
+			s.limiter = ratelimit.NewMemory()
+		}
+	}
+
+	// Responses to requests with idempotency keys are replayed by any replica
+	s.idempotency = idempotency.NewPostgres()
+
+	// Create the schema of the graphql api
+	if s.graph, err = graph.New(conf.GraphQL.MaxComplexity); err != nil {
+		return nil, fmt.Errorf("could not create graphql schema: %w", err)
+	}
+
+	// Create the external identity providers for social login
+	s.providers = make(map[string]oidc.IdentityProvider, len(conf.OIDC.Providers))
+	for _, provider := range conf.OIDC.Providers {
+		var idp *oidc.Provider
+		if idp, err = oidc.New(oidc.Config{
+			Name:         provider.Name,
+			Issuer:       provider.Issuer,
+			ClientID:     provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			RedirectURL:  strings.TrimSuffix(conf.OIDC.RedirectURL, "/") + "/" + provider.Name + "/callback",
+			Scopes:       conf.OIDC.Scopes,
+		}); err != nil {
+			return nil, fmt.Errorf("could not configure identity provider %q: %w", provider.Name, err)
+		}
+		s.providers[provider.Name] = idp
+	}
+
 	// Create the Gin router and setup its routes
 	gin.SetMode(conf.Mode)
 	s.router = gin.New()
@@ -69,6 +142,13 @@ func New(conf config.Config) (s *Server, err error) {
 	s.router.ForwardedByClientIP = true
 	s.router.UseRawPath = false
 	s.router.UnescapePathValues = true
+
+	// Forwarded headers are only trusted from the configured proxies so that clients
+	// cannot choose the IP address that they are rate limited and audited by.
+	if err = s.router.SetTrustedProxies(conf.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
 	if err = s.setupRoutes(); err != nil {
 		return nil, err
 	}
@@ -82,20 +162,43 @@ func New(conf config.Config) (s *Server, err error) {
 		WriteTimeout: 20 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
+
+	// Create the grpc server, which is served on its own bind address
+	if conf.RPC.Enabled {
+		s.rpc = s.newRPC()
+	}
+
+	// Close event streams on shutdown so that they do not block graceful shutdown
+	s.srv.RegisterOnShutdown(s.events.Close)
 	return s, nil
 }
 
 type Server struct {
 	sync.RWMutex
-	conf    config.Config      // configuration of the API server
-	srv     *http.Server       // handle to a custom http server with specified API defaults
-	router  *gin.Engine        // the http handler and associated middleware
-	auth    *auth.ClaimsIssuer // used to issue and verify authentication jwt tokens
-	healthy bool               // application state of the server for health checks
-	ready   bool               // application state of the server for ready checks
-	started time.Time          // the timestamp when the server was started
-	url     *url.URL           // the url of the service when it's running
-	errc    chan error         // synchronize shutdown gracefully
+	conf        config.Config                    // configuration of the API server
+	srv         *http.Server                     // handle to a custom http server with specified API defaults
+	router      *gin.Engine                      // the http handler and associated middleware
+	rpc         *grpc.Server                     // serves the grpc api if it is enabled
+	auth        *auth.ClaimsIssuer               // used to issue and verify authentication jwt tokens
+	throttle    *auth.LoginThrottle              // used to prevent brute-force attacks on logins
+	apikeys     *auth.APIKeys                    // used to authenticate api key credentials
+	revocations *auth.Revocations                // tokens revoked before they expire
+	versions    *auth.PermissionVersions         // current permission versions of users and roles
+	providers   map[string]oidc.IdentityProvider // external identity providers users can sign in with
+	mailer      mail.Mailer                      // sends email verifications to users
+	apis        map[string]*apiVersion           // the api versions served by the router by path prefix
+	middleware  *middleware                      // authentication and rate limits shared by api versions
+	events      *pubsub.Broker                   // notifies event streams of new galaxy events
+	webhooks    *webhooks.Dispatcher             // delivers galaxy events to webhooks
+	graph       *graph.Schema                    // executes the queries of the graphql api
+	limiter     ratelimit.Store                  // rate limit buckets if rate limiting is enabled
+	idempotency idempotency.Store                // responses replayed to retries of idempotent requests
+	healthy     bool                             // application state of the server for health checks
+	ready       bool                             // application state of the server for ready checks
+	started     time.Time                        // the timestamp when the server was started
+	url         *url.URL                         // the url of the service when it's running
+	errc        chan error                       // synchronize shutdown gracefully
+	stop        context.CancelFunc               // stops background routines on shutdown
 }
 
 func (s *Server) Serve() (err error) {
@@ -113,6 +216,30 @@ func (s *Server) Serve() (err error) {
 			return err
 		}
 		log.Debug().Bool("read-only", s.conf.Database.ReadOnly).Str("dsn", s.conf.Database.URL).Msg("connected to database")
+
+		// Load token revocations made by other replicas in the background and listen
+		// for changes to revocations and permissions made by any replica.
+		var ctx context.Context
+		ctx, s.stop = context.WithCancel(context.Background())
+		go s.revocations.Run(ctx, s.conf.Auth.RevocationSync)
+		go s.listen(ctx)
+
+		// Deliver webhooks queued by any replica, checking the queue when new galaxy
+		// events are recorded; read-only replicas cannot claim deliveries.
+		if !s.conf.Database.ReadOnly {
+			go s.webhooks.Run(ctx, s.events.Subscribe(pubsub.All).C)
+		}
+
+		// Delete the idempotency keys that have expired; read-only replicas cannot
+		// reserve keys so they do not need to delete them.
+		if !s.conf.Database.ReadOnly {
+			go s.idempotency.Run(ctx, idempotency.SweepInterval)
+		}
+
+		// Delete the rate limit buckets that have refilled
+		if s.limiter != nil {
+			go s.limiter.Run(ctx, ratelimit.SweepInterval)
+		}
 	}
 
 	// Create a socket to listen on and infer the final URL.
@@ -125,6 +252,14 @@ func (s *Server) Serve() (err error) {
 		return fmt.Errorf("could not listen on bind addr %s: %s", s.srv.Addr, err)
 	}
 
+	var rpcSock net.Listener
+	if s.rpc != nil {
+		if rpcSock, err = net.Listen("tcp", s.conf.RPC.BindAddr); err != nil {
+			sock.Close()
+			return fmt.Errorf("could not listen on rpc bind addr %s: %s", s.conf.RPC.BindAddr, err)
+		}
+	}
+
 	s.SetStatus(true, true)
 	s.started = time.Now()
 	s.setURL(sock.Addr())
@@ -145,6 +280,16 @@ func (s *Server) Serve() (err error) {
 		s.errc <- nil
 	}()
 
+	// Listen for grpc calls and handle them.
+	if rpcSock != nil {
+		go func() {
+			if serr := s.rpc.Serve(rpcSock); serr != nil {
+				s.errc <- serr
+			}
+		}()
+		log.Info().Str("addr", rpcSock.Addr().String()).Msg("cosmos grpc service started")
+	}
+
 	log.Info().Str("url", s.URL()).Msg("cosmos api service started")
 	return <-s.errc
 }
@@ -170,6 +315,15 @@ func (s *Server) Shutdown() error {
 		errs = append(errs, err)
 	}
 
+	// Event streams are closed by the http server shutdown so grpc streams also end
+	if s.rpc != nil {
+		s.stopRPC(ctx)
+	}
+
+	if s.stop != nil {
+		s.stop()
+	}
+
 	if !s.conf.Maintenance {
 		if err := db.Close(); err != nil {
 			errs = append(errs, err)
@@ -0,0 +1,119 @@
+package cosmos
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	t.Setenv("COSMOS_MODE", "test")
+	t.Setenv("COSMOS_DATABASE_TESTING", "true")
+	t.Setenv("COSMOS_RATELIMIT_AUTH", "2/1m")
+
+	login := func(s *Server, forwarded string) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/login", nil)
+		req.RemoteAddr = "192.0.2.1:4000"
+		req.Header.Set("X-Forwarded-For", forwarded)
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Forwarded headers of clients are ignored so spoofed addresses share a quota
+	conf, err := config.New()
+	require.NoError(t, err)
+
+	s, err := New(conf)
+	require.NoError(t, err)
+	s.SetStatus(true, true)
+
+	require.NotEqual(t, http.StatusTooManyRequests, login(s, "203.0.113.1"))
+	require.NotEqual(t, http.StatusTooManyRequests, login(s, "203.0.113.2"))
+	require.Equal(t, http.StatusTooManyRequests, login(s, "203.0.113.3"), "a spoofed forwarded header should not reset the quota")
+
+	// Forwarded headers of trusted proxies identify the client
+	t.Setenv("COSMOS_TRUSTED_PROXIES", "192.0.2.0/24")
+	conf, err = config.New()
+	require.NoError(t, err)
+
+	s, err = New(conf)
+	require.NoError(t, err)
+	s.SetStatus(true, true)
+
+	require.NotEqual(t, http.StatusTooManyRequests, login(s, "203.0.113.1"))
+	require.NotEqual(t, http.StatusTooManyRequests, login(s, "203.0.113.1"))
+	require.Equal(t, http.StatusTooManyRequests, login(s, "203.0.113.1"))
+	require.NotEqual(t, http.StatusTooManyRequests, login(s, "203.0.113.2"), "clients behind a trusted proxy have their own quota")
+
+	// Invalid proxies are rejected
+	conf.TrustedProxies = []string{"not an address"}
+	_, err = New(conf)
+	require.Error(t, err)
+}
+
+func TestErrorReplies(t *testing.T) {
+	t.Setenv("COSMOS_MODE", "test")
+	t.Setenv("COSMOS_DATABASE_TESTING", "true")
+	t.Setenv("COSMOS_RATELIMIT_ENABLED", "false")
+	conf, err := config.New()
+	require.NoError(t, err)
+
+	s, err := New(conf)
+	require.NoError(t, err)
+	s.SetStatus(true, true)
+
+	// Handlers that panic are recovered by the middleware of the router
+	s.router.GET("/v1/panic", func(*gin.Context) { panic("something went wrong") })
+
+	testCases := []struct {
+		method string
+		path   string
+		status int
+		code   string
+	}{
+		{http.MethodGet, "/v1/notfound", http.StatusNotFound, api.CodeNotFound},
+		{http.MethodDelete, "/v1/status", http.StatusMethodNotAllowed, api.CodeMethodNotAllowed},
+		{http.MethodGet, "/v1/panic", http.StatusInternalServerError, api.CodeInternal},
+	}
+
+	for _, tc := range testCases {
+		requireErrorReply(t, s, tc.method, tc.path, tc.status, tc.code)
+	}
+
+	// Every route is unavailable in maintenance mode
+	t.Setenv("COSMOS_MAINTENANCE", "true")
+	conf, err = config.New()
+	require.NoError(t, err)
+
+	s, err = New(conf)
+	require.NoError(t, err)
+	s.SetStatus(true, true)
+
+	requireErrorReply(t, s, http.MethodGet, "/v1/status", http.StatusServiceUnavailable, api.CodeMaintenance)
+	requireErrorReply(t, s, http.MethodPost, "/v1/login", http.StatusServiceUnavailable, api.CodeMaintenance)
+}
+
+// requireErrorReply makes the request to the router and requires the JSON error reply
+// to have the status and error code and to identify the request.
+func requireErrorReply(t *testing.T, s *Server, method, path string, status int, code string) {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set(api.RequestIDHeader, "req42")
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	require.Equal(t, status, w.Code, "unexpected status of %s %s", method, path)
+	require.Equal(t, "req42", w.Header().Get(api.RequestIDHeader))
+
+	rep := &api.Reply{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), rep), "expected a json reply to %s %s", method, path)
+	require.False(t, rep.Success)
+	require.NotEmpty(t, rep.Error)
+	require.Equal(t, code, rep.Code, "unexpected error code of %s %s", method, path)
+	require.Equal(t, "req42", rep.RequestID, "expected the request id in the reply to %s %s", method, path)
+}
@@ -0,0 +1,74 @@
+package cosmos
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/gin-gonic/gin"
+)
+
+// Headers of conditional requests. Resources are tagged with a strong ETag derived from
+// their ID and modified timestamp, which is maintained by the database, so that clients
+// can poll resources cheaply with If-None-Match and can update resources with If-Match
+// without overwriting the changes of other users.
+const (
+	HeaderETag        = "ETag"
+	HeaderIfMatch     = "If-Match"
+	HeaderIfNoneMatch = "If-None-Match"
+)
+
+// etag returns the strong entity tag of the resource with the ID that was last modified
+// at the timestamp; timestamps are stored by Postgres with microsecond precision.
+func etag(id int64, modified time.Time) string {
+	return `"` + strconv.FormatInt(id, 36) + "-" + strconv.FormatInt(modified.UnixMicro(), 36) + `"`
+}
+
+// matchETag returns true if the etag is in the comma separated list of entity tags of a
+// conditional header or if the header is *. If-None-Match uses weak comparison, which
+// ignores the W/ prefix of weak tags, and If-Match uses strong comparison, in which
+// weak tags never match.
+func matchETag(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag header of the response and returns true after writing a
+// 304 response if the etag matches the If-None-Match header of the request.
+func notModified(c *gin.Context, etag string) bool {
+	c.Header(HeaderETag, etag)
+	if header := c.GetHeader(HeaderIfNoneMatch); header != "" && matchETag(header, etag, true) {
+		c.AbortWithStatus(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// preconditionFailed returns true after writing a 412 error reply if the request has an
+// If-Match header that does not match the current etag of the resource.
+func preconditionFailed(c *gin.Context, etag string) bool {
+	if header := c.GetHeader(HeaderIfMatch); header != "" && !matchETag(header, etag, false) {
+		c.Header(HeaderETag, etag)
+		api.Error(c, http.StatusPreconditionFailed, api.ErrPrecondition)
+		return true
+	}
+	return false
+}
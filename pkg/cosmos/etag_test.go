@@ -0,0 +1,94 @@
+package cosmos
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestETag(t *testing.T) {
+	modified := time.Date(2026, 10, 18, 12, 30, 0, 123456789, time.UTC)
+	tag := etag(42, modified)
+	require.Equal(t, `"16-`+strconv.FormatInt(modified.UnixMicro(), 36)+`"`, tag)
+
+	// Tags are strong and change with the ID and the modified timestamp; timestamps are
+	// compared with the microsecond precision of the database.
+	require.NotEqual(t, tag, etag(43, modified))
+	require.NotEqual(t, tag, etag(42, modified.Add(time.Microsecond)))
+	require.Equal(t, tag, etag(42, modified.Truncate(time.Microsecond)))
+
+	testCases := []struct {
+		header   string
+		weak     bool
+		expected bool
+	}{
+		{tag, false, true},
+		{"*", false, true},
+		{`"other", ` + tag, false, true},
+		{`"other"`, true, false},
+		{"W/" + tag, true, true},
+		{"W/" + tag, false, false},
+		{`W/"other", W/` + tag, true, true},
+	}
+
+	for i, tc := range testCases {
+		require.Equal(t, tc.expected, matchETag(tc.header, tag, tc.weak), "test case %d failed", i)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tag := etag(1, time.Now())
+
+	router := gin.New()
+	router.GET("/", func(c *gin.Context) {
+		if notModified(c, tag) {
+			return
+		}
+		c.JSON(http.StatusOK, api.Reply{Success: true})
+	})
+	router.PUT("/", func(c *gin.Context) {
+		if preconditionFailed(c, tag) {
+			return
+		}
+		c.JSON(http.StatusOK, api.Reply{Success: true})
+	})
+
+	request := func(method, header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(http.MethodGet, "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, tag, w.Header().Get(HeaderETag))
+
+	w = request(http.MethodGet, HeaderIfNoneMatch, tag)
+	require.Equal(t, http.StatusNotModified, w.Code)
+	require.Equal(t, tag, w.Header().Get(HeaderETag))
+	require.Empty(t, w.Body.String())
+
+	require.Equal(t, http.StatusOK, request(http.MethodGet, HeaderIfNoneMatch, `"stale"`).Code)
+
+	require.Equal(t, http.StatusOK, request(http.MethodPut, "", "").Code)
+	require.Equal(t, http.StatusOK, request(http.MethodPut, HeaderIfMatch, tag).Code)
+	require.Equal(t, http.StatusOK, request(http.MethodPut, HeaderIfMatch, "*").Code)
+
+	w = request(http.MethodPut, HeaderIfMatch, `"stale"`)
+	require.Equal(t, http.StatusPreconditionFailed, w.Code)
+	require.Equal(t, tag, w.Header().Get(HeaderETag))
+	require.Contains(t, w.Body.String(), api.CodePrecondition)
+
+	require.Equal(t, http.StatusPreconditionFailed, request(http.MethodPut, HeaderIfMatch, "W/"+tag).Code, "weak tags do not match if-match")
+}
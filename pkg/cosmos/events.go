@@ -0,0 +1,257 @@
+package cosmos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/bbengfort/cosmos/pkg/pubsub"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	lastEventIDHeader = "Last-Event-ID"
+	eventBatchSize    = 100
+	eventPingInterval = 30 * time.Second
+	eventRetryDelay   = time.Second
+	eventRetries      = 5
+	eventWriteTimeout = 20 * time.Second
+)
+
+var errLeftGalaxy = errors.New("player left the galaxy")
+
+// GalaxyEvents streams the events of the galaxy that are visible to the user as
+// server-sent events. The stream resumes after the event in the Last-Event-ID header
+// (or the last_event_id query parameter for clients that cannot set headers) and
+// otherwise starts with the next event; the resumed event must be an event of the
+// galaxy. Events are read from the database when any replica notifies that the galaxy
+// has new events, so no events are missed if the stream is resumed on a different
+// replica. The stream is closed when the access token expires, when the player leaves
+// the galaxy, or when the server shuts down; the client should reconnect with the ID
+// of the last event it received.
+func (s *Server) GalaxyEvents(c *gin.Context) {
+	var (
+		err      error
+		galaxyID int64
+		userID   int64
+		lastID   int64
+		resume   bool
+		claims   *auth.Claims
+	)
+
+	if galaxyID, err = auth.GetGalaxyID(c); err != nil {
+		log.Warn().Err(err).Msg("could not get galaxy from request")
+		api.Error(c, http.StatusInternalServerError, "could not stream galaxy events")
+		return
+	}
+
+	if claims, err = auth.GetClaims(c); err != nil {
+		log.Warn().Err(err).Msg("could not get claims to stream galaxy events")
+		api.Error(c, http.StatusInternalServerError, "could not stream galaxy events")
+		return
+	}
+
+	if userID, err = claims.SubjectID(); err != nil {
+		log.Warn().Err(err).Msg("could not identify user to stream galaxy events")
+		api.Error(c, http.StatusInternalServerError, "could not stream galaxy events")
+		return
+	}
+
+	if lastID, resume, err = lastEventID(c); err != nil {
+		api.Error(c, http.StatusBadRequest, api.InvalidField(lastEventIDHeader, "must be the id of an event"))
+		return
+	}
+
+	// Subscribe before reading the latest event so that no events are missed
+	sub := s.events.Subscribe(galaxyID)
+	defer sub.Close()
+
+	ctx := c.Request.Context()
+	if !resume {
+		if lastID, err = models.LatestGalaxyEventID(ctx, galaxyID); err != nil {
+			log.Error().Err(err).Msg("could not get latest galaxy event")
+			api.Error(c, http.StatusInternalServerError, "could not stream galaxy events")
+			return
+		}
+	} else if lastID != 0 {
+		// Streams can only resume after an event of the galaxy, otherwise no events
+		// would ever be sent to the client.
+		var exists bool
+		if exists, err = models.GalaxyEventExists(ctx, galaxyID, lastID); err != nil {
+			log.Error().Err(err).Msg("could not check last galaxy event")
+			api.Error(c, http.StatusInternalServerError, "could not stream galaxy events")
+			return
+		}
+
+		if !exists {
+			api.Error(c, http.StatusBadRequest, api.InvalidField(lastEventIDHeader, "must be the id of an event of the galaxy"))
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	stream := &eventStream{c: c, rc: http.NewResponseController(c.Writer)}
+	if err = stream.write(fmt.Sprintf("retry: %d\n\n", listenRetryInterval.Milliseconds())); err != nil {
+		return
+	}
+
+	ping := func() error { return stream.write(": ping\n\n") }
+	if err = followGalaxyEvents(ctx, sub, claims, galaxyID, userID, lastID, stream.send, ping); err != nil {
+		if !errors.Is(err, errLeftGalaxy) && ctx.Err() == nil {
+			log.Warn().Err(err).Int64("galaxy_id", galaxyID).Msg("could not stream galaxy events")
+		}
+	}
+}
+
+// followGalaxyEvents sends the events of the galaxy after the last event that are
+// visible to the user whenever the subscription is notified of new events, and pings
+// the client at the ping interval so that idle connections are kept alive. Events are
+// held back by the database until the transactions before them have committed, so if
+// no events are sent after a notification the events are read again after a short
+// delay. It returns nil when the context is done, when the claims expire so that
+// revoked users are removed, or when the server shuts down; errLeftGalaxy is returned
+// if the player leaves the galaxy. The caller must subscribe before reading the last
+// event ID so that no events are missed.
+func followGalaxyEvents(ctx context.Context, sub *pubsub.Subscription, claims *auth.Claims, galaxyID, userID, lastID int64, send func(*api.GalaxyEvent) error, ping func() error) (err error) {
+	expires := time.NewTimer(time.Hour)
+	if claims.ExpiresAt != nil {
+		expires.Reset(time.Until(claims.ExpiresAt.Time))
+	}
+	defer expires.Stop()
+
+	pings := time.NewTicker(eventPingInterval)
+	defer pings.Stop()
+
+	retry := time.NewTimer(eventRetryDelay)
+	retry.Stop()
+	defer retry.Stop()
+
+	var retries int
+	for {
+		prevID := lastID
+		if lastID, err = sendGalaxyEvents(ctx, send, galaxyID, userID, lastID); err != nil {
+			return err
+		}
+
+		if lastID == prevID && retries > 0 {
+			retries--
+			retry.Reset(eventRetryDelay)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-expires.C:
+			return nil
+		case _, ok := <-sub.C:
+			if !ok {
+				// The server is shutting down
+				return nil
+			}
+			retries = eventRetries
+		case <-retry.C:
+		case <-pings.C:
+			if err = ping(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendGalaxyEvents sends the events after the last event and returns the ID of the
+// last event that was sent.
+func sendGalaxyEvents(ctx context.Context, send func(*api.GalaxyEvent) error, galaxyID, userID, lastID int64) (_ int64, err error) {
+	for {
+		var events []*models.GalaxyEvent
+		if events, err = models.ListGalaxyEvents(ctx, galaxyID, userID, lastID, eventBatchSize); err != nil {
+			return lastID, err
+		}
+
+		for _, event := range events {
+			if err = send(galaxyEventReply(event)); err != nil {
+				return lastID, err
+			}
+			lastID = event.ID
+
+			if event.Type == api.EventPlayerLeft {
+				left := &api.PlayerEvent{}
+				if err = json.Unmarshal([]byte(event.Data), left); err == nil && left.UserID == userID {
+					return lastID, errLeftGalaxy
+				}
+			}
+		}
+
+		if len(events) < eventBatchSize {
+			return lastID, nil
+		}
+	}
+}
+
+// lastEventID returns the ID of the last event the client received if the client is
+// resuming the stream.
+func lastEventID(c *gin.Context) (id int64, resume bool, err error) {
+	value := c.GetHeader(lastEventIDHeader)
+	if value == "" {
+		value = c.Query("last_event_id")
+	}
+
+	if value == "" {
+		return 0, false, nil
+	}
+
+	if id, err = strconv.ParseInt(value, 10, 64); err != nil || id < 0 {
+		return 0, false, strconv.ErrSyntax
+	}
+	return id, true, nil
+}
+
+// eventStream writes server-sent events to the response. The write deadline of the
+// server is extended before every write so that the stream is not closed while it is
+// active but a stalled client cannot hold on to the connection.
+type eventStream struct {
+	c  *gin.Context
+	rc *http.ResponseController
+}
+
+func (s *eventStream) send(event *api.GalaxyEvent) (err error) {
+	var data []byte
+	if data, err = json.Marshal(event); err != nil {
+		return err
+	}
+	return s.write(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data))
+}
+
+func (s *eventStream) write(msg string) (err error) {
+	if err = s.rc.SetWriteDeadline(time.Now().Add(eventWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+
+	if _, err = s.c.Writer.WriteString(msg); err != nil {
+		return err
+	}
+	s.c.Writer.Flush()
+	return nil
+}
+
+func galaxyEventReply(event *models.GalaxyEvent) *api.GalaxyEvent {
+	return &api.GalaxyEvent{
+		ID:       event.ID,
+		GalaxyID: event.GalaxyID,
+		Type:     event.Type,
+		Data:     json.RawMessage(event.Data),
+		Created:  event.Created.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,52 @@
+package cosmos
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bbengfort/cosmos/internal/dbtest"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGalaxyEventsResume(t *testing.T) {
+	t.Setenv("COSMOS_MODE", "test")
+	t.Setenv("COSMOS_DATABASE_TESTING", "true")
+	t.Setenv("COSMOS_RATELIMIT_ENABLED", "false")
+	conf, err := config.New()
+	require.NoError(t, err)
+
+	s, err := New(conf)
+	require.NoError(t, err)
+	s.SetStatus(true, true)
+
+	mock := dbtest.Connect(t)
+
+	claims := &auth.Claims{Role: "Admin", Permissions: []string{auth.ManageGalaxies}, UserVersion: 1, RoleVersion: 1}
+	claims.SetSubjectID(42)
+	s.versions.Update(42, models.PermissionVersion{UserVersion: 1, Role: "Admin", RoleVersion: 1})
+	token, _, err := s.auth.CreateTokens(claims)
+	require.NoError(t, err)
+
+	// Streams cannot resume after an event that does not exist or is of another galaxy
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM players").WithArgs(7, 42).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(int64(7), int64(99)).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/galaxy/7/events", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(lastEventIDHeader, "99")
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	require.Contains(t, w.Body.String(), lastEventIDHeader)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,13 +1,21 @@
 package cosmos
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/bbengfort/cosmos/pkg/api/v1"
 	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/db"
 	"github.com/bbengfort/cosmos/pkg/db/models"
 	"github.com/bbengfort/cosmos/pkg/enums"
 	"github.com/bbengfort/cosmos/pkg/jcode"
+	"github.com/bbengfort/cosmos/pkg/pagination"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
 )
@@ -17,38 +25,124 @@ const (
 	DefaultMaxTurns = 1000
 )
 
+// ListGalaxies returns a page of the galaxies the user is playing in, optionally
+// filtered by game state and size.
 func (s *Server) ListGalaxies(c *gin.Context) {
 	var (
 		err      error
+		in       *api.GalaxyQuery
+		out      *api.GalaxyList
 		userID   int64
-		claims   *auth.Claims
+		query    *models.GalaxyQuery
 		galaxies []*models.Galaxy
+		next     *pagination.Cursor
 	)
 
-	if claims, err = auth.GetClaims(c); err != nil {
-		log.Warn().Err(err).Msg("could not get claims from request")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not complete list galaxies request"))
+	in = &api.GalaxyQuery{}
+	if err = c.BindQuery(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
 		return
 	}
 
-	if userID, err = claims.SubjectID(); err != nil {
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if userID, err = subjectID(c); err != nil {
 		log.Warn().Err(err).Msg("could not parse user ID from claims")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not complete list galaxies request"))
+		api.Error(c, http.StatusInternalServerError, "could not complete list galaxies request")
+		return
+	}
+
+	if query, err = galaxyQuery(userID, in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if galaxies, next, err = models.QueryGalaxies(c.Request.Context(), query); err != nil {
+		if errors.Is(err, pagination.ErrTokenMismatch) {
+			api.Error(c, http.StatusBadRequest, errGalaxyTokenMismatch)
+			return
+		}
+
+		log.Error().Err(err).Msg("could not fetch galaxies from the database")
+		api.Error(c, http.StatusInternalServerError, "could not complete list galaxies request")
 		return
 	}
 
-	if galaxies, err = models.ListActiveGalaxies(c.Request.Context(), userID); err != nil {
-		log.Error().Err(err).Msg("could not fetch active galaxies from the database")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not complete list galaxies request"))
+	if out, err = galaxyList(galaxies, next); err != nil {
+		log.Error().Err(err).Msg("could not create next page token")
+		api.Error(c, http.StatusInternalServerError, "could not complete list galaxies request")
 		return
 	}
 
-	c.JSON(http.StatusOK, galaxies)
+	c.JSON(http.StatusOK, out)
+}
+
+var errGalaxyTokenMismatch = api.InvalidField("page_token", "does not match the filters or order of the request")
+
+// galaxyQuery creates the database query for the galaxies of the user from a validated
+// request, returning an invalid field error if the order or pagination is invalid.
+func galaxyQuery(userID int64, in *api.GalaxyQuery) (query *models.GalaxyQuery, err error) {
+	query = &models.GalaxyQuery{UserID: userID}
+
+	// The states and sizes have been validated so they can be parsed without errors
+	for _, name := range in.State {
+		state, _ := enums.ParseGameState(name)
+		query.States = append(query.States, state)
+	}
+
+	for _, name := range in.Size {
+		size, _ := enums.ParseSize(name)
+		query.Sizes = append(query.Sizes, size)
+	}
+
+	if query.Order, err = pagination.ParseOrder(in.OrderBy, models.GalaxyOrderFields...); err != nil {
+		return nil, api.InvalidField("order_by", "must be one of "+strings.Join(models.GalaxyOrderFields, ", "))
+	}
+
+	if query.PageSize, err = pagination.PageSize(in.PageSize); err != nil {
+		return nil, api.InvalidField("page_size", "must be greater than or equal to 0")
+	}
+
+	if query.Cursor, err = pagination.Parse(in.PageToken); err != nil {
+		return nil, api.InvalidField("page_token", "is invalid")
+	}
+
+	// The sort key of the cursor is parsed for the order of the query so that page
+	// tokens that were modified by the client are rejected before they are queried.
+	if query.Cursor != nil {
+		if err = query.Cursor.Check(query.Order, query.Filter()); err != nil {
+			return nil, errGalaxyTokenMismatch
+		}
+
+		if _, err = models.ParseGalaxySortKey(query.Order.Field, query.Cursor.Key); err != nil {
+			return nil, api.InvalidField("page_token", "is invalid")
+		}
+	}
+	return query, nil
+}
+
+// galaxyList creates the reply to a galaxy query with the token of the next page.
+func galaxyList(galaxies []*models.Galaxy, next *pagination.Cursor) (out *api.GalaxyList, err error) {
+	out = &api.GalaxyList{Galaxies: make([]*api.Galaxy, 0, len(galaxies))}
+	for _, galaxy := range galaxies {
+		out.Galaxies = append(out.Galaxies, galaxyReply(galaxy))
+	}
+
+	if next != nil {
+		if out.NextPageToken, err = next.Token(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
 }
 
 func (s *Server) CreateGalaxy(c *gin.Context) {
 	var (
 		err    error
+		in     *api.CreateGalaxyRequest
 		galaxy *models.Galaxy
 		userID int64
 		claims *auth.Claims
@@ -57,32 +151,41 @@ func (s *Server) CreateGalaxy(c *gin.Context) {
 
 	if claims, err = auth.GetClaims(c); err != nil {
 		log.Warn().Err(err).Msg("could not get claims to create galaxy")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not create galaxy"))
+		api.Error(c, http.StatusInternalServerError, "could not create galaxy")
 		return
 	}
 
 	if userID, err = claims.SubjectID(); err != nil {
 		log.Warn().Err(err).Msg("could not parse claims to create galaxy")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not create galaxy"))
+		api.Error(c, http.StatusInternalServerError, "could not create galaxy")
 		return
 	}
 
-	// Parse the galaxy from the user input
-	galaxy = &models.Galaxy{}
-	if err = c.BindJSON(galaxy); err != nil {
-		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+	in = &api.CreateGalaxyRequest{}
+	if err = c.BindJSON(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
 		return
 	}
 
-	// Set the defaults for the galaxy before saving it.
-	galaxy.JoinCode = jcode.New()
-	galaxy.GameState = enums.Pending
-	galaxy.MaxTurns = DefaultMaxTurns
-	galaxy.Turn = 0
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
 
-	// Ensure there is a galaxy size
-	if galaxy.Size == enums.UnknownSize {
-		galaxy.Size = DefaultGameSize
+	// Only the name and size are set by the user; the rest of the galaxy is defaults.
+	galaxy = &models.Galaxy{
+		Name:      in.Name,
+		Size:      DefaultGameSize,
+		MaxTurns:  DefaultMaxTurns,
+		JoinCode:  jcode.New(),
+		GameState: enums.Pending,
+	}
+
+	if in.Size != "" {
+		if galaxy.Size, err = enums.ParseSize(in.Size); err != nil {
+			api.Error(c, http.StatusBadRequest, api.InvalidField("size", "is invalid"))
+			return
+		}
 	}
 
 	// Set the max players based on game size
@@ -91,7 +194,7 @@ func (s *Server) CreateGalaxy(c *gin.Context) {
 	// Create the galaxy
 	if err = models.CreateGalaxy(c.Request.Context(), galaxy); err != nil {
 		log.Error().Err(err).Msg("could not create galaxy")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not create galaxy"))
+		api.Error(c, http.StatusInternalServerError, "could not create galaxy")
 		return
 	}
 
@@ -106,9 +209,389 @@ func (s *Server) CreateGalaxy(c *gin.Context) {
 
 	if err = models.CreatePlayer(c.Request.Context(), player); err != nil {
 		log.Error().Err(err).Msg("could not create player for galaxy")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not create galaxy"))
+		api.Error(c, http.StatusInternalServerError, "could not create galaxy")
+		return
+	}
+
+	c.Header(HeaderETag, galaxyETag(galaxy))
+	c.JSON(http.StatusCreated, galaxyReply(galaxy))
+}
+
+// GetGalaxy returns the galaxy if the user is a player in the galaxy. The reply is empty
+// with a 304 status if the galaxy has not been modified since the client fetched it.
+func (s *Server) GetGalaxy(c *gin.Context) {
+	var (
+		err      error
+		galaxyID int64
+		galaxy   *models.Galaxy
+	)
+
+	if galaxyID, err = auth.GetGalaxyID(c); err != nil {
+		log.Warn().Err(err).Msg("could not get galaxy from request")
+		api.Error(c, http.StatusInternalServerError, "could not get galaxy")
+		return
+	}
+
+	if galaxy, err = models.GetGalaxy(c.Request.Context(), galaxyID); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			api.Error(c, http.StatusNotFound, auth.ErrGalaxyNotFound)
+			return
+		}
+
+		log.Error().Err(err).Msg("could not fetch galaxy from the database")
+		api.Error(c, http.StatusInternalServerError, "could not get galaxy")
+		return
+	}
+
+	if notModified(c, galaxyETag(galaxy)) {
+		return
+	}
+	c.JSON(http.StatusOK, galaxyReply(galaxy))
+}
+
+// UpdateGalaxy changes the name or the maximum number of turns of the galaxy. If the
+// request has an If-Match header, the galaxy is only updated if it has not been
+// modified since the client fetched it so that admins do not overwrite each other.
+func (s *Server) UpdateGalaxy(c *gin.Context) {
+	var (
+		err      error
+		in       *api.UpdateGalaxyRequest
+		galaxyID int64
+		actorID  int64
+		galaxy   *models.Galaxy
+		modified time.Time
+	)
+
+	in = &api.UpdateGalaxyRequest{}
+	if err = c.BindJSON(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if galaxyID, err = auth.GetGalaxyID(c); err != nil {
+		log.Warn().Err(err).Msg("could not get galaxy from request")
+		api.Error(c, http.StatusInternalServerError, "could not update galaxy")
+		return
+	}
+
+	if actorID, err = subjectID(c); err != nil {
+		log.Warn().Err(err).Msg("could not identify user to update galaxy")
+		api.Error(c, http.StatusInternalServerError, "could not update galaxy")
+		return
+	}
+
+	if galaxy, err = models.GetGalaxy(c.Request.Context(), galaxyID); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			api.Error(c, http.StatusNotFound, auth.ErrGalaxyNotFound)
+			return
+		}
+
+		log.Error().Err(err).Msg("could not fetch galaxy from the database")
+		api.Error(c, http.StatusInternalServerError, "could not update galaxy")
+		return
+	}
+
+	// The galaxy is only updated if it is unchanged since it was fetched above so that
+	// concurrent updates cannot be lost between the If-Match check and the update.
+	if preconditionFailed(c, galaxyETag(galaxy)) {
+		return
+	}
+
+	if c.GetHeader(HeaderIfMatch) != "" {
+		modified = galaxy.Modified
+	}
+
+	if in.MaxTurns != 0 && in.MaxTurns < galaxy.Turn {
+		api.Error(c, http.StatusBadRequest, api.InvalidField("max_turns", "must not be less than the current turn"))
+		return
+	}
+
+	changes := make([]string, 0, 2)
+	if in.Name != "" && in.Name != galaxy.Name {
+		changes = append(changes, fmt.Sprintf("name %q", in.Name))
+		galaxy.Name = in.Name
+	}
+
+	if in.MaxTurns != 0 && in.MaxTurns != galaxy.MaxTurns {
+		changes = append(changes, fmt.Sprintf("max turns %d", in.MaxTurns))
+		galaxy.MaxTurns = in.MaxTurns
+	}
+
+	if len(changes) > 0 {
+		if err = models.UpdateGalaxy(c.Request.Context(), galaxy, modified); err != nil {
+			switch {
+			case errors.Is(err, db.ErrModified):
+				api.Error(c, http.StatusPreconditionFailed, api.ErrPrecondition)
+			case errors.Is(err, db.ErrNotFound):
+				api.Error(c, http.StatusNotFound, auth.ErrGalaxyNotFound)
+			default:
+				log.Error().Err(err).Msg("could not update galaxy")
+				api.Error(c, http.StatusInternalServerError, "could not update galaxy")
+			}
+			return
+		}
+		s.audit(c, models.AuditGalaxyUpdated, actorID, 0, galaxyDetail(galaxyID, strings.Join(changes, ", ")))
+	}
+
+	c.Header(HeaderETag, galaxyETag(galaxy))
+	c.JSON(http.StatusOK, galaxyReply(galaxy))
+}
+
+// DeleteGalaxy deletes the galaxy along with all of its players. If the request has an
+// If-Match header, the galaxy is only deleted if it has not been modified since the
+// client fetched it.
+func (s *Server) DeleteGalaxy(c *gin.Context) {
+	var (
+		err      error
+		galaxyID int64
+		actorID  int64
+		modified time.Time
+	)
+
+	if galaxyID, err = auth.GetGalaxyID(c); err != nil {
+		log.Warn().Err(err).Msg("could not get galaxy from request")
+		api.Error(c, http.StatusInternalServerError, "could not delete galaxy")
+		return
+	}
+
+	if actorID, err = subjectID(c); err != nil {
+		log.Warn().Err(err).Msg("could not identify user to delete galaxy")
+		api.Error(c, http.StatusInternalServerError, "could not delete galaxy")
+		return
+	}
+
+	if c.GetHeader(HeaderIfMatch) != "" {
+		var galaxy *models.Galaxy
+		if galaxy, err = models.GetGalaxy(c.Request.Context(), galaxyID); err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				api.Error(c, http.StatusNotFound, auth.ErrGalaxyNotFound)
+				return
+			}
+
+			log.Error().Err(err).Msg("could not fetch galaxy from the database")
+			api.Error(c, http.StatusInternalServerError, "could not delete galaxy")
+			return
+		}
+
+		if preconditionFailed(c, galaxyETag(galaxy)) {
+			return
+		}
+		modified = galaxy.Modified
+	}
+
+	if err = models.DeleteGalaxy(c.Request.Context(), galaxyID, modified); err != nil {
+		switch {
+		case errors.Is(err, db.ErrModified):
+			api.Error(c, http.StatusPreconditionFailed, api.ErrPrecondition)
+		case errors.Is(err, db.ErrNotFound):
+			api.Error(c, http.StatusNotFound, auth.ErrGalaxyNotFound)
+		default:
+			log.Error().Err(err).Msg("could not delete galaxy")
+			api.Error(c, http.StatusInternalServerError, "could not delete galaxy")
+		}
+		return
+	}
+
+	s.audit(c, models.AuditGalaxyDeleted, actorID, 0, galaxyDetail(galaxyID, ""))
+	c.JSON(http.StatusOK, api.Reply{Success: true})
+}
+
+// ListPlayers returns the players in the galaxy and their galaxy roles.
+func (s *Server) ListPlayers(c *gin.Context) {
+	var (
+		err      error
+		galaxyID int64
+		players  []*models.Player
+	)
+
+	if galaxyID, err = auth.GetGalaxyID(c); err != nil {
+		log.Warn().Err(err).Msg("could not get galaxy from request")
+		api.Error(c, http.StatusInternalServerError, "could not list players")
+		return
+	}
+
+	if players, err = models.ListPlayers(c.Request.Context(), galaxyID); err != nil {
+		log.Error().Err(err).Msg("could not list players from the database")
+		api.Error(c, http.StatusInternalServerError, "could not list players")
+		return
+	}
+
+	out := &api.PlayerList{Players: make([]*api.Player, 0, len(players))}
+	for _, player := range players {
+		out.Players = append(out.Players, playerReply(c.Request.Context(), player))
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// SetPlayerRole assigns the player to the specified galaxy role. Galaxy admins cannot
+// change their own role so that a galaxy is not accidentally left without an admin.
+func (s *Server) SetPlayerRole(c *gin.Context) {
+	var (
+		err     error
+		in      *api.SetRoleRequest
+		actorID int64
+		player  *models.Player
+		prev    *models.Role
+	)
+
+	in = &api.SetRoleRequest{}
+	if err = c.BindJSON(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if actorID, err = subjectID(c); err != nil {
+		log.Warn().Err(err).Msg("could not identify user to set player role")
+		api.Error(c, http.StatusInternalServerError, "could not set player role")
+		return
+	}
+
+	if player, err = s.galaxyPlayer(c, "could not set player role"); err != nil {
+		return
+	}
+
+	if player.PlayerID == actorID {
+		api.Error(c, http.StatusForbidden, "galaxy admins cannot change their own role")
 		return
 	}
 
-	c.JSON(http.StatusCreated, galaxy)
+	ctx := c.Request.Context()
+	if prev, err = player.Role(ctx); err != nil {
+		log.Error().Err(err).Msg("could not fetch player role from database")
+		api.Error(c, http.StatusInternalServerError, "could not set player role")
+		return
+	}
+
+	if err = player.SetRole(ctx, in.Role); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			api.Error(c, http.StatusBadRequest, api.ErrUnknownUserRole)
+			return
+		}
+
+		log.Error().Err(err).Msg("could not update player role")
+		api.Error(c, http.StatusInternalServerError, "could not set player role")
+		return
+	}
+
+	s.audit(c, models.AuditPlayerRole, actorID, player.PlayerID, galaxyDetail(player.GalaxyID, prev.Title+" -> "+in.Role))
+	c.JSON(http.StatusOK, playerReply(c.Request.Context(), player))
+}
+
+// RemovePlayer removes the player from the galaxy. Players leave a galaxy rather than
+// removing themselves so galaxy admins cannot remove their own player.
+func (s *Server) RemovePlayer(c *gin.Context) {
+	var (
+		err     error
+		actorID int64
+		player  *models.Player
+	)
+
+	if actorID, err = subjectID(c); err != nil {
+		log.Warn().Err(err).Msg("could not identify user to remove player")
+		api.Error(c, http.StatusInternalServerError, "could not remove player")
+		return
+	}
+
+	if player, err = s.galaxyPlayer(c, "could not remove player"); err != nil {
+		return
+	}
+
+	if player.PlayerID == actorID {
+		api.Error(c, http.StatusForbidden, "galaxy admins cannot remove themselves")
+		return
+	}
+
+	if err = models.DeletePlayer(c.Request.Context(), player.GalaxyID, player.PlayerID); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			api.Error(c, http.StatusNotFound, "player not found")
+			return
+		}
+
+		log.Error().Err(err).Msg("could not delete player")
+		api.Error(c, http.StatusInternalServerError, "could not remove player")
+		return
+	}
+
+	s.audit(c, models.AuditPlayerRemoved, actorID, player.PlayerID, galaxyDetail(player.GalaxyID, ""))
+	c.JSON(http.StatusOK, api.Reply{Success: true})
+}
+
+// galaxyPlayer fetches the player identified by the player parameter of the request in
+// the authorized galaxy. If the player cannot be fetched an error response is written
+// and an error is returned.
+func (s *Server) galaxyPlayer(c *gin.Context, msg string) (player *models.Player, err error) {
+	var galaxyID, userID int64
+	if galaxyID, err = auth.GetGalaxyID(c); err != nil {
+		log.Warn().Err(err).Msg("could not get galaxy from request")
+		api.Error(c, http.StatusInternalServerError, msg)
+		return nil, err
+	}
+
+	if userID, err = strconv.ParseInt(c.Param("player"), 10, 64); err != nil {
+		api.Error(c, http.StatusBadRequest, api.ErrMissingID)
+		return nil, err
+	}
+
+	if player, err = models.GetPlayer(c.Request.Context(), galaxyID, userID); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			api.Error(c, http.StatusNotFound, "player not found")
+			return nil, err
+		}
+
+		log.Error().Err(err).Msg("could not fetch player from database")
+		api.Error(c, http.StatusInternalServerError, msg)
+		return nil, err
+	}
+	return player, nil
+}
+
+func galaxyETag(galaxy *models.Galaxy) string {
+	return etag(galaxy.ID, galaxy.Modified)
+}
+
+func galaxyReply(galaxy *models.Galaxy) *api.Galaxy {
+	return &api.Galaxy{
+		ID:         galaxy.ID,
+		Name:       galaxy.Name,
+		Turn:       galaxy.Turn,
+		Size:       galaxy.Size.String(),
+		MaxPlayers: galaxy.MaxPlayers,
+		MaxTurns:   galaxy.MaxTurns,
+		JoinCode:   galaxy.JoinCode.String(),
+		State:      galaxy.GameState.String(),
+		Created:    galaxy.Created.Format(time.RFC3339),
+		Modified:   galaxy.Modified.Format(time.RFC3339),
+	}
+}
+
+func playerReply(ctx context.Context, player *models.Player) *api.Player {
+	out := &api.Player{
+		UserID:    player.PlayerID,
+		Name:      player.Name,
+		Faction:   player.Faction.String(),
+		Character: player.Character.String(),
+		Joined:    player.Created.Format(time.RFC3339),
+	}
+
+	if role, err := player.Role(ctx); err == nil {
+		out.Role = role.Title
+	}
+	return out
+}
+
+func galaxyDetail(galaxyID int64, detail string) string {
+	if detail == "" {
+		return fmt.Sprintf("galaxy %d", galaxyID)
+	}
+	return fmt.Sprintf("galaxy %d: %s", galaxyID, detail)
 }
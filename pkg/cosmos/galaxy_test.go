@@ -0,0 +1,48 @@
+package cosmos
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/pagination"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGalaxyQueryPageToken(t *testing.T) {
+	token := func(order, key string) string {
+		o, err := pagination.ParseOrder(order, "created", "modified", "name", "turn")
+		require.NoError(t, err)
+		tks, err := pagination.New(o, key, 7, "").Token()
+		require.NoError(t, err)
+		return tks
+	}
+
+	testCases := []struct {
+		order string
+		key   string
+		err   error
+	}{
+		{"-created", time.Now().Format(time.RFC3339Nano), nil},
+		{"-created", "yesterday", api.InvalidField("page_token", "is invalid")},
+		{"modified", "2025-06-01", api.InvalidField("page_token", "is invalid")},
+		{"turn", "3", nil},
+		{"turn", "3; DROP TABLE galaxies", api.InvalidField("page_token", "is invalid")},
+		{"turn", "4294967296", api.InvalidField("page_token", "is invalid")},
+		{"name", "Andromeda", nil},
+	}
+
+	for _, tc := range testCases {
+		query, err := galaxyQuery(42, &api.GalaxyQuery{OrderBy: tc.order, PageToken: token(tc.order, tc.key)})
+		if tc.err == nil {
+			require.NoError(t, err, "%s %q", tc.order, tc.key)
+			require.Equal(t, tc.key, query.Cursor.Key)
+		} else {
+			require.Equal(t, tc.err, err, "%s %q", tc.order, tc.key)
+		}
+	}
+
+	// Tokens of a different order are rejected as mismatched rather than invalid
+	_, err := galaxyQuery(42, &api.GalaxyQuery{OrderBy: "turn", PageToken: token("created", time.Now().Format(time.RFC3339Nano))})
+	require.Equal(t, errGalaxyTokenMismatch, err)
+}
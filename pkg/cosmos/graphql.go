@@ -0,0 +1,46 @@
+package cosmos
+
+import (
+	"net/http"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// GraphQL executes a GraphQL query against the galaxies of the user. Queries that could
+// not be executed because they are invalid or too complex are bad requests; otherwise
+// the reply is successful even if some of the fields could not be resolved, in which
+// case the errors of the fields are in the reply.
+func (s *Server) GraphQL(c *gin.Context) {
+	var (
+		err    error
+		in     *api.GraphQLRequest
+		claims *auth.Claims
+	)
+
+	if claims, err = auth.GetClaims(c); err != nil {
+		log.Warn().Err(err).Msg("could not get claims for graphql query")
+		api.Error(c, http.StatusInternalServerError, "could not execute query")
+		return
+	}
+
+	in = &api.GraphQLRequest{}
+	if err = c.BindJSON(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	out := s.graph.Execute(c.Request.Context(), claims, s.auth.Versions(), in)
+	if out.Data == nil {
+		c.JSON(http.StatusBadRequest, out)
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,153 @@
+package cosmos
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// Introspect allows companion services to determine if a token issued by the server is
+// active and the permissions it grants as described by RFC 7662. A token is only active
+// if it is a valid access token that has not been revoked and whose permissions are
+// still current; refresh tokens are never active since they cannot be used to access
+// resources. The administrator impersonating the subject of an impersonation token is
+// returned as the actor. The request must be authenticated with API key credentials.
+func (s *Server) Introspect(c *gin.Context) {
+	var (
+		err     error
+		in      *api.TokenRequest
+		claims  *auth.Claims
+		current bool
+	)
+
+	in = &api.TokenRequest{}
+	if err = c.ShouldBind(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// Verify also checks if the token or all of the user's tokens have been revoked
+	if claims, err = s.auth.Verify(in.Token); err != nil {
+		log.Debug().Err(err).Msg("introspected token is not active")
+		c.JSON(http.StatusOK, &api.IntrospectReply{Active: false})
+		return
+	}
+
+	if claims.IsRefreshToken() {
+		log.Debug().Msg("introspected token is a refresh token")
+		c.JSON(http.StatusOK, &api.IntrospectReply{Active: false})
+		return
+	}
+
+	if current, err = s.versions.Current(c.Request.Context(), claims); err != nil {
+		log.Error().Err(err).Msg("could not check permission versions of introspected token")
+		api.Error(c, http.StatusInternalServerError, "could not introspect token")
+		return
+	}
+
+	if !current {
+		log.Debug().Str("subject", claims.Subject).Msg("introspected token has stale permissions")
+		c.JSON(http.StatusOK, &api.IntrospectReply{Active: false})
+		return
+	}
+
+	out := &api.IntrospectReply{
+		Active:      true,
+		Scope:       strings.Join(claims.Permissions, " "),
+		ClientID:    claims.ClientID,
+		Subject:     claims.Subject,
+		Role:        claims.Role,
+		Permissions: claims.Permissions,
+		Audience:    claims.Audience,
+		Issuer:      claims.Issuer,
+		TokenID:     claims.ID,
+	}
+
+	if claims.Actor != nil {
+		out.Actor = &api.Actor{Subject: claims.Actor.Subject, Email: claims.Actor.Email}
+	}
+
+	if claims.ExpiresAt != nil {
+		out.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+
+	if claims.IssuedAt != nil {
+		out.IssuedAt = claims.IssuedAt.Unix()
+	}
+
+	if claims.NotBefore != nil {
+		out.NotBefore = claims.NotBefore.Unix()
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+// Revoke allows companion services to revoke a token issued by the server as described
+// by RFC 7009. Revoking either an access token or a refresh token revokes both tokens
+// since they are issued together. As required by the RFC, the response is successful
+// even if the token is invalid or has already expired. The request must be
+// authenticated with API key credentials.
+func (s *Server) Revoke(c *gin.Context) {
+	var (
+		err     error
+		in      *api.TokenRequest
+		actorID int64
+		userID  int64
+		claims  *auth.Claims
+	)
+
+	in = &api.TokenRequest{}
+	if err = c.ShouldBind(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if actorID, err = subjectID(c); err != nil {
+		log.Warn().Err(err).Msg("could not identify service to revoke token")
+		api.Error(c, http.StatusInternalServerError, "could not revoke token")
+		return
+	}
+
+	// Expired tokens are parsed so that the refresh token issued with them is revoked
+	if claims, err = s.auth.Parse(in.Token); err != nil {
+		log.Debug().Err(err).Msg("could not parse token to revoke")
+		c.JSON(http.StatusOK, &api.Reply{Success: true})
+		return
+	}
+
+	if userID, err = claims.SubjectID(); err != nil {
+		log.Debug().Err(err).Msg("could not parse subject of token to revoke")
+		c.JSON(http.StatusOK, &api.Reply{Success: true})
+		return
+	}
+
+	if err = s.revocations.RevokeToken(c.Request.Context(), claims); err != nil {
+		if errors.Is(err, auth.ErrNoTokenID) {
+			c.JSON(http.StatusOK, &api.Reply{Success: true})
+			return
+		}
+
+		log.Error().Err(err).Msg("could not revoke token")
+		api.Error(c, http.StatusServiceUnavailable, "could not revoke token")
+		return
+	}
+
+	s.audit(c, models.AuditTokenRevoked, actorID, userID, claims.ID)
+	c.JSON(http.StatusOK, &api.Reply{Success: true})
+}
@@ -0,0 +1,79 @@
+package cosmos
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntrospect(t *testing.T) {
+	t.Setenv("COSMOS_MODE", "test")
+	t.Setenv("COSMOS_DATABASE_TESTING", "true")
+	t.Setenv("COSMOS_RATELIMIT_ENABLED", "false")
+
+	// Refresh tokens are valid as soon as they are issued so that they are not rejected
+	// only because they are not yet valid
+	t.Setenv("COSMOS_AUTH_TOKEN_OVERLAP", "-24h")
+	conf, err := config.New()
+	require.NoError(t, err)
+
+	s, err := New(conf)
+	require.NoError(t, err)
+	s.SetStatus(true, true)
+
+	service := &auth.Claims{ClientID: "companion", Role: "Admin", Permissions: []string{"tokens:introspect"}, UserVersion: 1, RoleVersion: 1}
+	service.SetSubjectID(1)
+	s.versions.Update(1, models.PermissionVersion{UserVersion: 1, Role: "Admin", RoleVersion: 1})
+	token, _, err := s.auth.CreateTokens(service)
+	require.NoError(t, err)
+
+	introspect := func(tks string) *api.IntrospectReply {
+		form := url.Values{"token": {tks}}
+		req := httptest.NewRequest(http.MethodPost, "/v1/auth/introspect", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		out := &api.IntrospectReply{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), out))
+		return out
+	}
+
+	claims := &auth.Claims{Role: "Player", Permissions: []string{"games:read"}, UserVersion: 2, RoleVersion: 1}
+	claims.SetSubjectID(42)
+	s.versions.Update(42, models.PermissionVersion{UserVersion: 2, Role: "Player", RoleVersion: 1})
+	access, refresh, err := s.auth.CreateTokens(claims)
+	require.NoError(t, err)
+
+	out := introspect(access)
+	require.True(t, out.Active)
+	require.Equal(t, claims.Subject, out.Subject)
+	require.Nil(t, out.Actor)
+
+	// Refresh tokens are rejected even though they are valid
+	_, err = s.auth.Verify(refresh)
+	require.NoError(t, err)
+	require.Equal(t, &api.IntrospectReply{Active: false}, introspect(refresh))
+
+	// The administrator impersonating the subject is the actor of the token
+	impersonated := &auth.Claims{Role: "Player", Permissions: []string{"games:read"}, UserVersion: 2, RoleVersion: 1}
+	impersonated.SetSubjectID(42)
+	tks, _, err := s.auth.CreateImpersonationToken(impersonated, &auth.Actor{Subject: "1", Email: "admin@example.com"})
+	require.NoError(t, err)
+
+	out = introspect(tks)
+	require.True(t, out.Active)
+	require.Equal(t, claims.Subject, out.Subject)
+	require.Equal(t, &api.Actor{Subject: "1", Email: "admin@example.com"}, out.Actor)
+}
@@ -0,0 +1,253 @@
+package cosmos
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/bbengfort/cosmos/pkg/otp"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+var errMFAAPIKey = errors.New("api keys cannot be used to manage the authenticators of the account")
+
+// MFAStatus returns the multi-factor authentication enrollment status of the user.
+func (s *Server) MFAStatus(c *gin.Context) {
+	var (
+		err    error
+		userID int64
+		claims *auth.Claims
+		user   *models.User
+		role   *models.Role
+		totp   *models.TOTP
+		out    *api.MFAStatusReply
+	)
+
+	if claims, err = auth.GetClaims(c); err != nil {
+		log.Warn().Err(err).Msg("could not get claims from request")
+		api.Error(c, http.StatusInternalServerError, "could not fetch mfa status")
+		return
+	}
+
+	if userID, err = claims.SubjectID(); err != nil {
+		log.Warn().Err(err).Msg("could not parse user ID from claims")
+		api.Error(c, http.StatusInternalServerError, "could not fetch mfa status")
+		return
+	}
+
+	ctx := c.Request.Context()
+	if user, err = models.GetUser(ctx, userID); err != nil {
+		log.Error().Err(err).Msg("could not fetch user from database")
+		api.Error(c, http.StatusInternalServerError, "could not fetch mfa status")
+		return
+	}
+
+	if role, err = user.Role(ctx); err != nil {
+		log.Error().Err(err).Msg("could not fetch user role from database")
+		api.Error(c, http.StatusInternalServerError, "could not fetch mfa status")
+		return
+	}
+
+	out = &api.MFAStatusReply{Required: role.RequireMFA}
+	if totp, err = models.GetTOTP(ctx, userID); err != nil && !errors.Is(err, db.ErrNotFound) {
+		log.Error().Err(err).Msg("could not fetch totp enrollment from database")
+		api.Error(c, http.StatusInternalServerError, "could not fetch mfa status")
+		return
+	}
+
+	if totp != nil && totp.Verified {
+		out.Enrolled = true
+		if out.RecoveryCodes, err = models.RemainingRecoveryCodes(ctx, userID); err != nil {
+			log.Error().Err(err).Msg("could not count recovery codes")
+			api.Error(c, http.StatusInternalServerError, "could not fetch mfa status")
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+// EnrollTOTP creates a new unverified TOTP secret for the user to add to their
+// authenticator. The enrollment is not used for login until it is verified.
+func (s *Server) EnrollTOTP(c *gin.Context) {
+	var (
+		err    error
+		userID int64
+		claims *auth.Claims
+		totp   *models.TOTP
+		out    *api.TOTPEnrollReply
+	)
+
+	if claims, err = auth.GetClaims(c); err != nil {
+		log.Warn().Err(err).Msg("could not get claims from request")
+		api.Error(c, http.StatusInternalServerError, "could not enroll authenticator")
+		return
+	}
+
+	// A leaked API key must not be able to take over the second factor of its owner
+	if claims.ClientID != "" {
+		api.Error(c, http.StatusForbidden, errMFAAPIKey)
+		return
+	}
+
+	if userID, err = claims.SubjectID(); err != nil {
+		log.Warn().Err(err).Msg("could not parse user ID from claims")
+		api.Error(c, http.StatusInternalServerError, "could not enroll authenticator")
+		return
+	}
+
+	// A verified enrollment must be removed before a new authenticator is enrolled
+	ctx := c.Request.Context()
+	if totp, err = models.GetTOTP(ctx, userID); err != nil && !errors.Is(err, db.ErrNotFound) {
+		log.Error().Err(err).Msg("could not fetch totp enrollment from database")
+		api.Error(c, http.StatusInternalServerError, "could not enroll authenticator")
+		return
+	}
+
+	if totp != nil && totp.Verified {
+		api.Error(c, http.StatusConflict, "an authenticator is already enrolled")
+		return
+	}
+
+	totp = &models.TOTP{UserID: userID}
+	if totp.Secret, err = otp.NewSecret(); err != nil {
+		log.Error().Err(err).Msg("could not create totp secret")
+		api.Error(c, http.StatusInternalServerError, "could not enroll authenticator")
+		return
+	}
+
+	if err = models.CreateTOTP(ctx, totp); err != nil {
+		log.Error().Err(err).Msg("could not save totp enrollment")
+		api.Error(c, http.StatusInternalServerError, "could not enroll authenticator")
+		return
+	}
+
+	out = &api.TOTPEnrollReply{
+		Secret:          totp.Secret,
+		ProvisioningURI: otp.ProvisioningURI(totp.Secret, s.conf.Auth.TOTPIssuer, claims.Email),
+	}
+	c.JSON(http.StatusCreated, out)
+}
+
+// VerifyTOTP verifies a pending enrollment with a code from the user's authenticator.
+// Once verified the user must submit a code on every login and a set of single-use
+// recovery codes is returned; this is the only time the recovery codes are available.
+func (s *Server) VerifyTOTP(c *gin.Context) {
+	var (
+		err     error
+		in      *api.TOTPVerifyRequest
+		out     *api.TOTPVerifyReply
+		userID  int64
+		counter uint64
+		claims  *auth.Claims
+		totp    *models.TOTP
+	)
+
+	in = &api.TOTPVerifyRequest{}
+	if err = c.BindJSON(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if claims, err = auth.GetClaims(c); err != nil {
+		log.Warn().Err(err).Msg("could not get claims from request")
+		api.Error(c, http.StatusInternalServerError, "could not verify authenticator")
+		return
+	}
+
+	// A leaked API key must not be able to take over the second factor of its owner
+	if claims.ClientID != "" {
+		api.Error(c, http.StatusForbidden, errMFAAPIKey)
+		return
+	}
+
+	if userID, err = claims.SubjectID(); err != nil {
+		log.Warn().Err(err).Msg("could not parse user ID from claims")
+		api.Error(c, http.StatusInternalServerError, "could not verify authenticator")
+		return
+	}
+
+	ctx := c.Request.Context()
+	if totp, err = models.GetTOTP(ctx, userID); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			api.Error(c, http.StatusBadRequest, "no authenticator enrollment is pending")
+			return
+		}
+
+		log.Error().Err(err).Msg("could not fetch totp enrollment from database")
+		api.Error(c, http.StatusInternalServerError, "could not verify authenticator")
+		return
+	}
+
+	if totp.Verified {
+		api.Error(c, http.StatusConflict, "an authenticator is already enrolled")
+		return
+	}
+
+	if counter, err = otp.Validate(totp.Secret, in.Code, time.Now()); err != nil {
+		api.Error(c, http.StatusBadRequest, "invalid authenticator code")
+		return
+	}
+
+	out = &api.TOTPVerifyReply{}
+	if out.RecoveryCodes, err = otp.NewRecoveryCodes(otp.RecoveryCodes); err != nil {
+		log.Error().Err(err).Msg("could not generate recovery codes")
+		api.Error(c, http.StatusInternalServerError, "could not verify authenticator")
+		return
+	}
+
+	hashes := make([]string, 0, len(out.RecoveryCodes))
+	for _, code := range out.RecoveryCodes {
+		hashes = append(hashes, otp.HashRecoveryCode(code))
+	}
+
+	if err = totp.Verify(ctx, int64(counter), hashes); err != nil {
+		log.Error().Err(err).Msg("could not verify totp enrollment")
+		api.Error(c, http.StatusInternalServerError, "could not verify authenticator")
+		return
+	}
+
+	s.audit(c, models.AuditMFAEnrolled, userID, userID, "totp")
+	c.JSON(http.StatusOK, out)
+}
+
+// RemoveTOTP removes the user's authenticator and recovery codes. This endpoint
+// requires that the user authenticated with their second factor.
+func (s *Server) RemoveTOTP(c *gin.Context) {
+	var (
+		err    error
+		userID int64
+		claims *auth.Claims
+	)
+
+	if claims, err = auth.GetClaims(c); err != nil {
+		log.Warn().Err(err).Msg("could not get claims from request")
+		api.Error(c, http.StatusInternalServerError, "could not remove authenticator")
+		return
+	}
+
+	if userID, err = claims.SubjectID(); err != nil {
+		log.Warn().Err(err).Msg("could not parse user ID from claims")
+		api.Error(c, http.StatusInternalServerError, "could not remove authenticator")
+		return
+	}
+
+	if err = models.DeleteTOTP(c.Request.Context(), userID); err != nil {
+		log.Error().Err(err).Msg("could not delete totp enrollment")
+		api.Error(c, http.StatusInternalServerError, "could not remove authenticator")
+		return
+	}
+
+	s.audit(c, models.AuditMFARemoved, userID, userID, "totp")
+	c.JSON(http.StatusOK, &api.Reply{Success: true})
+}
@@ -0,0 +1,68 @@
+package cosmos
+
+import (
+	"context"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/rs/zerolog/log"
+)
+
+// The delay before listening for database notifications again after an error
+const listenRetryInterval = 5 * time.Second
+
+// listen for database notifications that invalidate the in-memory caches of the server
+// or that wake the event streams of galaxies until the context is canceled.
+// Notifications are sent by database triggers so that changes made by any replica take
+// effect immediately on all replicas.
+func (s *Server) listen(ctx context.Context) {
+	handler := func(n *db.Notification) {
+		// Notifications may have been missed while the listener was disconnected
+		if n == nil {
+			s.versions.Reset()
+			s.events.NotifyAll()
+			go func() {
+				if err := s.revocations.Refresh(ctx); err != nil && ctx.Err() == nil {
+					log.Warn().Err(err).Msg("could not refresh token revocations")
+				}
+			}()
+			return
+		}
+
+		switch n.Channel {
+		case models.PermissionVersionsChannel:
+			s.versions.Invalidate(n.Payload)
+		case models.TokenRevocationsChannel:
+			if err := s.revocations.Apply(n.Payload); err != nil {
+				log.Warn().Err(err).Msg("could not apply token revocation notification")
+			}
+		case models.GalaxyEventsChannel:
+			galaxyID, _, err := models.ParseGalaxyEventNotification(n.Payload)
+			if err != nil {
+				log.Warn().Err(err).Msg("could not parse galaxy event notification")
+				s.events.NotifyAll()
+				return
+			}
+			s.events.Notify(galaxyID)
+		}
+	}
+
+	for {
+		err := db.Listen(ctx, handler, models.PermissionVersionsChannel, models.TokenRevocationsChannel, models.GalaxyEventsChannel)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Notifications may be missed until the listener is reestablished
+		log.Warn().Err(err).Msg("could not listen for database notifications")
+		s.versions.Reset()
+		s.events.NotifyAll()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenRetryInterval):
+		}
+	}
+}
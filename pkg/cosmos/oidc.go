@@ -0,0 +1,412 @@
+package cosmos
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/bbengfort/cosmos/pkg/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// The authorization request state is stored in an http only cookie that is only sent
+// to the oidc endpoints so that the callback can verify the state and nonce and can
+// exchange the code with the PKCE verifier.
+const (
+	oidcStateCookie = "oidc_state"
+	oidcCookiePath  = "/v1/oidc"
+)
+
+var (
+	errNoExternalEmail  = errors.New("identity provider did not return an email address")
+	errUnverifiedEmail  = errors.New("identity provider has not verified the email address")
+	errEmailExists      = errors.New("an account with this email already exists; sign in with your password to link the provider")
+	errIdentityLinked   = errors.New("identity is already linked to another account")
+	errInvalidOIDCState = errors.New("invalid or expired sign in request")
+)
+
+// The state is not signed so the user that is linking a provider is identified by their
+// access token, which cannot be forged, rather than by their user ID.
+type oidcState struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Link     string `json:"link,omitempty"`
+}
+
+// RegisterIdentityProvider adds an external identity provider that users can sign in
+// with, replacing any provider with the same name.
+func (s *Server) RegisterIdentityProvider(provider oidc.IdentityProvider) {
+	s.Lock()
+	s.providers[provider.Name()] = provider
+	s.Unlock()
+}
+
+func (s *Server) identityProvider(name string) (provider oidc.IdentityProvider, ok bool) {
+	s.RLock()
+	provider, ok = s.providers[name]
+	s.RUnlock()
+	return provider, ok
+}
+
+// IdentityProviders lists the external identity providers that users can sign in with.
+func (s *Server) IdentityProviders(c *gin.Context) {
+	s.RLock()
+	out := &api.IdentityProviderList{Providers: make([]string, 0, len(s.providers))}
+	for name := range s.providers {
+		out.Providers = append(out.Providers, name)
+	}
+	s.RUnlock()
+
+	sort.Strings(out.Providers)
+	c.JSON(http.StatusOK, out)
+}
+
+// OIDCLogin starts the authorization code flow by redirecting the user to the identity
+// provider. The state, nonce, and PKCE verifier are stored in a cookie for the callback.
+func (s *Server) OIDCLogin(c *gin.Context) {
+	var (
+		err      error
+		ok       bool
+		provider oidc.IdentityProvider
+		authURL  string
+	)
+
+	if provider, ok = s.identityProvider(c.Param("provider")); !ok {
+		api.Error(c, http.StatusNotFound, oidc.ErrUnknownProvider)
+		return
+	}
+
+	if authURL, err = s.startOIDC(c, provider, ""); err != nil {
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCLink starts the authorization code flow to link the identity provider to the
+// account of the authenticated user once they have confirmed their password. Identities
+// are only linked to existing accounts this way since cosmos does not verify the email
+// addresses of accounts, so an account with the email of an identity may not belong to
+// the user of the identity. The authorization URL is returned rather than redirected to
+// so that the request can be made with an authorization header.
+func (s *Server) OIDCLink(c *gin.Context) {
+	var (
+		err      error
+		ok       bool
+		in       *api.LinkIdentityRequest
+		provider oidc.IdentityProvider
+		claims   *auth.Claims
+		user     *models.User
+		token    string
+		authURL  string
+	)
+
+	in = &api.LinkIdentityRequest{}
+	if err = c.BindJSON(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if provider, ok = s.identityProvider(c.Param("provider")); !ok {
+		api.Error(c, http.StatusNotFound, oidc.ErrUnknownProvider)
+		return
+	}
+
+	if claims, user, err = s.profileUser(c, "could not link identity provider", true); err != nil {
+		return
+	}
+
+	if !s.confirmIdentity(c, claims, user, in.Password, "could not link identity provider") {
+		return
+	}
+
+	if token, err = auth.GetAccessToken(c); err != nil {
+		log.Warn().Err(err).Msg("could not get access token of authenticated request")
+		api.Error(c, http.StatusInternalServerError, "could not link identity provider")
+		return
+	}
+
+	if authURL, err = s.startOIDC(c, provider, token); err != nil {
+		return
+	}
+	c.JSON(http.StatusOK, &api.LinkIdentityReply{URL: authURL})
+}
+
+// startOIDC creates the state of an authorization request, stores it in the state
+// cookie, and returns the authorization URL of the provider. If the request links the
+// provider, link is the access token of the user. An error response is written if the
+// request cannot be started.
+func (s *Server) startOIDC(c *gin.Context, provider oidc.IdentityProvider, link string) (authURL string, err error) {
+	var data []byte
+	state := &oidcState{Provider: provider.Name(), Link: link}
+	if state.State, err = oidc.NewState(); err != nil {
+		log.Error().Err(err).Msg("could not create oidc state")
+		api.Error(c, http.StatusInternalServerError, "could not start sign in")
+		return "", err
+	}
+
+	if state.Nonce, err = oidc.NewState(); err != nil {
+		log.Error().Err(err).Msg("could not create oidc nonce")
+		api.Error(c, http.StatusInternalServerError, "could not start sign in")
+		return "", err
+	}
+
+	if state.Verifier, err = oidc.NewVerifier(); err != nil {
+		log.Error().Err(err).Msg("could not create pkce verifier")
+		api.Error(c, http.StatusInternalServerError, "could not start sign in")
+		return "", err
+	}
+
+	if authURL, err = provider.AuthCodeURL(c.Request.Context(), state.State, state.Nonce, oidc.Challenge(state.Verifier)); err != nil {
+		log.Error().Err(err).Str("provider", provider.Name()).Msg("could not create authorization url")
+		api.Error(c, http.StatusServiceUnavailable, "could not start sign in")
+		return "", err
+	}
+
+	if data, err = json.Marshal(state); err != nil {
+		log.Error().Err(err).Msg("could not marshal oidc state")
+		api.Error(c, http.StatusInternalServerError, "could not start sign in")
+		return "", err
+	}
+
+	// The cookie must be sent on the top-level redirect back from the provider
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, base64.RawURLEncoding.EncodeToString(data), int(s.conf.OIDC.StateTTL.Seconds()), oidcCookiePath, s.conf.Auth.CookieDomain, true, true)
+	return authURL, nil
+}
+
+// OIDCCallback completes the authorization code flow: the code is exchanged for an ID
+// token which identifies the user, then the user is issued cosmos tokens. Users are
+// created with the default role the first time they sign in with a provider unless
+// the flow was started to link the provider to the account of an existing user.
+func (s *Server) OIDCCallback(c *gin.Context) {
+	var (
+		err      error
+		ok       bool
+		provider oidc.IdentityProvider
+		state    *oidcState
+		identity *oidc.Identity
+		user     *models.User
+		attempts *models.LoginAttempts
+	)
+
+	if provider, ok = s.identityProvider(c.Param("provider")); !ok {
+		api.Error(c, http.StatusNotFound, oidc.ErrUnknownProvider)
+		return
+	}
+
+	// The state cookie can only be used once
+	state, err = getOIDCState(c)
+	c.SetCookie(oidcStateCookie, "", -1, oidcCookiePath, s.conf.Auth.CookieDomain, true, true)
+
+	if err != nil || state.Provider != provider.Name() || subtle.ConstantTimeCompare([]byte(state.State), []byte(c.Query("state"))) != 1 {
+		log.Debug().Err(err).Msg("invalid oidc callback state")
+		api.Error(c, http.StatusBadRequest, errInvalidOIDCState)
+		return
+	}
+
+	if errcode := c.Query("error"); errcode != "" {
+		log.Debug().Str("error", errcode).Str("provider", provider.Name()).Msg("identity provider returned an error")
+		api.Error(c, http.StatusForbidden, "authentication failed")
+		return
+	}
+
+	ctx := c.Request.Context()
+	if identity, err = provider.Identify(ctx, c.Query("code"), state.Verifier, state.Nonce); err != nil {
+		log.Warn().Err(err).Str("provider", provider.Name()).Msg("could not identify user with identity provider")
+		api.Error(c, http.StatusForbidden, "authentication failed")
+		return
+	}
+
+	if state.Link != "" {
+		user, err = s.linkExternalUser(c, state.Link, identity)
+	} else {
+		user, err = s.externalUser(c, identity)
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, errNoExternalEmail), errors.Is(err, errUnverifiedEmail), errors.Is(err, errInvalidOIDCState):
+			api.Error(c, http.StatusForbidden, err)
+		case errors.Is(err, errEmailExists), errors.Is(err, errIdentityLinked):
+			api.Error(c, http.StatusConflict, err)
+		default:
+			log.Error().Err(err).Str("provider", provider.Name()).Msg("could not get or create user for external identity")
+			api.Error(c, http.StatusInternalServerError, "authentication failed")
+		}
+		return
+	}
+
+	// Disabled and locked accounts cannot sign in with an external provider either
+	if s.accountDisabled(c, user) {
+		return
+	}
+
+	if attempts, err = models.GetLoginAttempts(ctx, models.UserLoginKey(user.ID)); err != nil {
+		log.Error().Err(err).Msg("could not fetch user login attempts from database")
+		api.Error(c, http.StatusInternalServerError, "authentication failed")
+		return
+	}
+
+	if !s.throttle.Allowed(attempts) {
+		s.audit(c, models.AuditLoginThrottled, 0, user.ID, identity.Provider)
+		api.Error(c, http.StatusForbidden, "authentication failed")
+		return
+	}
+
+	if s.secondFactorRequired(c, user, auth.AMRFederated) {
+		return
+	}
+	s.completeLogin(c, user, attempts, auth.AMRFederated)
+}
+
+// externalUser returns the user linked to the external identity. If the identity has
+// not been seen before a new user is created with the default role if the provider has
+// verified the email address. Identities are never linked to an existing account with
+// the same email address since cosmos does not verify the email addresses of accounts;
+// the user must sign in with their password and link the provider instead.
+func (s *Server) externalUser(c *gin.Context, identity *oidc.Identity) (user *models.User, err error) {
+	var ident *models.ExternalIdentity
+	ctx := c.Request.Context()
+
+	if ident, err = models.GetExternalIdentity(ctx, identity.Provider, identity.Subject); err == nil {
+		return models.GetUser(ctx, ident.UserID)
+	}
+
+	if !errors.Is(err, db.ErrNotFound) {
+		return nil, err
+	}
+
+	if identity.Email == "" {
+		return nil, errNoExternalEmail
+	}
+
+	ident = &models.ExternalIdentity{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    sql.NullString{Valid: true, String: identity.Email},
+	}
+
+	// Accounts cannot be created for email addresses that the user may not own
+	if !identity.EmailVerified {
+		return nil, errUnverifiedEmail
+	}
+
+	if _, err = models.GetUser(ctx, identity.Email); err == nil {
+		return nil, errEmailExists
+	}
+
+	if !errors.Is(db.Check(err), db.ErrNotFound) {
+		return nil, err
+	}
+
+	// Create a new user on first sign in. The user has no usable password: the derived
+	// key of a random secret is stored since passwords are required and must be unique.
+	user = &models.User{
+		Name:  sql.NullString{Valid: identity.Name != "", String: identity.Name},
+		Email: identity.Email,
+	}
+
+	var secret string
+	if secret, err = oidc.NewVerifier(); err != nil {
+		return nil, err
+	}
+
+	if user.Password, err = auth.CreateDerivedKey(secret); err != nil {
+		return nil, err
+	}
+
+	if err = models.CreateExternalUser(ctx, user, ident); err != nil {
+		return nil, err
+	}
+
+	s.audit(c, models.AuditIdentityLinked, user.ID, user.ID, identity.Provider)
+	log.Info().Int64("user_id", user.ID).Str("email", user.Email).Str("provider", identity.Provider).Msg("new user registered with identity provider")
+	return user, nil
+}
+
+// linkExternalUser links the external identity to the user of the access token that
+// started the link request and returns the user. Identities that are already linked to
+// the user are allowed so that linking can be retried.
+func (s *Server) linkExternalUser(c *gin.Context, token string, identity *oidc.Identity) (user *models.User, err error) {
+	var (
+		claims *auth.Claims
+		userID int64
+		ident  *models.ExternalIdentity
+	)
+
+	if claims, err = s.auth.Verify(token); err != nil {
+		log.Debug().Err(err).Msg("access token of link request is no longer valid")
+		return nil, errInvalidOIDCState
+	}
+
+	if userID, err = claims.SubjectID(); err != nil {
+		return nil, errInvalidOIDCState
+	}
+
+	ctx := c.Request.Context()
+	if user, err = models.GetUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	if ident, err = models.GetExternalIdentity(ctx, identity.Provider, identity.Subject); err == nil {
+		if ident.UserID != user.ID {
+			return nil, errIdentityLinked
+		}
+		return user, nil
+	}
+
+	if !errors.Is(err, db.ErrNotFound) {
+		return nil, err
+	}
+
+	ident = &models.ExternalIdentity{
+		UserID:   user.ID,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    sql.NullString{Valid: identity.Email != "", String: identity.Email},
+	}
+
+	if err = models.LinkExternalIdentity(ctx, ident); err != nil {
+		if errors.Is(db.Check(err), db.ErrAlreadyExists) {
+			return nil, errIdentityLinked
+		}
+		return nil, err
+	}
+
+	s.audit(c, models.AuditIdentityLinked, user.ID, user.ID, identity.Provider)
+	return user, nil
+}
+
+func getOIDCState(c *gin.Context) (state *oidcState, err error) {
+	var cookie string
+	if cookie, err = c.Cookie(oidcStateCookie); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if data, err = base64.RawURLEncoding.DecodeString(cookie); err != nil {
+		return nil, err
+	}
+
+	state = &oidcState{}
+	if err = json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
@@ -0,0 +1,94 @@
+package cosmos
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bbengfort/cosmos/internal/dbtest"
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/bbengfort/cosmos/pkg/oidc"
+	"github.com/bbengfort/cosmos/pkg/oidc/oidctest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCCallback(t *testing.T) {
+	t.Setenv("COSMOS_MODE", "test")
+	t.Setenv("COSMOS_DATABASE_TESTING", "true")
+	t.Setenv("COSMOS_RATELIMIT_ENABLED", "false")
+	conf, err := config.New()
+	require.NoError(t, err)
+
+	s, err := New(conf)
+	require.NoError(t, err)
+	s.SetStatus(true, true)
+
+	mock := dbtest.Connect(t)
+
+	stub, err := oidctest.New("cosmos", "supersecret")
+	require.NoError(t, err)
+	defer stub.Close()
+
+	provider, err := oidc.New(stub.Config("stub", "http://localhost/v1/oidc/stub/callback"))
+	require.NoError(t, err)
+	s.RegisterIdentityProvider(provider)
+
+	// signin executes the authorization code flow and returns the callback response
+	signin := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/oidc/stub/login", nil))
+		require.Equal(t, http.StatusFound, w.Code)
+
+		code, state, err := stub.Authorize(w.Header().Get("Location"))
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/v1/oidc/stub/callback?code="+code+"&state="+state, nil)
+		for _, cookie := range w.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+
+		w = httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Accounts are not created for email addresses the provider has not verified
+	stub.SetIdentity(oidctest.Identity{Subject: "attacker", Email: "jane@example.com", EmailVerified: false})
+	expectNoIdentity(mock, "attacker")
+	w := signin()
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Contains(t, w.Body.String(), errUnverifiedEmail.Error())
+
+	// Identities are not linked to existing accounts with the same email address even if
+	// the provider has verified it, since the account's email may not have been verified
+	stub.SetIdentity(oidctest.Identity{Subject: "jane", Email: "jane@example.com", EmailVerified: true})
+	expectNoIdentity(mock, "jane")
+
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM users WHERE email=").WithArgs("jane@example.com").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "name", "email", "password", "role_id", "last_login", "disabled", "has_password", "perms_version", "created", "modified"}).
+			AddRow(2, "Jane", "jane@example.com", "", 3, nil, false, true, 1, now, now),
+	)
+	mock.ExpectQuery("FROM roles WHERE id=").WithArgs(3).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "title", "description", "is_default", "require_mfa", "version", "created", "modified"}).
+			AddRow(3, "Player", nil, true, false, 1, now, now),
+	)
+	mock.ExpectQuery("FROM role_permissions").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "created", "modified"}))
+	mock.ExpectCommit()
+
+	w = signin()
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Contains(t, w.Body.String(), "link the provider")
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// expectNoIdentity expects the external identity to be looked up and not found.
+func expectNoIdentity(mock sqlmock.Sqlmock, subject string) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM external_identities").WithArgs("stub", subject).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+}
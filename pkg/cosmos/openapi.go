@@ -0,0 +1,166 @@
+package cosmos
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bbengfort/cosmos/pkg"
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/idempotency"
+	"github.com/bbengfort/cosmos/pkg/openapi"
+	"github.com/gin-gonic/gin"
+)
+
+// Security schemes that can be used to authenticate requests. Access tokens are sent in
+// the Authorization header or in the access_token cookie set by the login endpoints; API
+// key credentials are sent using HTTP basic authentication.
+const (
+	securityBearer = "bearer"
+	securityCookie = "cookie"
+	securityAPIKey = "apikey"
+)
+
+var (
+	authenticated = []string{securityBearer, securityCookie, securityAPIKey}
+	apiKeyOnly    = []string{securityAPIKey}
+
+	idempotencyKey = []string{idempotency.HeaderKey}
+)
+
+const (
+	mfaRequired   = "Requires multi-factor authentication."
+	notImpersonal = "Cannot be used while impersonating a user."
+
+	graphQLFields     = "Fields are authorized with the galaxy role of the user; restricted fields are null. Queries that are too complex are rejected."
+	ifNoneMatch       = "The reply has an ETag header; if the If-None-Match header matches the ETag, the galaxy is unchanged and the reply is empty with a 304 status."
+	ifMatch           = "If the If-Match header is set to the ETag of the galaxy, the request fails with a 412 status if the galaxy has been modified since it was fetched."
+	idempotent        = "Retries with the same Idempotency-Key header replay the first response; the key cannot be reused for a different request."
+	deprecatedVersion = "The deprecation and sunset dates are only included if the api version is deprecated."
+
+	signedDeliveries = "Deliveries are POSTed as a GalaxyEvent and signed in the X-Cosmos-Signature header as t=<unix time>,v1=<hex HMAC-SHA256 of the time, a period, and the body keyed by the secret>."
+)
+
+// v1Routes describes every route registered in setupV1Routes for the OpenAPI document.
+// The security and permissions of each route must match its auth middleware; routes
+// that are not described here or whose permissions differ from the permissions recorded
+// by the authorization middleware cause the OpenAPI tests to fail.
+var v1Routes = []openapi.Route{
+	{Method: http.MethodGet, Path: "/v1/status", Tag: "status", Summary: "Server status and version", Description: deprecatedVersion, Reply: api.StatusReply{}},
+	{Method: http.MethodGet, Path: "/v1/openapi.json", Tag: "status", Summary: "OpenAPI document of the v1 API"},
+
+	// Authentication
+	{Method: http.MethodPost, Path: "/v1/register", Tag: "auth", Summary: "Register a new user", Request: api.RegisterRequest{}, Reply: api.RegisterReply{}, Status: http.StatusCreated},
+	{Method: http.MethodPost, Path: "/v1/login", Tag: "auth", Summary: "Login with a username and password", Request: api.LoginRequest{}, Reply: api.LoginReply{}},
+	{Method: http.MethodPost, Path: "/v1/login/mfa", Tag: "auth", Summary: "Complete a login with a second factor", Request: api.MFALoginRequest{}, Reply: api.LoginReply{}},
+	{Method: http.MethodPost, Path: "/v1/logout", Tag: "auth", Summary: "Clear the authentication cookies", Reply: api.Reply{}},
+	{Method: http.MethodPost, Path: "/v1/reauthenticate", Tag: "auth", Summary: "Exchange a refresh token for new tokens", Request: api.ReauthenticateRequest{}, Reply: api.LoginReply{}},
+	{Method: http.MethodPost, Path: "/v1/authenticate", Tag: "auth", Summary: "Exchange api key credentials for an access token", Request: api.APIKeyLoginRequest{}, Reply: api.LoginReply{}},
+	{Method: http.MethodPost, Path: "/v1/auth/introspect", Tag: "auth", Summary: "Introspect a token (RFC 7662)", Request: api.TokenRequest{}, Reply: api.IntrospectReply{}, Security: apiKeyOnly, Permissions: []string{"tokens:introspect"}},
+	{Method: http.MethodPost, Path: "/v1/auth/revoke", Tag: "auth", Summary: "Revoke a token (RFC 7009)", Request: api.TokenRequest{}, Reply: api.Reply{}, Security: apiKeyOnly, Permissions: []string{"tokens:introspect"}},
+
+	// External identity providers
+	{Method: http.MethodGet, Path: "/v1/oidc", Tag: "oidc", Summary: "List external identity providers", Reply: api.IdentityProviderList{}},
+	{Method: http.MethodGet, Path: "/v1/oidc/:provider/login", Tag: "oidc", Summary: "Redirect to the identity provider to sign in", Status: http.StatusFound},
+	{Method: http.MethodGet, Path: "/v1/oidc/:provider/callback", Tag: "oidc", Summary: "Complete a sign in with the identity provider", Reply: api.LoginReply{}},
+	{Method: http.MethodPost, Path: "/v1/oidc/:provider/link", Tag: "oidc", Summary: "Link the identity provider to the account of the user", Request: api.LinkIdentityRequest{}, Reply: api.LinkIdentityReply{}, Security: authenticated},
+
+	// Profile
+	{Method: http.MethodGet, Path: "/v1/me", Tag: "profile", Summary: "Profile of the authenticated user", Reply: api.Profile{}, Security: authenticated},
+	{Method: http.MethodPatch, Path: "/v1/me", Tag: "profile", Summary: "Update the name or email of the user", Request: api.UpdateProfileRequest{}, Reply: api.Profile{}, Security: authenticated},
+	{Method: http.MethodDelete, Path: "/v1/me", Tag: "profile", Summary: "Delete the account of the user", Request: api.DeleteAccountRequest{}, Reply: api.Reply{}, Security: authenticated},
+	{Method: http.MethodPut, Path: "/v1/me/password", Tag: "profile", Summary: "Change or set the password of the user", Request: api.ChangePasswordRequest{}, Reply: api.LoginReply{}, Security: authenticated},
+	{Method: http.MethodPost, Path: "/v1/me/email/verify", Tag: "profile", Summary: "Verify a change of email address", Request: api.VerifyEmailRequest{}, Reply: api.Profile{}, Security: authenticated},
+
+	// Galaxies
+	{Method: http.MethodGet, Path: "/v1/galaxy/", Tag: "galaxy", Summary: "List the galaxies of the user", Query: api.GalaxyQuery{}, Reply: api.GalaxyList{}, Security: authenticated, Permissions: []string{"games:read"}},
+	{Method: http.MethodPost, Path: "/v1/galaxy/", Tag: "galaxy", Summary: "Create a galaxy", Description: idempotent, Request: api.CreateGalaxyRequest{}, Reply: api.Galaxy{}, Status: http.StatusCreated, Security: authenticated, Permissions: []string{"games:create"}, Headers: idempotencyKey},
+	{Method: http.MethodGet, Path: "/v1/galaxy/:id", Tag: "galaxy", Summary: "Get a galaxy", Description: ifNoneMatch, Reply: api.Galaxy{}, Security: authenticated, Permissions: []string{"galaxy:observe"}, Headers: []string{HeaderIfNoneMatch}},
+	{Method: http.MethodPatch, Path: "/v1/galaxy/:id", Tag: "galaxy", Summary: "Update a galaxy", Description: ifMatch, Request: api.UpdateGalaxyRequest{}, Reply: api.Galaxy{}, Security: authenticated, Permissions: []string{"galaxy:admin"}, Headers: []string{HeaderIfMatch}},
+	{Method: http.MethodDelete, Path: "/v1/galaxy/:id", Tag: "galaxy", Summary: "Delete a galaxy", Description: ifMatch, Reply: api.Reply{}, Security: authenticated, Permissions: []string{"galaxy:admin"}, Headers: []string{HeaderIfMatch}},
+	{Method: http.MethodGet, Path: "/v1/galaxy/:id/events", Tag: "galaxy", Summary: "Stream the events of a galaxy", Description: "Server-sent events; resume the stream with the Last-Event-ID header.", Reply: api.GalaxyEvent{}, ContentType: "text/event-stream", Security: authenticated, Permissions: []string{"galaxy:observe"}},
+	{Method: http.MethodGet, Path: "/v1/galaxy/:id/players", Tag: "galaxy", Summary: "List the players of a galaxy", Reply: api.PlayerList{}, Security: authenticated, Permissions: []string{"galaxy:observe"}},
+	{Method: http.MethodPut, Path: "/v1/galaxy/:id/players/:player/role", Tag: "galaxy", Summary: "Set the galaxy role of a player", Request: api.SetRoleRequest{}, Reply: api.Player{}, Security: authenticated, Permissions: []string{"galaxy:admin"}},
+	{Method: http.MethodDelete, Path: "/v1/galaxy/:id/players/:player", Tag: "galaxy", Summary: "Remove a player from a galaxy", Reply: api.Reply{}, Security: authenticated, Permissions: []string{"galaxy:admin"}},
+
+	// GraphQL
+	{Method: http.MethodPost, Path: "/v1/graphql", Tag: "graphql", Summary: "Query galaxies with GraphQL", Description: graphQLFields, Request: api.GraphQLRequest{}, Reply: api.GraphQLReply{}, Security: authenticated},
+
+	// Multi-factor authentication
+	{Method: http.MethodGet, Path: "/v1/mfa", Tag: "mfa", Summary: "Multi-factor enrollment status", Description: notImpersonal, Reply: api.MFAStatusReply{}, Security: authenticated},
+	{Method: http.MethodPost, Path: "/v1/mfa/totp", Tag: "mfa", Summary: "Start enrolling an authenticator app", Description: notImpersonal, Reply: api.TOTPEnrollReply{}, Status: http.StatusCreated, Security: authenticated},
+	{Method: http.MethodPost, Path: "/v1/mfa/totp/verify", Tag: "mfa", Summary: "Verify the authenticator app enrollment", Description: notImpersonal, Request: api.TOTPVerifyRequest{}, Reply: api.TOTPVerifyReply{}, Security: authenticated},
+	{Method: http.MethodDelete, Path: "/v1/mfa/totp", Tag: "mfa", Summary: "Remove the authenticator app", Description: notImpersonal + " " + mfaRequired, Reply: api.Reply{}, Security: authenticated},
+
+	// API keys
+	{Method: http.MethodGet, Path: "/v1/apikeys", Tag: "apikeys", Summary: "List the api keys of the user", Description: notImpersonal, Reply: api.APIKeyList{}, Security: authenticated},
+	{Method: http.MethodPost, Path: "/v1/apikeys", Tag: "apikeys", Summary: "Create an api key", Description: notImpersonal, Request: api.CreateAPIKeyRequest{}, Reply: api.APIKey{}, Status: http.StatusCreated, Security: authenticated},
+	{Method: http.MethodDelete, Path: "/v1/apikeys/:id", Tag: "apikeys", Summary: "Delete an api key", Description: notImpersonal, Reply: api.Reply{}, Security: authenticated},
+
+	// Webhooks
+	{Method: http.MethodGet, Path: "/v1/webhooks", Tag: "webhooks", Summary: "List the webhooks of the user", Description: notImpersonal, Reply: api.WebhookList{}, Security: authenticated},
+	{Method: http.MethodPost, Path: "/v1/webhooks", Tag: "webhooks", Summary: "Create a webhook for galaxy events", Description: notImpersonal + " " + signedDeliveries, Request: api.CreateWebhookRequest{}, Reply: api.Webhook{}, Status: http.StatusCreated, Security: authenticated},
+	{Method: http.MethodDelete, Path: "/v1/webhooks/:id", Tag: "webhooks", Summary: "Delete a webhook", Description: notImpersonal, Reply: api.Reply{}, Security: authenticated},
+	{Method: http.MethodGet, Path: "/v1/webhooks/:id/deliveries", Tag: "webhooks", Summary: "Delivery log of a webhook", Description: notImpersonal, Query: api.WebhookDeliveryQuery{}, Reply: api.WebhookDeliveryList{}, Security: authenticated},
+
+	// Administration
+	{Method: http.MethodGet, Path: "/v1/admin/users", Tag: "admin", Summary: "Search users", Description: notImpersonal + " " + mfaRequired, Query: api.UserQuery{}, Reply: api.UserList{}, Security: authenticated, Permissions: []string{"users:manage"}},
+	{Method: http.MethodGet, Path: "/v1/admin/users/:id", Tag: "admin", Summary: "Get a user", Description: notImpersonal + " " + mfaRequired, Reply: api.User{}, Security: authenticated, Permissions: []string{"users:manage"}},
+	{Method: http.MethodPut, Path: "/v1/admin/users/:id/role", Tag: "admin", Summary: "Set the role of a user", Description: notImpersonal + " " + mfaRequired, Request: api.SetRoleRequest{}, Reply: api.User{}, Security: authenticated, Permissions: []string{"users:manage"}},
+	{Method: http.MethodPost, Path: "/v1/admin/users/:id/impersonate", Tag: "admin", Summary: "Create an access token to act as a user", Description: notImpersonal + " " + mfaRequired, Reply: api.ImpersonateReply{}, Security: authenticated, Permissions: []string{"users:manage"}},
+	{Method: http.MethodPost, Path: "/v1/admin/users/:id/disable", Tag: "admin", Summary: "Disable a user account", Description: notImpersonal + " " + mfaRequired, Reply: api.User{}, Security: authenticated, Permissions: []string{"users:manage"}},
+	{Method: http.MethodPost, Path: "/v1/admin/users/:id/enable", Tag: "admin", Summary: "Enable a user account", Description: notImpersonal + " " + mfaRequired, Reply: api.User{}, Security: authenticated, Permissions: []string{"users:manage"}},
+	{Method: http.MethodPost, Path: "/v1/admin/users/:id/unlock", Tag: "admin", Summary: "Unlock a locked user account", Description: notImpersonal + " " + mfaRequired, Reply: api.Reply{}, Security: authenticated, Permissions: []string{"users:manage"}},
+	{Method: http.MethodGet, Path: "/v1/admin/roles", Tag: "admin", Summary: "List roles", Description: notImpersonal + " " + mfaRequired, Reply: api.RoleList{}, Security: authenticated, Permissions: []string{"users:manage"}},
+	{Method: http.MethodPost, Path: "/v1/admin/roles", Tag: "admin", Summary: "Create a role", Description: notImpersonal + " " + mfaRequired + " " + idempotent, Request: api.CreateRoleRequest{}, Reply: api.Role{}, Status: http.StatusCreated, Security: authenticated, Permissions: []string{"users:manage"}, Headers: idempotencyKey},
+	{Method: http.MethodPut, Path: "/v1/admin/roles/:id/permissions", Tag: "admin", Summary: "Replace the permissions of a role", Description: notImpersonal + " " + mfaRequired, Request: api.RolePermissionsRequest{}, Reply: api.Role{}, Security: authenticated, Permissions: []string{"users:manage"}},
+	{Method: http.MethodDelete, Path: "/v1/admin/roles/:id", Tag: "admin", Summary: "Delete a role", Description: notImpersonal + " " + mfaRequired, Reply: api.Reply{}, Security: authenticated, Permissions: []string{"users:manage"}},
+	{Method: http.MethodGet, Path: "/v1/admin/permissions", Tag: "admin", Summary: "List permissions", Description: notImpersonal + " " + mfaRequired, Reply: api.PermissionList{}, Security: authenticated, Permissions: []string{"users:manage"}},
+}
+
+// OpenAPI returns the OpenAPI document of the api version of the request.
+func (s *Server) OpenAPI(c *gin.Context) {
+	v, ok := getAPIVersion(c)
+	if !ok {
+		api.NotFound(c)
+		return
+	}
+	c.JSON(http.StatusOK, v.openapi)
+}
+
+// NewOpenAPI generates the OpenAPI document of the api version from its route
+// descriptions. Every operation of a deprecated version is marked as deprecated.
+func NewOpenAPI(v *apiVersion) (doc *openapi.Document, err error) {
+	description := "Multiplayer space strategy game API. Error replies contain a stable error code and the request ID."
+	if !v.deprecated.IsZero() {
+		description += " This version of the API is deprecated"
+		if v.successor != "" {
+			description += "; clients should migrate to " + v.successor
+		}
+		description += "."
+	}
+
+	doc = openapi.New(openapi.Info{
+		Title:       "Cosmos API " + v.name,
+		Description: description,
+		Version:     pkg.Version(),
+	})
+
+	doc.Components.SecuritySchemes[securityBearer] = &openapi.SecurityScheme{Type: "http", Scheme: "bearer", BearerFormat: "JWT"}
+	doc.Components.SecuritySchemes[securityCookie] = &openapi.SecurityScheme{Type: "apiKey", In: "cookie", Name: "access_token", Description: "Unsafe requests must include the csrf_token cookie in the X-CSRF-TOKEN header."}
+	doc.Components.SecuritySchemes[securityAPIKey] = &openapi.SecurityScheme{Type: "http", Scheme: "basic", Description: "The username is the client ID and the password is the client secret of an api key."}
+	doc.ErrorReply(api.Reply{})
+
+	prefix := "/" + v.name + "/"
+	for _, route := range v.describe {
+		if !strings.HasPrefix(route.Path, prefix) {
+			return nil, fmt.Errorf("route %s %s is not a route of api version %s", route.Method, route.Path, v.name)
+		}
+
+		route.Deprecated = route.Deprecated || !v.deprecated.IsZero()
+		if err = doc.Add(route); err != nil {
+			return nil, fmt.Errorf("could not add route to openapi document: %w", err)
+		}
+	}
+	return doc, nil
+}
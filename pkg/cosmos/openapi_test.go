@@ -0,0 +1,64 @@
+package cosmos
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/bbengfort/cosmos/pkg/openapi"
+	"github.com/stretchr/testify/require"
+)
+
+// Fails when a route is added, removed, or changed without updating its description in
+// the OpenAPI document or vice versa.
+func TestOpenAPIRoutes(t *testing.T) {
+	t.Setenv("COSMOS_MODE", "test")
+	t.Setenv("COSMOS_DATABASE_TESTING", "true")
+	conf, err := config.New()
+	require.NoError(t, err)
+
+	s, err := New(conf)
+	require.NoError(t, err)
+
+	doc := s.apis["v1"].openapi
+	registered := make([]string, 0)
+	for _, route := range s.router.Routes() {
+		if strings.HasPrefix(route.Path, "/v1/") {
+			registered = append(registered, route.Method+" "+route.Path)
+		}
+	}
+	sort.Strings(registered)
+	require.Equal(t, registered, doc.Operations(), "the v1 routes and the openapi document have drifted apart")
+
+	// The documented permissions are the permissions checked by the route middleware
+	permissions := s.apis["v1"].permissions
+	require.Len(t, permissions, len(registered), "the permissions of every route should be recorded")
+	for path, item := range doc.Paths {
+		operations := map[string]*openapi.Operation{http.MethodGet: item.Get, http.MethodPut: item.Put, http.MethodPost: item.Post, http.MethodDelete: item.Delete, http.MethodPatch: item.Patch}
+		for method, op := range operations {
+			if op != nil {
+				route := method + " " + openapi.GinPath(path)
+				require.Equal(t, permissions[route], op.Permissions, "the x-permissions of %s do not match its authorization middleware", route)
+			}
+		}
+	}
+	require.Equal(t, []string{"galaxy:admin"}, permissions["DELETE /v1/galaxy/:id"])
+	require.Equal(t, []string{"users:manage"}, permissions["POST /v1/admin/users/:id/impersonate"])
+	require.Empty(t, permissions["GET /v1/me"])
+
+	// The document is served by the API
+	s.SetStatus(true, true)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/openapi.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	served := &openapi.Document{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), served))
+	require.Equal(t, openapi.Version, served.OpenAPI)
+	require.Equal(t, doc.Operations(), served.Operations())
+	require.Contains(t, served.Components.Schemas, "Galaxy")
+}
@@ -0,0 +1,481 @@
+package cosmos
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/bbengfort/cosmos/pkg/mail"
+	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// The number of random bytes in an email verification token
+const emailTokenLen = 32
+
+var (
+	errProfileAPIKey    = errors.New("api keys cannot be used to modify the account")
+	errRecentLogin      = errors.New("sign in again to confirm this change")
+	errInvalidPassword  = errors.New("incorrect password")
+	errEmailInUse       = errors.New("email address is already in use")
+	errInvalidEmailCode = errors.New("invalid or expired email verification token")
+)
+
+// Profile returns the account of the authenticated user along with their role and the
+// permissions granted by the role.
+func (s *Server) Profile(c *gin.Context) {
+	var (
+		err  error
+		user *models.User
+	)
+
+	if _, user, err = s.profileUser(c, "could not fetch profile", false); err != nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, s.profileReply(c, user))
+}
+
+// UpdateProfile changes the name of the user immediately. Email changes are not applied
+// until the user follows the verification link that is sent to the new email address.
+func (s *Server) UpdateProfile(c *gin.Context) {
+	var (
+		err  error
+		in   *api.UpdateProfileRequest
+		user *models.User
+	)
+
+	in = &api.UpdateProfileRequest{}
+	if err = c.BindJSON(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, user, err = s.profileUser(c, "could not update profile", true); err != nil {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if in.Name != "" && in.Name != user.Name.String {
+		if err = user.SetName(ctx, in.Name); err != nil {
+			log.Error().Err(err).Msg("could not update user name")
+			api.Error(c, http.StatusInternalServerError, "could not update profile")
+			return
+		}
+	}
+
+	if in.Email != "" && in.Email != user.Email {
+		var other *models.User
+		if other, err = models.GetUser(ctx, in.Email); err == nil && other.ID != user.ID {
+			api.Error(c, http.StatusConflict, errEmailInUse)
+			return
+		} else if err != nil && !errors.Is(db.Check(err), db.ErrNotFound) {
+			log.Error().Err(err).Msg("could not check if email address is in use")
+			api.Error(c, http.StatusInternalServerError, "could not update profile")
+			return
+		}
+
+		if err = s.sendEmailVerification(c, user, in.Email); err != nil {
+			log.Error().Err(err).Msg("could not send email verification")
+			api.Error(c, http.StatusInternalServerError, "could not update profile")
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, s.profileReply(c, user))
+}
+
+// VerifyEmail applies a pending email change using the token sent to the new address.
+// A notice is sent to the previous email address so that an unexpected change can be
+// reported by the user.
+func (s *Server) VerifyEmail(c *gin.Context) {
+	var (
+		err   error
+		in    *api.VerifyEmailRequest
+		user  *models.User
+		email string
+	)
+
+	in = &api.VerifyEmailRequest{}
+	if err = c.BindJSON(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, user, err = s.profileUser(c, "could not verify email", true); err != nil {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if email, err = models.VerifyEmail(ctx, user.ID, hashEmailToken(in.Token)); err != nil {
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			api.Error(c, http.StatusBadRequest, errInvalidEmailCode)
+		case errors.Is(err, db.ErrAlreadyExists):
+			api.Error(c, http.StatusConflict, errEmailInUse)
+		default:
+			log.Error().Err(err).Msg("could not verify email change")
+			api.Error(c, http.StatusInternalServerError, "could not verify email")
+		}
+		return
+	}
+
+	prev := user.Email
+	user.Email = email
+	s.audit(c, models.AuditEmailChanged, user.ID, user.ID, prev+" -> "+email)
+
+	notice := &mail.Message{
+		To:      prev,
+		Subject: "Your Cosmos email address was changed",
+		Body:    fmt.Sprintf("The email address of your Cosmos account was changed to %s. If you did not make this change, please contact an administrator immediately.\n", email),
+	}
+
+	if err = s.mailer.Send(ctx, notice); err != nil {
+		log.Warn().Err(err).Int64("user_id", user.ID).Msg("could not send email change notice")
+	}
+
+	c.JSON(http.StatusOK, s.profileReply(c, user))
+}
+
+// ChangePassword sets a new password for the user after confirming their current
+// password. All previously issued tokens are revoked so that other sessions are signed
+// out; new tokens are returned so that the current session can continue.
+func (s *Server) ChangePassword(c *gin.Context) {
+	var (
+		err    error
+		in     *api.ChangePasswordRequest
+		out    *api.LoginReply
+		claims *auth.Claims
+		user   *models.User
+		dk     string
+	)
+
+	in = &api.ChangePasswordRequest{}
+	if err = c.BindJSON(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if claims, user, err = s.profileUser(c, "could not change password", true); err != nil {
+		return
+	}
+
+	if !s.confirmIdentity(c, claims, user, in.CurrentPassword, "could not change password") {
+		return
+	}
+
+	if dk, err = auth.CreateDerivedKey(in.NewPassword); err != nil {
+		log.Warn().Err(err).Msg("could not create derived key for password")
+		api.Error(c, http.StatusInternalServerError, "could not change password")
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err = user.UpdatePassword(ctx, dk); err != nil {
+		log.Error().Err(err).Msg("could not update user password")
+		api.Error(c, http.StatusInternalServerError, "could not change password")
+		return
+	}
+
+	if err = s.revocations.Revoke(ctx, user.ID); err != nil {
+		log.Error().Err(err).Int64("user_id", user.ID).Msg("could not revoke tokens after password change")
+		api.Error(c, http.StatusInternalServerError, "could not change password")
+		return
+	}
+	s.audit(c, models.AuditPasswordChanged, user.ID, user.ID, "")
+
+	// Issue new tokens for the current session; the user has just confirmed their identity
+	amr := claims.AMR
+	if claims, err = auth.NewClaimsForUser(ctx, user); err != nil {
+		log.Error().Err(err).Msg("could not create claims for user")
+		api.Error(c, http.StatusInternalServerError, "could not change password")
+		return
+	}
+	claims.AMR = amr
+	claims.AuthTime = jwt.NewNumericDate(time.Now())
+
+	out = &api.LoginReply{}
+	if out.MFAEnrollmentRequired, err = requireMFAEnrollment(ctx, user, claims); err != nil {
+		log.Error().Err(err).Msg("could not fetch user role from database")
+		api.Error(c, http.StatusInternalServerError, "could not change password")
+		return
+	}
+
+	if out.AccessToken, out.RefreshToken, err = s.auth.CreateTokens(claims); err != nil {
+		log.Error().Err(err).Msg("could not create access and refresh tokens for user")
+		api.Error(c, http.StatusInternalServerError, "could not change password")
+		return
+	}
+
+	auth.SetAuthCookies(c, out.AccessToken, out.RefreshToken, s.conf.Auth.CookieDomain)
+	c.JSON(http.StatusOK, out)
+}
+
+// DeleteAccount permanently deletes the user's account after confirming their password.
+// The user is removed from all of their galaxies and all of their tokens and API keys
+// are revoked.
+func (s *Server) DeleteAccount(c *gin.Context) {
+	var (
+		err    error
+		in     *api.DeleteAccountRequest
+		claims *auth.Claims
+		user   *models.User
+		keys   []*models.APIKey
+	)
+
+	in = &api.DeleteAccountRequest{}
+	if err = c.BindJSON(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if claims, user, err = s.profileUser(c, "could not delete account", true); err != nil {
+		return
+	}
+
+	if !s.confirmIdentity(c, claims, user, in.Password, "could not delete account") {
+		return
+	}
+
+	// The API keys are deleted with the user so they must be listed beforehand in order
+	// to remove any cached credentials.
+	ctx := c.Request.Context()
+	if keys, err = models.ListAPIKeys(ctx, user.ID); err != nil {
+		log.Error().Err(err).Msg("could not list api keys of deleted user")
+		api.Error(c, http.StatusInternalServerError, "could not delete account")
+		return
+	}
+
+	if err = models.DeleteUser(ctx, user.ID); err != nil {
+		log.Error().Err(err).Int64("user_id", user.ID).Msg("could not delete user")
+		api.Error(c, http.StatusInternalServerError, "could not delete account")
+		return
+	}
+
+	for _, key := range keys {
+		s.apikeys.Revoke(key.ClientID)
+	}
+
+	// The account has been deleted so the request cannot fail at this point; if the
+	// revocation cannot be stored, at least revoke the tokens on this replica.
+	if err = s.revocations.Revoke(ctx, user.ID); err != nil {
+		log.Error().Err(err).Int64("user_id", user.ID).Msg("could not store token revocation for deleted user")
+		s.revocations.Add(user.ID, time.Now().Truncate(time.Second))
+	}
+
+	// The user ID is recorded in the detail since the audit log cannot reference the user
+	s.audit(c, models.AuditAccountDeleted, 0, 0, fmt.Sprintf("user %d <%s>", user.ID, user.Email))
+	auth.ClearAuthCookies(c, s.conf.Auth.CookieDomain)
+	c.JSON(http.StatusOK, &api.Reply{Success: true})
+}
+
+// profileUser fetches the authenticated user. If sensitive is true, requests that are
+// authenticated with API keys or by administrators impersonating the user are rejected,
+// since neither bots and service accounts nor administrators may modify the account of
+// the user. If the user cannot be fetched, an error response is written and an error
+// is returned.
+func (s *Server) profileUser(c *gin.Context, msg string, sensitive bool) (claims *auth.Claims, user *models.User, err error) {
+	var userID int64
+	if claims, err = auth.GetClaims(c); err != nil {
+		log.Warn().Err(err).Msg("could not get claims from request")
+		api.Error(c, http.StatusInternalServerError, msg)
+		return nil, nil, err
+	}
+
+	if sensitive && claims.ClientID != "" {
+		api.Error(c, http.StatusForbidden, errProfileAPIKey)
+		return nil, nil, errProfileAPIKey
+	}
+
+	if sensitive && claims.Impersonated() {
+		api.Error(c, http.StatusForbidden, auth.ErrImpersonation)
+		return nil, nil, auth.ErrImpersonation
+	}
+
+	if userID, err = claims.SubjectID(); err != nil {
+		log.Warn().Err(err).Msg("could not parse user ID from claims")
+		api.Error(c, http.StatusInternalServerError, msg)
+		return nil, nil, err
+	}
+
+	if user, err = models.GetUser(c.Request.Context(), userID); err != nil {
+		if errors.Is(db.Check(err), db.ErrNotFound) {
+			api.Error(c, http.StatusNotFound, "user not found")
+			return nil, nil, err
+		}
+
+		log.Error().Err(err).Msg("could not fetch user from database")
+		api.Error(c, http.StatusInternalServerError, msg)
+		return nil, nil, err
+	}
+	return claims, user, nil
+}
+
+// confirmIdentity verifies the user's password before a sensitive change is made to
+// their account; failed attempts are throttled like failed logins. Users that signed up
+// with an external identity provider and have never set a password must instead have
+// logged in recently. Returns false if an error response has been written.
+func (s *Server) confirmIdentity(c *gin.Context, claims *auth.Claims, user *models.User, password, msg string) bool {
+	var (
+		err      error
+		verified bool
+		attempts *models.LoginAttempts
+	)
+
+	if !user.HasPassword {
+		if !claims.AuthenticatedWithin(s.conf.Auth.RecentLogin) {
+			api.Error(c, http.StatusForbidden, errRecentLogin)
+			return false
+		}
+		return true
+	}
+
+	if password == "" {
+		api.Error(c, http.StatusBadRequest, api.ErrMissingField)
+		return false
+	}
+
+	ctx := c.Request.Context()
+	if attempts, err = models.GetLoginAttempts(ctx, models.UserLoginKey(user.ID)); err != nil {
+		log.Error().Err(err).Msg("could not fetch user login attempts from database")
+		api.Error(c, http.StatusInternalServerError, msg)
+		return false
+	}
+
+	if !s.throttle.Allowed(attempts) {
+		s.audit(c, models.AuditLoginThrottled, user.ID, user.ID, user.Email)
+		api.Error(c, http.StatusForbidden, errInvalidPassword)
+		return false
+	}
+
+	if verified, _, err = auth.VerifyDerivedKey(user.Password, password); err != nil {
+		log.Error().Err(err).Msg("could not verify derived key")
+		api.Error(c, http.StatusInternalServerError, msg)
+		return false
+	}
+
+	if !verified {
+		s.loginFailed(c, user, user.Email, attempts)
+		api.Error(c, http.StatusForbidden, errInvalidPassword)
+		return false
+	}
+
+	if attempts.Failures > 0 {
+		if err = models.ResetLoginAttempts(ctx, attempts.Key); err != nil {
+			log.Warn().Err(err).Msg("could not reset user login attempts")
+		}
+	}
+	return true
+}
+
+// sendEmailVerification creates a pending email change for the user and sends the
+// verification token to the new email address. Only the hash of the token is stored.
+func (s *Server) sendEmailVerification(c *gin.Context, user *models.User, email string) (err error) {
+	var (
+		token string
+		link  *url.URL
+	)
+
+	buf := make([]byte, emailTokenLen)
+	if _, err = rand.Read(buf); err != nil {
+		return err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+
+	verification := &models.EmailVerification{
+		UserID:  user.ID,
+		Email:   email,
+		Token:   hashEmailToken(token),
+		Expires: time.Now().Add(s.conf.Auth.VerifyEmailTTL),
+	}
+
+	if err = models.CreateEmailVerification(c.Request.Context(), verification); err != nil {
+		return err
+	}
+
+	if link, err = url.Parse(s.conf.Mail.VerifyEmailURL); err != nil {
+		return err
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	msg := &mail.Message{
+		To:      email,
+		Subject: "Verify your Cosmos email address",
+		Body:    fmt.Sprintf("Follow the link below to verify your new email address. The link expires at %s.\n\n%s\n", verification.Expires.Format(time.RFC1123), link.String()),
+	}
+	return s.mailer.Send(c.Request.Context(), msg)
+}
+
+func hashEmailToken(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
+
+func (s *Server) profileReply(c *gin.Context, user *models.User) *api.Profile {
+	ctx := c.Request.Context()
+	out := &api.Profile{
+		ID:          user.ID,
+		Name:        user.Name.String,
+		Email:       user.Email,
+		HasPassword: user.HasPassword,
+		Permissions: make([]string, 0),
+		Created:     user.Created.Format(time.RFC3339),
+	}
+
+	if role, err := user.Role(ctx); err == nil {
+		out.Role = role.Title
+	}
+
+	if perms, err := user.Permissions(ctx); err == nil {
+		for _, perm := range perms {
+			out.Permissions = append(out.Permissions, perm.Title)
+		}
+	}
+
+	if totp, err := models.GetTOTP(ctx, user.ID); err == nil {
+		out.MFAEnrolled = totp.Verified
+	}
+
+	if verification, err := models.GetEmailVerification(ctx, user.ID); err == nil {
+		out.PendingEmail = verification.Email
+	}
+
+	if user.LastLogin.Valid {
+		out.LastLogin = user.LastLogin.Time.Format(time.RFC3339)
+	}
+	return out
+}
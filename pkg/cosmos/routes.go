@@ -1,10 +1,15 @@
 package cosmos
 
 import (
+	"net/http"
 	"time"
 
+	"github.com/bbengfort/cosmos/pkg/api/v1"
 	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/bbengfort/cosmos/pkg/idempotency"
 	"github.com/bbengfort/cosmos/pkg/logger"
+	"github.com/bbengfort/cosmos/pkg/ratelimit"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -13,8 +18,9 @@ import (
 func (s *Server) setupRoutes() (err error) {
 	// Setup CORS configuration
 	corsConf := cors.Config{
-		AllowMethods:     []string{"GET", "HEAD"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-CSRF-TOKEN"},
+		AllowMethods:     []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-CSRF-TOKEN", api.RequestIDHeader, idempotency.HeaderKey, HeaderIfMatch, HeaderIfNoneMatch},
+		ExposeHeaders:    []string{api.RequestIDHeader, HeaderETag, ratelimit.HeaderLimit, ratelimit.HeaderRemaining, ratelimit.HeaderReset, ratelimit.HeaderPolicy, ratelimit.HeaderRetryAfter, idempotency.HeaderReplayed, HeaderDeprecation, HeaderSunset, HeaderLink},
 		AllowOrigins:     s.conf.AllowOrigins,
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
@@ -27,8 +33,8 @@ func (s *Server) setupRoutes() (err error) {
 		// NOTE: logging panics will not recover
 		logger.GinLogger("cosmos"),
 
-		// Panic recovery middleware
-		gin.Recovery(),
+		// Panic recovery middleware returns the JSON error reply of the API
+		gin.CustomRecovery(api.Recovery),
 
 		// CORS configuration allows the front-end to make cross-origin requests
 		cors.New(corsConf),
@@ -44,8 +50,19 @@ func (s *Server) setupRoutes() (err error) {
 		}
 	}
 
-	// Create authentication middleware to add to specific routes
-	authenticate := auth.Authenticate(s.auth)
+	// Create authentication middleware and the rate limits of the route groups, which
+	// are shared by every api version. Authenticated routes are limited per user so the
+	// limits must be added after the authentication middleware; the client limit must be
+	// added before it so that requests with invalid credentials are limited.
+	s.middleware = &middleware{
+		authenticate: auth.Authenticate(s.auth, s),
+		limitClient:  s.limitClient("client", s.conf.RateLimit.Client),
+		limitAuth:    s.limit("auth", s.conf.RateLimit.Auth),
+		limitGalaxy:  s.limit("galaxy", s.conf.RateLimit.Galaxy),
+		limitGraphQL: s.limit("graphql", s.conf.RateLimit.GraphQL),
+		limitDefault: s.limit("default", s.conf.RateLimit.Default),
+		idempotent:   idempotency.Idempotent(s.idempotency, s.conf.Idempotency.TTL, s.conf.Idempotency.Lease),
+	}
 
 	// Kubernetes liveness probes
 	s.router.GET("/healthz", s.Healthz)
@@ -56,25 +73,147 @@ func (s *Server) setupRoutes() (err error) {
 	s.router.NoRoute(s.NotFound)
 	s.router.NoMethod(s.NotAllowed)
 
-	// Add the v1 API routes
-	v1 := s.router.Group("/v1")
-	{
-		// Heartbeat route
-		v1.GET("/status", s.Status)
-
-		// Authentication routes
-		v1.POST("/register", s.Register)
-		v1.POST("/login", s.Login)
-		v1.POST("/logout", s.Logout)
-		v1.POST("/reauthenticate", s.Reauthenticate)
-
-		// Galaxy resource
-		galaxy := v1.Group("/galaxy", authenticate)
-		{
-			galaxy.GET("/", s.ListGalaxies, auth.Authorize("games:read"))
-			galaxy.POST("/", s.CreateGalaxy, auth.Authorize("games:create"))
-		}
+	// Add the routes of every api version under its own path prefix
+	s.apis = make(map[string]*apiVersion)
+	if err = s.register(&apiVersion{name: "v1", routes: s.setupV1Routes, describe: v1Routes}); err != nil {
+		return err
 	}
 
 	return nil
 }
+
+// limit returns the rate limit middleware of the route group, which does nothing if
+// rate limiting is disabled.
+func (s *Server) limit(group string, limit config.RateLimit) gin.HandlerFunc {
+	if s.limiter == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return ratelimit.Limit(s.limiter, group, limit)
+}
+
+// limitClient returns the rate limit middleware of the route group by client IP address,
+// which does nothing if rate limiting is disabled.
+func (s *Server) limitClient(group string, limit config.RateLimit) gin.HandlerFunc {
+	if s.limiter == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return ratelimit.LimitClient(s.limiter, group, limit)
+}
+
+// Setup the routes of the v1 api.
+func (s *Server) setupV1Routes(v1 *routeGroup, mw *middleware) {
+	// Heartbeat route
+	v1.GET("/status", mw.limitDefault, s.Status)
+	v1.GET("/openapi.json", mw.limitDefault, s.OpenAPI)
+
+	// Authentication routes
+	v1.POST("/register", mw.limitAuth, s.Register)
+	v1.POST("/login", mw.limitAuth, s.Login)
+	v1.POST("/login/mfa", mw.limitAuth, s.LoginMFA)
+	v1.POST("/logout", mw.limitAuth, auth.CSRF(), s.Logout)
+	v1.POST("/reauthenticate", mw.limitAuth, auth.CSRF(), s.Reauthenticate)
+	v1.POST("/authenticate", mw.limitAuth, s.APIKeyLogin)
+
+	// Token introspection and revocation for companion services
+	tokens := v1.group("/auth", requires("tokens:introspect"), mw.limitClient, mw.authenticate, auth.RequireAPIKey())
+	tokens.Use(mw.limitDefault)
+	{
+		tokens.POST("/introspect", s.Introspect)
+		tokens.POST("/revoke", s.Revoke)
+	}
+
+	// Sign in with external identity providers
+	idp := v1.Group("/oidc", mw.limitAuth)
+	{
+		idp.GET("", s.IdentityProviders)
+		idp.GET("/:provider/login", s.OIDCLogin)
+		idp.GET("/:provider/callback", s.OIDCCallback)
+		idp.POST("/:provider/link", mw.authenticate, auth.DenyImpersonation(), s.OIDCLink)
+	}
+
+	// Profile of the authenticated user
+	me := v1.Group("/me", auth.AllowMFAEnrollment(), mw.limitClient, mw.authenticate, mw.limitDefault)
+	{
+		me.GET("", s.Profile)
+		me.PATCH("", s.UpdateProfile)
+		me.DELETE("", s.DeleteAccount)
+		me.PUT("/password", s.ChangePassword)
+		me.POST("/email/verify", s.VerifyEmail)
+	}
+
+	// Galaxy resource
+	galaxy := v1.Group("/galaxy", mw.limitClient, mw.authenticate, mw.limitGalaxy)
+	{
+		galaxy.route(http.MethodGet, "/", requires("games:read"), s.ListGalaxies)
+		galaxy.route(http.MethodPost, "/", requires("games:create"), mw.idempotent, s.CreateGalaxy)
+		galaxy.route(http.MethodGet, "/:id", requiresGalaxy("galaxy:observe"), s.GetGalaxy)
+		galaxy.route(http.MethodPatch, "/:id", requiresGalaxy("galaxy:admin"), s.UpdateGalaxy)
+		galaxy.route(http.MethodDelete, "/:id", requiresGalaxy("galaxy:admin"), s.DeleteGalaxy)
+		galaxy.route(http.MethodGet, "/:id/players", requiresGalaxy("galaxy:observe"), s.ListPlayers)
+		galaxy.route(http.MethodGet, "/:id/events", requiresGalaxy("galaxy:observe"), s.GalaxyEvents)
+		galaxy.route(http.MethodPut, "/:id/players/:player/role", requiresGalaxy("galaxy:admin"), s.SetPlayerRole)
+		galaxy.route(http.MethodDelete, "/:id/players/:player", requiresGalaxy("galaxy:admin"), s.RemovePlayer)
+	}
+
+	// Nested queries of galaxies that are authorized field by field
+	v1.POST("/graphql", mw.limitClient, mw.authenticate, mw.limitGraphQL, s.GraphQL)
+
+	// Multi-factor authentication enrollment
+	mfa := v1.Group("/mfa", auth.AllowMFAEnrollment(), mw.limitClient, mw.authenticate, mw.limitDefault, auth.DenyImpersonation())
+	{
+		mfa.GET("", s.MFAStatus)
+		mfa.POST("/totp", s.EnrollTOTP)
+		mfa.POST("/totp/verify", s.VerifyTOTP)
+		mfa.DELETE("/totp", auth.RequireMFA(), s.RemoveTOTP)
+	}
+
+	// API keys for bots and service accounts
+	apikeys := v1.Group("/apikeys", mw.limitClient, mw.authenticate, mw.limitDefault, auth.DenyImpersonation())
+	{
+		apikeys.GET("", s.ListAPIKeys)
+		apikeys.POST("", s.CreateAPIKey)
+		apikeys.DELETE("/:id", s.DeleteAPIKey)
+	}
+
+	// Webhooks that deliver galaxy events to bots and other services
+	hooks := v1.Group("/webhooks", mw.limitClient, mw.authenticate, mw.limitDefault, auth.DenyImpersonation())
+	{
+		hooks.GET("", s.ListWebhooks)
+		hooks.POST("", s.CreateWebhook)
+		hooks.DELETE("/:id", s.DeleteWebhook)
+		hooks.GET("/:id/deliveries", s.WebhookDeliveries)
+	}
+
+	// User, role, and permission administration
+	admin := v1.group("/admin", requires("users:manage"), mw.limitClient, mw.authenticate, mw.limitDefault, auth.DenyImpersonation())
+	admin.Use(auth.RequireMFA())
+	{
+		admin.GET("/users", s.ListUsers)
+		admin.GET("/users/:id", s.GetUser)
+		admin.PUT("/users/:id/role", s.SetUserRole)
+		admin.POST("/users/:id/impersonate", s.ImpersonateUser)
+		admin.POST("/users/:id/disable", s.DisableUser)
+		admin.POST("/users/:id/enable", s.EnableUser)
+		admin.POST("/users/:id/unlock", s.UnlockUser)
+		admin.GET("/roles", s.ListRoles)
+		admin.POST("/roles", mw.idempotent, s.CreateRole)
+		admin.PUT("/roles/:id/permissions", s.SetRolePermissions)
+		admin.DELETE("/roles/:id", s.DeleteRole)
+		admin.GET("/permissions", s.ListPermissions)
+	}
+}
+
+// middleware is the authentication, rate limit, and idempotency middleware of the route
+// groups that is shared by every api version. Idempotency must be added after the
+// authorization middleware of a route so that unauthorized responses are not replayed.
+// Authorization middleware is built by the route groups so that its permissions are
+// recorded.
+type middleware struct {
+	authenticate gin.HandlerFunc
+	limitClient  gin.HandlerFunc
+	limitAuth    gin.HandlerFunc
+	limitGalaxy  gin.HandlerFunc
+	limitGraphQL gin.HandlerFunc
+	limitDefault gin.HandlerFunc
+	idempotent   gin.HandlerFunc
+}
@@ -0,0 +1,299 @@
+package cosmos
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/bbengfort/cosmos/pkg/pagination"
+	"github.com/bbengfort/cosmos/pkg/rpc"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Authorization policies of the grpc api, which match the routes of the v1 api. Calls
+// to galaxies are authorized with the galaxy role of the player by the handler since
+// the galaxy is identified by the request rather than the route.
+var rpcPolicies = map[string]rpc.Policy{
+	rpc.AuthenticateMethod: {Public: true},
+	rpc.ListGalaxiesMethod: {Permissions: []string{"games:read"}},
+	rpc.GetGalaxyMethod:    {},
+	rpc.ListPlayersMethod:  {},
+	rpc.StreamEventsMethod: {},
+}
+
+// newRPC creates the grpc server of the grpc api, which shares the claims issuer and
+// the handlers of the v1 api.
+func (s *Server) newRPC() *grpc.Server {
+	authenticator := rpc.NewAuthenticator(s.auth, rpcPolicies)
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(rpc.UnaryLogger("cosmos"), s.availableUnary(), authenticator.Unary()),
+		grpc.ChainStreamInterceptor(rpc.StreamLogger("cosmos"), s.availableStream(), authenticator.Stream()),
+	)
+
+	svc := &rpcService{s: s}
+	rpc.RegisterAuthServer(srv, svc)
+	rpc.RegisterGalaxiesServer(srv, svc)
+	return srv
+}
+
+// stopRPC gracefully stops the grpc server, waiting for active calls to complete until
+// the context is done and then closing them.
+func (s *Server) stopRPC(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.rpc.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.rpc.Stop()
+	}
+}
+
+// availableUnary rejects calls when the server is unavailable like Available.
+func (s *Server) availableUnary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := s.unavailable(); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// availableStream rejects streams when the server is unavailable like Available.
+func (s *Server) availableStream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := s.unavailable(); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+func (s *Server) unavailable() error {
+	s.RLock()
+	healthy := s.healthy
+	ready := s.ready
+	s.RUnlock()
+
+	switch {
+	case !healthy:
+		return status.Error(codes.Unavailable, "server is "+serverStatusUnhealthy)
+	case !ready:
+		return status.Error(codes.Unavailable, "server is "+serverStatusNotReady)
+	case s.conf.Maintenance:
+		return status.Error(codes.Unavailable, api.ErrMaintenance.Error())
+	}
+	return nil
+}
+
+// rpcService implements the services of the grpc api; it is separate from the Server
+// since the method names of the services are the same as the v1 handlers.
+type rpcService struct {
+	rpc.UnimplementedAuthServer
+	rpc.UnimplementedGalaxiesServer
+	s *Server
+}
+
+// Authenticate exchanges API key credentials for an access token like APIKeyLogin.
+func (r *rpcService) Authenticate(ctx context.Context, req *rpc.APIKeyLoginRequest) (_ *rpc.LoginReply, err error) {
+	in := req.APIKeyLogin()
+	if err = in.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var out *api.LoginReply
+	if out, err = r.s.authenticateKey(ctx, rpc.ClientIP(ctx), in); err != nil {
+		switch {
+		case errors.Is(err, errAuthenticationFailed):
+			return nil, status.Error(codes.PermissionDenied, "authentication failed")
+		case errors.Is(err, auth.ErrAccountDisabled):
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		default:
+			return nil, status.Error(codes.Internal, "authentication failed")
+		}
+	}
+	return rpc.NewLoginReply(out), nil
+}
+
+// ListGalaxies returns a page of the galaxies the user is playing in like ListGalaxies.
+func (r *rpcService) ListGalaxies(ctx context.Context, req *rpc.GalaxyQuery) (_ *rpc.GalaxyList, err error) {
+	var (
+		in       = req.Query()
+		out      *api.GalaxyList
+		userID   int64
+		query    *models.GalaxyQuery
+		galaxies []*models.Galaxy
+		next     *pagination.Cursor
+	)
+
+	if err = in.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if userID, err = rpcSubjectID(ctx); err != nil {
+		log.Warn().Err(err).Msg("could not parse user ID from claims")
+		return nil, status.Error(codes.Internal, "could not complete list galaxies request")
+	}
+
+	if query, err = galaxyQuery(userID, in); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if galaxies, next, err = models.QueryGalaxies(ctx, query); err != nil {
+		if errors.Is(err, pagination.ErrTokenMismatch) {
+			return nil, status.Error(codes.InvalidArgument, errGalaxyTokenMismatch.Error())
+		}
+
+		log.Error().Err(err).Msg("could not fetch galaxies from the database")
+		return nil, status.Error(codes.Internal, "could not complete list galaxies request")
+	}
+
+	if out, err = galaxyList(galaxies, next); err != nil {
+		log.Error().Err(err).Msg("could not create next page token")
+		return nil, status.Error(codes.Internal, "could not complete list galaxies request")
+	}
+	return rpc.NewGalaxyList(out), nil
+}
+
+// GetGalaxy returns the galaxy if the user is a player in the galaxy like GetGalaxy.
+func (r *rpcService) GetGalaxy(ctx context.Context, in *rpc.GalaxyRequest) (_ *rpc.Galaxy, err error) {
+	if _, err = r.authorizeGalaxy(ctx, in.GalaxyId, "galaxy:observe"); err != nil {
+		return nil, err
+	}
+
+	var galaxy *models.Galaxy
+	if galaxy, err = models.GetGalaxy(ctx, in.GalaxyId); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, auth.ErrGalaxyNotFound.Error())
+		}
+
+		log.Error().Err(err).Msg("could not fetch galaxy from the database")
+		return nil, status.Error(codes.Internal, "could not get galaxy")
+	}
+	return rpc.NewGalaxy(galaxyReply(galaxy)), nil
+}
+
+// ListPlayers returns the players in the galaxy and their galaxy roles like ListPlayers.
+func (r *rpcService) ListPlayers(ctx context.Context, in *rpc.GalaxyRequest) (_ *rpc.PlayerList, err error) {
+	if _, err = r.authorizeGalaxy(ctx, in.GalaxyId, "galaxy:observe"); err != nil {
+		return nil, err
+	}
+
+	var players []*models.Player
+	if players, err = models.ListPlayers(ctx, in.GalaxyId); err != nil {
+		log.Error().Err(err).Msg("could not list players from the database")
+		return nil, status.Error(codes.Internal, "could not list players")
+	}
+
+	out := &api.PlayerList{Players: make([]*api.Player, 0, len(players))}
+	for _, player := range players {
+		out.Players = append(out.Players, playerReply(ctx, player))
+	}
+	return rpc.NewPlayerList(out), nil
+}
+
+// StreamEvents streams the events of the galaxy that are visible to the user like
+// GalaxyEvents. The stream ends without an error when the access token expires, when
+// the player leaves the galaxy, or when the server shuts down; the client should
+// resume the stream with the ID of the last event it received.
+func (r *rpcService) StreamEvents(in *rpc.StreamEventsRequest, stream rpc.Galaxies_StreamEventsServer) (err error) {
+	var (
+		userID int64
+		lastID int64
+		claims *auth.Claims
+	)
+
+	ctx := stream.Context()
+	if claims, err = r.authorizeGalaxy(ctx, in.GalaxyId, "galaxy:observe"); err != nil {
+		return err
+	}
+
+	if userID, err = claims.SubjectID(); err != nil {
+		log.Warn().Err(err).Msg("could not identify user to stream galaxy events")
+		return status.Error(codes.Internal, "could not stream galaxy events")
+	}
+
+	if in.LastEventId != nil && in.GetLastEventId() < 0 {
+		return status.Error(codes.InvalidArgument, "last_event_id must be the id of an event")
+	}
+
+	// Subscribe before reading the latest event so that no events are missed
+	sub := r.s.events.Subscribe(in.GalaxyId)
+	defer sub.Close()
+
+	if in.LastEventId != nil {
+		lastID = in.GetLastEventId()
+	} else if lastID, err = models.LatestGalaxyEventID(ctx, in.GalaxyId); err != nil {
+		log.Error().Err(err).Msg("could not get latest galaxy event")
+		return status.Error(codes.Internal, "could not stream galaxy events")
+	}
+
+	// Streams can only resume after an event of the galaxy
+	if in.LastEventId != nil && lastID != 0 {
+		var exists bool
+		if exists, err = models.GalaxyEventExists(ctx, in.GalaxyId, lastID); err != nil {
+			log.Error().Err(err).Msg("could not check last galaxy event")
+			return status.Error(codes.Internal, "could not stream galaxy events")
+		}
+
+		if !exists {
+			return status.Error(codes.InvalidArgument, "last_event_id must be the id of an event of the galaxy")
+		}
+	}
+
+	// Idle streams are kept alive by http/2 pings so no ping events are sent
+	send := func(event *api.GalaxyEvent) error { return stream.Send(rpc.NewGalaxyEvent(event)) }
+	ping := func() error { return nil }
+	if err = followGalaxyEvents(ctx, sub, claims, in.GalaxyId, userID, lastID, send, ping); err != nil {
+		if errors.Is(err, errLeftGalaxy) || ctx.Err() != nil {
+			return nil
+		}
+
+		log.Warn().Err(err).Int64("galaxy_id", in.GalaxyId).Msg("could not stream galaxy events")
+		return status.Error(codes.Internal, "could not stream galaxy events")
+	}
+	return nil
+}
+
+// authorizeGalaxy authorizes the user to access the galaxy like AuthorizeGalaxy,
+// returning the claims of the call or a status error.
+func (r *rpcService) authorizeGalaxy(ctx context.Context, galaxyID int64, permissions ...string) (claims *auth.Claims, err error) {
+	var ok bool
+	if claims, ok = rpc.ClaimsFromContext(ctx); !ok {
+		log.Warn().Msg("no claims in call")
+		return nil, status.Error(codes.Unauthenticated, auth.ErrNotAuthorized.Error())
+	}
+
+	if _, err = auth.AuthorizePlayer(ctx, r.s.auth.Versions(), claims, galaxyID, permissions...); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrNotAuthorized):
+			return nil, status.Error(codes.PermissionDenied, auth.ErrNotAuthorized.Error())
+		case errors.Is(err, auth.ErrGalaxyNotFound):
+			return nil, status.Error(codes.NotFound, auth.ErrGalaxyNotFound.Error())
+		case errors.Is(err, auth.ErrStalePermissions):
+			return nil, status.Error(codes.Unauthenticated, auth.ErrStalePermissions.Error())
+		default:
+			log.Error().Err(err).Msg("could not authorize galaxy call")
+			return nil, status.Error(codes.Internal, "could not authorize call")
+		}
+	}
+	return claims, nil
+}
+
+// rpcSubjectID returns the ID of the user from the claims of the call.
+func rpcSubjectID(ctx context.Context) (_ int64, err error) {
+	claims, ok := rpc.ClaimsFromContext(ctx)
+	if !ok {
+		return 0, auth.ErrNoClaims
+	}
+	return claims.SubjectID()
+}
@@ -17,20 +17,33 @@ const (
 )
 
 // Status is an unauthenticated endpoint that returns the status of the api server and
-// can be used for heartbeats and liveness checks. This status method is the global
-// status method, meaning it returns the latest version of the whipser service, no
-// matter how many API versions are available.
+// can be used for heartbeats and liveness checks. The status reports the api version
+// that handled the request so that clients of deprecated versions are notified of the
+// deprecation and sunset of the version as well as by the deprecation headers.
 func (s *Server) Status(c *gin.Context) {
-	c.JSON(http.StatusOK, v1.StatusReply{
+	out := v1.StatusReply{
 		Status:  serverStatusOK,
 		Uptime:  time.Since(s.started).String(),
 		Version: pkg.Version(),
-	})
+	}
+
+	if v, ok := getAPIVersion(c); ok {
+		out.APIVersion = v.name
+		if !v.deprecated.IsZero() {
+			out.Deprecated = v.deprecated.UTC().Format(time.RFC3339)
+		}
+		if !v.sunset.IsZero() {
+			out.Sunset = v.sunset.UTC().Format(time.RFC3339)
+		}
+	}
+
+	c.JSON(http.StatusOK, out)
 }
 
 // Available is middleware that uses the healthy boolean to return a service unavailable
 // http status code if the server is shutting down. It does this before all routes to
-// ensure that complex handling doesn't bog down the server.
+// ensure that complex handling doesn't bog down the server. The error code of the reply
+// distinguishes maintenance mode from an unhealthy or unready server.
 func (s *Server) Available() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Check the health and ready status of the server
@@ -39,24 +52,16 @@ func (s *Server) Available() gin.HandlerFunc {
 		ready := s.ready
 		s.RUnlock()
 
-		if s.conf.Maintenance || !healthy || !ready {
-			out := v1.StatusReply{
-				Uptime:  time.Since(s.started).String(),
-				Version: pkg.Version(),
-			}
-
-			switch {
-			case !healthy:
-				out.Status = serverStatusUnhealthy
-			case !ready:
-				out.Status = serverStatusNotReady
-			default:
-				out.Status = serverStatusMaintenance
-			}
-
-			// Write the 503 response and stop processing the request
-			c.JSON(http.StatusServiceUnavailable, out)
-			c.Abort()
+		// Write the 503 response and stop processing the request
+		switch {
+		case !healthy:
+			v1.Error(c, http.StatusServiceUnavailable, "server is "+serverStatusUnhealthy)
+			return
+		case !ready:
+			v1.Error(c, http.StatusServiceUnavailable, "server is "+serverStatusNotReady)
+			return
+		case s.conf.Maintenance:
+			v1.Error(c, http.StatusServiceUnavailable, v1.ErrMaintenance)
 			return
 		}
 
@@ -92,10 +97,12 @@ func (s *Server) Readyz(c *gin.Context) {
 	c.Data(http.StatusOK, "text/plain", []byte(serverStatusOK))
 }
 
+// NotFound returns a JSON error reply for routes that do not exist.
 func (s *Server) NotFound(c *gin.Context) {
-	c.String(http.StatusNotFound, http.StatusText(http.StatusNotFound))
+	v1.NotFound(c)
 }
 
+// NotAllowed returns a JSON error reply for methods that routes do not handle.
 func (s *Server) NotAllowed(c *gin.Context) {
-	c.String(http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+	v1.NotAllowed(c)
 }
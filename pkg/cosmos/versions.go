@@ -0,0 +1,212 @@
+package cosmos
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/openapi"
+	"github.com/gin-gonic/gin"
+)
+
+// Headers of the responses of deprecated api versions. Deprecation (RFC 9745) is the
+// unix time the version was deprecated, Sunset (RFC 8594) is the date the version will
+// be removed, and Link refers clients to the OpenAPI document of the successor version.
+const (
+	HeaderDeprecation = "Deprecation"
+	HeaderSunset      = "Sunset"
+	HeaderLink        = "Link"
+)
+
+// The api version that handles a request is stored in the gin context under this key.
+const contextAPIVersion = "api_version"
+
+var versionName = regexp.MustCompile(`^v[1-9][0-9]*$`)
+
+// apiVersion is a version of the API that is served under its own path prefix so that
+// new versions can change routes and types without breaking the clients of old
+// versions. Each version adds its own routes and describes its routes and types for its
+// OpenAPI document. Old versions are deprecated rather than removed so that clients
+// have until the sunset to migrate to the successor version.
+type apiVersion struct {
+	name        string                         // the path prefix of the version, e.g. v1
+	routes      func(*routeGroup, *middleware) // adds the handlers of the version to its route group
+	describe    []openapi.Route                // describes the routes and types of the version
+	deprecated  time.Time                      // when the version was deprecated, zero if it is current
+	sunset      time.Time                      // when the version will be removed, zero if not scheduled
+	successor   string                         // the version clients of a deprecated version should use
+	openapi     *openapi.Document              // generated from the descriptions when registered
+	permissions map[string][]string            // permissions checked by the middleware of each route
+}
+
+// register adds the routes of the version to the router under its path prefix and
+// generates its OpenAPI document. Versions must be registered before the server starts
+// handling requests since the router cannot be modified concurrently.
+func (s *Server) register(v *apiVersion) (err error) {
+	if !versionName.MatchString(v.name) {
+		return fmt.Errorf("invalid api version %q: must be v followed by the major version", v.name)
+	}
+
+	if _, ok := s.apis[v.name]; ok {
+		return fmt.Errorf("api version %s has already been registered", v.name)
+	}
+
+	if !v.sunset.IsZero() && v.deprecated.IsZero() {
+		return fmt.Errorf("api version %s must be deprecated before its sunset", v.name)
+	}
+
+	if v.openapi, err = NewOpenAPI(v); err != nil {
+		return err
+	}
+
+	v.permissions = make(map[string][]string)
+	group := &routeGroup{RouterGroup: s.router.Group("/"+v.name, v.headers), routes: v.permissions}
+	v.routes(group, s.middleware)
+
+	s.apis[v.name] = v
+	return nil
+}
+
+// headers is middleware that stores the version in the context for the handlers that
+// are shared by versions and adds the deprecation headers if the version is deprecated.
+func (v *apiVersion) headers(c *gin.Context) {
+	c.Set(contextAPIVersion, v)
+
+	if !v.deprecated.IsZero() {
+		c.Header(HeaderDeprecation, "@"+strconv.FormatInt(v.deprecated.Unix(), 10))
+		if !v.sunset.IsZero() {
+			c.Header(HeaderSunset, v.sunset.UTC().Format(http.TimeFormat))
+		}
+		if v.successor != "" {
+			c.Header(HeaderLink, `</`+v.successor+`/openapi.json>; rel="successor-version"`)
+		}
+	}
+
+	c.Next()
+}
+
+// getAPIVersion returns the api version of the route that is handling the request.
+func getAPIVersion(c *gin.Context) (*apiVersion, bool) {
+	if val, exists := c.Get(contextAPIVersion); exists {
+		v, ok := val.(*apiVersion)
+		return v, ok
+	}
+	return nil, false
+}
+
+// routeGroup registers the routes of an api version and records the permissions that
+// are checked by the authorization middleware of each route and of its groups, so that
+// the OpenAPI document can be checked against the permissions that are enforced. The
+// authorization middleware is built by the group from the permissions that the route
+// or group is registered with so that the recorded permissions are always the ones
+// that are checked.
+type routeGroup struct {
+	*gin.RouterGroup
+	permissions []string            // permissions checked by the middleware of the group
+	routes      map[string][]string // permissions of each route by method and path
+}
+
+// authorization describes the permissions that are required by a route or group.
+// Galaxy permissions are checked by AuthorizeGalaxy for the galaxy of the route and
+// other permissions are checked by Authorize.
+type authorization struct {
+	permissions []string
+	galaxy      bool
+}
+
+// requires returns the authorization of routes that require the permissions.
+func requires(permissions ...string) authorization {
+	return authorization{permissions: permissions}
+}
+
+// requiresGalaxy returns the authorization of galaxy routes that require the galaxy
+// permissions.
+func requiresGalaxy(permissions ...string) authorization {
+	return authorization{permissions: permissions, galaxy: true}
+}
+
+// middleware returns the authorization middleware that checks the permissions or nil
+// if no permissions are required.
+func (a authorization) middleware() gin.HandlerFunc {
+	switch {
+	case len(a.permissions) == 0:
+		return nil
+	case a.galaxy:
+		return auth.AuthorizeGalaxy(a.permissions...)
+	default:
+		return auth.Authorize(a.permissions...)
+	}
+}
+
+// group creates a route group whose routes require the permissions of the authorization
+// in addition to the permissions of this group. The authorization middleware is added
+// after the handlers of the group so that requests are authenticated first.
+func (g *routeGroup) group(relativePath string, authz authorization, handlers ...gin.HandlerFunc) *routeGroup {
+	if authorize := authz.middleware(); authorize != nil {
+		handlers = append(handlers, authorize)
+	}
+
+	var permissions []string
+	permissions = append(permissions, g.permissions...)
+	return &routeGroup{
+		RouterGroup: g.RouterGroup.Group(relativePath, handlers...),
+		permissions: append(permissions, authz.permissions...),
+		routes:      g.routes,
+	}
+}
+
+// route adds the handlers of the route to the group and records the permissions of the
+// route. The authorization middleware is added before the handlers of the route so that
+// idempotent responses are only stored for authorized requests.
+func (g *routeGroup) route(method, relativePath string, authz authorization, handlers ...gin.HandlerFunc) {
+	if authorize := authz.middleware(); authorize != nil {
+		handlers = append([]gin.HandlerFunc{authorize}, handlers...)
+	}
+
+	var permissions []string
+	permissions = append(permissions, g.permissions...)
+	g.routes[method+" "+joinPaths(g.BasePath(), relativePath)] = append(permissions, authz.permissions...)
+	g.RouterGroup.Handle(method, relativePath, handlers...)
+}
+
+func (g *routeGroup) Group(relativePath string, handlers ...gin.HandlerFunc) *routeGroup {
+	return g.group(relativePath, authorization{}, handlers...)
+}
+
+func (g *routeGroup) GET(relativePath string, handlers ...gin.HandlerFunc) {
+	g.route(http.MethodGet, relativePath, authorization{}, handlers...)
+}
+
+func (g *routeGroup) POST(relativePath string, handlers ...gin.HandlerFunc) {
+	g.route(http.MethodPost, relativePath, authorization{}, handlers...)
+}
+
+func (g *routeGroup) PUT(relativePath string, handlers ...gin.HandlerFunc) {
+	g.route(http.MethodPut, relativePath, authorization{}, handlers...)
+}
+
+func (g *routeGroup) PATCH(relativePath string, handlers ...gin.HandlerFunc) {
+	g.route(http.MethodPatch, relativePath, authorization{}, handlers...)
+}
+
+func (g *routeGroup) DELETE(relativePath string, handlers ...gin.HandlerFunc) {
+	g.route(http.MethodDelete, relativePath, authorization{}, handlers...)
+}
+
+// joinPaths joins the relative path of a route to the base path of its group like gin.
+func joinPaths(base, relative string) string {
+	if relative == "" {
+		return base
+	}
+
+	joined := path.Join(base, relative)
+	if strings.HasSuffix(relative, "/") && !strings.HasSuffix(joined, "/") {
+		return joined + "/"
+	}
+	return joined
+}
@@ -0,0 +1,233 @@
+package cosmos
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bbengfort/cosmos/internal/dbtest"
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/bbengfort/cosmos/pkg/openapi"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// v1 requests whose response shapes must not change when other versions are added.
+// Authenticated requests are made by a moderator and expect the database queries of
+// their successful replies.
+var v1Requests = []struct {
+	method        string
+	path          string
+	authenticated bool
+	expect        func(sqlmock.Sqlmock)
+}{
+	{http.MethodGet, "/v1/status", false, nil},
+	{http.MethodGet, "/v1/galaxy/", false, nil},
+	{http.MethodGet, "/v1/galaxy/", true, expectListGalaxies},
+	{http.MethodGet, "/v1/galaxy/7", true, expectGetGalaxy},
+	{http.MethodPost, "/v1/login", false, nil},
+	{http.MethodGet, "/v1/notfound", false, nil},
+}
+
+var galaxyColumns = []string{"id", "name", "turn", "size", "max_players", "max_turns", "join_code", "game_state", "created", "modified"}
+
+func galaxyRows() *sqlmock.Rows {
+	created := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	return sqlmock.NewRows(galaxyColumns).AddRow(7, "Andromeda", 3, []byte("small"), 4, 100, "ABCD1234", []byte("playing"), created, created)
+}
+
+func expectListGalaxies(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM galaxies g JOIN players p").WillReturnRows(galaxyRows())
+	mock.ExpectCommit()
+}
+
+// expectGetGalaxy expects the moderator to be authorized without being a player.
+func expectGetGalaxy(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM players").WithArgs(7, 42).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM galaxies WHERE id=").WithArgs(7).WillReturnRows(galaxyRows())
+	mock.ExpectCommit()
+}
+
+// shape describes a response by its status code, the keys of its JSON body, and the
+// version headers so that the responses of clients of a version can be compared.
+type shape struct {
+	Code        int
+	Keys        []string
+	Deprecation string
+	Sunset      string
+	Link        string
+}
+
+func TestAPIVersions(t *testing.T) {
+	t.Setenv("COSMOS_MODE", "test")
+	t.Setenv("COSMOS_DATABASE_TESTING", "true")
+	t.Setenv("COSMOS_RATELIMIT_ENABLED", "false")
+	conf, err := config.New()
+	require.NoError(t, err)
+
+	s, err := New(conf)
+	require.NoError(t, err)
+	s.SetStatus(true, true)
+
+	mock := dbtest.Connect(t)
+
+	claims := &auth.Claims{Role: "Admin", Permissions: []string{"games:read", auth.ManageGalaxies}, UserVersion: 1, RoleVersion: 1}
+	claims.SetSubjectID(42)
+	s.versions.Update(42, models.PermissionVersion{UserVersion: 1, Role: "Admin", RoleVersion: 1})
+	token, _, err := s.auth.CreateTokens(claims)
+	require.NoError(t, err)
+
+	// The v1 status reports the api version that handled the request
+	status := &api.StatusReply{}
+	require.Equal(t, http.StatusOK, do(t, s, http.MethodGet, "/v1/status", status).Code)
+	require.Equal(t, "v1", status.APIVersion)
+	require.Empty(t, status.Deprecated)
+	require.Empty(t, status.Sunset)
+
+	before := make([]shape, 0, len(v1Requests))
+	for _, req := range v1Requests {
+		before = append(before, responseShape(t, s, mock, req.method, req.path, req.authenticated, req.expect, token))
+	}
+	require.Equal(t, http.StatusUnauthorized, before[1].Code)
+	require.Equal(t, http.StatusOK, before[2].Code)
+	require.Equal(t, http.StatusOK, before[3].Code)
+	operations := s.apis["v1"].openapi.Operations()
+
+	// Add a v2 whose status and galaxy routes have different replies than v1
+	type statusV2 struct {
+		Healthy  bool     `json:"healthy"`
+		Versions []string `json:"versions"`
+	}
+
+	v2 := &apiVersion{
+		name: "v2",
+		routes: func(v2 *routeGroup, mw *middleware) {
+			v2.GET("/status", mw.limitDefault, func(c *gin.Context) {
+				c.JSON(http.StatusOK, statusV2{Healthy: true, Versions: []string{"v1", "v2"}})
+			})
+			v2.GET("/galaxy/", mw.authenticate, mw.limitGalaxy, func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{"items": []string{}})
+			})
+		},
+		describe: []openapi.Route{
+			{Method: http.MethodGet, Path: "/v2/status", Tag: "status", Summary: "Server health", Reply: statusV2{}},
+			{Method: http.MethodGet, Path: "/v2/galaxy/", Tag: "galaxy", Summary: "List galaxies", Security: authenticated},
+		},
+	}
+	require.NoError(t, s.register(v2))
+	require.Equal(t, []string{"GET /v2/galaxy/", "GET /v2/status"}, s.apis["v2"].openapi.Operations())
+
+	reply := &statusV2{}
+	require.Equal(t, http.StatusOK, do(t, s, http.MethodGet, "/v2/status", reply).Code)
+	require.Equal(t, &statusV2{Healthy: true, Versions: []string{"v1", "v2"}}, reply)
+
+	// The v1 responses and document are unchanged by the v2 handlers
+	for i, req := range v1Requests {
+		require.Equal(t, before[i], responseShape(t, s, mock, req.method, req.path, req.authenticated, req.expect, token), "the response shape of %s %s changed", req.method, req.path)
+	}
+	require.Equal(t, operations, s.apis["v1"].openapi.Operations())
+
+	// Deprecating v1 adds the deprecation headers without changing the response shapes
+	// other than the deprecation dates of the status
+	deprecated := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.apis["v1"].deprecated, s.apis["v1"].sunset, s.apis["v1"].successor = deprecated, sunset, "v2"
+
+	for i, req := range v1Requests {
+		expected := before[i]
+		if expected.Code != http.StatusNotFound {
+			expected.Deprecation = "@1767225600"
+			expected.Sunset = "Fri, 01 Jan 2027 00:00:00 GMT"
+			expected.Link = `</v2/openapi.json>; rel="successor-version"`
+		}
+		if req.path == "/v1/status" {
+			expected.Keys = append(expected.Keys, "deprecated", "sunset")
+			sort.Strings(expected.Keys)
+		}
+		require.Equal(t, expected, responseShape(t, s, mock, req.method, req.path, req.authenticated, req.expect, token), "the response of %s %s is not deprecated", req.method, req.path)
+	}
+
+	status = &api.StatusReply{}
+	do(t, s, http.MethodGet, "/v1/status", status)
+	require.Equal(t, "2026-01-01T00:00:00Z", status.Deprecated)
+	require.Equal(t, "2027-01-01T00:00:00Z", status.Sunset)
+
+	// The current version is not deprecated
+	w := do(t, s, http.MethodGet, "/v2/status", nil)
+	require.Empty(t, w.Header().Get(HeaderDeprecation))
+	require.Empty(t, w.Header().Get(HeaderSunset))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRegisterAPIVersion(t *testing.T) {
+	t.Setenv("COSMOS_MODE", "test")
+	t.Setenv("COSMOS_DATABASE_TESTING", "true")
+	conf, err := config.New()
+	require.NoError(t, err)
+
+	s, err := New(conf)
+	require.NoError(t, err)
+
+	routes := func(*routeGroup, *middleware) {}
+	require.Error(t, s.register(&apiVersion{name: "v1", routes: routes}), "versions cannot be registered twice")
+	require.Error(t, s.register(&apiVersion{name: "2", routes: routes}), "versions must be prefixed by v")
+	require.Error(t, s.register(&apiVersion{name: "v0", routes: routes}), "versions start at v1")
+	require.Error(t, s.register(&apiVersion{name: "v2", routes: routes, sunset: time.Now()}), "versions must be deprecated before their sunset")
+	require.Error(t, s.register(&apiVersion{name: "v2", routes: routes, describe: []openapi.Route{{Method: http.MethodGet, Path: "/v1/status"}}}), "versions cannot describe the routes of other versions")
+	require.NotContains(t, s.apis, "v2")
+}
+
+// do makes the request to the router and decodes the JSON reply if one is specified.
+func do(t *testing.T, s *Server, method, path string, reply interface{}) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	if reply != nil {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), reply))
+	}
+	return w
+}
+
+// responseShape returns the shape of the response to the request, which is made with
+// the access token if it is authenticated after setting the expected database queries.
+func responseShape(t *testing.T, s *Server, mock sqlmock.Sqlmock, method, path string, authenticated bool, expect func(sqlmock.Sqlmock), token string) shape {
+	if expect != nil {
+		expect(mock)
+	}
+
+	req := httptest.NewRequest(method, path, nil)
+	if authenticated {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+
+	body := make(map[string]interface{})
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+
+	keys := make([]string, 0, len(body))
+	for key := range body {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return shape{
+		Code:        w.Code,
+		Keys:        keys,
+		Deprecation: w.Header().Get(HeaderDeprecation),
+		Sunset:      w.Header().Get(HeaderSunset),
+		Link:        w.Header().Get(HeaderLink),
+	}
+}
@@ -0,0 +1,277 @@
+package cosmos
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/bbengfort/cosmos/pkg/pagination"
+	"github.com/bbengfort/cosmos/pkg/webhooks"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// ListWebhooks returns the webhooks owned by the user without their secrets.
+func (s *Server) ListWebhooks(c *gin.Context) {
+	var (
+		err    error
+		userID int64
+		hooks  []*models.Webhook
+	)
+
+	if userID, err = subjectID(c); err != nil {
+		log.Warn().Err(err).Msg("could not parse user ID from claims")
+		api.Error(c, http.StatusInternalServerError, "could not list webhooks")
+		return
+	}
+
+	if hooks, err = models.ListWebhooks(c.Request.Context(), userID); err != nil {
+		log.Error().Err(err).Msg("could not fetch webhooks from the database")
+		api.Error(c, http.StatusInternalServerError, "could not list webhooks")
+		return
+	}
+
+	out := &api.WebhookList{Webhooks: make([]*api.Webhook, 0, len(hooks))}
+	for _, hook := range hooks {
+		out.Webhooks = append(out.Webhooks, webhookReply(hook))
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// CreateWebhook subscribes a URL to the events of a galaxy the user is playing in or to
+// the events of all of the user's galaxies. The secret used to sign deliveries is
+// returned in the response and cannot be retrieved again.
+func (s *Server) CreateWebhook(c *gin.Context) {
+	var (
+		err    error
+		in     *api.CreateWebhookRequest
+		out    *api.Webhook
+		userID int64
+		hook   *models.Webhook
+	)
+
+	in = &api.CreateWebhookRequest{}
+	if err = c.BindJSON(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// Deliveries are sent from inside the network so internal addresses are rejected
+	ctx := c.Request.Context()
+	if !s.conf.Webhooks.AllowPrivate {
+		if err = webhooks.CheckURL(ctx, in.URL); err != nil {
+			api.Error(c, http.StatusBadRequest, api.InvalidField("url", "must resolve to a public address"))
+			return
+		}
+	}
+
+	if userID, err = subjectID(c); err != nil {
+		log.Warn().Err(err).Msg("could not parse user ID from claims")
+		api.Error(c, http.StatusInternalServerError, "could not create webhook")
+		return
+	}
+
+	// Users can only subscribe to the events of galaxies they are playing in
+	if in.GalaxyID > 0 {
+		if _, err = models.GetPlayer(ctx, in.GalaxyID, userID); err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				api.Error(c, http.StatusBadRequest, api.InvalidField("galaxy_id", "must be a galaxy you are playing in"))
+				return
+			}
+
+			log.Error().Err(err).Msg("could not fetch player from the database")
+			api.Error(c, http.StatusInternalServerError, "could not create webhook")
+			return
+		}
+	}
+
+	hook = &models.Webhook{
+		UserID:     userID,
+		GalaxyID:   sql.NullInt64{Valid: in.GalaxyID > 0, Int64: in.GalaxyID},
+		URL:        in.URL,
+		EventTypes: in.Events,
+	}
+
+	if hook.Secret, err = webhooks.NewSecret(); err != nil {
+		log.Error().Err(err).Msg("could not generate webhook secret")
+		api.Error(c, http.StatusInternalServerError, "could not create webhook")
+		return
+	}
+
+	if err = models.CreateWebhook(ctx, hook); err != nil {
+		log.Error().Err(err).Msg("could not create webhook")
+		api.Error(c, http.StatusInternalServerError, "could not create webhook")
+		return
+	}
+
+	s.audit(c, models.AuditWebhookCreated, userID, userID, hook.URL)
+
+	out = webhookReply(hook)
+	out.Secret = hook.Secret
+	c.JSON(http.StatusCreated, out)
+}
+
+// DeleteWebhook deletes one of the user's webhooks along with its delivery log.
+func (s *Server) DeleteWebhook(c *gin.Context) {
+	var (
+		err       error
+		webhookID int64
+		userID    int64
+	)
+
+	if webhookID, err = strconv.ParseInt(c.Param("id"), 10, 64); err != nil {
+		api.Error(c, http.StatusBadRequest, api.ErrMissingID)
+		return
+	}
+
+	if userID, err = subjectID(c); err != nil {
+		log.Warn().Err(err).Msg("could not parse user ID from claims")
+		api.Error(c, http.StatusInternalServerError, "could not delete webhook")
+		return
+	}
+
+	if err = models.DeleteWebhook(c.Request.Context(), userID, webhookID); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			api.Error(c, http.StatusNotFound, "webhook not found")
+			return
+		}
+
+		log.Error().Err(err).Msg("could not delete webhook")
+		api.Error(c, http.StatusInternalServerError, "could not delete webhook")
+		return
+	}
+
+	s.audit(c, models.AuditWebhookDeleted, userID, userID, strconv.FormatInt(webhookID, 10))
+	c.JSON(http.StatusOK, &api.Reply{Success: true})
+}
+
+// WebhookDeliveries returns a page of the delivery log of one of the user's webhooks,
+// most recent deliveries first.
+func (s *Server) WebhookDeliveries(c *gin.Context) {
+	var (
+		err        error
+		in         *api.WebhookDeliveryQuery
+		query      *models.WebhookDeliveryQuery
+		userID     int64
+		deliveries []*models.WebhookDelivery
+		next       *pagination.Cursor
+	)
+
+	in = &api.WebhookDeliveryQuery{}
+	if err = c.BindQuery(in); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		api.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	query = &models.WebhookDeliveryQuery{}
+	if query.WebhookID, err = strconv.ParseInt(c.Param("id"), 10, 64); err != nil {
+		api.Error(c, http.StatusBadRequest, api.ErrMissingID)
+		return
+	}
+
+	if query.PageSize, err = pagination.PageSize(in.PageSize); err != nil {
+		api.Error(c, http.StatusBadRequest, api.InvalidField("page_size", "must be greater than or equal to 0"))
+		return
+	}
+
+	if query.Cursor, err = pagination.Parse(in.PageToken); err != nil {
+		api.Error(c, http.StatusBadRequest, api.InvalidField("page_token", "is invalid"))
+		return
+	}
+
+	if userID, err = subjectID(c); err != nil {
+		log.Warn().Err(err).Msg("could not parse user ID from claims")
+		api.Error(c, http.StatusInternalServerError, "could not list webhook deliveries")
+		return
+	}
+
+	// Only the owner of the webhook can view its delivery log
+	ctx := c.Request.Context()
+	if _, err = models.GetWebhook(ctx, userID, query.WebhookID); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			api.Error(c, http.StatusNotFound, "webhook not found")
+			return
+		}
+
+		log.Error().Err(err).Msg("could not fetch webhook from the database")
+		api.Error(c, http.StatusInternalServerError, "could not list webhook deliveries")
+		return
+	}
+
+	if deliveries, next, err = models.ListWebhookDeliveries(ctx, query); err != nil {
+		if errors.Is(err, pagination.ErrTokenMismatch) {
+			api.Error(c, http.StatusBadRequest, api.InvalidField("page_token", "does not match the webhook of the request"))
+			return
+		}
+
+		log.Error().Err(err).Msg("could not fetch webhook deliveries from the database")
+		api.Error(c, http.StatusInternalServerError, "could not list webhook deliveries")
+		return
+	}
+
+	out := &api.WebhookDeliveryList{Deliveries: make([]*api.WebhookDelivery, 0, len(deliveries))}
+	for _, delivery := range deliveries {
+		out.Deliveries = append(out.Deliveries, webhookDeliveryReply(delivery))
+	}
+
+	if next != nil {
+		if out.NextPageToken, err = next.Token(); err != nil {
+			log.Error().Err(err).Msg("could not create next page token")
+			api.Error(c, http.StatusInternalServerError, "could not list webhook deliveries")
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+func webhookReply(hook *models.Webhook) *api.Webhook {
+	out := &api.Webhook{
+		ID:       hook.ID,
+		URL:      hook.URL,
+		GalaxyID: hook.GalaxyID.Int64,
+		Events:   hook.EventTypes,
+		Created:  hook.Created.Format(time.RFC3339),
+	}
+
+	if out.Events == nil {
+		out.Events = []string{}
+	}
+	return out
+}
+
+func webhookDeliveryReply(delivery *models.WebhookDelivery) *api.WebhookDelivery {
+	out := &api.WebhookDelivery{
+		ID:             delivery.ID,
+		EventID:        delivery.EventID,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		ResponseStatus: int(delivery.ResponseStatus.Int64),
+		Error:          delivery.Error.String,
+		Created:        delivery.Created.Format(time.RFC3339),
+	}
+
+	if delivery.Status == models.DeliveryPending {
+		out.NextAttempt = delivery.NextAttempt.Format(time.RFC3339)
+	}
+
+	if delivery.Delivered.Valid {
+		out.Delivered = delivery.Delivered.Time.Format(time.RFC3339)
+	}
+	return out
+}
@@ -16,6 +16,7 @@ import (
 
 var (
 	readonly bool            // if true, only allow database reads
+	dsn      string          // used to open dedicated connections to listen for notifications
 	conn     *sqlx.DB        // connection pool to the DB managed by the package
 	connmu   sync.RWMutex    // synchronize connect and close DB connection
 	connect  sync.Once       // ensure that the database is only connected to once
@@ -23,9 +24,10 @@ var (
 )
 
 var (
-	ErrNotConnected = errors.New("not connected to the database")
-	ErrReadOnly     = errors.New("connected in readonly mode - only readonly transactions allowed")
-	ErrNotfound     = errors.New("record not found or no rows returned")
+	ErrNotConnected     = errors.New("not connected to the database")
+	ErrAlreadyConnected = errors.New("already connected to the database")
+	ErrReadOnly         = errors.New("connected in readonly mode - only readonly transactions allowed")
+	ErrNotfound         = errors.New("record not found or no rows returned")
 )
 
 // Connect to the Postgres database specified by the DSN. Connecting in read-only mode
@@ -38,6 +40,7 @@ func Connect(conf config.DatabaseConfig) (err error) {
 	// Ensure that the connect function is only called once.
 	connect.Do(func() {
 		readonly = conf.ReadOnly
+		dsn = conf.URL
 		if conn, err = sqlx.Open("postgres", conf.URL); err != nil {
 			return
 		}
@@ -61,6 +64,26 @@ func Connect(conf config.DatabaseConfig) (err error) {
 	return err
 }
 
+// ConnectDB connects the package to an open connection pool rather than to the database
+// of a configuration, e.g. to a sqlmock database in unit tests. The schema is not
+// initialized and ErrAlreadyConnected is returned if the package is already connected.
+func ConnectDB(pool *sqlx.DB) (err error) {
+	connmu.Lock()
+	defer connmu.Unlock()
+
+	connected := true
+	connect.Do(func() {
+		readonly = false
+		conn = pool
+		connected = false
+	})
+
+	if connected {
+		return ErrAlreadyConnected
+	}
+	return nil
+}
+
 // Close the database safely and allow reconnect after close.
 func Close() (err error) {
 	connmu.Lock()
@@ -68,6 +91,7 @@ func Close() (err error) {
 		err = conn.Close()
 		conn = nil
 		mock = nil
+		dsn = ""
 		connect = sync.Once{}
 	}
 	connmu.Unlock()
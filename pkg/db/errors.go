@@ -10,6 +10,8 @@ import (
 var (
 	ErrNotFound      = errors.New("object not found in database")
 	ErrAlreadyExists = errors.New("object already exists in database")
+	ErrInUse         = errors.New("object is referenced by other objects in database")
+	ErrModified      = errors.New("object has been modified since it was read from database")
 )
 
 func Check(err error) error {
@@ -18,8 +20,11 @@ func Check(err error) error {
 	}
 
 	if pgErr, ok := err.(*pq.Error); ok {
-		if pgErr.Code == "23505" {
+		switch pgErr.Code {
+		case "23505":
 			return ErrAlreadyExists
+		case "23503":
+			return ErrInUse
 		}
 	}
 
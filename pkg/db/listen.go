@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	minReconnectInterval = 1 * time.Second
+	maxReconnectInterval = 1 * time.Minute
+	listenerPingInterval = 90 * time.Second
+)
+
+// Notification is a payload sent to a channel with NOTIFY or pg_notify.
+type Notification struct {
+	Channel string
+	Payload string
+}
+
+// Listen on the specified channels until the context is canceled, calling the handler
+// for every notification received. The listener uses a dedicated connection that is
+// reestablished if it is lost; notifications sent while the listener is disconnected
+// are lost so the handler is called with a nil notification after reconnecting so that
+// any state maintained by notifications can be reset.
+func Listen(ctx context.Context, handler func(*Notification), channels ...string) (err error) {
+	connmu.RLock()
+	url := dsn
+	connmu.RUnlock()
+
+	if url == "" {
+		return ErrNotConnected
+	}
+
+	listener := pq.NewListener(url, minReconnectInterval, maxReconnectInterval, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Warn().Err(err).Int("event", int(event)).Msg("database listener connection error")
+		}
+	})
+	defer listener.Close()
+
+	for _, channel := range channels {
+		if err = listener.Listen(channel); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(listenerPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			if n == nil {
+				handler(nil)
+				continue
+			}
+			handler(&Notification{Channel: n.Channel, Payload: n.Extra})
+		case <-ticker.C:
+			// Ping to detect a dead connection that would otherwise go unnoticed
+			go listener.Ping()
+		}
+	}
+}
@@ -0,0 +1,60 @@
+-- Tracks failed logins for brute-force protection and records an audit trail.
+BEGIN;
+
+/*
+ * Tables
+ */
+
+-- Login attempts track consecutive failed logins keyed by account or by client IP
+-- address so that exponential backoff and temporary lockouts can be enforced across
+-- all of the replicas of the cosmos api server.
+CREATE TABLE IF NOT EXISTS login_attempts (
+    key             VARCHAR(255) PRIMARY KEY,
+    failures        INTEGER NOT NULL DEFAULT 0,
+    last_failure    TIMESTAMPTZ DEFAULT NULL,
+    next_attempt    TIMESTAMPTZ DEFAULT NULL,
+    locked_until    TIMESTAMPTZ DEFAULT NULL,
+    created         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    modified        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT failures_nonnegative CHECK (failures >= 0)
+);
+
+-- The audit log is an append-only record of security sensitive events such as logins
+-- and account management. The actor is the user who performed the action and the user
+-- is the account the action was performed on (they are often the same user).
+CREATE TABLE IF NOT EXISTS audit_log (
+    id          SERIAL PRIMARY KEY,
+    actor_id    INTEGER DEFAULT NULL,
+    user_id     INTEGER DEFAULT NULL,
+    client_ip   VARCHAR(255) NOT NULL DEFAULT '',
+    action      VARCHAR(255) NOT NULL,
+    detail      TEXT DEFAULT NULL,
+    created     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_user ON audit_log (user_id, created);
+
+/*
+ * Foreign Key Relationships
+ */
+
+-- Audit records are retained even if the user is deleted.
+ALTER TABLE audit_log ADD CONSTRAINT fk_audit_log_actor
+    FOREIGN KEY (actor_id) REFERENCES users (id)
+    ON DELETE SET NULL;
+
+ALTER TABLE audit_log ADD CONSTRAINT fk_audit_log_user
+    FOREIGN KEY (user_id) REFERENCES users (id)
+    ON DELETE SET NULL;
+
+/*
+ * Automatically update modified timestamps
+ */
+
+-- Login attempts modified timestamp
+CREATE TRIGGER set_login_attempts_modified
+BEFORE UPDATE ON login_attempts
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_modified_timestamp();
+
+COMMIT;
@@ -0,0 +1,57 @@
+-- Multi-factor authentication using time-based one-time passwords.
+BEGIN;
+
+/*
+ * Tables
+ */
+
+-- Each user can enroll a single TOTP authenticator. The enrollment is not used to
+-- authenticate the user until it is verified with a code from the authenticator. The
+-- last counter is the most recently accepted time step, used to prevent code replay.
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id         INTEGER PRIMARY KEY,
+    secret          VARCHAR(255) NOT NULL,
+    verified        BOOL NOT NULL DEFAULT false,
+    last_counter    BIGINT NOT NULL DEFAULT 0,
+    created         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    modified        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Single use recovery codes that can be used in place of a TOTP code; only the hash of
+-- the recovery code is stored.
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id          SERIAL PRIMARY KEY,
+    user_id     INTEGER NOT NULL,
+    code        VARCHAR(255) NOT NULL,
+    used        TIMESTAMPTZ DEFAULT NULL,
+    created     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT unique_recovery_codes_user_code UNIQUE(user_id, code)
+);
+
+-- Roles that require users to enroll a second factor
+ALTER TABLE roles ADD COLUMN require_mfa BOOL NOT NULL DEFAULT false;
+UPDATE roles SET require_mfa=true WHERE title='Admin';
+
+/*
+ * Foreign Key Relationships
+ */
+
+ALTER TABLE user_totp ADD CONSTRAINT fk_user_totp_user
+    FOREIGN KEY (user_id) REFERENCES users (id)
+    ON DELETE CASCADE;
+
+ALTER TABLE recovery_codes ADD CONSTRAINT fk_recovery_codes_user
+    FOREIGN KEY (user_id) REFERENCES users (id)
+    ON DELETE CASCADE;
+
+/*
+ * Automatically update modified timestamps
+ */
+
+-- User TOTP modified timestamp
+CREATE TRIGGER set_user_totp_modified
+BEFORE UPDATE ON user_totp
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_modified_timestamp();
+
+COMMIT;
@@ -0,0 +1,64 @@
+-- API keys allow bots and service accounts to authenticate without a password.
+BEGIN;
+
+/*
+ * Tables
+ */
+
+-- API keys are owned by a user and are identified by a client id. Only the derived key
+-- of the client secret is stored; the secret itself is only returned on creation.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id          SERIAL PRIMARY KEY,
+    client_id   VARCHAR(64) NOT NULL UNIQUE,
+    secret      VARCHAR(255) NOT NULL UNIQUE,
+    name        VARCHAR(255) NOT NULL DEFAULT '',
+    user_id     INTEGER NOT NULL,
+    expires     TIMESTAMPTZ DEFAULT NULL,
+    last_used   TIMESTAMPTZ DEFAULT NULL,
+    created     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    modified    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- The permissions granted to an API key; this should be a subset of the permissions of
+-- the user that owns the key.
+CREATE TABLE IF NOT EXISTS api_key_permissions (
+    api_key_id      INTEGER NOT NULL,
+    permission_id   INTEGER NOT NULL,
+    created         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    modified        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (api_key_id, permission_id)
+);
+
+/*
+ * Foreign Key Relationships
+ */
+
+ALTER TABLE api_keys ADD CONSTRAINT fk_api_keys_user
+    FOREIGN KEY (user_id) REFERENCES users (id)
+    ON DELETE CASCADE;
+
+ALTER TABLE api_key_permissions ADD CONSTRAINT fk_api_key_permissions_api_key
+    FOREIGN KEY (api_key_id) REFERENCES api_keys (id)
+    ON DELETE CASCADE;
+
+ALTER TABLE api_key_permissions ADD CONSTRAINT fk_api_key_permissions_permission
+    FOREIGN KEY (permission_id) REFERENCES permissions (id)
+    ON DELETE CASCADE;
+
+/*
+ * Automatically update modified timestamps
+ */
+
+-- API keys modified timestamp
+CREATE TRIGGER set_api_keys_modified
+BEFORE UPDATE ON api_keys
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_modified_timestamp();
+
+-- API key permissions modified timestamp
+CREATE TRIGGER set_api_key_permissions_modified
+BEFORE UPDATE ON api_key_permissions
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_modified_timestamp();
+
+COMMIT;
@@ -0,0 +1,41 @@
+-- External identities link users to accounts with OpenID Connect identity providers.
+BEGIN;
+
+/*
+ * Tables
+ */
+
+-- An external identity is uniquely identified by the provider and the subject that the
+-- provider asserts in its ID tokens; a user may link identities from many providers.
+CREATE TABLE IF NOT EXISTS external_identities (
+    id          SERIAL PRIMARY KEY,
+    user_id     INTEGER NOT NULL,
+    provider    VARCHAR(255) NOT NULL,
+    subject     VARCHAR(255) NOT NULL,
+    email       VARCHAR(255) DEFAULT NULL,
+    created     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    modified    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS external_identities_user_idx ON external_identities (user_id);
+
+/*
+ * Foreign Key Relationships
+ */
+
+ALTER TABLE external_identities ADD CONSTRAINT fk_external_identities_user
+    FOREIGN KEY (user_id) REFERENCES users (id)
+    ON DELETE CASCADE;
+
+/*
+ * Automatically update modified timestamps
+ */
+
+-- External identities modified timestamp
+CREATE TRIGGER set_external_identities_modified
+BEFORE UPDATE ON external_identities
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_modified_timestamp();
+
+COMMIT;
@@ -0,0 +1,10 @@
+-- Supports the administration of users, roles, and permissions.
+BEGIN;
+
+-- Disabled users cannot login, reauthenticate, or use their API keys.
+ALTER TABLE users ADD COLUMN disabled BOOL NOT NULL DEFAULT false;
+
+-- Allows users to be searched by email and name case insensitively.
+CREATE INDEX IF NOT EXISTS users_email_lower_idx ON users (LOWER(email));
+
+COMMIT;
@@ -0,0 +1,56 @@
+-- Supports self-service account management: email changes and token revocation.
+BEGIN;
+
+/*
+ * Tables
+ */
+
+-- Users that registered with an external identity provider do not have a password
+-- that they know; they must sign in again to confirm sensitive changes instead.
+ALTER TABLE users ADD COLUMN has_password BOOL NOT NULL DEFAULT true;
+
+-- A pending change of a user's email address; the change is applied once the user
+-- submits the token sent to the new address. Only the hash of the token is stored.
+CREATE TABLE IF NOT EXISTS email_verifications (
+    user_id     INTEGER PRIMARY KEY,
+    email       VARCHAR(255) NOT NULL,
+    token       VARCHAR(255) NOT NULL UNIQUE,
+    expires     TIMESTAMPTZ NOT NULL,
+    created     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    modified    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Tokens issued to the user before the revocation timestamp are rejected. There is
+-- deliberately no foreign key so that the tokens of deleted users remain revoked.
+CREATE TABLE IF NOT EXISTS token_revocations (
+    user_id         INTEGER PRIMARY KEY,
+    revoked_before  TIMESTAMPTZ NOT NULL,
+    created         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    modified        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+/*
+ * Foreign Key Relationships
+ */
+
+ALTER TABLE email_verifications ADD CONSTRAINT fk_email_verifications_user
+    FOREIGN KEY (user_id) REFERENCES users (id)
+    ON DELETE CASCADE;
+
+/*
+ * Automatically update modified timestamps
+ */
+
+-- Email verifications modified timestamp
+CREATE TRIGGER set_email_verifications_modified
+BEFORE UPDATE ON email_verifications
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_modified_timestamp();
+
+-- Token revocations modified timestamp
+CREATE TRIGGER set_token_revocations_modified
+BEFORE UPDATE ON token_revocations
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_modified_timestamp();
+
+COMMIT;
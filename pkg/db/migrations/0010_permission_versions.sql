@@ -0,0 +1,109 @@
+-- Versions the permissions of users and roles so that access tokens issued before a
+-- permission change can be rejected before they expire.
+BEGIN;
+
+/*
+ * Tables
+ */
+
+-- The user version is incremented when the user's role changes or they are disabled.
+ALTER TABLE users ADD COLUMN perms_version INTEGER NOT NULL DEFAULT 1;
+
+-- The role version is incremented when permissions are added to or removed from it.
+ALTER TABLE roles ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+/*
+ * Permission version triggers
+ */
+
+-- Increment the user version when the user's permissions change
+CREATE OR REPLACE FUNCTION trigger_increment_user_perms_version()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF NEW.role_id IS DISTINCT FROM OLD.role_id OR NEW.disabled IS DISTINCT FROM OLD.disabled THEN
+    NEW.perms_version = OLD.perms_version + 1;
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER increment_users_perms_version
+BEFORE UPDATE ON users
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_increment_user_perms_version();
+
+-- Increment the role version when the role's permissions change
+CREATE OR REPLACE FUNCTION trigger_increment_role_version()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    UPDATE roles SET version=version+1 WHERE id=OLD.role_id;
+    RETURN OLD;
+  END IF;
+
+  UPDATE roles SET version=version+1 WHERE id=NEW.role_id;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER increment_role_permissions_version
+AFTER INSERT OR DELETE ON role_permissions
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_increment_role_version();
+
+/*
+ * Notifications
+ */
+
+-- Notify API servers that the permission versions of a user or role have changed so
+-- that they can invalidate their caches. Notifications are delivered on commit.
+CREATE OR REPLACE FUNCTION trigger_notify_user_perms_version()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    PERFORM pg_notify('permission_versions', 'user:' || OLD.id);
+  ELSIF NEW.perms_version IS DISTINCT FROM OLD.perms_version THEN
+    PERFORM pg_notify('permission_versions', 'user:' || NEW.id);
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notify_users_perms_version
+AFTER UPDATE OR DELETE ON users
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_notify_user_perms_version();
+
+CREATE OR REPLACE FUNCTION trigger_notify_role_version()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    PERFORM pg_notify('permission_versions', 'role:' || OLD.title);
+  ELSIF NEW.version IS DISTINCT FROM OLD.version THEN
+    PERFORM pg_notify('permission_versions', 'role:' || NEW.title);
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notify_roles_version
+AFTER UPDATE OR DELETE ON roles
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_notify_role_version();
+
+-- Notify API servers of token revocations so that they take effect immediately rather
+-- than when the revocations are next refreshed.
+CREATE OR REPLACE FUNCTION trigger_notify_token_revocation()
+RETURNS TRIGGER AS $$
+BEGIN
+  PERFORM pg_notify('token_revocations', NEW.user_id || ':' || EXTRACT(EPOCH FROM NEW.revoked_before)::BIGINT);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notify_token_revocations
+AFTER INSERT OR UPDATE ON token_revocations
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_notify_token_revocation();
+
+COMMIT;
@@ -0,0 +1,26 @@
+-- Galaxy permissions are granted to players by the role of their player in the galaxy
+-- rather than by the role of their user account.
+BEGIN;
+
+INSERT INTO permissions (id, title, description) VALUES
+    (5, 'galaxy:admin', 'Can moderate a galaxy and manage its players'),
+    (6, 'galaxy:play', 'Can play in a galaxy by issuing orders'),
+    (7, 'galaxy:observe', 'Can view a galaxy and its players')
+;
+
+-- Admins own the galaxies they create, players can play, and observers can only watch.
+INSERT INTO role_permissions (role_id, permission_id) VALUES
+    (1, 5),
+    (1, 6),
+    (1, 7),
+    (2, 6),
+    (2, 7),
+    (3, 7)
+;
+
+-- The default roles and permissions were created with explicit IDs so the sequences
+-- must be advanced to prevent conflicts when new roles or permissions are created.
+SELECT setval('roles_id_seq', (SELECT MAX(id) FROM roles));
+SELECT setval('permissions_id_seq', (SELECT MAX(id) FROM permissions));
+
+COMMIT;
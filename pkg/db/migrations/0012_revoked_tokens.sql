@@ -0,0 +1,66 @@
+-- Supports revoking individual tokens and introspecting tokens for companion services.
+BEGIN;
+
+/*
+ * Tables
+ */
+
+-- Individual tokens that have been revoked, identified by their jti claim. Access and
+-- refresh tokens issued together share an ID so both are revoked. The row can be
+-- deleted once the tokens have expired. There is deliberately no foreign key so that
+-- revoked tokens of deleted users remain revoked.
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    token_id    VARCHAR(26) PRIMARY KEY,
+    user_id     INTEGER NOT NULL,
+    expires     TIMESTAMPTZ NOT NULL,
+    created     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    modified    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires ON revoked_tokens (expires);
+
+/*
+ * Default permissions
+ */
+
+-- Allows the API keys of companion services to introspect and revoke tokens
+INSERT INTO permissions (id, title, description) VALUES
+    (8, 'tokens:introspect', 'Can introspect and revoke the tokens of any user')
+;
+
+INSERT INTO role_permissions (role_id, permission_id) VALUES
+    (1, 8)
+;
+
+SELECT setval('permissions_id_seq', (SELECT MAX(id) FROM permissions));
+
+/*
+ * Automatically update modified timestamps
+ */
+
+-- Revoked tokens modified timestamp
+CREATE TRIGGER set_revoked_tokens_modified
+BEFORE UPDATE ON revoked_tokens
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_modified_timestamp();
+
+/*
+ * Notifications
+ */
+
+-- Notify API servers of revoked tokens on the token revocations channel; the payload is
+-- prefixed with jti to distinguish it from revocations of all of a user's tokens.
+CREATE OR REPLACE FUNCTION trigger_notify_revoked_token()
+RETURNS TRIGGER AS $$
+BEGIN
+  PERFORM pg_notify('token_revocations', 'jti:' || NEW.token_id || ':' || EXTRACT(EPOCH FROM NEW.expires)::BIGINT);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notify_revoked_tokens
+AFTER INSERT ON revoked_tokens
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_notify_revoked_token();
+
+COMMIT;
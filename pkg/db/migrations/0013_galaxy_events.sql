@@ -0,0 +1,113 @@
+-- Galaxy events are streamed to the players of a galaxy as they happen.
+BEGIN;
+
+/*
+ * Tables
+ */
+
+-- Events that happened in a galaxy in the order that they happened. The ID of the event
+-- is used to resume event streams. Events with a player ID are only visible to that
+-- player (e.g. the report of a battle the player fought); all other events are visible
+-- to every player of the galaxy.
+CREATE TABLE IF NOT EXISTS galaxy_events (
+    id          BIGSERIAL PRIMARY KEY,
+    galaxy_id   INTEGER NOT NULL,
+    player_id   INTEGER DEFAULT NULL,
+    event_type  VARCHAR(64) NOT NULL,
+    data        JSONB NOT NULL DEFAULT '{}',
+    created     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_galaxy_events_galaxy ON galaxy_events (galaxy_id, id);
+
+/*
+ * Foreign Key Relationships
+ */
+
+ALTER TABLE galaxy_events ADD CONSTRAINT fk_galaxy_events_galaxy
+    FOREIGN KEY (galaxy_id) REFERENCES galaxies (id)
+    ON DELETE CASCADE;
+
+ALTER TABLE galaxy_events ADD CONSTRAINT fk_galaxy_events_player
+    FOREIGN KEY (player_id) REFERENCES users (id)
+    ON DELETE CASCADE;
+
+/*
+ * Events
+ */
+
+-- Record an event when the turn of a galaxy advances or the state of the game changes.
+CREATE OR REPLACE FUNCTION trigger_galaxy_updated_event()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF NEW.turn <> OLD.turn THEN
+    INSERT INTO galaxy_events (galaxy_id, event_type, data)
+      VALUES (NEW.id, 'turn_advanced', jsonb_build_object('turn', NEW.turn));
+  END IF;
+
+  IF NEW.game_state <> OLD.game_state THEN
+    INSERT INTO galaxy_events (galaxy_id, event_type, data)
+      VALUES (NEW.id, 'state_changed', jsonb_build_object('state', NEW.game_state));
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER galaxy_updated_event
+AFTER UPDATE ON galaxies
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_galaxy_updated_event();
+
+-- Record an event when a player joins a galaxy.
+CREATE OR REPLACE FUNCTION trigger_player_joined_event()
+RETURNS TRIGGER AS $$
+BEGIN
+  INSERT INTO galaxy_events (galaxy_id, event_type, data)
+    VALUES (NEW.galaxy_id, 'player_joined', jsonb_build_object('user_id', NEW.player_id, 'name', NEW.name));
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER player_joined_event
+AFTER INSERT ON players
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_player_joined_event();
+
+-- Record an event when a player leaves a galaxy unless the players are being deleted
+-- because the galaxy itself was deleted.
+CREATE OR REPLACE FUNCTION trigger_player_left_event()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM galaxies WHERE id = OLD.galaxy_id) THEN
+    INSERT INTO galaxy_events (galaxy_id, event_type, data)
+      VALUES (OLD.galaxy_id, 'player_left', jsonb_build_object('user_id', OLD.player_id, 'name', OLD.name));
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER player_left_event
+AFTER DELETE ON players
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_player_left_event();
+
+/*
+ * Notifications
+ */
+
+-- Notify API servers of new events on the galaxy events channel so that they can send
+-- the events to the streams of the galaxy; the payload is the galaxy ID and event ID.
+CREATE OR REPLACE FUNCTION trigger_notify_galaxy_event()
+RETURNS TRIGGER AS $$
+BEGIN
+  PERFORM pg_notify('galaxy_events', NEW.galaxy_id || ':' || NEW.id);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notify_galaxy_events
+AFTER INSERT ON galaxy_events
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_notify_galaxy_event();
+
+COMMIT;
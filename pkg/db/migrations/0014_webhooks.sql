@@ -0,0 +1,94 @@
+-- Webhooks notify external services such as chat bots of galaxy events.
+BEGIN;
+
+/*
+ * Tables
+ */
+
+-- Webhooks are owned by a user and receive the events of a single galaxy or, if the
+-- galaxy is null, of every galaxy the user is playing in. If event types are specified
+-- only events of those types are delivered. The secret is used to sign deliveries so
+-- it must be stored in the clear; it is only returned to the user on creation.
+CREATE TABLE IF NOT EXISTS webhooks (
+    id          SERIAL PRIMARY KEY,
+    user_id     INTEGER NOT NULL,
+    galaxy_id   INTEGER DEFAULT NULL,
+    url         VARCHAR(2048) NOT NULL,
+    secret      VARCHAR(255) NOT NULL,
+    event_types VARCHAR(64)[] NOT NULL DEFAULT '{}',
+    created     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    modified    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhooks_user ON webhooks (user_id);
+
+-- The durable delivery queue and delivery log of webhooks. Deliveries are pending until
+-- they are delivered or until they have failed too many times. Pending deliveries are
+-- claimed by an API server by moving their next attempt into the future so that a
+-- delivery is retried by another replica if the server stops while delivering it.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id              BIGSERIAL PRIMARY KEY,
+    webhook_id      INTEGER NOT NULL,
+    event_id        BIGINT NOT NULL,
+    status          VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    response_status INTEGER DEFAULT NULL,
+    error           TEXT DEFAULT NULL,
+    delivered       TIMESTAMPTZ DEFAULT NULL,
+    created         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    modified        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (webhook_id, event_id),
+    CONSTRAINT chk_webhook_deliveries_status CHECK (status IN ('pending', 'delivered', 'failed'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_pending ON webhook_deliveries (next_attempt) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries (webhook_id, id);
+
+/*
+ * Foreign Key Relationships
+ */
+
+ALTER TABLE webhooks ADD CONSTRAINT fk_webhooks_user
+    FOREIGN KEY (user_id) REFERENCES users (id)
+    ON DELETE CASCADE;
+
+ALTER TABLE webhooks ADD CONSTRAINT fk_webhooks_galaxy
+    FOREIGN KEY (galaxy_id) REFERENCES galaxies (id)
+    ON DELETE CASCADE;
+
+ALTER TABLE webhook_deliveries ADD CONSTRAINT fk_webhook_deliveries_webhook
+    FOREIGN KEY (webhook_id) REFERENCES webhooks (id)
+    ON DELETE CASCADE;
+
+ALTER TABLE webhook_deliveries ADD CONSTRAINT fk_webhook_deliveries_event
+    FOREIGN KEY (event_id) REFERENCES galaxy_events (id)
+    ON DELETE CASCADE;
+
+/*
+ * Delivery Queue
+ */
+
+-- Queue a delivery of each new galaxy event to the webhooks that subscribe to it in the
+-- same transaction that records the event so that no events are lost. Events are only
+-- delivered to webhooks of users who are currently players of the galaxy and who are
+-- permitted to see the event.
+CREATE OR REPLACE FUNCTION trigger_queue_webhook_deliveries()
+RETURNS TRIGGER AS $$
+BEGIN
+  INSERT INTO webhook_deliveries (webhook_id, event_id)
+    SELECT w.id, NEW.id FROM webhooks w
+      JOIN players p ON p.player_id = w.user_id AND p.galaxy_id = NEW.galaxy_id
+      WHERE (w.galaxy_id IS NULL OR w.galaxy_id = NEW.galaxy_id)
+        AND (cardinality(w.event_types) = 0 OR NEW.event_type = ANY(w.event_types))
+        AND (NEW.player_id IS NULL OR NEW.player_id = w.user_id);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER queue_webhook_deliveries
+AFTER INSERT ON galaxy_events
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_queue_webhook_deliveries();
+
+COMMIT;
@@ -0,0 +1,33 @@
+-- Shares the rate limit buckets of the API between replicas.
+BEGIN;
+
+/*
+ * Tables
+ */
+
+-- Token buckets of rate limited users and client addresses per route group. The tokens
+-- are the number of tokens in the bucket at the updated timestamp; the bucket is full at
+-- the expires timestamp, after which the row can be deleted since a missing bucket is
+-- the same as a full bucket.
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limits (
+    key         VARCHAR(255) PRIMARY KEY,
+    tokens      DOUBLE PRECISION NOT NULL,
+    updated     TIMESTAMPTZ NOT NULL,
+    expires     TIMESTAMPTZ NOT NULL,
+    created     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    modified    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limits_expires ON rate_limits (expires);
+
+/*
+ * Automatically update modified timestamps
+ */
+
+-- Rate limits modified timestamp
+CREATE TRIGGER set_rate_limits_modified
+BEFORE UPDATE ON rate_limits
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_modified_timestamp();
+
+COMMIT;
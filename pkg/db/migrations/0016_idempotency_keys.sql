@@ -0,0 +1,45 @@
+-- Stores the responses to requests with idempotency keys so that retries are replayed.
+BEGIN;
+
+/*
+ * Tables
+ */
+
+-- The first response to a request with an Idempotency-Key header for each user and key.
+-- The fingerprint is the hash of the method, path, and body of the request so that a key
+-- cannot be reused for a different request. The status is null while the request is
+-- being processed; the key can be deleted and reused once it expires.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id         INTEGER NOT NULL,
+    key             VARCHAR(255) NOT NULL,
+    fingerprint     BYTEA NOT NULL,
+    status          INTEGER DEFAULT NULL,
+    content_type    VARCHAR(255) DEFAULT NULL,
+    body            BYTEA DEFAULT NULL,
+    expires         TIMESTAMPTZ NOT NULL,
+    created         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    modified        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys (expires);
+
+/*
+ * Foreign Key Relationships
+ */
+
+ALTER TABLE idempotency_keys ADD CONSTRAINT fk_idempotency_keys_user
+    FOREIGN KEY (user_id) REFERENCES users (id)
+    ON DELETE CASCADE;
+
+/*
+ * Automatically update modified timestamps
+ */
+
+-- Idempotency keys modified timestamp
+CREATE TRIGGER set_idempotency_keys_modified
+BEFORE UPDATE ON idempotency_keys
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_modified_timestamp();
+
+COMMIT;
@@ -0,0 +1,18 @@
+-- Streams galaxy events in the order that their transactions committed.
+BEGIN;
+
+/*
+ * Tables
+ */
+
+-- The ID of the transaction that recorded the event. Event IDs are allocated when the
+-- event is inserted, so concurrent transactions can commit events out of ID order, e.g.
+-- when two players join a galaxy at the same time. Streams only read the events of
+-- transactions older than the oldest transaction that is still in progress and resume
+-- after the transaction and ID of the last event, so an event that commits late is
+-- never skipped. Existing events are recorded with the transaction of this migration.
+ALTER TABLE galaxy_events ADD COLUMN IF NOT EXISTS xid XID8 NOT NULL DEFAULT pg_current_xact_id();
+
+CREATE INDEX IF NOT EXISTS idx_galaxy_events_galaxy_xid ON galaxy_events (galaxy_id, xid, id);
+
+COMMIT;
@@ -0,0 +1,9 @@
+-- Leases the idempotency keys of requests in progress so that a key can be taken over
+-- if the replica processing the request dies before the response is stored.
+BEGIN;
+
+-- The request of a key that has not completed is abandoned once its lease lapses and the
+-- key can be reserved again by a retry of the same request.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+COMMIT;
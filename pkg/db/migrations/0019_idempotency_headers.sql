@@ -0,0 +1,8 @@
+-- Stores the headers of the responses to requests with idempotency keys so that retries
+-- are replayed with the same entity tags and locations.
+BEGIN;
+
+-- A JSON object of the replayed header names and their values.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS headers JSONB NOT NULL DEFAULT '{}';
+
+COMMIT;
@@ -0,0 +1,51 @@
+-- Supports revoking the tokens issued to an API key when the key is deleted.
+BEGIN;
+
+/*
+ * Tables
+ */
+
+-- API keys whose tokens have been revoked, identified by the client ID of the key. Client
+-- IDs are not reused so every token issued to the key is revoked. The row can be deleted
+-- once the tokens issued before the revocation have expired. There is deliberately no
+-- foreign key since the API key is deleted when its tokens are revoked.
+CREATE TABLE IF NOT EXISTS revoked_clients (
+    client_id   VARCHAR(64) PRIMARY KEY,
+    user_id     INTEGER NOT NULL,
+    expires     TIMESTAMPTZ NOT NULL,
+    created     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    modified    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_clients_expires ON revoked_clients (expires);
+
+/*
+ * Automatically update modified timestamps
+ */
+
+-- Revoked clients modified timestamp
+CREATE TRIGGER set_revoked_clients_modified
+BEFORE UPDATE ON revoked_clients
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_modified_timestamp();
+
+/*
+ * Notifications
+ */
+
+-- Notify API servers of revoked clients on the token revocations channel; the payload is
+-- prefixed with client to distinguish it from the other revocations.
+CREATE OR REPLACE FUNCTION trigger_notify_revoked_client()
+RETURNS TRIGGER AS $$
+BEGIN
+  PERFORM pg_notify('token_revocations', 'client:' || NEW.client_id || ':' || EXTRACT(EPOCH FROM NEW.expires)::BIGINT);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notify_revoked_clients
+AFTER INSERT ON revoked_clients
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_notify_revoked_client();
+
+COMMIT;
@@ -0,0 +1,311 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/jmoiron/sqlx"
+)
+
+// EmailVerification is a pending change to a user's email address. The token is the
+// hash of the verification token that is sent to the new email address.
+type EmailVerification struct {
+	UserID   int64     `db:"user_id"`
+	Email    string    `db:"email"`
+	Token    string    `db:"token"`
+	Expires  time.Time `db:"expires"`
+	Created  time.Time `db:"created"`
+	Modified time.Time `db:"modified"`
+}
+
+const createEmailVerificationSQL = "INSERT INTO email_verifications (user_id, email, token, expires, created, modified) VALUES (:user_id, :email, :token, :expires, :created, :modified) ON CONFLICT (user_id) DO UPDATE SET email=EXCLUDED.email, token=EXCLUDED.token, expires=EXCLUDED.expires"
+
+// CreateEmailVerification creates a pending email change for the user, replacing any
+// previous pending change so that only the most recent token can be used.
+func CreateEmailVerification(ctx context.Context, v *EmailVerification) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	v.Created = time.Now()
+	v.Modified = v.Created
+	if _, err = tx.NamedExec(createEmailVerificationSQL, v); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const getEmailVerificationSQL = "SELECT * FROM email_verifications WHERE user_id=$1 AND expires > NOW()"
+
+// GetEmailVerification returns the user's pending email change if it has not expired.
+func GetEmailVerification(ctx context.Context, userID int64) (v *EmailVerification, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	v = &EmailVerification{}
+	if err = tx.Get(v, getEmailVerificationSQL, userID); err != nil {
+		return nil, db.Check(err)
+	}
+
+	tx.Commit()
+	return v, nil
+}
+
+const (
+	verifyEmailSQL     = "DELETE FROM email_verifications WHERE user_id=$1 AND token=$2 RETURNING email, expires"
+	updateUserEmailSQL = "UPDATE users SET email=$1 WHERE id=$2"
+)
+
+// VerifyEmail applies the pending email change of the user if the token hash matches
+// and the verification has not expired. The pending change is consumed either way so
+// the token cannot be guessed. Returns db.ErrNotFound if the token is invalid and
+// db.ErrAlreadyExists if another user has the email address when checked.
+func VerifyEmail(ctx context.Context, userID int64, token string) (email string, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var expires time.Time
+	if err = tx.QueryRow(verifyEmailSQL, userID, token).Scan(&email, &expires); err != nil {
+		return "", db.Check(err)
+	}
+
+	if expires.Before(time.Now()) {
+		// Commit to delete the expired verification
+		tx.Commit()
+		return "", db.ErrNotFound
+	}
+
+	if _, err = tx.Exec(updateUserEmailSQL, email, userID); err != nil {
+		return "", db.Check(err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+	return email, nil
+}
+
+const (
+	revokeTokensSQL      = "INSERT INTO token_revocations (user_id, revoked_before) VALUES ($1, $2) ON CONFLICT (user_id) DO UPDATE SET revoked_before=GREATEST(token_revocations.revoked_before, EXCLUDED.revoked_before)"
+	listRevocationsSQL   = "SELECT user_id, revoked_before FROM token_revocations WHERE revoked_before > $1"
+	deleteRevocationsSQL = "DELETE FROM token_revocations WHERE revoked_before <= $1"
+)
+
+// RevokeTokens records that all tokens issued to the user before the timestamp are
+// revoked. A later revocation timestamp always takes precedence.
+func RevokeTokens(ctx context.Context, userID int64, before time.Time) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(revokeTokensSQL, userID, before); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// TokenRevocations returns a map of user ID to revocation timestamp for revocations
+// after the specified timestamp. Revocations at or before the timestamp are deleted
+// since any tokens they applied to have expired.
+func TokenRevocations(ctx context.Context, after time.Time) (revocations map[int64]time.Time, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(deleteRevocationsSQL, after); err != nil {
+		return nil, err
+	}
+
+	var rows *sql.Rows
+	if rows, err = tx.Query(listRevocationsSQL, after); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revocations = make(map[int64]time.Time)
+	for rows.Next() {
+		var (
+			userID int64
+			before time.Time
+		)
+
+		if err = rows.Scan(&userID, &before); err != nil {
+			return nil, err
+		}
+		revocations[userID] = before
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows.Close()
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return revocations, nil
+}
+
+const (
+	revokeTokenSQL         = "INSERT INTO revoked_tokens (token_id, user_id, expires) VALUES ($1, $2, $3) ON CONFLICT (token_id) DO NOTHING"
+	listRevokedTokensSQL   = "SELECT token_id, expires FROM revoked_tokens WHERE expires > $1"
+	deleteRevokedTokensSQL = "DELETE FROM revoked_tokens WHERE expires <= $1"
+)
+
+// RevokeToken records that the token with the specified ID is revoked until it expires.
+func RevokeToken(ctx context.Context, tokenID string, userID int64, expires time.Time) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(revokeTokenSQL, tokenID, userID, expires); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseToken revokes a single-use token, e.g. an MFA token when it is exchanged, and
+// returns false if the token had already been revoked so that it is only used once.
+func UseToken(ctx context.Context, tokenID string, userID int64, expires time.Time) (unused bool, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	if result, err = tx.Exec(revokeTokenSQL, tokenID, userID, expires); err != nil {
+		return false, err
+	}
+
+	if nrows, _ := result.RowsAffected(); nrows == 0 {
+		return false, nil
+	}
+	return true, tx.Commit()
+}
+
+// RevokedTokens returns a map of token ID to expiration for tokens that have been
+// revoked and have not expired. Revoked tokens that have expired are deleted.
+func RevokedTokens(ctx context.Context) (tokens map[string]time.Time, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err = tx.Exec(deleteRevokedTokensSQL, now); err != nil {
+		return nil, err
+	}
+
+	var rows *sql.Rows
+	if rows, err = tx.Query(listRevokedTokensSQL, now); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens = make(map[string]time.Time)
+	for rows.Next() {
+		var (
+			tokenID string
+			expires time.Time
+		)
+
+		if err = rows.Scan(&tokenID, &expires); err != nil {
+			return nil, err
+		}
+		tokens[tokenID] = expires
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows.Close()
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+const (
+	revokeClientSQL         = "INSERT INTO revoked_clients (client_id, user_id, expires) VALUES ($1, $2, $3) ON CONFLICT (client_id) DO UPDATE SET expires=GREATEST(revoked_clients.expires, EXCLUDED.expires)"
+	listRevokedClientsSQL   = "SELECT client_id, expires FROM revoked_clients WHERE expires > $1"
+	deleteRevokedClientsSQL = "DELETE FROM revoked_clients WHERE expires <= $1"
+)
+
+// RevokeClient records that the tokens issued to the API key with the specified client
+// ID are revoked until the tokens expire.
+func RevokeClient(ctx context.Context, clientID string, userID int64, expires time.Time) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(revokeClientSQL, clientID, userID, expires); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RevokedClients returns a map of client ID to expiration for API keys whose tokens have
+// been revoked and have not expired. Revoked clients whose tokens have expired are
+// deleted.
+func RevokedClients(ctx context.Context) (clients map[string]time.Time, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err = tx.Exec(deleteRevokedClientsSQL, now); err != nil {
+		return nil, err
+	}
+
+	var rows *sql.Rows
+	if rows, err = tx.Query(listRevokedClientsSQL, now); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients = make(map[string]time.Time)
+	for rows.Next() {
+		var (
+			clientID string
+			expires  time.Time
+		)
+
+		if err = rows.Scan(&clientID, &expires); err != nil {
+			return nil, err
+		}
+		clients[clientID] = expires
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows.Close()
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
@@ -0,0 +1,185 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrUnknownPermission = errors.New("unknown permission")
+
+// APIKey allows a bot or service account to authenticate on behalf of the user that
+// owns the key with a subset of the user's permissions. The secret is the derived key
+// of the client secret, which is only available when the key is created.
+type APIKey struct {
+	ID          int64        `db:"id"`
+	ClientID    string       `db:"client_id"`
+	Secret      string       `db:"secret"`
+	Name        string       `db:"name"`
+	UserID      int64        `db:"user_id"`
+	Expires     sql.NullTime `db:"expires"`
+	LastUsed    sql.NullTime `db:"last_used"`
+	Created     time.Time    `db:"created"`
+	Modified    time.Time    `db:"modified"`
+	permissions []string
+}
+
+const (
+	createAPIKeySQL     = "INSERT INTO api_keys (client_id, secret, name, user_id, expires, created, modified) VALUES (:client_id, :secret, :name, :user_id, :expires, :created, :modified) RETURNING id;"
+	getPermissionIDSQL  = "SELECT id FROM permissions WHERE title=$1"
+	createAPIKeyPermSQL = "INSERT INTO api_key_permissions (api_key_id, permission_id) VALUES ($1, $2)"
+)
+
+// CreateAPIKey creates the API key with the specified permissions. The caller is
+// responsible for ensuring the permissions are a subset of the owner's permissions.
+func CreateAPIKey(ctx context.Context, key *APIKey, permissions []string) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	key.Created = time.Now()
+	key.Modified = key.Created
+
+	var (
+		query string
+		args  []interface{}
+	)
+
+	if query, args, err = tx.BindNamed(createAPIKeySQL, key); err != nil {
+		return err
+	}
+
+	if err = tx.Get(&key.ID, query, args...); err != nil {
+		return err
+	}
+
+	for _, permission := range permissions {
+		var permID int64
+		if err = tx.Get(&permID, getPermissionIDSQL, permission); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%w %q", ErrUnknownPermission, permission)
+			}
+			return err
+		}
+
+		if _, err = tx.Exec(createAPIKeyPermSQL, key.ID, permID); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	key.permissions = permissions
+	return nil
+}
+
+const (
+	getAPIKeySQL    = "SELECT * FROM api_keys WHERE client_id=$1"
+	listAPIKeysSQL  = "SELECT * FROM api_keys WHERE user_id=$1 ORDER BY created"
+	deleteAPIKeySQL = "DELETE FROM api_keys WHERE id=$1 AND user_id=$2 RETURNING client_id"
+	getAPIKeyPerms  = "SELECT p.title FROM api_key_permissions kp JOIN permissions p ON kp.permission_id=p.id WHERE kp.api_key_id=$1"
+)
+
+// GetAPIKey by client ID along with its permissions.
+func GetAPIKey(ctx context.Context, clientID string) (key *APIKey, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	key = &APIKey{}
+	if err = tx.Get(key, getAPIKeySQL, clientID); err != nil {
+		return nil, err
+	}
+
+	if err = key.getPermissions(tx); err != nil {
+		return nil, err
+	}
+
+	tx.Commit()
+	return key, nil
+}
+
+// ListAPIKeys returns all of the API keys owned by the specified user.
+func ListAPIKeys(ctx context.Context, userID int64) (keys []*APIKey, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	keys = make([]*APIKey, 0)
+	if err = tx.Select(&keys, listAPIKeysSQL, userID); err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if err = key.getPermissions(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	tx.Commit()
+	return keys, nil
+}
+
+// DeleteAPIKey deletes the API key with the specified ID if it is owned by the user and
+// returns the client ID of the deleted key so that it can be removed from caches.
+func DeleteAPIKey(ctx context.Context, userID, keyID int64) (clientID string, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if err = tx.Get(&clientID, deleteAPIKeySQL, keyID, userID); err != nil {
+		return "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+	return clientID, nil
+}
+
+// Permissions returns the titles of the permissions granted to the API key.
+func (k *APIKey) Permissions() []string {
+	return k.permissions
+}
+
+// Expired returns true if the API key has an expiration that has passed.
+func (k *APIKey) Expired() bool {
+	return k.Expires.Valid && k.Expires.Time.Before(time.Now())
+}
+
+const updateAPIKeyLastUsedSQL = "UPDATE api_keys SET last_used=:last_used WHERE id=:id"
+
+// Used updates the last used timestamp of the API key.
+func (k *APIKey) Used(ctx context.Context) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	k.LastUsed = sql.NullTime{Valid: true, Time: time.Now()}
+	if _, err = tx.NamedExec(updateAPIKeyLastUsedSQL, k); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (k *APIKey) getPermissions(tx *sqlx.Tx) (err error) {
+	k.permissions = make([]string, 0, 4)
+	return tx.Select(&k.permissions, getAPIKeyPerms, k.ID)
+}
@@ -1,12 +1,40 @@
 package models
 
-import "time"
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
 
 type Asteroid struct {
-	ID       int64
-	SystemID int64
-	Orbit    int16
-	Density  float64
-	Created  time.Time
-	Modified time.Time
+	ID       int64     `db:"id"`
+	SystemID int64     `db:"system_id"`
+	Orbit    int16     `db:"orbit"`
+	Density  float64   `db:"density"`
+	Created  time.Time `db:"created"`
+	Modified time.Time `db:"modified"`
+}
+
+const listAsteroidsSQL = "SELECT * FROM asteroids WHERE system_id=ANY($1) ORDER BY system_id, orbit"
+
+// ListAsteroids returns the asteroid belts of all of the specified systems ordered by
+// system and orbit so that the belts of many systems can be loaded with a single query.
+func ListAsteroids(ctx context.Context, systemIDs []int64) (asteroids []*Asteroid, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	asteroids = make([]*Asteroid, 0)
+	if err = tx.Select(&asteroids, listAsteroidsSQL, pq.Array(systemIDs)); err != nil {
+		return nil, err
+	}
+
+	tx.Commit()
+	return asteroids, nil
 }
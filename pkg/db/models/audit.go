@@ -0,0 +1,85 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/jmoiron/sqlx"
+)
+
+// Audit actions that are recorded in the audit log.
+const (
+	AuditLogin           = "login"
+	AuditLoginFailed     = "login_failed"
+	AuditLoginThrottled  = "login_throttled"
+	AuditAccountLocked   = "account_locked"
+	AuditAccountUnlocked = "account_unlocked"
+	AuditMFAEnrolled     = "mfa_enrolled"
+	AuditMFARemoved      = "mfa_removed"
+	AuditMFAFailed       = "mfa_failed"
+	AuditRecoveryUsed    = "recovery_code_used"
+	AuditAPIKeyCreated   = "api_key_created"
+	AuditAPIKeyDeleted   = "api_key_deleted"
+	AuditIdentityLinked  = "identity_linked"
+	AuditAccountDisabled = "account_disabled"
+	AuditAccountEnabled  = "account_enabled"
+	AuditRoleChanged     = "role_changed"
+	AuditRoleCreated     = "role_created"
+	AuditRoleUpdated     = "role_updated"
+	AuditRoleDeleted     = "role_deleted"
+	AuditPasswordChanged = "password_changed"
+	AuditEmailChanged    = "email_changed"
+	AuditAccountDeleted  = "account_deleted"
+	AuditPlayerRole      = "player_role_changed"
+	AuditPlayerRemoved   = "player_removed"
+	AuditGalaxyUpdated   = "galaxy_updated"
+	AuditGalaxyDeleted   = "galaxy_deleted"
+	AuditTokenRevoked    = "token_revoked"
+	AuditImpersonation   = "impersonation"
+	AuditWebhookCreated  = "webhook_created"
+	AuditWebhookDeleted  = "webhook_deleted"
+)
+
+// AuditEvent is an append-only record of a security sensitive action. The actor is the
+// user who performed the action and the user is the account that was acted upon; either
+// may be null, e.g. if a login fails for an unknown account.
+type AuditEvent struct {
+	ID       int64          `db:"id"`
+	ActorID  sql.NullInt64  `db:"actor_id"`
+	UserID   sql.NullInt64  `db:"user_id"`
+	ClientIP string         `db:"client_ip"`
+	Action   string         `db:"action"`
+	Detail   sql.NullString `db:"detail"`
+	Created  time.Time      `db:"created"`
+}
+
+const (
+	createAuditEventSQL = "INSERT INTO audit_log (actor_id, user_id, client_ip, action, detail, created) VALUES (:actor_id, :user_id, :client_ip, :action, :detail, :created) RETURNING id;"
+)
+
+// CreateAuditEvent appends the event to the audit log.
+func CreateAuditEvent(ctx context.Context, event *AuditEvent) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	event.Created = time.Now()
+
+	var (
+		query string
+		args  []interface{}
+	)
+
+	if query, args, err = tx.BindNamed(createAuditEventSQL, event); err != nil {
+		return err
+	}
+
+	if err = tx.Get(&event.ID, query, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/jmoiron/sqlx"
+)
+
+// GalaxyEventsChannel is notified when an event is recorded in a galaxy; see the galaxy
+// events migration.
+const GalaxyEventsChannel = "galaxy_events"
+
+// GalaxyEvent is something that happened in a galaxy. Events are recorded by database
+// triggers when the turn or state of a galaxy changes and when players join or leave,
+// and by the game engine for everything else. Events with a player ID are only visible
+// to that player. The data is a JSON object whose fields depend on the event type.
+type GalaxyEvent struct {
+	ID       int64         `db:"id"`
+	GalaxyID int64         `db:"galaxy_id"`
+	PlayerID sql.NullInt64 `db:"player_id"`
+	Type     string        `db:"event_type"`
+	Data     string        `db:"data"`
+	Created  time.Time     `db:"created"`
+}
+
+const createGalaxyEventSQL = "INSERT INTO galaxy_events (galaxy_id, player_id, event_type, data, created) VALUES (:galaxy_id, :player_id, :event_type, :data, :created) RETURNING id"
+
+// CreateGalaxyEvent records an event in the galaxy, setting the ID of the event.
+func CreateGalaxyEvent(ctx context.Context, event *GalaxyEvent) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if event.Data == "" {
+		event.Data = "{}"
+	}
+	event.Created = time.Now()
+
+	var (
+		query string
+		args  []interface{}
+	)
+
+	if query, args, err = tx.BindNamed(createGalaxyEventSQL, event); err != nil {
+		return err
+	}
+
+	if err = tx.Get(&event.ID, query, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Events are only read once the transactions of every event before them have committed,
+// i.e. their transaction is older than the oldest transaction in progress, and they are
+// read in the order of their transactions so that events that commit out of ID order
+// are not skipped by streams that resume after the last event they read.
+const (
+	galaxyEventColumns  = "id, galaxy_id, player_id, event_type, data, created"
+	committedEventsSQL  = "xid < pg_snapshot_xmin(pg_current_snapshot())"
+	listGalaxyEventsSQL = "SELECT " + galaxyEventColumns + " FROM galaxy_events WHERE galaxy_id=$1 AND " + committedEventsSQL + " AND ($2=0 OR (xid, id) > (SELECT xid, id FROM galaxy_events WHERE galaxy_id=$1 AND id=$2)) AND (player_id IS NULL OR player_id=$3) ORDER BY xid, id LIMIT $4"
+)
+
+// ListGalaxyEvents returns the events of the galaxy after the specified event ID that
+// are visible to the user in the order they were committed, up to the limit. Events
+// whose transactions may be preceded by a transaction that is still in progress are
+// not returned until that transaction is done. No events are returned if the event ID
+// is not zero and is not an event of the galaxy, so callers must check that streams
+// resume after an event of the galaxy with GalaxyEventExists.
+func ListGalaxyEvents(ctx context.Context, galaxyID, userID, after int64, limit int) (events []*GalaxyEvent, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	events = make([]*GalaxyEvent, 0)
+	if err = tx.Select(&events, listGalaxyEventsSQL, galaxyID, after, userID, limit); err != nil {
+		return nil, err
+	}
+
+	tx.Commit()
+	return events, nil
+}
+
+const galaxyEventExistsSQL = "SELECT EXISTS(SELECT 1 FROM galaxy_events WHERE galaxy_id=$1 AND id=$2)"
+
+// GalaxyEventExists returns true if the event ID is the ID of an event of the galaxy.
+func GalaxyEventExists(ctx context.Context, galaxyID, eventID int64) (exists bool, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err = tx.Get(&exists, galaxyEventExistsSQL, galaxyID, eventID); err != nil {
+		return false, err
+	}
+
+	tx.Commit()
+	return exists, nil
+}
+
+const latestGalaxyEventSQL = "SELECT COALESCE((SELECT id FROM galaxy_events WHERE galaxy_id=$1 AND " + committedEventsSQL + " ORDER BY xid DESC, id DESC LIMIT 1), 0)"
+
+// LatestGalaxyEventID returns the ID of the most recent committed event of the galaxy,
+// after which events are listed by ListGalaxyEvents, or zero if nothing has happened in
+// the galaxy yet.
+func LatestGalaxyEventID(ctx context.Context, galaxyID int64) (id int64, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err = tx.Get(&id, latestGalaxyEventSQL, galaxyID); err != nil {
+		return 0, err
+	}
+
+	tx.Commit()
+	return id, nil
+}
+
+// ParseGalaxyEventNotification returns the galaxy ID and the event ID of a galaxy
+// events notification payload, e.g. "42:1337".
+func ParseGalaxyEventNotification(payload string) (galaxyID, eventID int64, err error) {
+	gid, eid, _ := strings.Cut(payload, ":")
+	if galaxyID, err = strconv.ParseInt(gid, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("could not parse galaxy event notification %q: %w", payload, err)
+	}
+
+	if eventID, err = strconv.ParseInt(eid, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("could not parse galaxy event notification %q: %w", payload, err)
+	}
+	return galaxyID, eventID, nil
+}
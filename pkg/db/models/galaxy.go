@@ -3,12 +3,18 @@ package models
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/bbengfort/cosmos/pkg/db"
 	"github.com/bbengfort/cosmos/pkg/enums"
 	"github.com/bbengfort/cosmos/pkg/jcode"
+	"github.com/bbengfort/cosmos/pkg/pagination"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type Galaxy struct {
@@ -25,7 +31,7 @@ type Galaxy struct {
 }
 
 const (
-	createGalaxySQL = "INSERT INTO galaxies (name, turn, size, max_players, max_turns, join_code, created, modified) VALUES (:name, :turn, :size, :max_players, :max_turns, :join_code, :created, :modified) RETURNING ID;"
+	createGalaxySQL = "INSERT INTO galaxies (name, turn, size, max_players, max_turns, join_code, created, modified) VALUES (:name, :turn, :size, :max_players, :max_turns, :join_code, :created, :modified) RETURNING id, created, modified;"
 )
 
 func CreateGalaxy(ctx context.Context, galaxy *Galaxy) (err error) {
@@ -93,3 +99,245 @@ func ListActiveGalaxies(ctx context.Context, userID int64) (galaxies []*Galaxy,
 	tx.Commit()
 	return galaxies, nil
 }
+
+// GalaxyQuery filters, sorts, and paginates the galaxies of a user returned by
+// QueryGalaxies. If no states are specified, completed galaxies are not returned.
+type GalaxyQuery struct {
+	UserID   int64
+	States   []enums.GameState
+	Sizes    []enums.Size
+	Order    pagination.Order
+	Cursor   *pagination.Cursor // the last galaxy of the previous page
+	PageSize int
+}
+
+// GalaxyOrderFields are the fields that galaxies can be ordered by.
+var GalaxyOrderFields = []string{"created", "modified", "name", "turn"}
+
+// The columns of the order fields and casts of their sort keys
+var galaxyOrderColumns = map[string][2]string{
+	"created":  {"g.created", "TIMESTAMPTZ"},
+	"modified": {"g.modified", "TIMESTAMPTZ"},
+	"name":     {"g.name", "VARCHAR"},
+	"turn":     {"g.turn", "INTEGER"},
+}
+
+// Filter returns the fingerprint of the filters of the query for page tokens.
+func (q *GalaxyQuery) Filter() string {
+	values := make([]string, 0, len(q.States)+len(q.Sizes))
+	for _, state := range q.States {
+		values = append(values, "state:"+state.String())
+	}
+	for _, size := range q.Sizes {
+		values = append(values, "size:"+size.String())
+	}
+	return pagination.Filter(values...)
+}
+
+// QueryGalaxies returns a page of the galaxies that the user is a player in along with
+// the cursor of the next page, which is nil if there are no more galaxies.
+func QueryGalaxies(ctx context.Context, query *GalaxyQuery) (galaxies []*Galaxy, next *pagination.Cursor, err error) {
+	column, ok := galaxyOrderColumns[query.Order.Field]
+	if !ok {
+		return nil, nil, pagination.ErrInvalidOrder
+	}
+
+	var key interface{}
+	if query.Cursor != nil {
+		if err = query.Cursor.Check(query.Order, query.Filter()); err != nil {
+			return nil, nil, err
+		}
+
+		if key, err = ParseGalaxySortKey(query.Order.Field, query.Cursor.Key); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	params := []interface{}{query.UserID}
+	conditions := []string{"p.player_id=$1"}
+
+	if len(query.States) > 0 {
+		states := make([]string, 0, len(query.States))
+		for _, state := range query.States {
+			states = append(states, state.String())
+		}
+		params = append(params, pq.Array(states))
+		conditions = append(conditions, fmt.Sprintf("g.game_state::TEXT=ANY($%d)", len(params)))
+	} else {
+		conditions = append(conditions, "g.game_state!='completed'")
+	}
+
+	if len(query.Sizes) > 0 {
+		sizes := make([]string, 0, len(query.Sizes))
+		for _, size := range query.Sizes {
+			sizes = append(sizes, size.String())
+		}
+		params = append(params, pq.Array(sizes))
+		conditions = append(conditions, fmt.Sprintf("g.size::TEXT=ANY($%d)", len(params)))
+	}
+
+	if query.Cursor != nil {
+		params = append(params, key, query.Cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, g.id) %s ($%d::%s, $%d)", column[0], query.Order.Comparison(), len(params)-1, column[1], len(params)))
+	}
+
+	// Fetch one more galaxy than the page size to determine if there is a next page
+	params = append(params, query.PageSize+1)
+	stmt := "SELECT g.* FROM galaxies g JOIN players p ON g.id=p.galaxy_id WHERE " + strings.Join(conditions, " AND ")
+	stmt += fmt.Sprintf(" ORDER BY %s %s, g.id %s LIMIT $%d", column[0], query.Order.Direction(), query.Order.Direction(), len(params))
+
+	galaxies = make([]*Galaxy, 0, query.PageSize+1)
+	if err = tx.Select(&galaxies, stmt, params...); err != nil {
+		return nil, nil, err
+	}
+
+	if len(galaxies) > query.PageSize {
+		galaxies = galaxies[:query.PageSize]
+		last := galaxies[len(galaxies)-1]
+		next = pagination.New(query.Order, last.sortKey(query.Order.Field), last.ID, query.Filter())
+	}
+
+	tx.Commit()
+	return galaxies, next, nil
+}
+
+// sortKey returns the value of the order field of the galaxy for a pagination cursor.
+func (g *Galaxy) sortKey(field string) string {
+	switch field {
+	case "created":
+		return g.Created.Format(time.RFC3339Nano)
+	case "modified":
+		return g.Modified.Format(time.RFC3339Nano)
+	case "name":
+		return g.Name
+	case "turn":
+		return strconv.FormatInt(g.Turn, 10)
+	default:
+		return ""
+	}
+}
+
+// ParseGalaxySortKey parses the sort key of a pagination cursor as a value of the order
+// field, returning pagination.ErrInvalidPageToken if the key is not a value of the field
+// so that page tokens modified by clients are not passed to the database.
+func ParseGalaxySortKey(field, key string) (_ interface{}, err error) {
+	switch field {
+	case "created", "modified":
+		var ts time.Time
+		if ts, err = time.Parse(time.RFC3339Nano, key); err != nil {
+			return nil, pagination.ErrInvalidPageToken
+		}
+		return ts, nil
+	case "name":
+		return key, nil
+	case "turn":
+		var turn int64
+		if turn, err = strconv.ParseInt(key, 10, 32); err != nil {
+			return nil, pagination.ErrInvalidPageToken
+		}
+		return turn, nil
+	default:
+		return nil, pagination.ErrInvalidOrder
+	}
+}
+
+const getGalaxySQL = "SELECT * FROM galaxies WHERE id=$1"
+
+// GetGalaxy by ID; returns db.ErrNotFound if the galaxy does not exist.
+func GetGalaxy(ctx context.Context, id int64) (galaxy *Galaxy, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	galaxy = &Galaxy{}
+	if err = tx.Get(galaxy, getGalaxySQL, id); err != nil {
+		return nil, db.Check(err)
+	}
+
+	tx.Commit()
+	return galaxy, nil
+}
+
+const getGalaxiesSQL = "SELECT * FROM galaxies WHERE id=ANY($1) ORDER BY id"
+
+// GetGalaxies returns the galaxies with the specified IDs; IDs that do not exist are
+// ignored rather than returning db.ErrNotFound.
+func GetGalaxies(ctx context.Context, ids []int64) (galaxies []*Galaxy, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	galaxies = make([]*Galaxy, 0)
+	if err = tx.Select(&galaxies, getGalaxiesSQL, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+
+	tx.Commit()
+	return galaxies, nil
+}
+
+const updateGalaxySQL = "UPDATE galaxies SET name=$2, max_turns=$3 WHERE id=$1 AND ($4::timestamptz IS NULL OR modified=$4) RETURNING modified"
+
+// UpdateGalaxy saves the name and max turns of the galaxy, updating its modified
+// timestamp. If modified is not zero, the galaxy is only updated if it has not been
+// modified since then and db.ErrModified is returned otherwise so that concurrent
+// updates do not overwrite each other. Returns db.ErrNotFound if the galaxy does not
+// exist.
+func UpdateGalaxy(ctx context.Context, galaxy *Galaxy, modified time.Time) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = tx.Get(&galaxy.Modified, updateGalaxySQL, galaxy.ID, galaxy.Name, galaxy.MaxTurns, unmodifiedSince(modified)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) && !modified.IsZero() {
+			return db.ErrModified
+		}
+		return db.Check(err)
+	}
+	return tx.Commit()
+}
+
+const deleteGalaxySQL = "DELETE FROM galaxies WHERE id=$1 AND ($2::timestamptz IS NULL OR modified=$2)"
+
+// DeleteGalaxy deletes the galaxy along with its players and systems. If modified is
+// not zero, the galaxy is only deleted if it has not been modified since then and
+// db.ErrModified is returned otherwise. Returns db.ErrNotFound if the galaxy does not
+// exist.
+func DeleteGalaxy(ctx context.Context, id int64, modified time.Time) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	if result, err = tx.Exec(deleteGalaxySQL, id, unmodifiedSince(modified)); err != nil {
+		return err
+	}
+
+	if nrows, _ := result.RowsAffected(); nrows == 0 {
+		if !modified.IsZero() {
+			return db.ErrModified
+		}
+		return db.ErrNotFound
+	}
+	return tx.Commit()
+}
+
+// unmodifiedSince is the condition of conditional updates, which is null if the update
+// is unconditional.
+func unmodifiedSince(modified time.Time) sql.NullTime {
+	return sql.NullTime{Time: modified, Valid: !modified.IsZero()}
+}
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/jmoiron/sqlx"
+)
+
+// IdempotencyKey is the first response to a request with an idempotency key, which is
+// replayed when the user retries the request. The fingerprint identifies the request so
+// that a key cannot be reused with a different request. The headers are a JSON object of
+// the replayed response headers. The status is null while the request is being
+// processed; if the request has not completed when the lease lapses, the request is
+// assumed to be abandoned and the key can be taken over by a retry.
+type IdempotencyKey struct {
+	UserID      int64          `db:"user_id"`
+	Key         string         `db:"key"`
+	Fingerprint []byte         `db:"fingerprint"`
+	Status      sql.NullInt64  `db:"status"`
+	ContentType sql.NullString `db:"content_type"`
+	Headers     string         `db:"headers"`
+	Body        []byte         `db:"body"`
+	LockedUntil time.Time      `db:"locked_until"`
+	Expires     time.Time      `db:"expires"`
+	Created     time.Time      `db:"created"`
+	Modified    time.Time      `db:"modified"`
+}
+
+const (
+	deleteExpiredIdempotencyKeySQL = "DELETE FROM idempotency_keys WHERE user_id=$1 AND key=$2 AND expires<=$3"
+	createIdempotencyKeySQL        = "INSERT INTO idempotency_keys (user_id, key, fingerprint, locked_until, expires) VALUES (:user_id, :key, :fingerprint, :locked_until, :expires) ON CONFLICT (user_id, key) DO UPDATE SET locked_until=EXCLUDED.locked_until WHERE idempotency_keys.status IS NULL AND idempotency_keys.fingerprint=EXCLUDED.fingerprint AND idempotency_keys.locked_until<=NOW()"
+	getIdempotencyKeySQL           = "SELECT * FROM idempotency_keys WHERE user_id=$1 AND key=$2"
+	completeIdempotencyKeySQL      = "UPDATE idempotency_keys SET status=:status, content_type=:content_type, headers=:headers, body=:body WHERE user_id=:user_id AND key=:key AND locked_until=:locked_until AND status IS NULL"
+	deleteIdempotencyKeySQL        = "DELETE FROM idempotency_keys WHERE user_id=$1 AND key=$2 AND locked_until=$3 AND status IS NULL"
+	deleteIdempotencyKeysSQL       = "DELETE FROM idempotency_keys WHERE expires<$1"
+)
+
+// ReserveIdempotencyKey creates the key for a request that is about to be processed,
+// replacing the key if it has expired. If the user has already used the key, the key is
+// not created and the existing key is returned instead; concurrent reservations of the
+// same key wait for each other so that only one of the requests is processed. A key whose
+// request has not completed before its lease lapsed is taken over by a reservation for
+// the same request and its lease is renewed.
+func ReserveIdempotencyKey(ctx context.Context, key *IdempotencyKey) (existing *IdempotencyKey, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(deleteExpiredIdempotencyKeySQL, key.UserID, key.Key, time.Now()); err != nil {
+		return nil, err
+	}
+
+	var result sql.Result
+	if result, err = tx.NamedExec(createIdempotencyKeySQL, key); err != nil {
+		return nil, err
+	}
+
+	var created int64
+	if created, err = result.RowsAffected(); err != nil {
+		return nil, err
+	}
+
+	if created == 0 {
+		existing = &IdempotencyKey{}
+		if err = tx.Get(existing, getIdempotencyKeySQL, key.UserID, key.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// CompleteIdempotencyKey saves the response to the request of the key if the request
+// still holds the lease of the key, which is identified by the reserved locked until
+// timestamp. If the key was taken over by another request or has already completed,
+// db.ErrNotFound is returned.
+func CompleteIdempotencyKey(ctx context.Context, key *IdempotencyKey) (err error) {
+	if key.Headers == "" {
+		key.Headers = "{}"
+	}
+
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	if result, err = tx.NamedExec(completeIdempotencyKeySQL, key); err != nil {
+		return err
+	}
+
+	if nrows, _ := result.RowsAffected(); nrows == 0 {
+		return db.ErrNotFound
+	}
+	return tx.Commit()
+}
+
+// DeleteIdempotencyKey deletes the key so that the request can be retried, e.g. when
+// the request could not be processed because of an internal error. The key is only
+// deleted if the request still holds the lease that it was reserved with.
+func DeleteIdempotencyKey(ctx context.Context, userID int64, key string, lockedUntil time.Time) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(deleteIdempotencyKeySQL, userID, key, lockedUntil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteExpiredIdempotencyKeys deletes the keys that expired before the specified time
+// and returns the number of keys deleted.
+func DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (deleted int64, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	if result, err = tx.Exec(deleteIdempotencyKeysSQL, before); err != nil {
+		return 0, err
+	}
+
+	if deleted, err = result.RowsAffected(); err != nil {
+		return 0, err
+	}
+	return deleted, tx.Commit()
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/jmoiron/sqlx"
+)
+
+// ExternalIdentity links a user to the subject asserted by an external identity
+// provider so that the user can sign in with that provider.
+type ExternalIdentity struct {
+	ID       int64          `db:"id"`
+	UserID   int64          `db:"user_id"`
+	Provider string         `db:"provider"`
+	Subject  string         `db:"subject"`
+	Email    sql.NullString `db:"email"`
+	Created  time.Time      `db:"created"`
+	Modified time.Time      `db:"modified"`
+}
+
+const getExternalIdentitySQL = "SELECT * FROM external_identities WHERE provider=$1 AND subject=$2"
+
+// GetExternalIdentity by the provider name and the subject asserted by the provider.
+func GetExternalIdentity(ctx context.Context, provider, subject string) (ident *ExternalIdentity, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ident = &ExternalIdentity{}
+	if err = tx.Get(ident, getExternalIdentitySQL, provider, subject); err != nil {
+		return nil, db.Check(err)
+	}
+
+	tx.Commit()
+	return ident, nil
+}
+
+// LinkExternalIdentity links the external identity to an existing user.
+func LinkExternalIdentity(ctx context.Context, ident *ExternalIdentity) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = createExternalIdentity(tx, ident); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreateExternalUser creates a new user with the default role along with the external
+// identity that the user signed in with in a single transaction.
+func CreateExternalUser(ctx context.Context, user *User, ident *ExternalIdentity) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The user does not know the random password created for them
+	user.HasPassword = false
+	if err = createUser(tx, user); err != nil {
+		return err
+	}
+
+	ident.UserID = user.ID
+	if err = createExternalIdentity(tx, ident); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const createExternalIdentitySQL = "INSERT INTO external_identities (user_id, provider, subject, email, created, modified) VALUES (:user_id, :provider, :subject, :email, :created, :modified) RETURNING id"
+
+func createExternalIdentity(tx *sqlx.Tx, ident *ExternalIdentity) (err error) {
+	ident.Created = time.Now()
+	ident.Modified = ident.Created
+
+	var (
+		query string
+		args  []interface{}
+	)
+
+	if query, args, err = tx.BindNamed(createExternalIdentitySQL, ident); err != nil {
+		return err
+	}
+	return tx.Get(&ident.ID, query, args...)
+}
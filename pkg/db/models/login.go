@@ -0,0 +1,129 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/jmoiron/sqlx"
+)
+
+// Prefixes used to construct login attempt keys for accounts, client addresses, and
+// the client IDs of API keys.
+const (
+	UserLoginPrefix   = "user:"
+	ClientLoginPrefix = "ip:"
+	APIKeyLoginPrefix = "key:"
+)
+
+// LoginAttempts tracks the consecutive failed logins for either a user account or a
+// client IP address, identified by the key. The next attempt and locked until
+// timestamps are used to throttle login requests before the password is verified.
+type LoginAttempts struct {
+	Key         string       `db:"key"`
+	Failures    int64        `db:"failures"`
+	LastFailure sql.NullTime `db:"last_failure"`
+	NextAttempt sql.NullTime `db:"next_attempt"`
+	LockedUntil sql.NullTime `db:"locked_until"`
+	Created     time.Time    `db:"created"`
+	Modified    time.Time    `db:"modified"`
+}
+
+// UserLoginKey returns the login attempts key for the specified user account.
+func UserLoginKey(userID int64) string {
+	return UserLoginPrefix + strconv.FormatInt(userID, 10)
+}
+
+// ClientLoginKey returns the login attempts key for the specified client IP address.
+func ClientLoginKey(clientIP string) string {
+	return ClientLoginPrefix + clientIP
+}
+
+// APIKeyLoginKey returns the login attempts key for the specified API key client ID.
+func APIKeyLoginKey(clientID string) string {
+	return APIKeyLoginPrefix + clientID
+}
+
+// IsUser returns true if the login attempts are tracking a user account.
+func (a *LoginAttempts) IsUser() bool {
+	return strings.HasPrefix(a.Key, UserLoginPrefix)
+}
+
+// Locked returns true if the login attempts are currently locked out.
+func (a *LoginAttempts) Locked() bool {
+	return a.LockedUntil.Valid && a.LockedUntil.Time.After(time.Now())
+}
+
+const (
+	getLoginAttemptsSQL      = "SELECT * FROM login_attempts WHERE key=$1"
+	recordLoginFailureSQL    = "INSERT INTO login_attempts (key, failures, last_failure, created, modified) VALUES ($1, 1, $2, $2, $2) ON CONFLICT (key) DO UPDATE SET failures=CASE WHEN login_attempts.last_failure IS NULL OR login_attempts.last_failure<=$3 THEN 1 ELSE login_attempts.failures+1 END, locked_until=CASE WHEN login_attempts.last_failure IS NULL OR login_attempts.last_failure<=$3 THEN NULL ELSE login_attempts.locked_until END, last_failure=EXCLUDED.last_failure RETURNING *"
+	throttleLoginAttemptsSQL = "UPDATE login_attempts SET next_attempt=:next_attempt, locked_until=:locked_until WHERE key=:key"
+	resetLoginAttemptsSQL    = "DELETE FROM login_attempts WHERE key=$1"
+)
+
+// GetLoginAttempts returns the failed login record for the specified key. If there are
+// no failed logins recorded for the key, an empty record is returned without error.
+func GetLoginAttempts(ctx context.Context, key string) (attempts *LoginAttempts, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	attempts = &LoginAttempts{}
+	if err = tx.Get(attempts, getLoginAttemptsSQL, key); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		attempts = &LoginAttempts{Key: key}
+	}
+
+	tx.Commit()
+	return attempts, nil
+}
+
+// RecordLoginFailure increments the failures of the key in the database, creating the
+// record if it does not exist, so that concurrent failed logins are all counted.
+// Failures are counted from one again if the last failure was before the forget
+// timestamp. The row is locked until the throttle function has computed the next
+// attempt and lockout of the incremented failures, which are then saved.
+func RecordLoginFailure(ctx context.Context, key string, forget time.Time, throttle func(*LoginAttempts)) (attempts *LoginAttempts, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	attempts = &LoginAttempts{}
+	if err = tx.Get(attempts, recordLoginFailureSQL, key, time.Now(), forget); err != nil {
+		return nil, err
+	}
+
+	throttle(attempts)
+	if _, err = tx.NamedExec(throttleLoginAttemptsSQL, attempts); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// ResetLoginAttempts clears any failed logins and lockouts for the specified key.
+func ResetLoginAttempts(ctx context.Context, key string) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(resetLoginAttemptsSQL, key); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
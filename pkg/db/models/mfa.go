@@ -0,0 +1,196 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/jmoiron/sqlx"
+)
+
+// TOTP is a user's time-based one-time password enrollment. An enrollment is only used
+// as a second factor once it has been verified with a code from the authenticator.
+type TOTP struct {
+	UserID      int64     `db:"user_id"`
+	Secret      string    `db:"secret"`
+	Verified    bool      `db:"verified"`
+	LastCounter int64     `db:"last_counter"`
+	Created     time.Time `db:"created"`
+	Modified    time.Time `db:"modified"`
+}
+
+const (
+	getTOTPSQL    = "SELECT * FROM user_totp WHERE user_id=$1"
+	createTOTPSQL = "INSERT INTO user_totp (user_id, secret, verified, last_counter, created, modified) VALUES (:user_id, :secret, :verified, :last_counter, :created, :modified) ON CONFLICT (user_id) DO UPDATE SET secret=EXCLUDED.secret, verified=EXCLUDED.verified, last_counter=EXCLUDED.last_counter"
+	deleteTOTPSQL = "DELETE FROM user_totp WHERE user_id=$1"
+)
+
+// GetTOTP returns the TOTP enrollment for the user or db.ErrNotFound if the user has
+// not enrolled an authenticator.
+func GetTOTP(ctx context.Context, userID int64) (totp *TOTP, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	totp = &TOTP{}
+	if err = tx.Get(totp, getTOTPSQL, userID); err != nil {
+		return nil, db.Check(err)
+	}
+
+	tx.Commit()
+	return totp, nil
+}
+
+// CreateTOTP creates a new unverified TOTP enrollment for the user, replacing any
+// previous unverified enrollment.
+func CreateTOTP(ctx context.Context, totp *TOTP) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	totp.Verified = false
+	totp.LastCounter = 0
+	totp.Created = time.Now()
+	totp.Modified = totp.Created
+
+	if _, err = tx.NamedExec(createTOTPSQL, totp); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const (
+	verifyTOTPSQL        = "UPDATE user_totp SET verified=true, last_counter=$2 WHERE user_id=$1"
+	deleteRecoverySQL    = "DELETE FROM recovery_codes WHERE user_id=$1"
+	createRecoverySQL    = "INSERT INTO recovery_codes (user_id, code, created) VALUES ($1, $2, $3)"
+	useRecoveryCodeSQL   = "UPDATE recovery_codes SET used=$3 WHERE user_id=$1 AND code=$2 AND used IS NULL"
+	useTOTPCounterSQL    = "UPDATE user_totp SET last_counter=$2 WHERE user_id=$1 AND verified=true AND last_counter < $2"
+	countRecoveryCodeSQL = "SELECT count(id) FROM recovery_codes WHERE user_id=$1 AND used IS NULL"
+)
+
+// Verify the TOTP enrollment, storing the counter of the code used to verify it, and
+// replace any recovery codes with the specified hashed recovery codes.
+func (t *TOTP) Verify(ctx context.Context, counter int64, recoveryCodes []string) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(verifyTOTPSQL, t.UserID, counter); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(deleteRecoverySQL, t.UserID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, code := range recoveryCodes {
+		if _, err = tx.Exec(createRecoverySQL, t.UserID, code, now); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	t.Verified = true
+	t.LastCounter = counter
+	return nil
+}
+
+// UseCounter marks the time step counter of a TOTP code as used. Returns false if the
+// counter has already been used (or an earlier counter) to prevent the replay of codes.
+func (t *TOTP) UseCounter(ctx context.Context, counter int64) (_ bool, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	if result, err = tx.Exec(useTOTPCounterSQL, t.UserID, counter); err != nil {
+		return false, err
+	}
+
+	var rows int64
+	if rows, err = result.RowsAffected(); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	if rows == 1 {
+		t.LastCounter = counter
+		return true, nil
+	}
+	return false, nil
+}
+
+// DeleteTOTP removes the user's TOTP enrollment and all of their recovery codes.
+func DeleteTOTP(ctx context.Context, userID int64) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(deleteTOTPSQL, userID); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(deleteRecoverySQL, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks the hashed recovery code as used. Returns false if the code
+// does not exist for the user or has already been used.
+func UseRecoveryCode(ctx context.Context, userID int64, code string) (_ bool, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	if result, err = tx.Exec(useRecoveryCodeSQL, userID, code, time.Now()); err != nil {
+		return false, err
+	}
+
+	var rows int64
+	if rows, err = result.RowsAffected(); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// RemainingRecoveryCodes returns the number of unused recovery codes for the user.
+func RemainingRecoveryCodes(ctx context.Context, userID int64) (count int64, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err = tx.Get(&count, countRecoveryCodeSQL, userID); err != nil {
+		return 0, err
+	}
+
+	tx.Commit()
+	return count, nil
+}
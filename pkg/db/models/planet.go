@@ -1,29 +1,54 @@
 package models
 
 import (
+	"context"
+	"database/sql"
 	"time"
 
+	"github.com/bbengfort/cosmos/pkg/db"
 	"github.com/bbengfort/cosmos/pkg/enums"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type Planet struct {
-	ID           int64
-	SystemID     int64
-	Name         string
-	PlanetClass  enums.PlanetClass
-	IsHomeworld  bool
-	Orbit        int16
-	OrbitalSpeed float32
-	Labs         int16
-	Tech         int64
-	Mines        int16
-	Metals       int64
-	Reactors     int16
-	Energy       int64
-	Cities       int16
-	Credits      int64
-	Farms        int16
-	Food         int64
-	Created      time.Time
-	Modified     time.Time
+	ID           int64             `db:"id"`
+	SystemID     int64             `db:"system_id"`
+	Name         string            `db:"name"`
+	PlanetClass  enums.PlanetClass `db:"planet_class"`
+	IsHomeworld  bool              `db:"is_homeworld"`
+	Orbit        int16             `db:"orbit"`
+	OrbitalSpeed float32           `db:"orbital_speed"`
+	Labs         int16             `db:"labs"`
+	Tech         int64             `db:"tech"`
+	Mines        int16             `db:"mines"`
+	Metals       int64             `db:"metals"`
+	Reactors     int16             `db:"reactors"`
+	Energy       int64             `db:"energy"`
+	Cities       int16             `db:"cities"`
+	Credits      int64             `db:"credits"`
+	Farms        int16             `db:"farms"`
+	Food         int64             `db:"food"`
+	Created      time.Time         `db:"created"`
+	Modified     time.Time         `db:"modified"`
+}
+
+const listPlanetsSQL = "SELECT * FROM planets WHERE system_id=ANY($1) ORDER BY system_id, orbit"
+
+// ListPlanets returns the planets of all of the specified systems ordered by system and
+// orbit so that the planets of many systems can be loaded with a single query.
+func ListPlanets(ctx context.Context, systemIDs []int64) (planets []*Planet, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	planets = make([]*Planet, 0)
+	if err = tx.Select(&planets, listPlanetsSQL, pq.Array(systemIDs)); err != nil {
+		return nil, err
+	}
+
+	tx.Commit()
+	return planets, nil
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/bbengfort/cosmos/pkg/db"
 	"github.com/bbengfort/cosmos/pkg/enums"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type Player struct {
@@ -52,3 +53,203 @@ func CreatePlayer(ctx context.Context, player *Player) (err error) {
 	}
 	return tx.Commit()
 }
+
+const getPlayerSQL = "SELECT * FROM players WHERE galaxy_id=$1 AND player_id=$2"
+
+// GetPlayer returns the user's player in the galaxy along with the player's galaxy role
+// and its permissions. Returns db.ErrNotFound if the user is not a player in the galaxy.
+func GetPlayer(ctx context.Context, galaxyID, userID int64) (player *Player, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	player = &Player{}
+	if err = tx.Get(player, getPlayerSQL, galaxyID, userID); err != nil {
+		return nil, db.Check(err)
+	}
+
+	if player.role, err = getRole(tx, player.RoleID); err != nil {
+		return nil, err
+	}
+
+	tx.Commit()
+	return player, nil
+}
+
+const (
+	listPlayersSQL         = "SELECT * FROM players WHERE galaxy_id=$1 ORDER BY created"
+	listGalaxiesPlayersSQL = "SELECT * FROM players WHERE galaxy_id=ANY($1) ORDER BY galaxy_id, created"
+)
+
+// ListPlayers returns the players in the galaxy in the order that they joined along
+// with their galaxy roles.
+func ListPlayers(ctx context.Context, galaxyID int64) (players []*Player, err error) {
+	return listPlayers(ctx, listPlayersSQL, galaxyID)
+}
+
+// ListGalaxiesPlayers returns the players of all of the specified galaxies ordered by
+// galaxy and then in the order that they joined along with their galaxy roles, so that
+// the players of many galaxies can be loaded with a single query.
+func ListGalaxiesPlayers(ctx context.Context, galaxyIDs []int64) (players []*Player, err error) {
+	return listPlayers(ctx, listGalaxiesPlayersSQL, pq.Array(galaxyIDs))
+}
+
+func listPlayers(ctx context.Context, query string, args ...interface{}) (players []*Player, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	players = make([]*Player, 0)
+	if err = tx.Select(&players, query, args...); err != nil {
+		return nil, err
+	}
+
+	roles := make(map[int64]*Role)
+	for _, player := range players {
+		var ok bool
+		if player.role, ok = roles[player.RoleID]; !ok {
+			if player.role, err = getRole(tx, player.RoleID); err != nil {
+				return nil, err
+			}
+			roles[player.RoleID] = player.role
+		}
+	}
+
+	tx.Commit()
+	return players, nil
+}
+
+const listUserPlayersSQL = "SELECT * FROM players WHERE player_id=$1 AND galaxy_id=ANY($2) ORDER BY galaxy_id"
+
+// ListUserPlayers returns the user's players in the specified galaxies along with their
+// galaxy roles and the permissions of the roles, so that the user can be authorized in
+// many galaxies with a fixed number of queries. Galaxies the user is not a player in are
+// omitted.
+func ListUserPlayers(ctx context.Context, userID int64, galaxyIDs []int64) (players []*Player, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	players = make([]*Player, 0, len(galaxyIDs))
+	if err = tx.Select(&players, listUserPlayersSQL, userID, pq.Array(galaxyIDs)); err != nil {
+		return nil, err
+	}
+
+	roleIDs := make([]int64, 0, len(players))
+	for _, player := range players {
+		roleIDs = append(roleIDs, player.RoleID)
+	}
+
+	var roles map[int64]*Role
+	if roles, err = listRolesPermissions(tx, roleIDs); err != nil {
+		return nil, err
+	}
+
+	for _, player := range players {
+		if player.role = roles[player.RoleID]; player.role == nil {
+			return nil, fmt.Errorf("could not find role %d of player", player.RoleID)
+		}
+	}
+
+	tx.Commit()
+	return players, nil
+}
+
+// Role returns the galaxy role of the player, which is distinct from the role of the
+// player's user account.
+func (p *Player) Role(ctx context.Context) (_ *Role, err error) {
+	if p.role == nil {
+		var tx *sqlx.Tx
+		if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+
+		if p.role, err = getRole(tx, p.RoleID); err != nil {
+			return nil, err
+		}
+		tx.Commit()
+	}
+	return p.role, nil
+}
+
+// HasPermission returns true if the galaxy role of the player grants the permission.
+func (p *Player) HasPermission(ctx context.Context, permission string) (_ bool, err error) {
+	var role *Role
+	if role, err = p.Role(ctx); err != nil {
+		return false, err
+	}
+
+	var perms []*Permission
+	if perms, err = role.Permissions(ctx); err != nil {
+		return false, err
+	}
+
+	for _, perm := range perms {
+		if perm.Title == permission {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+const updatePlayerRoleSQL = "UPDATE players SET role_id=$1 WHERE galaxy_id=$2 AND player_id=$3"
+
+// SetRole assigns the galaxy role with the specified title to the player.
+func (p *Player) SetRole(ctx context.Context, title string) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var role *Role
+	if role, err = getRole(tx, title); err != nil {
+		return db.Check(err)
+	}
+
+	if _, err = tx.Exec(updatePlayerRoleSQL, role.ID, p.GalaxyID, p.PlayerID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	p.role = role
+	p.RoleID = role.ID
+	return nil
+}
+
+const deletePlayerSQL = "DELETE FROM players WHERE galaxy_id=$1 AND player_id=$2"
+
+// DeletePlayer removes the user from the galaxy. If the player was the only galaxy
+// admin then the longest standing remaining player is promoted to admin. Returns
+// db.ErrNotFound if the user is not a player in the galaxy.
+func DeletePlayer(ctx context.Context, galaxyID, userID int64) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	if result, err = tx.Exec(deletePlayerSQL, galaxyID, userID); err != nil {
+		return err
+	}
+
+	if nrows, _ := result.RowsAffected(); nrows == 0 {
+		return db.ErrNotFound
+	}
+
+	if _, err = tx.Exec(promoteAdminSQL, galaxyID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/jmoiron/sqlx"
+)
+
+// RateLimitBucket is the token bucket of a rate limited user or client address that is
+// shared by the replicas of the API. The bucket is full at the expires timestamp.
+type RateLimitBucket struct {
+	Key      string    `db:"key"`
+	Tokens   float64   `db:"tokens"`
+	Updated  time.Time `db:"updated"`
+	Expires  time.Time `db:"expires"`
+	Created  time.Time `db:"created"`
+	Modified time.Time `db:"modified"`
+}
+
+const (
+	createRateLimitBucketSQL  = "INSERT INTO rate_limits (key, tokens, updated, expires) VALUES ($1, $2, $3, $3) ON CONFLICT (key) DO NOTHING"
+	lockRateLimitBucketSQL    = "SELECT * FROM rate_limits WHERE key=$1 FOR UPDATE"
+	updateRateLimitBucketSQL  = "UPDATE rate_limits SET tokens=:tokens, updated=:updated, expires=:expires WHERE key=:key"
+	deleteRateLimitBucketsSQL = "DELETE FROM rate_limits WHERE expires<$1"
+)
+
+// UpdateRateLimitBucket locks the bucket of the key so that replicas update it one at a
+// time, creating a bucket with the specified number of tokens if it does not exist. The
+// update function modifies the tokens and timestamps of the bucket, which are saved when
+// the function returns.
+func UpdateRateLimitBucket(ctx context.Context, key string, tokens float64, update func(*RateLimitBucket)) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(createRateLimitBucketSQL, key, tokens, time.Now()); err != nil {
+		return err
+	}
+
+	bucket := &RateLimitBucket{}
+	if err = tx.Get(bucket, lockRateLimitBucketSQL, key); err != nil {
+		return err
+	}
+
+	update(bucket)
+	if _, err = tx.NamedExec(updateRateLimitBucketSQL, bucket); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteExpiredRateLimitBuckets deletes the buckets that were full before the specified
+// time and returns the number of buckets deleted.
+func DeleteExpiredRateLimitBuckets(ctx context.Context, before time.Time) (deleted int64, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	if result, err = tx.Exec(deleteRateLimitBucketsSQL, before); err != nil {
+		return 0, err
+	}
+
+	if deleted, err = result.RowsAffected(); err != nil {
+		return 0, err
+	}
+	return deleted, tx.Commit()
+}
@@ -3,11 +3,13 @@ package models
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/bbengfort/cosmos/pkg/db"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 const defaultRole = "DefaultRole"
@@ -17,6 +19,8 @@ type Role struct {
 	Title       string         `db:"title"`
 	Description sql.NullString `db:"description"`
 	IsDefault   bool           `db:"is_default"`
+	RequireMFA  bool           `db:"require_mfa"`
+	Version     int64          `db:"version"`
 	Created     time.Time      `db:"created"`
 	Modified    time.Time      `db:"modified"`
 	permissions []*Permission
@@ -46,6 +50,10 @@ func GetRole(ctx context.Context, nameOrID any) (role *Role, err error) {
 	return role, err
 }
 
+const (
+	getRoleSQL = "SELECT id, title, description, is_default, require_mfa, version, created, modified FROM roles"
+)
+
 // Get role by ID (int64) or by title (string) from any transaction.
 func getRole(tx *sqlx.Tx, nameOrID any) (role *Role, err error) {
 	var query string
@@ -53,13 +61,13 @@ func getRole(tx *sqlx.Tx, nameOrID any) (role *Role, err error) {
 
 	switch t := nameOrID.(type) {
 	case int64, sql.NullInt64:
-		query = "SELECT * FROM roles WHERE id=$1"
+		query = getRoleSQL + " WHERE id=$1"
 	case string:
 		if t == defaultRole {
-			query = "SELECT * FROM roles WHERE is_default IS true LIMIT 1"
+			query = getRoleSQL + " WHERE is_default IS true LIMIT 1"
 			params = []interface{}{}
 		} else {
-			query = "SELECT * FROM roles WHERE title=$1"
+			query = getRoleSQL + " WHERE title=$1"
 		}
 	default:
 		return nil, fmt.Errorf("unknown role id type %T", nameOrID)
@@ -67,7 +75,7 @@ func getRole(tx *sqlx.Tx, nameOrID any) (role *Role, err error) {
 
 	// Fetch the role
 	role = &Role{}
-	if err = tx.QueryRow(query, params...).Scan(&role.ID, &role.Title, &role.Description, &role.IsDefault, &role.Created, &role.Modified); err != nil {
+	if err = tx.QueryRow(query, params...).Scan(&role.ID, &role.Title, &role.Description, &role.IsDefault, &role.RequireMFA, &role.Version, &role.Created, &role.Modified); err != nil {
 		return nil, err
 	}
 
@@ -120,3 +128,239 @@ func (r *Role) getPermissions(tx *sqlx.Tx) (err error) {
 
 	return rows.Err()
 }
+
+const (
+	listRolesSQL      = getRoleSQL + " WHERE id=ANY($1)"
+	listRolesPermsSQL = "SELECT rp.role_id, p.id, p.title, p.description, p.created, p.modified FROM role_permissions rp JOIN permissions p on rp.permission_id=p.id WHERE rp.role_id=ANY($1)"
+)
+
+// listRolesPermissions returns the roles with the specified IDs along with their
+// permissions, using one query for the roles and one for all of their permissions.
+func listRolesPermissions(tx *sqlx.Tx, roleIDs []int64) (_ map[int64]*Role, err error) {
+	roles := make(map[int64]*Role, len(roleIDs))
+	if len(roleIDs) == 0 {
+		return roles, nil
+	}
+
+	list := make([]*Role, 0, len(roleIDs))
+	if err = tx.Select(&list, listRolesSQL, pq.Array(roleIDs)); err != nil {
+		return nil, err
+	}
+
+	for _, role := range list {
+		role.permissions = make([]*Permission, 0, 4)
+		roles[role.ID] = role
+	}
+
+	var rows *sql.Rows
+	if rows, err = tx.Query(listRolesPermsSQL, pq.Array(roleIDs)); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var roleID int64
+		p := &Permission{}
+		if err = rows.Scan(&roleID, &p.ID, &p.Title, &p.Description, &p.Created, &p.Modified); err != nil {
+			return nil, err
+		}
+
+		if role, ok := roles[roleID]; ok {
+			role.permissions = append(role.permissions, p)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// ListRoles returns all roles along with their permissions.
+func ListRoles(ctx context.Context) (roles []*Role, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	roles = make([]*Role, 0, 4)
+	if err = tx.Select(&roles, getRoleSQL+" ORDER BY id"); err != nil {
+		return nil, err
+	}
+
+	for _, role := range roles {
+		if err = role.getPermissions(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	tx.Commit()
+	return roles, nil
+}
+
+const listPermissionsSQL = "SELECT * FROM permissions ORDER BY id"
+
+// ListPermissions returns all permissions that can be assigned to roles.
+func ListPermissions(ctx context.Context) (permissions []*Permission, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	permissions = make([]*Permission, 0, 8)
+	if err = tx.Select(&permissions, listPermissionsSQL); err != nil {
+		return nil, err
+	}
+
+	tx.Commit()
+	return permissions, nil
+}
+
+const createRoleSQL = "INSERT INTO roles (title, description, is_default, require_mfa, created, modified) VALUES (:title, :description, false, :require_mfa, :created, :modified) RETURNING id"
+
+// CreateRole creates a custom role with the specified permissions. Custom roles can
+// never be the default role assigned to new users.
+func CreateRole(ctx context.Context, role *Role, permissions []string) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	role.IsDefault = false
+	role.Created = time.Now()
+	role.Modified = role.Created
+
+	var (
+		query string
+		args  []interface{}
+	)
+
+	if query, args, err = tx.BindNamed(createRoleSQL, role); err != nil {
+		return err
+	}
+
+	if err = tx.Get(&role.ID, query, args...); err != nil {
+		return err
+	}
+
+	if err = role.setPermissions(tx, permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetPermissions replaces the permissions of the role with the specified permissions.
+func (r *Role) SetPermissions(ctx context.Context, permissions []string) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(deleteRolePermsSQL, r.ID); err != nil {
+		return err
+	}
+
+	if err = r.setPermissions(tx, permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const deleteRoleSQL = "DELETE FROM roles WHERE id=$1 AND is_default IS false RETURNING id"
+
+// DeleteRole deletes a role that is not the default role. Roles that are assigned to
+// users or galaxy players cannot be deleted and return db.ErrInUse when checked.
+func DeleteRole(ctx context.Context, id int64) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = tx.Get(&id, deleteRoleSQL, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const (
+	deleteRolePermsSQL = "DELETE FROM role_permissions WHERE role_id=$1"
+	createRolePermSQL  = "INSERT INTO role_permissions (role_id, permission_id) VALUES ($1, $2)"
+)
+
+func (r *Role) setPermissions(tx *sqlx.Tx, permissions []string) (err error) {
+	for _, permission := range permissions {
+		var permID int64
+		if err = tx.Get(&permID, getPermissionIDSQL, permission); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%w %q", ErrUnknownPermission, permission)
+			}
+			return err
+		}
+
+		if _, err = tx.Exec(createRolePermSQL, r.ID, permID); err != nil {
+			return err
+		}
+	}
+	return r.getPermissions(tx)
+}
+
+// Channels that the database notifies when the permission versions of users and roles
+// change or when tokens are revoked; see the permission versions migration.
+const (
+	PermissionVersionsChannel = "permission_versions"
+	TokenRevocationsChannel   = "token_revocations"
+)
+
+// PermissionVersion identifies the current permissions of a user. The user version is
+// incremented when the user's role changes and the role version is incremented when the
+// permissions of the role change.
+type PermissionVersion struct {
+	UserVersion int64  `db:"perms_version"`
+	Role        string `db:"title"`
+	RoleVersion int64  `db:"version"`
+}
+
+const (
+	getPermissionVersionSQL = "SELECT u.perms_version, r.title, r.version FROM users u JOIN roles r ON u.role_id=r.id WHERE u.id=$1"
+	getRoleVersionSQL       = "SELECT version FROM roles WHERE title=$1"
+)
+
+// GetPermissionVersion returns the current permission version of the user and the
+// user's role. Returns db.ErrNotFound if the user does not exist.
+func GetPermissionVersion(ctx context.Context, userID int64) (version *PermissionVersion, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	version = &PermissionVersion{}
+	if err = tx.Get(version, getPermissionVersionSQL, userID); err != nil {
+		return nil, db.Check(err)
+	}
+
+	tx.Commit()
+	return version, nil
+}
+
+// GetRoleVersion returns the current version of the role's permissions. Returns
+// db.ErrNotFound if the role does not exist.
+func GetRoleVersion(ctx context.Context, title string) (version int64, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err = tx.Get(&version, getRoleVersionSQL, title); err != nil {
+		return 0, db.Check(err)
+	}
+
+	tx.Commit()
+	return version, nil
+}
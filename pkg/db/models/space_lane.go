@@ -1,12 +1,40 @@
 package models
 
-import "time"
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
 
 type SpaceLane struct {
-	OriginID int64
-	TargetID int64
-	Distance int16
-	Hazards  int16
-	Created  time.Time
-	Modified time.Time
+	OriginID int64     `db:"origin_id"`
+	TargetID int64     `db:"target_id"`
+	Distance int16     `db:"distance"`
+	Hazards  int16     `db:"hazards"`
+	Created  time.Time `db:"created"`
+	Modified time.Time `db:"modified"`
+}
+
+const listSpaceLanesSQL = "SELECT * FROM space_lanes WHERE origin_id=ANY($1) ORDER BY origin_id, target_id"
+
+// ListSpaceLanes returns the space lanes that originate from all of the specified systems
+// ordered by origin so that the lanes of many systems can be loaded with a single query.
+func ListSpaceLanes(ctx context.Context, originIDs []int64) (lanes []*SpaceLane, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lanes = make([]*SpaceLane, 0)
+	if err = tx.Select(&lanes, listSpaceLanesSQL, pq.Array(originIDs)); err != nil {
+		return nil, err
+	}
+
+	tx.Commit()
+	return lanes, nil
 }
@@ -1,20 +1,66 @@
 package models
 
 import (
+	"context"
+	"database/sql"
 	"time"
 
+	"github.com/bbengfort/cosmos/pkg/db"
 	"github.com/bbengfort/cosmos/pkg/enums"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type System struct {
-	ID           int64
-	GalaxyID     int64
-	Name         string
-	IsHomeSystem bool
-	StarClass    enums.StarClass
-	SystemRadius int16
-	WarpGate     int16
-	Shipyard     int16
-	Created      time.Time
-	Modified     time.Time
+	ID           int64           `db:"id"`
+	GalaxyID     int64           `db:"galaxy_id"`
+	Name         string          `db:"name"`
+	IsHomeSystem bool            `db:"is_home_system"`
+	StarClass    enums.StarClass `db:"star_class"`
+	SystemRadius int16           `db:"system_radius"`
+	WarpGate     int16           `db:"warp_gate"`
+	Shipyard     int16           `db:"shipyard"`
+	Created      time.Time       `db:"created"`
+	Modified     time.Time       `db:"modified"`
+}
+
+const (
+	listSystemsSQL = "SELECT * FROM systems WHERE galaxy_id=ANY($1) ORDER BY galaxy_id, id"
+	getSystemsSQL  = "SELECT * FROM systems WHERE id=ANY($1) ORDER BY id"
+)
+
+// ListSystems returns the systems of all of the specified galaxies ordered by galaxy so
+// that the systems of many galaxies can be loaded with a single query.
+func ListSystems(ctx context.Context, galaxyIDs []int64) (systems []*System, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	systems = make([]*System, 0)
+	if err = tx.Select(&systems, listSystemsSQL, pq.Array(galaxyIDs)); err != nil {
+		return nil, err
+	}
+
+	tx.Commit()
+	return systems, nil
+}
+
+// GetSystems returns the systems with the specified IDs; IDs that do not exist are
+// ignored rather than returning db.ErrNotFound.
+func GetSystems(ctx context.Context, ids []int64) (systems []*System, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	systems = make([]*System, 0)
+	if err = tx.Select(&systems, getSystemsSQL, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+
+	tx.Commit()
+	return systems, nil
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/bbengfort/cosmos/pkg/db"
@@ -11,20 +12,24 @@ import (
 )
 
 type User struct {
-	ID        int64          `db:"id"`
-	Name      sql.NullString `db:"name"`
-	Email     string         `db:"email"`
-	Password  string         `db:"password"`
-	RoleID    sql.NullInt64  `db:"role_id"`
-	LastLogin sql.NullTime   `db:"last_login"`
-	Created   time.Time      `db:"created"`
-	Modified  time.Time      `db:"modified"`
-	role      *Role
+	ID          int64          `db:"id"`
+	Name        sql.NullString `db:"name"`
+	Email       string         `db:"email"`
+	Password    string         `db:"password"`
+	RoleID      sql.NullInt64  `db:"role_id"`
+	LastLogin   sql.NullTime   `db:"last_login"`
+	Disabled    bool           `db:"disabled"`
+	HasPassword bool           `db:"has_password"`
+	Version     int64          `db:"perms_version"`
+	Created     time.Time      `db:"created"`
+	Modified    time.Time      `db:"modified"`
+	role        *Role
+	roleTitle   sql.NullString
 }
 
 const (
-	createUserSQL     = "INSERT INTO users (name, email, password, role_id, last_login) VALUES (:name, :email, :password, :role_id, :last_login);"
-	popCreatedUserSQL = "SELECT id, created, modified FROM users WHERE email=$1"
+	createUserSQL     = "INSERT INTO users (name, email, password, role_id, last_login, has_password) VALUES (:name, :email, :password, :role_id, :last_login, :has_password);"
+	popCreatedUserSQL = "SELECT id, perms_version, created, modified FROM users WHERE email=$1"
 )
 
 // Create a new user in the database.
@@ -35,6 +40,14 @@ func CreateUser(ctx context.Context, user *User) (err error) {
 	}
 	defer tx.Rollback()
 
+	user.HasPassword = true
+	if err = createUser(tx, user); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func createUser(tx *sqlx.Tx, user *User) (err error) {
 	// Assign the user to the default role in the database
 	if user.role, err = getRole(tx, defaultRole); err != nil {
 		return fmt.Errorf("could not get default role: %w", err)
@@ -50,11 +63,7 @@ func CreateUser(ctx context.Context, user *User) (err error) {
 	}
 
 	// Populate the final fields for creating the user
-	if err = tx.QueryRowx(popCreatedUserSQL, user.Email).StructScan(user); err != nil {
-		return err
-	}
-
-	return tx.Commit()
+	return tx.QueryRowx(popCreatedUserSQL, user.Email).StructScan(user)
 }
 
 // Get user by ID (int64) or by email (string).
@@ -105,6 +114,25 @@ func (u *User) Role(ctx context.Context) (_ *Role, err error) {
 	return u.role, nil
 }
 
+// RoleTitle returns the title of the user's role. Users that were listed have the title
+// of their role joined by the list query so their roles are not fetched.
+func (u *User) RoleTitle(ctx context.Context) (_ string, err error) {
+	if u.role == nil {
+		switch {
+		case u.roleTitle.Valid:
+			return u.roleTitle.String, nil
+		case !u.RoleID.Valid:
+			return "", db.ErrNotFound
+		}
+	}
+
+	var role *Role
+	if role, err = u.Role(ctx); err != nil {
+		return "", err
+	}
+	return role.Title, nil
+}
+
 func (u *User) Permissions(ctx context.Context) (_ []*Permission, err error) {
 	var role *Role
 	if role, err = u.Role(ctx); err != nil {
@@ -128,3 +156,205 @@ func (u *User) LoggedIn(ctx context.Context) (err error) {
 	}
 	return tx.Commit()
 }
+
+const updatePasswordSQL = "UPDATE users SET password=:password, has_password=true WHERE id=:id"
+
+// UpdatePassword replaces the derived key of the user's password. Users that signed up
+// with an external identity provider have a password once it is updated.
+func (u *User) UpdatePassword(ctx context.Context, dk string) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	u.Password = dk
+	u.HasPassword = true
+	if _, err = tx.NamedExec(updatePasswordSQL, u); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UserQuery filters and paginates the users returned by ListUsers.
+type UserQuery struct {
+	Search string // case insensitive match on the user's email or name
+	Role   string // only return users with the specified role title
+	Limit  int
+	Offset int
+}
+
+// ListUsers returns the users matching the query ordered by ID, along with their roles.
+func ListUsers(ctx context.Context, query *UserQuery) (users []*User, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		conditions []string
+		params     []interface{}
+	)
+
+	if query.Search != "" {
+		params = append(params, "%"+strings.ToLower(query.Search)+"%")
+		conditions = append(conditions, fmt.Sprintf("(LOWER(u.email) LIKE $%d OR LOWER(u.name) LIKE $%d)", len(params), len(params)))
+	}
+
+	if query.Role != "" {
+		params = append(params, query.Role)
+		conditions = append(conditions, fmt.Sprintf("r.title=$%d", len(params)))
+	}
+
+	stmt := "SELECT u.*, r.title AS role_title FROM users u LEFT JOIN roles r ON u.role_id=r.id"
+	if len(conditions) > 0 {
+		stmt += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	params = append(params, query.Limit, query.Offset)
+	stmt += fmt.Sprintf(" ORDER BY u.id LIMIT $%d OFFSET $%d", len(params)-1, len(params))
+
+	rows := make([]*listedUser, 0, query.Limit)
+	if err = tx.Select(&rows, stmt, params...); err != nil {
+		return nil, err
+	}
+
+	users = make([]*User, 0, len(rows))
+	for _, row := range rows {
+		row.User.roleTitle = row.RoleTitle
+		users = append(users, &row.User)
+	}
+
+	tx.Commit()
+	return users, nil
+}
+
+// listedUser is a user with the title of their role joined by the list query.
+type listedUser struct {
+	User
+	RoleTitle sql.NullString `db:"role_title"`
+}
+
+const updateUserRoleSQL = "UPDATE users SET role_id=$1 WHERE id=$2 RETURNING perms_version"
+
+// SetRole assigns the role with the specified title to the user, which increments the
+// version of the user's permissions.
+func (u *User) SetRole(ctx context.Context, title string) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var role *Role
+	if role, err = getRole(tx, title); err != nil {
+		return err
+	}
+
+	var version int64
+	if err = tx.Get(&version, updateUserRoleSQL, role.ID, u.ID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	u.role = role
+	u.RoleID = sql.NullInt64{Valid: true, Int64: role.ID}
+	u.Version = version
+	return nil
+}
+
+const updateUserDisabledSQL = "UPDATE users SET disabled=$1 WHERE id=$2 RETURNING perms_version"
+
+// SetDisabled disables or re-enables the user's account, which increments the version
+// of the user's permissions.
+func (u *User) SetDisabled(ctx context.Context, disabled bool) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int64
+	if err = tx.Get(&version, updateUserDisabledSQL, disabled, u.ID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	u.Disabled = disabled
+	u.Version = version
+	return nil
+}
+
+const updateUserNameSQL = "UPDATE users SET name=:name WHERE id=:id"
+
+// SetName updates the display name of the user.
+func (u *User) SetName(ctx context.Context, name string) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	u.Name = sql.NullString{Valid: name != "", String: name}
+	if _, err = tx.NamedExec(updateUserNameSQL, u); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const (
+	userGalaxiesSQL   = "SELECT galaxy_id FROM players WHERE player_id=$1"
+	deleteUserSQL     = "DELETE FROM users WHERE id=$1"
+	deleteUserLogins  = "DELETE FROM login_attempts WHERE key=$1"
+	deleteEmptyGalaxy = "DELETE FROM galaxies g WHERE g.id=$1 AND NOT EXISTS (SELECT 1 FROM players p WHERE p.galaxy_id=g.id)"
+	promoteAdminSQL   = "UPDATE players SET role_id=1 WHERE galaxy_id=$1 AND player_id=(SELECT player_id FROM players WHERE galaxy_id=$1 ORDER BY created LIMIT 1) AND NOT EXISTS (SELECT 1 FROM players WHERE galaxy_id=$1 AND role_id=1)"
+)
+
+// DeleteUser deletes the user's account and everything that the user owns. The user
+// is removed from all of their galaxies: galaxies without any remaining players are
+// deleted and galaxies that have lost their only admin have their longest standing
+// player promoted to admin. The audit log is retained with the user ID set to null.
+func DeleteUser(ctx context.Context, userID int64) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	galaxies := make([]int64, 0)
+	if err = tx.Select(&galaxies, userGalaxiesSQL, userID); err != nil {
+		return err
+	}
+
+	var result sql.Result
+	if result, err = tx.Exec(deleteUserSQL, userID); err != nil {
+		return err
+	}
+
+	if nrows, _ := result.RowsAffected(); nrows == 0 {
+		return sql.ErrNoRows
+	}
+
+	// The user's players are deleted by the cascade on the players table
+	for _, galaxyID := range galaxies {
+		if _, err = tx.Exec(deleteEmptyGalaxy, galaxyID); err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(promoteAdminSQL, galaxyID); err != nil {
+			return err
+		}
+	}
+
+	if _, err = tx.Exec(deleteUserLogins, UserLoginKey(userID)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
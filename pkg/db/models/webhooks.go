@@ -0,0 +1,275 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/bbengfort/cosmos/pkg/pagination"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Status of webhook deliveries.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook delivers the galaxy events of a galaxy, or of all of the galaxies the user is
+// playing in if the galaxy ID is null, to the URL. If event types are specified then
+// only events of those types are delivered. Deliveries are queued by a database trigger
+// when events are recorded; see the webhooks migration.
+type Webhook struct {
+	ID         int64          `db:"id"`
+	UserID     int64          `db:"user_id"`
+	GalaxyID   sql.NullInt64  `db:"galaxy_id"`
+	URL        string         `db:"url"`
+	Secret     string         `db:"secret"`
+	EventTypes pq.StringArray `db:"event_types"`
+	Created    time.Time      `db:"created"`
+	Modified   time.Time      `db:"modified"`
+}
+
+const createWebhookSQL = "INSERT INTO webhooks (user_id, galaxy_id, url, secret, event_types, created, modified) VALUES (:user_id, :galaxy_id, :url, :secret, :event_types, :created, :modified) RETURNING id"
+
+// CreateWebhook creates the webhook, setting its ID. The caller is responsible for
+// ensuring the user is a player of the galaxy.
+func CreateWebhook(ctx context.Context, webhook *Webhook) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if webhook.EventTypes == nil {
+		webhook.EventTypes = pq.StringArray{}
+	}
+	webhook.Created = time.Now()
+	webhook.Modified = webhook.Created
+
+	var (
+		query string
+		args  []interface{}
+	)
+
+	if query, args, err = tx.BindNamed(createWebhookSQL, webhook); err != nil {
+		return err
+	}
+
+	if err = tx.Get(&webhook.ID, query, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const (
+	getWebhookSQL    = "SELECT * FROM webhooks WHERE id=$1 AND user_id=$2"
+	listWebhooksSQL  = "SELECT * FROM webhooks WHERE user_id=$1 ORDER BY created"
+	deleteWebhookSQL = "DELETE FROM webhooks WHERE id=$1 AND user_id=$2"
+)
+
+// GetWebhook returns the webhook if it is owned by the user or db.ErrNotFound.
+func GetWebhook(ctx context.Context, userID, webhookID int64) (webhook *Webhook, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	webhook = &Webhook{}
+	if err = tx.Get(webhook, getWebhookSQL, webhookID, userID); err != nil {
+		return nil, db.Check(err)
+	}
+
+	tx.Commit()
+	return webhook, nil
+}
+
+// ListWebhooks returns all of the webhooks owned by the user.
+func ListWebhooks(ctx context.Context, userID int64) (webhooks []*Webhook, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	webhooks = make([]*Webhook, 0)
+	if err = tx.Select(&webhooks, listWebhooksSQL, userID); err != nil {
+		return nil, err
+	}
+
+	tx.Commit()
+	return webhooks, nil
+}
+
+// DeleteWebhook deletes the webhook and its deliveries if it is owned by the user.
+// Returns db.ErrNotFound if the user does not own the webhook.
+func DeleteWebhook(ctx context.Context, userID, webhookID int64) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	if result, err = tx.Exec(deleteWebhookSQL, webhookID, userID); err != nil {
+		return err
+	}
+
+	if nrows, _ := result.RowsAffected(); nrows == 0 {
+		return db.ErrNotFound
+	}
+	return tx.Commit()
+}
+
+// WebhookDelivery is an entry in the delivery log of a webhook. The attempts include
+// the attempt that is in progress; the response status and error are of the most
+// recent attempt that completed.
+type WebhookDelivery struct {
+	ID             int64          `db:"id"`
+	WebhookID      int64          `db:"webhook_id"`
+	EventID        int64          `db:"event_id"`
+	Status         string         `db:"status"`
+	Attempts       int            `db:"attempts"`
+	NextAttempt    time.Time      `db:"next_attempt"`
+	ResponseStatus sql.NullInt64  `db:"response_status"`
+	Error          sql.NullString `db:"error"`
+	Delivered      sql.NullTime   `db:"delivered"`
+	Created        time.Time      `db:"created"`
+	Modified       time.Time      `db:"modified"`
+}
+
+// WebhookDeliveryQuery fetches a page of the delivery log of a webhook, most recent
+// deliveries first.
+type WebhookDeliveryQuery struct {
+	WebhookID int64
+	Cursor    *pagination.Cursor // the last delivery of the previous page
+	PageSize  int
+}
+
+// The delivery log is always ordered by descending ID
+var deliveryOrder = pagination.Order{Field: "id", Descending: true}
+
+// ListWebhookDeliveries returns a page of the delivery log of the webhook along with the
+// cursor of the next page, which is nil if there are no more deliveries.
+func ListWebhookDeliveries(ctx context.Context, query *WebhookDeliveryQuery) (deliveries []*WebhookDelivery, next *pagination.Cursor, err error) {
+	filter := pagination.Filter("webhook:" + strconv.FormatInt(query.WebhookID, 10))
+	if query.Cursor != nil {
+		if err = query.Cursor.Check(deliveryOrder, filter); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	params := []interface{}{query.WebhookID}
+	stmt := "SELECT * FROM webhook_deliveries WHERE webhook_id=$1"
+	if query.Cursor != nil {
+		params = append(params, query.Cursor.ID)
+		stmt += fmt.Sprintf(" AND id %s $%d", deliveryOrder.Comparison(), len(params))
+	}
+
+	// Fetch one more delivery than the page size to determine if there is a next page
+	params = append(params, query.PageSize+1)
+	stmt += fmt.Sprintf(" ORDER BY id %s LIMIT $%d", deliveryOrder.Direction(), len(params))
+
+	deliveries = make([]*WebhookDelivery, 0, query.PageSize+1)
+	if err = tx.Select(&deliveries, stmt, params...); err != nil {
+		return nil, nil, err
+	}
+
+	if len(deliveries) > query.PageSize {
+		deliveries = deliveries[:query.PageSize]
+		last := deliveries[len(deliveries)-1]
+		next = pagination.New(deliveryOrder, strconv.FormatInt(last.ID, 10), last.ID, filter)
+	}
+
+	tx.Commit()
+	return deliveries, next, nil
+}
+
+// PendingDelivery is a delivery that has been claimed from the delivery queue along
+// with the webhook and event that are required to deliver it.
+type PendingDelivery struct {
+	ID           int64     `db:"id"`
+	WebhookID    int64     `db:"webhook_id"`
+	Attempts     int       `db:"attempts"`
+	URL          string    `db:"url"`
+	Secret       string    `db:"secret"`
+	EventID      int64     `db:"event_id"`
+	GalaxyID     int64     `db:"galaxy_id"`
+	EventType    string    `db:"event_type"`
+	Data         string    `db:"data"`
+	EventCreated time.Time `db:"event_created"`
+}
+
+const claimWebhookDeliveriesSQL = `WITH claimed AS (
+	UPDATE webhook_deliveries SET attempts=attempts+1, next_attempt=$1, modified=$2
+	WHERE id IN (
+		SELECT id FROM webhook_deliveries WHERE status='pending' AND next_attempt<=$2
+		ORDER BY next_attempt LIMIT $3 FOR UPDATE SKIP LOCKED
+	) RETURNING id, webhook_id, event_id, attempts
+)
+SELECT c.id, c.webhook_id, c.attempts, w.url, w.secret, e.id AS event_id, e.galaxy_id, e.event_type, e.data, e.created AS event_created
+FROM claimed c JOIN webhooks w ON c.webhook_id=w.id JOIN galaxy_events e ON c.event_id=e.id
+ORDER BY c.id`
+
+// ClaimWebhookDeliveries claims up to limit pending deliveries that are due for the
+// lease duration. Replicas skip deliveries that are being claimed by another replica,
+// and if the delivery is not completed before the lease expires it becomes due again.
+func ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) (deliveries []*PendingDelivery, err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	deliveries = make([]*PendingDelivery, 0)
+	if err = tx.Select(&deliveries, claimWebhookDeliveriesSQL, now.Add(lease), now, limit); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// WebhookAttempt is the outcome of an attempt to deliver a claimed delivery. If the
+// status is pending the delivery is retried at the next attempt.
+type WebhookAttempt struct {
+	DeliveryID     int64          `db:"id"`
+	Status         string         `db:"status"`
+	NextAttempt    time.Time      `db:"next_attempt"`
+	ResponseStatus sql.NullInt64  `db:"response_status"`
+	Error          sql.NullString `db:"error"`
+	Delivered      sql.NullTime   `db:"delivered"`
+	Modified       time.Time      `db:"modified"`
+}
+
+const recordWebhookAttemptSQL = "UPDATE webhook_deliveries SET status=:status, next_attempt=:next_attempt, response_status=:response_status, error=:error, delivered=:delivered, modified=:modified WHERE id=:id"
+
+// RecordWebhookAttempt updates the delivery log with the outcome of a delivery attempt.
+func RecordWebhookAttempt(ctx context.Context, attempt *WebhookAttempt) (err error) {
+	var tx *sqlx.Tx
+	if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: false}); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	attempt.Modified = time.Now()
+	if _, err = tx.NamedExec(recordWebhookAttemptSQL, attempt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -34,6 +34,91 @@ func TestMigrations(t *testing.T) {
 			Name: "Galaxies",
 			Path: "0003_galaxies.sql",
 		},
+		{
+			ID:   4,
+			Name: "Login Security",
+			Path: "0004_login_security.sql",
+		},
+		{
+			ID:   5,
+			Name: "Mfa",
+			Path: "0005_mfa.sql",
+		},
+		{
+			ID:   6,
+			Name: "Api Keys",
+			Path: "0006_api_keys.sql",
+		},
+		{
+			ID:   7,
+			Name: "External Identities",
+			Path: "0007_external_identities.sql",
+		},
+		{
+			ID:   8,
+			Name: "User Admin",
+			Path: "0008_user_admin.sql",
+		},
+		{
+			ID:   9,
+			Name: "Account Management",
+			Path: "0009_account_management.sql",
+		},
+		{
+			ID:   10,
+			Name: "Permission Versions",
+			Path: "0010_permission_versions.sql",
+		},
+		{
+			ID:   11,
+			Name: "Galaxy Permissions",
+			Path: "0011_galaxy_permissions.sql",
+		},
+		{
+			ID:   12,
+			Name: "Revoked Tokens",
+			Path: "0012_revoked_tokens.sql",
+		},
+		{
+			ID:   13,
+			Name: "Galaxy Events",
+			Path: "0013_galaxy_events.sql",
+		},
+		{
+			ID:   14,
+			Name: "Webhooks",
+			Path: "0014_webhooks.sql",
+		},
+		{
+			ID:   15,
+			Name: "Rate Limits",
+			Path: "0015_rate_limits.sql",
+		},
+		{
+			ID:   16,
+			Name: "Idempotency Keys",
+			Path: "0016_idempotency_keys.sql",
+		},
+		{
+			ID:   17,
+			Name: "Galaxy Event Order",
+			Path: "0017_galaxy_event_order.sql",
+		},
+		{
+			ID:   18,
+			Name: "Idempotency Leases",
+			Path: "0018_idempotency_leases.sql",
+		},
+		{
+			ID:   19,
+			Name: "Idempotency Headers",
+			Path: "0019_idempotency_headers.sql",
+		},
+		{
+			ID:   20,
+			Name: "Revoked Clients",
+			Path: "0020_revoked_clients.sql",
+		},
 	}
 
 	for i, migration := range migrations {
@@ -37,7 +37,7 @@ func (s GameState) Value() (driver.Value, error) {
 // Scanner interface
 //=====================================================================================
 
-func (s *GameState) Scan(value interface{}) error {
+func (s *GameState) Scan(value interface{}) (err error) {
 	// If value is nil set size to unknown
 	if value == nil {
 		*s = UnknownGameState
@@ -45,26 +45,26 @@ func (s *GameState) Scan(value interface{}) error {
 	}
 
 	// Convert the value to a string
-	if sv, err := driver.String.ConvertValue(value); err == nil {
-		if v, ok := sv.([]byte); ok {
-			// Parse the value of v
-			switch string(v) {
-			case "unknown":
-				*s = UnknownGameState
-			case "pending":
-				*s = Pending
-			case "playing":
-				*s = Playing
-			case "completed":
-				*s = Completed
-			default:
-				return ErrScanGameState
-			}
-			return nil
-		}
+	switch v := value.(type) {
+	case []byte:
+		*s, err = ParseGameState(string(v))
+	case string:
+		*s, err = ParseGameState(v)
+	default:
+		return ErrScanGameState
 	}
+	return err
+}
 
-	return ErrScanGameState
+// ParseGameState returns the game state with the specified name (case insensitive).
+func ParseGameState(name string) (GameState, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, sn := range gameStateNames {
+		if sn == name {
+			return GameState(i), nil
+		}
+	}
+	return UnknownGameState, ErrScanGameState
 }
 
 //=====================================================================================
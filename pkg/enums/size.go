@@ -80,7 +80,7 @@ func (s Size) Value() (driver.Value, error) {
 // Scanner interface
 //=====================================================================================
 
-func (s *Size) Scan(value interface{}) error {
+func (s *Size) Scan(value interface{}) (err error) {
 	// If value is nil set size to unknown
 	if value == nil {
 		*s = UnknownSize
@@ -88,29 +88,26 @@ func (s *Size) Scan(value interface{}) error {
 	}
 
 	// Convert the value to a string
-	if sv, err := driver.String.ConvertValue(value); err == nil {
-		if v, ok := sv.([]byte); ok {
-			// Parse the value of v
-			switch string(v) {
-			case "unknown":
-				*s = UnknownSize
-			case "small":
-				*s = Small
-			case "medium":
-				*s = Medium
-			case "large":
-				*s = Large
-			case "galactic":
-				*s = Galactic
-			case "cosmic":
-				*s = Cosmic
-			default:
-				return ErrScanSize
-			}
-			return nil
+	switch v := value.(type) {
+	case []byte:
+		*s, err = ParseSize(string(v))
+	case string:
+		*s, err = ParseSize(v)
+	default:
+		return ErrScanSize
+	}
+	return err
+}
+
+// ParseSize returns the size with the specified name (case insensitive).
+func ParseSize(name string) (Size, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, sn := range sizeNames {
+		if sn == name {
+			return Size(i), nil
 		}
 	}
-	return ErrScanSize
+	return UnknownSize, ErrScanSize
 }
 
 //=====================================================================================
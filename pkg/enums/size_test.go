@@ -1,6 +1,7 @@
 package enums_test
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/bbengfort/cosmos/pkg/enums"
@@ -36,4 +37,37 @@ func TestSize(t *testing.T) {
 		}
 	})
 
+	t.Run("Parse", func(t *testing.T) {
+		testCases := []struct {
+			name     string
+			expected enums.Size
+		}{
+			{"small", enums.Small},
+			{"Medium", enums.Medium},
+			{" LARGE ", enums.Large},
+			{"galactic", enums.Galactic},
+			{"cosmic", enums.Cosmic},
+		}
+
+		for i, tc := range testCases {
+			size, err := enums.ParseSize(tc.name)
+			require.NoError(t, err, "test case %d failed", i)
+			require.Equal(t, tc.expected, size, "test case %d failed", i)
+		}
+
+		_, err := enums.ParseSize("huge")
+		require.ErrorIs(t, err, enums.ErrScanSize)
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var size enums.Size
+		require.NoError(t, json.Unmarshal([]byte(`"galactic"`), &size))
+		require.Equal(t, enums.Galactic, size)
+
+		data, err := json.Marshal(size)
+		require.NoError(t, err)
+		require.Equal(t, `"galactic"`, string(data))
+
+		require.ErrorIs(t, json.Unmarshal([]byte(`"huge"`), &size), enums.ErrScanSize)
+	})
 }
@@ -0,0 +1,177 @@
+package graph
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/bbengfort/cosmos/pkg/pagination"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+// Estimates of the number of items returned by list fields, which multiply the
+// complexity of their selections. The number of galaxies is the page size of the
+// query; the other estimates are typical rather than worst case values so that
+// reasonable queries of a single galaxy are not rejected.
+const (
+	estimatedSystems   = 200
+	estimatedPlayers   = 20
+	estimatedPlanets   = 10
+	estimatedAsteroids = 5
+	estimatedLanes     = 6
+)
+
+// multipliers of the fields of the schema by type and field name.
+var multipliers = map[string]func(args map[string]int) int{
+	"Query.galaxies": func(args map[string]int) int {
+		if size, err := pagination.PageSize(args["pageSize"]); err == nil {
+			return size
+		}
+		return pagination.DefaultPageSize
+	},
+	"Galaxy.systems":   fixed(estimatedSystems),
+	"Galaxy.players":   fixed(estimatedPlayers),
+	"System.planets":   fixed(estimatedPlanets),
+	"System.asteroids": fixed(estimatedAsteroids),
+	"System.lanes":     fixed(estimatedLanes),
+}
+
+func fixed(n int) func(map[string]int) int {
+	return func(map[string]int) int { return n }
+}
+
+// Complexity returns the estimated complexity of the operation of a valid query. Each
+// field costs one and the cost of the fields of a list is multiplied by the estimated
+// number of items in the list.
+func (s *Schema) Complexity(query, operationName string, variables map[string]interface{}) (_ int, err error) {
+	var doc *ast.Document
+	if doc, err = parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(query), Name: "GraphQL request"}),
+	}); err != nil {
+		return 0, err
+	}
+	return s.complexity(doc, operationName, variables)
+}
+
+func (s *Schema) complexity(doc *ast.Document, operationName string, variables map[string]interface{}) (int, error) {
+	var operation *ast.OperationDefinition
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, def := range doc.Definitions {
+		switch def := def.(type) {
+		case *ast.OperationDefinition:
+			if operationName == "" || (def.Name != nil && def.Name.Value == operationName) {
+				if operation != nil && operationName == "" {
+					return 0, errors.New("must provide operation name if query contains multiple operations")
+				}
+				operation = def
+			}
+		case *ast.FragmentDefinition:
+			fragments[def.Name.Value] = def
+		}
+	}
+
+	if operation == nil {
+		return 0, fmt.Errorf("unknown operation named %q", operationName)
+	}
+
+	var root graphql.Type
+	switch operation.Operation {
+	case ast.OperationTypeQuery:
+		root = s.schema.QueryType()
+	case ast.OperationTypeMutation:
+		root = s.schema.MutationType()
+	}
+
+	c := &complexity{schema: &s.schema, fragments: fragments, variables: variables}
+	return c.selections(root, operation.SelectionSet), nil
+}
+
+type complexity struct {
+	schema    *graphql.Schema
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+}
+
+// selections returns the cost of the selections of the parent type, which is nil for
+// the fields of the introspection types.
+func (c *complexity) selections(parent graphql.Type, set *ast.SelectionSet) (cost int) {
+	if set == nil {
+		return 0
+	}
+
+	for _, selection := range set.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			cost += c.field(parent, selection)
+		case *ast.InlineFragment:
+			typ := parent
+			if selection.TypeCondition != nil {
+				typ = c.schema.Type(selection.TypeCondition.Name.Value)
+			}
+			cost += c.selections(typ, selection.SelectionSet)
+		case *ast.FragmentSpread:
+			if fragment, ok := c.fragments[selection.Name.Value]; ok {
+				cost += c.selections(c.schema.Type(fragment.TypeCondition.Name.Value), fragment.SelectionSet)
+			}
+		}
+	}
+	return cost
+}
+
+func (c *complexity) field(parent graphql.Type, field *ast.Field) int {
+	var (
+		child      graphql.Type
+		multiplier = 1
+	)
+
+	if object, ok := parent.(*graphql.Object); ok {
+		if def, ok := object.Fields()[field.Name.Value]; ok {
+			child, _ = graphql.GetNamed(def.Type).(graphql.Type)
+			if multiply, ok := multipliers[object.Name()+"."+field.Name.Value]; ok {
+				multiplier = multiply(c.arguments(field))
+			}
+		}
+	}
+	return multiplier * (1 + c.selections(child, field.SelectionSet))
+}
+
+// arguments returns the integer arguments of the field from literals or variables.
+func (c *complexity) arguments(field *ast.Field) map[string]int {
+	args := make(map[string]int, len(field.Arguments))
+	for _, arg := range field.Arguments {
+		var value interface{}
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			value = v.Value
+		case *ast.Variable:
+			value = c.variables[v.Name.Value]
+		}
+
+		if n, ok := integer(value); ok {
+			args[arg.Name.Value] = n
+		}
+	}
+	return args
+}
+
+func integer(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	case json.Number:
+		n, err := v.Int64()
+		return int(n), err == nil
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,211 @@
+/*
+Package graph implements the GraphQL API of cosmos, which allows clients to fetch a
+galaxy with its systems, planets, asteroids, space lanes, and players in one request.
+Fields are authorized with the galaxy permissions of the user, the database queries of
+each request are batched by dataloaders, and queries that are estimated to be too
+expensive are rejected before they are executed.
+*/
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/rs/zerolog/log"
+)
+
+// Schema executes GraphQL queries against the galaxies of the user.
+type Schema struct {
+	schema        graphql.Schema
+	maxComplexity int
+}
+
+// New creates the GraphQL schema; queries whose complexity is greater than the maximum
+// complexity are rejected.
+func New(maxComplexity int) (_ *Schema, err error) {
+	s := &Schema{maxComplexity: maxComplexity}
+	if s.schema, err = newSchema(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Execute the query with the claims of the user; the version checker is used to check
+// the claims of moderators like AuthorizePlayer. The reply has no data if the query
+// could not be parsed, is invalid, or is too complex; otherwise the fields that could
+// not be resolved are null and described by the errors of the reply.
+func (s *Schema) Execute(ctx context.Context, claims *auth.Claims, versions auth.VersionChecker, in *api.GraphQLRequest) *api.GraphQLReply {
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(in.Query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		return reply(&graphql.Result{Errors: gqlerrors.FormatErrors(err)})
+	}
+
+	if validation := graphql.ValidateDocument(&s.schema, doc, nil); !validation.IsValid {
+		return reply(&graphql.Result{Errors: validation.Errors})
+	}
+
+	var complexity int
+	if complexity, err = s.complexity(doc, in.OperationName, in.Variables); err != nil {
+		return reply(&graphql.Result{Errors: gqlerrors.FormatErrors(err)})
+	}
+
+	if complexity > s.maxComplexity {
+		err = fmt.Errorf("query complexity %d is greater than the maximum complexity %d", complexity, s.maxComplexity)
+		return reply(&graphql.Result{Errors: gqlerrors.FormatErrors(err)})
+	}
+
+	ctx = context.WithValue(ctx, requestKey, &request{
+		viewer:  newViewer(claims, versions),
+		loaders: newLoaders(),
+	})
+
+	return reply(graphql.Execute(graphql.ExecuteParams{
+		Schema:        s.schema,
+		AST:           doc,
+		OperationName: in.OperationName,
+		Args:          in.Variables,
+		Context:       ctx,
+	}))
+}
+
+func reply(result *graphql.Result) *api.GraphQLReply {
+	out := &api.GraphQLReply{Data: result.Data}
+	for _, err := range result.Errors {
+		e := &api.GraphQLError{Message: err.Message, Path: err.Path}
+		for _, loc := range err.Locations {
+			e.Locations = append(e.Locations, api.GraphQLLocation{Line: loc.Line, Column: loc.Column})
+		}
+		out.Errors = append(out.Errors, e)
+	}
+	return out
+}
+
+type contextKey uint8
+
+const requestKey contextKey = iota
+
+// request holds the state of a single request that is shared by the resolvers.
+type request struct {
+	viewer  *viewer
+	loaders *loaders
+}
+
+func getRequest(ctx context.Context) *request {
+	return ctx.Value(requestKey).(*request)
+}
+
+// viewer authorizes the user to access galaxies and caches the result for each galaxy
+// and permission. The players of the user are fetched with their galaxy roles and the
+// permissions of the roles by a loader, so that the user is authorized in all of the
+// galaxies at the same depth of the query with a single batch of queries.
+type viewer struct {
+	sync.Mutex
+	claims   *auth.Claims
+	versions auth.VersionChecker
+	userID   int64
+	players  *loader[*models.Player]
+	grants   map[grant]error
+}
+
+type grant struct {
+	galaxyID   int64
+	permission string
+}
+
+func newViewer(claims *auth.Claims, versions auth.VersionChecker) *viewer {
+	// Claims without a user ID are rejected when the user is authorized
+	userID, _ := claims.SubjectID()
+	v := &viewer{claims: claims, versions: versions, userID: userID, grants: make(map[grant]error)}
+	v.players = newLoader(func(ctx context.Context, galaxyIDs []int64) (map[int64]*models.Player, error) {
+		players, err := models.ListUserPlayers(ctx, userID, galaxyIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		values := make(map[int64]*models.Player, len(players))
+		for _, player := range players {
+			values[player.GalaxyID] = player
+		}
+		return values, nil
+	})
+	return v
+}
+
+// permitted returns an error if the user does not have the global permission or if the
+// permissions in their claims are stale like the Authorize middleware.
+func (v *viewer) permitted(ctx context.Context, permission string) (err error) {
+	if err = auth.CheckVersions(ctx, v.versions, v.claims); err != nil {
+		if errors.Is(err, auth.ErrStalePermissions) {
+			return auth.ErrStalePermissions
+		}
+
+		log.Error().Err(err).Msg("could not check permission versions")
+		return errors.New("could not authorize request")
+	}
+
+	if !v.claims.HasPermission(permission) {
+		return auth.ErrNotAuthorized
+	}
+	return nil
+}
+
+// prepare adds the galaxy to the next batch of players that are loaded; resolvers
+// prepare the galaxy and authorize the user in a thunk so that the galaxies of all of
+// the parents are authorized together.
+func (v *viewer) prepare(ctx context.Context, galaxyID int64) {
+	v.players.load(ctx, galaxyID)
+}
+
+// authorize returns an error that can be returned to the user if they do not have the
+// permission in the galaxy. The mutex only guards the cached grants so that concurrent
+// resolvers are not blocked while the players of the user are fetched.
+func (v *viewer) authorize(ctx context.Context, galaxyID int64, permission string) error {
+	key := grant{galaxyID, permission}
+	v.Lock()
+	err, ok := v.grants[key]
+	v.Unlock()
+	if ok {
+		return err
+	}
+
+	var player *models.Player
+	if player, err = v.players.get(ctx, galaxyID); err == nil {
+		err = auth.CheckPlayer(ctx, v.versions, v.claims, galaxyID, player, permission)
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrGalaxyNotFound):
+			err = auth.ErrGalaxyNotFound
+		case errors.Is(err, auth.ErrNotAuthorized):
+			err = auth.ErrNotAuthorized
+		case errors.Is(err, auth.ErrStalePermissions):
+			err = auth.ErrStalePermissions
+		default:
+			log.Error().Err(err).Int64("galaxy_id", galaxyID).Msg("could not authorize graphql field")
+			err = errors.New("could not authorize request")
+		}
+	}
+
+	v.Lock()
+	v.grants[key] = err
+	v.Unlock()
+	return err
+}
+
+// can returns true if the user has the permission in the galaxy; errors are treated as
+// not having the permission.
+func (v *viewer) can(ctx context.Context, galaxyID int64, permission string) bool {
+	return v.authorize(ctx, galaxyID, permission) == nil
+}
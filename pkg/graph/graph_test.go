@@ -0,0 +1,73 @@
+package graph_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/graph"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComplexity(t *testing.T) {
+	schema, err := graph.New(10000)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		query     string
+		variables map[string]interface{}
+		expected  int
+	}{
+		{`{ galaxy(id: 1) { id name } }`, nil, 3},
+		{`{ galaxy(id: 1) { systems { id } } }`, nil, 1 + 200*2},
+		{`{ galaxy(id: 1) { systems { planets { id economy { food } } } } }`, nil, 1 + 200*(1+10*(1+1+2))},
+		{`{ galaxy(id: 1) { players { name homeSystem { name } } } }`, nil, 1 + 20*(1+1+2)},
+		{`{ galaxies(pageSize: 10) { galaxies { id } nextPageToken } }`, nil, 10 * (1 + 2 + 1)},
+		{`{ galaxies { galaxies { id } } }`, nil, 50 * (1 + 2)},
+		{`{ galaxies(pageSize: 100000) { galaxies { id } } }`, nil, 500 * (1 + 2)},
+		{`query Q($size: Int) { galaxies(pageSize: $size) { galaxies { id } } }`, map[string]interface{}{"size": float64(5)}, 5 * (1 + 2)},
+		{`{ galaxy(id: 1) { ...G } } fragment G on Galaxy { systems { lanes { distance } } }`, nil, 1 + 200*(1+6*2)},
+		{`{ galaxy(id: 1) { ... on Galaxy { players { name } } } }`, nil, 1 + 20*2},
+		{`{ __schema { types { name } } }`, nil, 3},
+	}
+
+	for i, tc := range testCases {
+		complexity, err := schema.Complexity(tc.query, "", tc.variables)
+		require.NoError(t, err, "test case %d failed", i)
+		require.Equal(t, tc.expected, complexity, "test case %d failed", i)
+	}
+
+	// The operation must be specified if there are multiple operations
+	_, err = schema.Complexity(`query A { galaxy(id: 1) { id } } query B { galaxies { nextPageToken } }`, "", nil)
+	require.Error(t, err)
+
+	complexity, err := schema.Complexity(`query A { galaxy(id: 1) { id } } query B { galaxies { nextPageToken } }`, "B", nil)
+	require.NoError(t, err)
+	require.Equal(t, 50*2, complexity)
+}
+
+func TestExecuteErrors(t *testing.T) {
+	schema, err := graph.New(1000)
+	require.NoError(t, err)
+
+	claims := &auth.Claims{Permissions: []string{"galaxy:observe"}}
+	claims.SetSubjectID(7)
+
+	testCases := []struct {
+		query   string
+		message string
+	}{
+		{`{ galaxy(id: 1) { id `, "Syntax Error GraphQL request (1:22) Expected Name, found EOF\n\n1: { galaxy(id: 1) { id \n                        ^\n"},
+		{`{ galaxy(id: 1) { starbases } }`, `Cannot query field "starbases" on type "Galaxy".`},
+		{`{ galaxy(id: 1) { systems { planets { id } } } }`, "query complexity 4201 is greater than the maximum complexity 1000"},
+		{`{ galaxies { galaxies { id } } }`, auth.ErrNotAuthorized.Error()},
+	}
+
+	for i, tc := range testCases {
+		rep := schema.Execute(context.Background(), claims, nil, &api.GraphQLRequest{Query: tc.query})
+		require.Nil(t, rep.Data, "test case %d failed", i)
+		require.Len(t, rep.Errors, 1, "test case %d failed", i)
+		require.Equal(t, tc.message, rep.Errors[0].Message, "test case %d failed", i)
+	}
+}
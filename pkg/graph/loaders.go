@@ -0,0 +1,187 @@
+package graph
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/bbengfort/cosmos/pkg/db/models"
+)
+
+// loaders batch the database queries of a single request. Resolvers of list fields
+// are called for every parent before any thunk is resolved, so the keys of all of the
+// parents at the same depth of the query are fetched with a single query rather than a
+// query per parent.
+type loaders struct {
+	galaxies  *loader[*models.Galaxy]
+	systems   *loader[*models.System]
+	players   *loader[[]*models.Player]
+	galaxy    *loader[[]*models.System]
+	planets   *loader[[]*models.Planet]
+	asteroids *loader[[]*models.Asteroid]
+	lanes     *loader[[]*models.SpaceLane]
+}
+
+func newLoaders() *loaders {
+	l := &loaders{}
+	l.galaxies = newLoader(func(ctx context.Context, ids []int64) (map[int64]*models.Galaxy, error) {
+		galaxies, err := models.GetGalaxies(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+
+		values := make(map[int64]*models.Galaxy, len(galaxies))
+		for _, galaxy := range galaxies {
+			values[galaxy.ID] = galaxy
+		}
+		return values, nil
+	})
+
+	l.systems = newLoader(func(ctx context.Context, ids []int64) (map[int64]*models.System, error) {
+		systems, err := models.GetSystems(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+
+		values := make(map[int64]*models.System, len(systems))
+		for _, system := range systems {
+			values[system.ID] = system
+		}
+		return values, nil
+	})
+
+	l.players = newLoader(func(ctx context.Context, galaxyIDs []int64) (map[int64][]*models.Player, error) {
+		players, err := models.ListGalaxiesPlayers(ctx, galaxyIDs)
+		if err != nil {
+			return nil, err
+		}
+		return group(galaxyIDs, players, func(p *models.Player) int64 { return p.GalaxyID }), nil
+	})
+
+	// The systems of galaxies are also added to the systems loader so that the systems
+	// of space lanes do not have to be fetched again.
+	l.galaxy = newLoader(func(ctx context.Context, galaxyIDs []int64) (map[int64][]*models.System, error) {
+		systems, err := models.ListSystems(ctx, galaxyIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, system := range systems {
+			l.systems.prime(system.ID, system)
+		}
+		return group(galaxyIDs, systems, func(s *models.System) int64 { return s.GalaxyID }), nil
+	})
+
+	l.planets = newLoader(func(ctx context.Context, systemIDs []int64) (map[int64][]*models.Planet, error) {
+		planets, err := models.ListPlanets(ctx, systemIDs)
+		if err != nil {
+			return nil, err
+		}
+		return group(systemIDs, planets, func(p *models.Planet) int64 { return p.SystemID }), nil
+	})
+
+	l.asteroids = newLoader(func(ctx context.Context, systemIDs []int64) (map[int64][]*models.Asteroid, error) {
+		asteroids, err := models.ListAsteroids(ctx, systemIDs)
+		if err != nil {
+			return nil, err
+		}
+		return group(systemIDs, asteroids, func(a *models.Asteroid) int64 { return a.SystemID }), nil
+	})
+
+	l.lanes = newLoader(func(ctx context.Context, originIDs []int64) (map[int64][]*models.SpaceLane, error) {
+		lanes, err := models.ListSpaceLanes(ctx, originIDs)
+		if err != nil {
+			return nil, err
+		}
+		return group(originIDs, lanes, func(l *models.SpaceLane) int64 { return l.OriginID }), nil
+	})
+	return l
+}
+
+// group the items by the key of their parent; every key has a non-nil list so that
+// parents without any items resolve to an empty list.
+func group[V any](keys []int64, items []V, key func(V) int64) map[int64][]V {
+	groups := make(map[int64][]V, len(keys))
+	for _, k := range keys {
+		groups[k] = make([]V, 0)
+	}
+
+	for _, item := range items {
+		k := key(item)
+		groups[k] = append(groups[k], item)
+	}
+	return groups
+}
+
+// loader fetches the values of the keys that are loaded before the first value is
+// resolved in a single batch and caches the values for the rest of the request.
+type loader[V any] struct {
+	sync.Mutex
+	fetch   func(ctx context.Context, keys []int64) (map[int64]V, error)
+	pending map[int64]struct{}
+	results map[int64]result[V]
+}
+
+type result[V any] struct {
+	value V
+	err   error
+}
+
+func newLoader[V any](fetch func(ctx context.Context, keys []int64) (map[int64]V, error)) *loader[V] {
+	return &loader[V]{
+		fetch:   fetch,
+		pending: make(map[int64]struct{}),
+		results: make(map[int64]result[V]),
+	}
+}
+
+// load returns a thunk that resolves the value of the key; the zero value is resolved
+// if the key does not exist.
+func (l *loader[V]) load(ctx context.Context, key int64) func() (interface{}, error) {
+	l.Lock()
+	if _, ok := l.results[key]; !ok {
+		l.pending[key] = struct{}{}
+	}
+	l.Unlock()
+
+	return func() (interface{}, error) {
+		return l.get(ctx, key)
+	}
+}
+
+// get returns the value of the key, fetching all of the pending keys if necessary.
+func (l *loader[V]) get(ctx context.Context, key int64) (V, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if _, ok := l.results[key]; !ok {
+		l.pending[key] = struct{}{}
+	}
+
+	if len(l.pending) > 0 {
+		keys := make([]int64, 0, len(l.pending))
+		for k := range l.pending {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+		l.pending = make(map[int64]struct{})
+
+		values, err := l.fetch(ctx, keys)
+		for _, k := range keys {
+			l.results[k] = result[V]{value: values[k], err: err}
+		}
+	}
+
+	r := l.results[key]
+	return r.value, r.err
+}
+
+// prime caches the value of a key that was fetched by another query.
+func (l *loader[V]) prime(key int64, value V) {
+	l.Lock()
+	defer l.Unlock()
+	if _, ok := l.results[key]; !ok {
+		l.results[key] = result[V]{value: value}
+		delete(l.pending, key)
+	}
+}
@@ -0,0 +1,107 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoader(t *testing.T) {
+	batches := make([][]int64, 0)
+	l := newLoader(func(_ context.Context, keys []int64) (map[int64]string, error) {
+		batches = append(batches, keys)
+		values := make(map[int64]string, len(keys))
+		for _, key := range keys {
+			if key > 0 {
+				values[key] = "value"
+			}
+		}
+		return values, nil
+	})
+
+	ctx := context.Background()
+	l.prime(3, "primed")
+
+	// Keys that are loaded before any value is resolved are fetched in one batch
+	thunks := []func() (interface{}, error){l.load(ctx, 2), l.load(ctx, 1), l.load(ctx, 3), l.load(ctx, 2), l.load(ctx, -1)}
+	expected := []interface{}{"value", "value", "primed", "value", ""}
+	for i, thunk := range thunks {
+		value, err := thunk()
+		require.NoError(t, err)
+		require.Equal(t, expected[i], value)
+	}
+	require.Equal(t, [][]int64{{-1, 1, 2}}, batches)
+
+	// Cached values are not fetched again
+	value, err := l.load(ctx, 1)()
+	require.NoError(t, err)
+	require.Equal(t, "value", value)
+	require.Len(t, batches, 1)
+
+	value, err = l.load(ctx, 4)()
+	require.NoError(t, err)
+	require.Equal(t, "value", value)
+	require.Equal(t, [][]int64{{-1, 1, 2}, {4}}, batches)
+
+	// Errors are returned for every key of the batch
+	failing := newLoader(func(context.Context, []int64) (map[int64]string, error) {
+		return nil, errors.New("database is down")
+	})
+
+	a, b := failing.load(ctx, 1), failing.load(ctx, 2)
+	_, err = a()
+	require.EqualError(t, err, "database is down")
+	_, err = b()
+	require.EqualError(t, err, "database is down")
+}
+
+func TestGroup(t *testing.T) {
+	groups := group([]int64{1, 2, 3}, []int64{10, 11, 30}, func(v int64) int64 { return v / 10 })
+	require.Equal(t, map[int64][]int64{1: {10, 11}, 2: {}, 3: {30}}, groups)
+}
+
+func TestViewer(t *testing.T) {
+	claims := &auth.Claims{Permissions: []string{"games:read"}}
+	claims.SetSubjectID(42)
+
+	batches := make([][]int64, 0)
+	v := newViewer(claims, nil)
+	v.players = newLoader(func(_ context.Context, galaxyIDs []int64) (map[int64]*models.Player, error) {
+		batches = append(batches, galaxyIDs)
+		return map[int64]*models.Player{}, nil
+	})
+
+	// Galaxies that are prepared before any is authorized are fetched in one batch
+	ctx := context.Background()
+	for _, galaxyID := range []int64{3, 1, 2} {
+		v.prepare(ctx, galaxyID)
+	}
+
+	for _, galaxyID := range []int64{3, 1, 2} {
+		require.ErrorIs(t, v.authorize(ctx, galaxyID, permObserve), auth.ErrGalaxyNotFound)
+	}
+	require.Equal(t, [][]int64{{1, 2, 3}}, batches)
+
+	// Grants are cached and the players of other permissions are not fetched again
+	require.ErrorIs(t, v.authorize(ctx, 1, permObserve), auth.ErrGalaxyNotFound)
+	require.False(t, v.can(ctx, 2, permAdmin))
+	require.Len(t, batches, 1)
+
+	// Server moderators are authorized in galaxies they are not players in
+	claims.Permissions = append(claims.Permissions, auth.ManageGalaxies)
+	v = newViewer(claims, nil)
+	v.players = newLoader(func(context.Context, []int64) (map[int64]*models.Player, error) {
+		return map[int64]*models.Player{}, nil
+	})
+	require.NoError(t, v.authorize(ctx, 1, permAdmin))
+
+	// Database errors are not returned to the user
+	v.players = newLoader(func(context.Context, []int64) (map[int64]*models.Player, error) {
+		return nil, errors.New("database is down")
+	})
+	require.EqualError(t, v.authorize(ctx, 2, permAdmin), "could not authorize request")
+}
@@ -0,0 +1,430 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/bbengfort/cosmos/pkg/enums"
+	"github.com/bbengfort/cosmos/pkg/pagination"
+	"github.com/graphql-go/graphql"
+	"github.com/rs/zerolog/log"
+)
+
+// Field level authorization: the galaxy and its players, systems, and lanes are visible
+// to observers; the economy of planets is only visible to players; and the join code of
+// the galaxy and the home systems of other players are only visible to galaxy admins.
+// Restricted fields resolve to null rather than an error so that one query can be used
+// by users with different galaxy roles.
+const (
+	permObserve = "galaxy:observe"
+	permPlay    = "galaxy:play"
+	permAdmin   = "galaxy:admin"
+)
+
+// newSchema defines the types and resolvers of the schema. Types that refer to each
+// other define their fields with thunks.
+func newSchema() (graphql.Schema, error) {
+	var system, lane *graphql.Object
+
+	economy := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "PlanetEconomy",
+		Description: "The buildings and resources of a planet.",
+		Fields: graphql.Fields{
+			"labs":     {Type: graphql.NewNonNull(graphql.Int)},
+			"tech":     {Type: graphql.NewNonNull(graphql.Int)},
+			"mines":    {Type: graphql.NewNonNull(graphql.Int)},
+			"metals":   {Type: graphql.NewNonNull(graphql.Int)},
+			"reactors": {Type: graphql.NewNonNull(graphql.Int)},
+			"energy":   {Type: graphql.NewNonNull(graphql.Int)},
+			"cities":   {Type: graphql.NewNonNull(graphql.Int)},
+			"credits":  {Type: graphql.NewNonNull(graphql.Int)},
+			"farms":    {Type: graphql.NewNonNull(graphql.Int)},
+			"food":     {Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+
+	planet := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Planet",
+		Fields: graphql.Fields{
+			"id":           {Type: graphql.NewNonNull(graphql.Int)},
+			"name":         {Type: graphql.NewNonNull(graphql.String)},
+			"planetClass":  {Type: graphql.NewNonNull(graphql.String)},
+			"isHomeworld":  {Type: graphql.NewNonNull(graphql.Boolean)},
+			"orbit":        {Type: graphql.NewNonNull(graphql.Int)},
+			"orbitalSpeed": {Type: graphql.NewNonNull(graphql.Float)},
+			"economy":      {Type: economy, Description: "Only visible to players of the galaxy.", Resolve: resolvePlanetEconomy},
+		},
+	})
+
+	asteroid := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Asteroid",
+		Description: "An asteroid belt in the orbit of a system.",
+		Fields: graphql.Fields{
+			"id":      {Type: graphql.NewNonNull(graphql.Int)},
+			"orbit":   {Type: graphql.NewNonNull(graphql.Int)},
+			"density": {Type: graphql.NewNonNull(graphql.Float)},
+		},
+	})
+
+	system = graphql.NewObject(graphql.ObjectConfig{
+		Name: "System",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":           {Type: graphql.NewNonNull(graphql.Int)},
+				"name":         {Type: graphql.NewNonNull(graphql.String)},
+				"isHomeSystem": {Type: graphql.NewNonNull(graphql.Boolean)},
+				"starClass":    {Type: graphql.NewNonNull(graphql.String)},
+				"systemRadius": {Type: graphql.NewNonNull(graphql.Int)},
+				"warpGate":     {Type: graphql.NewNonNull(graphql.Int)},
+				"shipyard":     {Type: graphql.NewNonNull(graphql.Int)},
+				"planets":      {Type: list(planet), Resolve: resolveSystemPlanets},
+				"asteroids":    {Type: list(asteroid), Resolve: resolveSystemAsteroids},
+				"lanes":        {Type: list(lane), Description: "The space lanes from the system to other systems.", Resolve: resolveSystemLanes},
+			}
+		}),
+	})
+
+	lane = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "SpaceLane",
+		Description: "A route between two systems of a galaxy.",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"originId": {Type: graphql.NewNonNull(graphql.Int)},
+				"targetId": {Type: graphql.NewNonNull(graphql.Int)},
+				"origin":   {Type: graphql.NewNonNull(system), Resolve: resolveLaneSystem(func(l *models.SpaceLane) int64 { return l.OriginID })},
+				"target":   {Type: graphql.NewNonNull(system), Resolve: resolveLaneSystem(func(l *models.SpaceLane) int64 { return l.TargetID })},
+				"distance": {Type: graphql.NewNonNull(graphql.Int)},
+				"hazards":  {Type: graphql.NewNonNull(graphql.Int)},
+			}
+		}),
+	})
+
+	player := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Player",
+		Description: "A user's player in a galaxy and their galaxy role.",
+		Fields: graphql.Fields{
+			"userId":     {Type: graphql.NewNonNull(graphql.Int), Resolve: field(func(p *models.Player) interface{} { return p.PlayerID })},
+			"name":       {Type: graphql.NewNonNull(graphql.String)},
+			"faction":    {Type: graphql.NewNonNull(graphql.String)},
+			"character":  {Type: graphql.NewNonNull(graphql.String)},
+			"role":       {Type: graphql.NewNonNull(graphql.String), Resolve: resolvePlayerRole},
+			"joined":     {Type: graphql.NewNonNull(graphql.String), Resolve: timestamp(func(p *models.Player) time.Time { return p.Created })},
+			"homeSystem": {Type: system, Description: "Only visible to the player and galaxy admins.", Resolve: resolvePlayerHomeSystem},
+		},
+	})
+
+	galaxy := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Galaxy",
+		Fields: graphql.Fields{
+			"id":         {Type: graphql.NewNonNull(graphql.Int)},
+			"name":       {Type: graphql.NewNonNull(graphql.String)},
+			"turn":       {Type: graphql.NewNonNull(graphql.Int)},
+			"size":       {Type: graphql.NewNonNull(graphql.String)},
+			"state":      {Type: graphql.NewNonNull(graphql.String), Resolve: field(func(g *models.Galaxy) interface{} { return g.GameState.String() })},
+			"maxPlayers": {Type: graphql.NewNonNull(graphql.Int)},
+			"maxTurns":   {Type: graphql.NewNonNull(graphql.Int)},
+			"joinCode":   {Type: graphql.String, Description: "Only visible to galaxy admins.", Resolve: resolveGalaxyJoinCode},
+			"created":    {Type: graphql.NewNonNull(graphql.String), Resolve: timestamp(func(g *models.Galaxy) time.Time { return g.Created })},
+			"modified":   {Type: graphql.NewNonNull(graphql.String), Resolve: timestamp(func(g *models.Galaxy) time.Time { return g.Modified })},
+			"players":    {Type: list(player), Resolve: resolveGalaxyPlayers},
+			"systems":    {Type: list(system), Resolve: resolveGalaxySystems},
+		},
+	})
+
+	galaxies := graphql.NewObject(graphql.ObjectConfig{
+		Name: "GalaxyList",
+		Fields: graphql.Fields{
+			"galaxies":      {Type: list(galaxy)},
+			"nextPageToken": {Type: graphql.String},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"galaxy": {
+				Type:        galaxy,
+				Description: "A galaxy that the user is permitted to observe.",
+				Args: graphql.FieldConfigArgument{
+					"id": {Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: resolveGalaxy,
+			},
+			"galaxies": {
+				Type:        graphql.NewNonNull(galaxies),
+				Description: "A page of the galaxies of the user filtered and ordered like the galaxy list of the v1 API.",
+				Args: graphql.FieldConfigArgument{
+					"state":     {Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+					"size":      {Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+					"orderBy":   {Type: graphql.String},
+					"pageSize":  {Type: graphql.Int},
+					"pageToken": {Type: graphql.String},
+				},
+				Resolve: resolveGalaxies,
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+func list(t graphql.Type) graphql.Type {
+	return graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(t)))
+}
+
+func resolveGalaxy(p graphql.ResolveParams) (interface{}, error) {
+	r := getRequest(p.Context)
+	galaxyID := int64(p.Args["id"].(int))
+	r.viewer.prepare(p.Context, galaxyID)
+
+	load := r.loaders.galaxies.load(p.Context, galaxyID)
+	return func() (interface{}, error) {
+		if err := r.viewer.authorize(p.Context, galaxyID, permObserve); err != nil {
+			return nil, err
+		}
+
+		galaxy, err := load()
+		if err != nil {
+			log.Error().Err(err).Msg("could not fetch galaxy from the database")
+			return nil, errors.New("could not load galaxy")
+		}
+
+		if galaxy.(*models.Galaxy) == nil {
+			return nil, auth.ErrGalaxyNotFound
+		}
+		return galaxy, nil
+	}, nil
+}
+
+func resolveGalaxies(p graphql.ResolveParams) (_ interface{}, err error) {
+	r := getRequest(p.Context)
+	if err = r.viewer.permitted(p.Context, "games:read"); err != nil {
+		return nil, err
+	}
+
+	var query *models.GalaxyQuery
+	if query, err = galaxyQuery(r.viewer.userID, p.Args); err != nil {
+		return nil, err
+	}
+
+	var (
+		galaxies []*models.Galaxy
+		next     *pagination.Cursor
+	)
+	if galaxies, next, err = models.QueryGalaxies(p.Context, query); err != nil {
+		if errors.Is(err, pagination.ErrTokenMismatch) {
+			return nil, err
+		}
+
+		log.Error().Err(err).Msg("could not fetch galaxies from the database")
+		return nil, errors.New("could not load galaxies")
+	}
+
+	for _, galaxy := range galaxies {
+		r.loaders.galaxies.prime(galaxy.ID, galaxy)
+	}
+
+	out := map[string]interface{}{"galaxies": galaxies}
+	if next != nil {
+		var token string
+		if token, err = next.Token(); err != nil {
+			log.Error().Err(err).Msg("could not create next page token")
+			return nil, errors.New("could not load galaxies")
+		}
+		out["nextPageToken"] = token
+	}
+	return out, nil
+}
+
+// galaxyQuery creates the galaxy query from the arguments of the galaxies field.
+func galaxyQuery(userID int64, args map[string]interface{}) (query *models.GalaxyQuery, err error) {
+	query = &models.GalaxyQuery{UserID: userID}
+
+	states, _ := args["state"].([]interface{})
+	for _, name := range states {
+		var state enums.GameState
+		if state, err = enums.ParseGameState(name.(string)); err != nil {
+			return nil, fmt.Errorf("invalid state %q", name)
+		}
+		query.States = append(query.States, state)
+	}
+
+	sizes, _ := args["size"].([]interface{})
+	for _, name := range sizes {
+		var size enums.Size
+		if size, err = enums.ParseSize(name.(string)); err != nil {
+			return nil, fmt.Errorf("invalid size %q", name)
+		}
+		query.Sizes = append(query.Sizes, size)
+	}
+
+	orderBy, _ := args["orderBy"].(string)
+	if query.Order, err = pagination.ParseOrder(orderBy, models.GalaxyOrderFields...); err != nil {
+		return nil, fmt.Errorf("orderBy must be one of %s", strings.Join(models.GalaxyOrderFields, ", "))
+	}
+
+	pageSize, _ := args["pageSize"].(int)
+	if query.PageSize, err = pagination.PageSize(pageSize); err != nil {
+		return nil, errors.New("pageSize must be greater than or equal to 0")
+	}
+
+	pageToken, _ := args["pageToken"].(string)
+	if query.Cursor, err = pagination.Parse(pageToken); err != nil {
+		return nil, errors.New("pageToken is invalid")
+	}
+
+	if query.Cursor != nil {
+		if err = query.Cursor.Check(query.Order, query.Filter()); err != nil {
+			return nil, err
+		}
+
+		if _, err = models.ParseGalaxySortKey(query.Order.Field, query.Cursor.Key); err != nil {
+			return nil, errors.New("pageToken is invalid")
+		}
+	}
+	return query, nil
+}
+
+func resolveGalaxyJoinCode(p graphql.ResolveParams) (interface{}, error) {
+	r := getRequest(p.Context)
+	galaxy := p.Source.(*models.Galaxy)
+	r.viewer.prepare(p.Context, galaxy.ID)
+	return func() (interface{}, error) {
+		if !r.viewer.can(p.Context, galaxy.ID, permAdmin) {
+			return nil, nil
+		}
+		return galaxy.JoinCode.String(), nil
+	}, nil
+}
+
+func resolveGalaxyPlayers(p graphql.ResolveParams) (interface{}, error) {
+	r := getRequest(p.Context)
+	galaxy := p.Source.(*models.Galaxy)
+	return authorized(p.Context, r.viewer, galaxy.ID, permObserve, loaded(r.loaders.players.load(p.Context, galaxy.ID), "players")), nil
+}
+
+func resolveGalaxySystems(p graphql.ResolveParams) (interface{}, error) {
+	r := getRequest(p.Context)
+	galaxy := p.Source.(*models.Galaxy)
+	return authorized(p.Context, r.viewer, galaxy.ID, permObserve, loaded(r.loaders.galaxy.load(p.Context, galaxy.ID), "systems")), nil
+}
+
+func resolveSystemPlanets(p graphql.ResolveParams) (interface{}, error) {
+	system := p.Source.(*models.System)
+	return loaded(getRequest(p.Context).loaders.planets.load(p.Context, system.ID), "planets"), nil
+}
+
+func resolveSystemAsteroids(p graphql.ResolveParams) (interface{}, error) {
+	system := p.Source.(*models.System)
+	return loaded(getRequest(p.Context).loaders.asteroids.load(p.Context, system.ID), "asteroids"), nil
+}
+
+func resolveSystemLanes(p graphql.ResolveParams) (interface{}, error) {
+	system := p.Source.(*models.System)
+	return loaded(getRequest(p.Context).loaders.lanes.load(p.Context, system.ID), "space lanes"), nil
+}
+
+func resolveLaneSystem(id func(*models.SpaceLane) int64) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		lane := p.Source.(*models.SpaceLane)
+		return loaded(getRequest(p.Context).loaders.systems.load(p.Context, id(lane)), "system"), nil
+	}
+}
+
+// resolvePlanetEconomy loads the system of the planet to authorize the user in its
+// galaxy; the system is usually cached since planets are loaded from their systems.
+func resolvePlanetEconomy(p graphql.ResolveParams) (interface{}, error) {
+	r := getRequest(p.Context)
+	planet := p.Source.(*models.Planet)
+	load := r.loaders.systems.load(p.Context, planet.SystemID)
+	return func() (interface{}, error) {
+		system, err := load()
+		if err != nil {
+			log.Error().Err(err).Msg("could not fetch system from the database")
+			return nil, errors.New("could not load economy")
+		}
+
+		if system := system.(*models.System); system == nil || !r.viewer.can(p.Context, system.GalaxyID, permPlay) {
+			return nil, nil
+		}
+		return planet, nil
+	}, nil
+}
+
+// resolvePlayerRole returns the title of the galaxy role of the player, which is
+// loaded with the players of the galaxy.
+func resolvePlayerRole(p graphql.ResolveParams) (interface{}, error) {
+	role, err := p.Source.(*models.Player).Role(p.Context)
+	if err != nil {
+		log.Error().Err(err).Msg("could not fetch player role from the database")
+		return nil, errors.New("could not load role")
+	}
+	return role.Title, nil
+}
+
+func resolvePlayerHomeSystem(p graphql.ResolveParams) (interface{}, error) {
+	r := getRequest(p.Context)
+	player := p.Source.(*models.Player)
+	if !player.HomeSystemID.Valid {
+		return nil, nil
+	}
+
+	if player.PlayerID == r.viewer.userID {
+		return loaded(r.loaders.systems.load(p.Context, player.HomeSystemID.Int64), "system"), nil
+	}
+
+	r.viewer.prepare(p.Context, player.GalaxyID)
+	load := loaded(r.loaders.systems.load(p.Context, player.HomeSystemID.Int64), "system")
+	return func() (interface{}, error) {
+		if !r.viewer.can(p.Context, player.GalaxyID, permAdmin) {
+			return nil, nil
+		}
+		return load()
+	}, nil
+}
+
+// authorized prepares the galaxy for authorization and returns a thunk that resolves
+// the thunk of a loader if the user has the permission in the galaxy. The loader is
+// called before the user is authorized so that its keys are batched with the keys of
+// the other parents; its values are discarded if the user is not authorized.
+func authorized(ctx context.Context, v *viewer, galaxyID int64, permission string, load func() (interface{}, error)) func() (interface{}, error) {
+	v.prepare(ctx, galaxyID)
+	return func() (interface{}, error) {
+		if err := v.authorize(ctx, galaxyID, permission); err != nil {
+			return nil, err
+		}
+		return load()
+	}
+}
+
+// loaded wraps the thunk of a loader to log database errors and return an error that
+// can be returned to the user.
+func loaded(load func() (interface{}, error), name string) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		value, err := load()
+		if err != nil {
+			log.Error().Err(err).Msgf("could not fetch %s from the database", name)
+			return nil, fmt.Errorf("could not load %s", name)
+		}
+		return value, nil
+	}
+}
+
+// field resolves a field with a function of the source.
+func field[T any](fn func(T) interface{}) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		return fn(p.Source.(T)), nil
+	}
+}
+
+// timestamp resolves a time field of the source as an RFC 3339 string.
+func timestamp[T any](fn func(T) time.Time) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		return fn(p.Source.(T)).Format(time.RFC3339), nil
+	}
+}
@@ -0,0 +1,237 @@
+/*
+Package idempotency makes state-changing requests safe to retry. The first response to
+a request with an Idempotency-Key header is stored for the user and replayed when the
+user retries the request with the same key until the key expires. Requests that use a
+key while the first request with the key is still being processed are rejected with a
+conflict and a key cannot be reused for a request with a different method, path, or
+body. Responses are stored in memory or in Postgres so that retries are replayed by any
+replica of the API.
+*/
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// Headers of idempotent requests and replayed responses.
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+)
+
+// ReplayedHeaders are the headers of the stored responses that are replayed in addition
+// to the content type, so retries receive the same entity tag and resource location.
+var ReplayedHeaders = []string{"ETag", "Location"}
+
+// MaxKeyLength is the maximum number of characters of an idempotency key.
+const MaxKeyLength = 255
+
+// SweepInterval is how often stores delete the keys that have expired.
+const SweepInterval = 10 * time.Minute
+
+var (
+	ErrInProgress = errors.New("a request with the idempotency key is in progress")
+	ErrKeyReused  = errors.New("the idempotency key was used for a different request")
+	ErrLeaseLost  = errors.New("the idempotency key was taken over by another request")
+)
+
+// Store keeps the responses to requests with idempotency keys.
+type Store interface {
+	// Reserve claims the key of the user for the request with the fingerprint until the
+	// key expires. If the key was already used for the request, the stored response is
+	// returned and the request must not be processed again. ErrInProgress is returned if
+	// the request of the key has not completed and ErrKeyReused if the key was used for
+	// a request with a different fingerprint. The request of the key is leased until the
+	// lease time; if it has not completed by then, e.g. because the replica processing
+	// it died, the key can be reserved again by a request with the same fingerprint.
+	Reserve(ctx context.Context, userID int64, key string, fingerprint []byte, lease, expires time.Time) (*Response, error)
+
+	// Complete stores the response to the request of the key that was reserved with the
+	// lease. ErrLeaseLost is returned if the key was taken over by another request after
+	// the lease lapsed or if the request of the key has already completed.
+	Complete(ctx context.Context, userID int64, key string, lease time.Time, response *Response) error
+
+	// Release deletes the key that was reserved with the lease so that the request can be
+	// retried. Keys that were taken over by another request or that have completed are
+	// not deleted.
+	Release(ctx context.Context, userID int64, key string, lease time.Time) error
+
+	// Run deletes expired keys at the interval until the context is done.
+	Run(ctx context.Context, interval time.Duration)
+}
+
+// Response is the stored response to a request with an idempotency key.
+type Response struct {
+	Status      int
+	ContentType string
+	Headers     map[string]string
+	Body        []byte
+}
+
+// replay returns the response of a request whose key was reserved by a request with
+// the reserved fingerprint, which is nil if that request has not completed.
+func replay(fingerprint, reserved []byte, response *Response) (*Response, error) {
+	if !bytes.Equal(fingerprint, reserved) {
+		return nil, ErrKeyReused
+	}
+
+	if response == nil {
+		return nil, ErrInProgress
+	}
+	return response, nil
+}
+
+// Fingerprint identifies a request by its method, URI, and body.
+func Fingerprint(method, uri string, body []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte(method + " " + uri + "\n"))
+	hash.Write(body)
+	return hash.Sum(nil)
+}
+
+// Idempotent returns middleware that replays the first response to requests with an
+// Idempotency-Key header for the amount of time specified by the ttl. Retries are
+// rejected while the first request is in progress, for at most the lease, after which
+// the request is assumed to be abandoned and a retry is processed instead. The middleware
+// must be added after the authentication and authorization middleware of the route
+// since keys belong to users and responses to unauthorized requests should not be
+// replayed. Requests without a key or without authentication are always processed.
+// Responses with a server error status are not stored so the request can be retried.
+func Idempotent(store Store, ttl, lease time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(HeaderKey)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		if len(key) > MaxKeyLength {
+			api.Error(c, http.StatusBadRequest, api.InvalidField(HeaderKey, "must be at most 255 characters"))
+			return
+		}
+
+		userID, ok := subject(c)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			api.Error(c, http.StatusBadRequest, api.ErrUnparsable)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// The lease identifies this request as the holder of the key when it is completed
+		// or released.
+		fingerprint := Fingerprint(c.Request.Method, c.Request.URL.RequestURI(), body)
+		now := time.Now()
+		leased := now.Add(lease)
+		stored, err := store.Reserve(c.Request.Context(), userID, key, fingerprint, leased, now.Add(ttl))
+		switch {
+		case errors.Is(err, ErrInProgress):
+			api.Error(c, http.StatusConflict, api.ErrInProgress)
+			return
+		case errors.Is(err, ErrKeyReused):
+			api.Error(c, http.StatusUnprocessableEntity, api.ErrKeyReused)
+			return
+		case err != nil:
+			log.Error().Err(err).Msg("could not reserve idempotency key")
+			api.Error(c, http.StatusInternalServerError, api.ErrInternal)
+			return
+		case stored != nil:
+			for name, value := range stored.Headers {
+				c.Header(name, value)
+			}
+			c.Header(HeaderReplayed, "true")
+			c.Data(stored.Status, stored.ContentType, stored.Body)
+			c.Abort()
+			return
+		}
+
+		// The key must be completed or released even if the client has disconnected or
+		// the handler panics so that retries are not rejected until the key expires.
+		ctx := context.WithoutCancel(c.Request.Context())
+		completed := false
+		defer func() {
+			if !completed {
+				if err := store.Release(ctx, userID, key, leased); err != nil {
+					log.Error().Err(err).Msg("could not release idempotency key")
+				}
+			}
+		}()
+
+		w := &recorder{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		if status := w.Status(); status < http.StatusInternalServerError {
+			response := &Response{Status: status, ContentType: w.Header().Get("Content-Type"), Headers: replayedHeaders(w.Header()), Body: w.body.Bytes()}
+			if err := store.Complete(ctx, userID, key, leased, response); err != nil {
+				if errors.Is(err, ErrLeaseLost) {
+					log.Warn().Err(err).Msg("idempotent response was not stored")
+					return
+				}
+				log.Error().Err(err).Msg("could not store idempotent response")
+				return
+			}
+			completed = true
+		}
+	}
+}
+
+// replayedHeaders returns the values of the replayed headers set on the response.
+func replayedHeaders(header http.Header) map[string]string {
+	var headers map[string]string
+	for _, name := range ReplayedHeaders {
+		if value := header.Get(name); value != "" {
+			if headers == nil {
+				headers = make(map[string]string, len(ReplayedHeaders))
+			}
+			headers[name] = value
+		}
+	}
+	return headers
+}
+
+// subject returns the user ID of an authenticated request.
+func subject(c *gin.Context) (int64, bool) {
+	claims, err := auth.GetClaims(c)
+	if err != nil {
+		return 0, false
+	}
+
+	userID, err := claims.SubjectID()
+	if err != nil {
+		return 0, false
+	}
+	return userID, true
+}
+
+// recorder copies the body of the response so that it can be stored.
+type recorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,221 @@
+package idempotency_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/idempotency"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	store := idempotency.NewMemory()
+	ctx := context.Background()
+	fingerprint := idempotency.Fingerprint(http.MethodPost, "/v1/galaxy/", []byte(`{"name":"andromeda"}`))
+	lease := time.Now().Add(time.Hour)
+	expires := time.Now().Add(50 * time.Millisecond)
+
+	stored, err := store.Reserve(ctx, 1, "key", fingerprint, lease, expires)
+	require.NoError(t, err)
+	require.Nil(t, stored)
+
+	// The key is in use until the request completes
+	_, err = store.Reserve(ctx, 1, "key", fingerprint, lease, expires)
+	require.ErrorIs(t, err, idempotency.ErrInProgress)
+
+	_, err = store.Reserve(ctx, 1, "key", idempotency.Fingerprint(http.MethodPost, "/v1/galaxy/", nil), lease, expires)
+	require.ErrorIs(t, err, idempotency.ErrKeyReused)
+
+	// Keys belong to users
+	stored, err = store.Reserve(ctx, 2, "key", fingerprint, lease, expires)
+	require.NoError(t, err)
+	require.Nil(t, stored)
+
+	response := &idempotency.Response{Status: http.StatusCreated, ContentType: "application/json", Body: []byte(`{"id":1}`)}
+	require.NoError(t, store.Complete(ctx, 1, "key", lease, response))
+
+	stored, err = store.Reserve(ctx, 1, "key", fingerprint, lease, expires)
+	require.NoError(t, err)
+	require.Equal(t, response, stored)
+
+	// Released keys can be reserved again
+	require.NoError(t, store.Release(ctx, 2, "key", lease))
+	stored, err = store.Reserve(ctx, 2, "key", fingerprint, lease, expires)
+	require.NoError(t, err)
+	require.Nil(t, stored)
+
+	// Expired keys can be reused and are deleted
+	time.Sleep(60 * time.Millisecond)
+	stored, err = store.Reserve(ctx, 1, "key", idempotency.Fingerprint(http.MethodDelete, "/v1/galaxy/1", nil), lease, time.Now())
+	require.NoError(t, err)
+	require.Nil(t, stored)
+
+	require.Equal(t, 2, store.Len())
+	store.Sweep()
+	require.Equal(t, 0, store.Len())
+}
+
+func TestMemoryLease(t *testing.T) {
+	store := idempotency.NewMemory()
+	ctx := context.Background()
+	fingerprint := idempotency.Fingerprint(http.MethodPost, "/v1/galaxy/", []byte(`{"name":"andromeda"}`))
+	expires := time.Now().Add(time.Hour)
+
+	abandoned := time.Now().Add(50 * time.Millisecond)
+	stored, err := store.Reserve(ctx, 1, "key", fingerprint, abandoned, expires)
+	require.NoError(t, err)
+	require.Nil(t, stored)
+
+	_, err = store.Reserve(ctx, 1, "key", fingerprint, time.Now().Add(time.Hour), expires)
+	require.ErrorIs(t, err, idempotency.ErrInProgress)
+
+	// Once the lease lapses the key is taken over by a retry of the same request
+	time.Sleep(60 * time.Millisecond)
+	_, err = store.Reserve(ctx, 1, "key", idempotency.Fingerprint(http.MethodPost, "/v1/galaxy/", nil), time.Now().Add(time.Hour), expires)
+	require.ErrorIs(t, err, idempotency.ErrKeyReused)
+
+	lease := time.Now().Add(time.Hour)
+	stored, err = store.Reserve(ctx, 1, "key", fingerprint, lease, expires)
+	require.NoError(t, err)
+	require.Nil(t, stored)
+
+	// The lease of the retry is renewed
+	_, err = store.Reserve(ctx, 1, "key", fingerprint, time.Now().Add(time.Hour), expires)
+	require.ErrorIs(t, err, idempotency.ErrInProgress)
+
+	// The abandoned request can neither complete nor release the key of the retry
+	response := &idempotency.Response{Status: http.StatusCreated, ContentType: "application/json", Body: []byte(`{"id":1}`)}
+	require.ErrorIs(t, store.Complete(ctx, 1, "key", abandoned, &idempotency.Response{Status: http.StatusBadRequest}), idempotency.ErrLeaseLost)
+	require.NoError(t, store.Release(ctx, 1, "key", abandoned))
+	require.Equal(t, 1, store.Len())
+
+	// Completed keys are always replayed and cannot be completed or released again
+	require.NoError(t, store.Complete(ctx, 1, "key", lease, response))
+	require.ErrorIs(t, store.Complete(ctx, 1, "key", lease, &idempotency.Response{Status: http.StatusBadRequest}), idempotency.ErrLeaseLost)
+	require.NoError(t, store.Release(ctx, 1, "key", lease))
+	stored, err = store.Reserve(ctx, 1, "key", fingerprint, time.Now(), expires)
+	require.NoError(t, err)
+	require.Equal(t, response, stored)
+}
+
+func TestIdempotent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := idempotency.NewMemory()
+
+	var calls int64
+	release := make(chan struct{})
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-User"); user != "" {
+			claims := &auth.Claims{}
+			uid, _ := strconv.ParseInt(user, 10, 64)
+			claims.SetSubjectID(uid)
+			c.Set(auth.ContextUserClaims, claims)
+		}
+	})
+	router.POST("/galaxy", idempotency.Idempotent(store, time.Hour, time.Minute), func(c *gin.Context) {
+		in := make(map[string]string)
+		if err := c.BindJSON(&in); err != nil {
+			return
+		}
+
+		n := atomic.AddInt64(&calls, 1)
+		switch in["name"] {
+		case "slow":
+			<-release
+		case "broken":
+			api.Error(c, http.StatusInternalServerError, api.ErrInternal)
+			return
+		}
+		c.Header("ETag", `"`+strconv.FormatInt(n, 10)+`"`)
+		c.Header("Location", "/galaxy/"+strconv.FormatInt(n, 10))
+		c.JSON(http.StatusCreated, gin.H{"id": n, "name": in["name"]})
+	})
+
+	request := func(user, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/galaxy", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if user != "" {
+			req.Header.Set("X-User", user)
+		}
+		if key != "" {
+			req.Header.Set(idempotency.HeaderKey, key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	code := func(w *httptest.ResponseRecorder) string {
+		rep := api.Reply{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rep))
+		return rep.Code
+	}
+
+	// The first response is replayed on retries
+	first := request("1", "create-andromeda", `{"name":"andromeda"}`)
+	require.Equal(t, http.StatusCreated, first.Code)
+	require.Empty(t, first.Header().Get(idempotency.HeaderReplayed))
+
+	retry := request("1", "create-andromeda", `{"name":"andromeda"}`)
+	require.Equal(t, http.StatusCreated, retry.Code)
+	require.Equal(t, "true", retry.Header().Get(idempotency.HeaderReplayed))
+	require.Equal(t, first.Body.String(), retry.Body.String())
+	require.Equal(t, first.Header().Get("Content-Type"), retry.Header().Get("Content-Type"))
+	require.Equal(t, `"1"`, retry.Header().Get("ETag"))
+	require.Equal(t, "/galaxy/1", retry.Header().Get("Location"))
+	require.Equal(t, int64(1), atomic.LoadInt64(&calls))
+
+	// The key cannot be reused for a different request
+	w := request("1", "create-andromeda", `{"name":"triangulum"}`)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	require.Equal(t, api.CodeKeyReused, code(w))
+
+	// Other users, requests without keys, and unauthenticated requests are processed
+	require.Equal(t, http.StatusCreated, request("2", "create-andromeda", `{"name":"andromeda"}`).Code)
+	require.Equal(t, http.StatusCreated, request("1", "", `{"name":"andromeda"}`).Code)
+	require.Equal(t, http.StatusCreated, request("", "create-andromeda", `{"name":"andromeda"}`).Code)
+	require.Equal(t, int64(4), atomic.LoadInt64(&calls))
+
+	// Concurrent requests with the same key are rejected until the first completes
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- request("1", "create-slow", `{"name":"slow"}`) }()
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&calls) == 5 }, time.Second, time.Millisecond)
+
+	w = request("1", "create-slow", `{"name":"slow"}`)
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Equal(t, api.CodeInProgress, code(w))
+
+	close(release)
+	require.Equal(t, http.StatusCreated, (<-done).Code)
+	require.Equal(t, "true", request("1", "create-slow", `{"name":"slow"}`).Header().Get(idempotency.HeaderReplayed))
+
+	// Server errors are not stored so the request can be retried
+	require.Equal(t, http.StatusInternalServerError, request("1", "create-broken", `{"name":"broken"}`).Code)
+	w = request("1", "create-broken", `{"name":"broken"}`)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Empty(t, w.Header().Get(idempotency.HeaderReplayed))
+	require.Equal(t, int64(7), atomic.LoadInt64(&calls))
+
+	// Client errors are stored and replayed
+	require.Equal(t, http.StatusBadRequest, request("1", "create-invalid", `{"name":`).Code)
+	w = request("1", "create-invalid", `{"name":`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, "true", w.Header().Get(idempotency.HeaderReplayed))
+
+	// Keys are limited in length
+	w = request("1", strings.Repeat("k", idempotency.MaxKeyLength+1), `{"name":"andromeda"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, api.CodeInvalidRequest, code(w))
+}
@@ -0,0 +1,122 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory stores the responses in memory so retries are only replayed by the replica
+// that processed the first request.
+type Memory struct {
+	sync.Mutex
+	keys map[memoryKey]*entry
+}
+
+type memoryKey struct {
+	userID int64
+	key    string
+}
+
+type entry struct {
+	fingerprint []byte
+	response    *Response // nil while the request is in progress
+	lease       time.Time
+	expires     time.Time
+}
+
+// held returns true if the request of the entry is in progress and is held by the
+// request that reserved the entry with the lease.
+func (e *entry) held(lease time.Time) bool {
+	return e.response == nil && e.lease.Equal(lease)
+}
+
+var _ Store = &Memory{}
+
+func NewMemory() *Memory {
+	return &Memory{keys: make(map[memoryKey]*entry)}
+}
+
+// Reserve claims the key of the user for the request with the fingerprint.
+func (m *Memory) Reserve(_ context.Context, userID int64, key string, fingerprint []byte, lease, expires time.Time) (*Response, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+	k := memoryKey{userID, key}
+	if e, ok := m.keys[k]; ok && e.expires.After(now) {
+		// Take over the key if its request was abandoned before it completed
+		if e.response == nil && !e.lease.After(now) && bytes.Equal(fingerprint, e.fingerprint) {
+			e.lease = lease
+			return nil, nil
+		}
+		return replay(fingerprint, e.fingerprint, e.response)
+	}
+
+	m.keys[k] = &entry{fingerprint: fingerprint, lease: lease, expires: expires}
+	return nil, nil
+}
+
+// Complete stores the response to the request of the key that was reserved with the
+// lease.
+func (m *Memory) Complete(_ context.Context, userID int64, key string, lease time.Time, response *Response) error {
+	m.Lock()
+	defer m.Unlock()
+
+	e, ok := m.keys[memoryKey{userID, key}]
+	if !ok || !e.held(lease) {
+		return ErrLeaseLost
+	}
+
+	e.response = response
+	return nil
+}
+
+// Release deletes the key that was reserved with the lease so that the request can be
+// retried.
+func (m *Memory) Release(_ context.Context, userID int64, key string, lease time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+
+	k := memoryKey{userID, key}
+	if e, ok := m.keys[k]; ok && e.held(lease) {
+		delete(m.keys, k)
+	}
+	return nil
+}
+
+// Run deletes expired keys at the interval until the context is done.
+func (m *Memory) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Sweep()
+		}
+	}
+}
+
+// Sweep deletes the keys that have expired.
+func (m *Memory) Sweep() {
+	now := time.Now()
+
+	m.Lock()
+	defer m.Unlock()
+	for k, e := range m.keys {
+		if !e.expires.After(now) {
+			delete(m.keys, k)
+		}
+	}
+}
+
+// Len returns the number of keys in memory.
+func (m *Memory) Len() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.keys)
+}
@@ -0,0 +1,105 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/db"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/rs/zerolog/log"
+)
+
+// Postgres stores the responses in the database so that retries are replayed by every
+// replica of the API. Concurrent reservations of a key are serialized by the primary key
+// of the key so that only one request with the key is processed.
+type Postgres struct{}
+
+// leasePrecision is the precision of the database timestamps; leases are truncated to it
+// so that the lease a key was reserved with matches the stored lease of the key.
+const leasePrecision = time.Microsecond
+
+var _ Store = &Postgres{}
+
+func NewPostgres() *Postgres {
+	return &Postgres{}
+}
+
+// Reserve claims the key of the user for the request with the fingerprint.
+func (p *Postgres) Reserve(ctx context.Context, userID int64, key string, fingerprint []byte, lease, expires time.Time) (_ *Response, err error) {
+	var existing *models.IdempotencyKey
+	if existing, err = models.ReserveIdempotencyKey(ctx, &models.IdempotencyKey{UserID: userID, Key: key, Fingerprint: fingerprint, LockedUntil: lease.Truncate(leasePrecision), Expires: expires}); err != nil {
+		return nil, err
+	}
+
+	if existing == nil {
+		return nil, nil
+	}
+
+	var response *Response
+	if existing.Status.Valid {
+		response = &Response{Status: int(existing.Status.Int64), ContentType: existing.ContentType.String, Body: existing.Body}
+		if existing.Headers != "" && existing.Headers != "{}" {
+			if err = json.Unmarshal([]byte(existing.Headers), &response.Headers); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return replay(fingerprint, existing.Fingerprint, response)
+}
+
+// Complete stores the response to the request of the key that was reserved with the
+// lease.
+func (p *Postgres) Complete(ctx context.Context, userID int64, key string, lease time.Time, response *Response) error {
+	headers := []byte("{}")
+	if len(response.Headers) > 0 {
+		var err error
+		if headers, err = json.Marshal(response.Headers); err != nil {
+			return err
+		}
+	}
+
+	err := models.CompleteIdempotencyKey(ctx, &models.IdempotencyKey{
+		UserID:      userID,
+		Key:         key,
+		Status:      sql.NullInt64{Int64: int64(response.Status), Valid: true},
+		ContentType: sql.NullString{String: response.ContentType, Valid: response.ContentType != ""},
+		Headers:     string(headers),
+		Body:        response.Body,
+		LockedUntil: lease.Truncate(leasePrecision),
+	})
+
+	if errors.Is(err, db.ErrNotFound) {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+// Release deletes the key that was reserved with the lease so that the request can be
+// retried.
+func (p *Postgres) Release(ctx context.Context, userID int64, key string, lease time.Time) error {
+	return models.DeleteIdempotencyKey(ctx, userID, key, lease.Truncate(leasePrecision))
+}
+
+// Run deletes expired keys at the interval until the context is done.
+func (p *Postgres) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if deleted, err := models.DeleteExpiredIdempotencyKeys(ctx, time.Now()); err != nil {
+				if ctx.Err() == nil {
+					log.Warn().Err(err).Msg("could not delete expired idempotency keys")
+				}
+			} else if deleted > 0 {
+				log.Debug().Int64("deleted", deleted).Msg("deleted expired idempotency keys")
+			}
+		}
+	}
+}
@@ -2,22 +2,44 @@ package logger
 
 import (
 	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/bbengfort/cosmos/pkg"
+	"github.com/bbengfort/cosmos/pkg/api/v1"
 	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
 	"github.com/rs/zerolog/log"
 )
 
+// ContextActor is the key of the subject of an administrator that is impersonating the
+// authenticated user in the gin context; if set it is included in the request logs.
+const ContextActor = "actor"
+
+// Request IDs supplied by a proxy or the client are only used if they are reasonable.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID returns the X-Request-ID header of the request if it is valid or a new ULID.
+func RequestID(c *gin.Context) string {
+	if rid := c.GetHeader(api.RequestIDHeader); validRequestID.MatchString(rid) {
+		return rid
+	}
+	return ulid.Make().String()
+}
+
 // GinLogger returns a new Gin middleware that performs logging for our JSON APIs using
 // zerolog rather than the default Gin logger which is a standard HTTP logger. Provide
 // the server name (e.g. adminAPI or BFF) to help us parse the logs.
+// The logger also identifies the request with the X-Request-ID response header, which
+// is included in the logs and in error replies.
 // NOTE: we previously used github.com/dn365/gin-zerolog but wanted more customization.
 func GinLogger(server string) gin.HandlerFunc {
 	version := pkg.Version()
 	return func(c *gin.Context) {
 		// Before request
 		started := time.Now()
+		requestID := RequestID(c)
+		c.Header(api.RequestIDHeader, requestID)
 
 		path := c.Request.URL.Path
 		if c.Request.URL.RawQuery != "" {
@@ -34,12 +56,18 @@ func GinLogger(server string) gin.HandlerFunc {
 			Str("ser_name", server).
 			Str("version", version).
 			Str("method", c.Request.Method).
+			Str("request_id", requestID).
 			Dur("resp_time", time.Since(started)).
 			Int("resp_bytes", c.Writer.Size()).
 			Int("status", status).
 			Str("client_ip", c.ClientIP()).
 			Logger()
 
+		// Log the administrator acting on behalf of the user
+		if actor := c.GetString(ContextActor); actor != "" {
+			logctx = logctx.With().Str("actor", actor).Logger()
+		}
+
 		// Log any errors that were added to the context
 		if len(c.Errors) > 0 {
 			errs := make([]error, 0, len(c.Errors))
@@ -0,0 +1,42 @@
+package logger_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(logger.GinLogger("test"))
+	router.GET("/", func(c *gin.Context) {
+		api.Error(c, http.StatusTeapot, "short and stout")
+	})
+
+	// A request ID is generated if the request does not have one
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusTeapot, w.Code)
+	require.Len(t, w.Header().Get(api.RequestIDHeader), 26, "expected a ulid request id")
+	require.Contains(t, w.Body.String(), `"request_id":"`+w.Header().Get(api.RequestIDHeader)+`"`)
+
+	// A valid request ID is propagated from the request
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(api.RequestIDHeader, "upstream-1234")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, "upstream-1234", w.Header().Get(api.RequestIDHeader))
+
+	// An invalid request ID is replaced
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(api.RequestIDHeader, "not a valid\trequest id")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Len(t, w.Header().Get(api.RequestIDHeader), 26)
+}
@@ -0,0 +1,157 @@
+/*
+Package mail sends transactional emails such as email address verifications, either
+through an SMTP server or by logging them to the console during development.
+*/
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrNoRecipient     = errors.New("email has no recipient")
+	ErrUnknownBackend  = errors.New("unknown mail backend")
+	ErrInvalidAddress  = errors.New("invalid email address")
+	ErrHeaderInjection = errors.New("email headers cannot contain newlines")
+)
+
+// Mailer sends plain text emails.
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Message is a plain text email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// New returns the mailer specified by the configuration.
+func New(conf config.MailConfig) (Mailer, error) {
+	switch conf.Backend {
+	case "console":
+		return &Console{}, nil
+	case "smtp":
+		return NewSMTP(conf)
+	default:
+		return nil, ErrUnknownBackend
+	}
+}
+
+// Validate the recipient and headers of the message.
+func (m *Message) Validate() error {
+	if m.To == "" {
+		return ErrNoRecipient
+	}
+
+	if _, err := mail.ParseAddress(m.To); err != nil {
+		return ErrInvalidAddress
+	}
+
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return ErrHeaderInjection
+	}
+	return nil
+}
+
+// Console logs emails rather than sending them; the messages that have been sent are
+// kept so that they can be inspected in tests.
+type Console struct {
+	sync.Mutex
+	sent []*Message
+}
+
+func (c *Console) Send(_ context.Context, msg *Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+
+	c.Lock()
+	c.sent = append(c.sent, msg)
+	c.Unlock()
+
+	log.Info().Str("to", msg.To).Str("subject", msg.Subject).Str("body", msg.Body).Msg("email sent to console")
+	return nil
+}
+
+// Sent returns the messages that have been sent by the console mailer.
+func (c *Console) Sent() []*Message {
+	c.Lock()
+	defer c.Unlock()
+	sent := make([]*Message, len(c.sent))
+	copy(sent, c.sent)
+	return sent
+}
+
+// SMTP sends emails via an SMTP server, authenticating with PLAIN auth if a username is
+// configured. The connection is upgraded with STARTTLS when the server supports it.
+type SMTP struct {
+	addr string
+	host string
+	from *mail.Address
+	auth smtp.Auth
+}
+
+func NewSMTP(conf config.MailConfig) (_ *SMTP, err error) {
+	m := &SMTP{
+		addr: net.JoinHostPort(conf.SMTPHost, strconv.Itoa(conf.SMTPPort)),
+		host: conf.SMTPHost,
+	}
+
+	if m.from, err = mail.ParseAddress(conf.From); err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %w", conf.From, ErrInvalidAddress)
+	}
+
+	if conf.SMTPUsername != "" {
+		m.auth = smtp.PlainAuth("", conf.SMTPUsername, conf.SMTPPassword, conf.SMTPHost)
+	}
+	return m, nil
+}
+
+func (m *SMTP) Send(ctx context.Context, msg *Message) (err error) {
+	if err = msg.Validate(); err != nil {
+		return err
+	}
+
+	// net/smtp does not accept a context so the send is abandoned rather than canceled
+	errc := make(chan error, 1)
+	go func() {
+		errc <- smtp.SendMail(m.addr, m.auth, m.from.Address, []string{msg.To}, m.format(msg, time.Now()))
+	}()
+
+	select {
+	case err = <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// format the message as an RFC 5322 email with a UTF-8 plain text body.
+func (m *SMTP) format(msg *Message, ts time.Time) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", m.from.String())
+	fmt.Fprintf(buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", ts.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return buf.Bytes()
+}
@@ -0,0 +1,57 @@
+package mail
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	mailer, err := New(config.MailConfig{Backend: "console"})
+	require.NoError(t, err)
+	require.IsType(t, &Console{}, mailer)
+
+	mailer, err = New(config.MailConfig{Backend: "smtp", SMTPHost: "smtp.example.com", SMTPPort: 587, From: "Cosmos <noreply@example.com>"})
+	require.NoError(t, err)
+	require.IsType(t, &SMTP{}, mailer)
+
+	_, err = New(config.MailConfig{Backend: "smtp", SMTPHost: "smtp.example.com", From: "not an address"})
+	require.ErrorIs(t, err, ErrInvalidAddress)
+
+	_, err = New(config.MailConfig{Backend: "pigeon"})
+	require.ErrorIs(t, err, ErrUnknownBackend)
+}
+
+func TestConsole(t *testing.T) {
+	mailer := &Console{}
+	ctx := context.Background()
+
+	require.ErrorIs(t, mailer.Send(ctx, &Message{Subject: "hello"}), ErrNoRecipient)
+	require.ErrorIs(t, mailer.Send(ctx, &Message{To: "nobody"}), ErrInvalidAddress)
+	require.ErrorIs(t, mailer.Send(ctx, &Message{To: "kate@example.com", Subject: "hello\r\nBcc: eve@example.com"}), ErrHeaderInjection)
+	require.Empty(t, mailer.Sent())
+
+	msg := &Message{To: "kate@example.com", Subject: "hello", Body: "world"}
+	require.NoError(t, mailer.Send(ctx, msg))
+	require.Equal(t, []*Message{msg}, mailer.Sent())
+}
+
+func TestFormat(t *testing.T) {
+	mailer, err := NewSMTP(config.MailConfig{SMTPHost: "smtp.example.com", SMTPPort: 587, From: "Cosmos <noreply@example.com>"})
+	require.NoError(t, err)
+
+	ts := time.Date(2022, 3, 14, 12, 0, 0, 0, time.UTC)
+	data := string(mailer.format(&Message{To: "kate@example.com", Subject: "Verify your email", Body: "line one\nline two"}, ts))
+
+	headers, body, ok := strings.Cut(data, "\r\n\r\n")
+	require.True(t, ok, "headers must be separated from the body")
+	require.Contains(t, headers, "From: \"Cosmos\" <noreply@example.com>\r\n")
+	require.Contains(t, headers, "To: kate@example.com\r\n")
+	require.Contains(t, headers, "Subject: Verify your email\r\n")
+	require.Contains(t, headers, "Date: Mon, 14 Mar 2022 12:00:00 +0000")
+	require.Equal(t, "line one\r\nline two", body)
+}
@@ -0,0 +1,17 @@
+package oidc
+
+import "errors"
+
+var (
+	ErrUnknownProvider   = errors.New("unknown identity provider")
+	ErrNoIssuer          = errors.New("identity provider requires an issuer url")
+	ErrNoClientID        = errors.New("identity provider requires a client id")
+	ErrIssuerMismatch    = errors.New("discovered issuer does not match configured issuer")
+	ErrNoIDToken         = errors.New("token response did not contain an id token")
+	ErrInvalidIDToken    = errors.New("could not parse or verify id token")
+	ErrInvalidAudience   = errors.New("id token was not issued for this client")
+	ErrInvalidIssuer     = errors.New("id token was not issued by the identity provider")
+	ErrInvalidNonce      = errors.New("id token nonce does not match authorization request")
+	ErrNoSubject         = errors.New("id token does not contain a subject")
+	ErrUnknownSigningKey = errors.New("id token signed with an unknown key")
+)
@@ -0,0 +1,396 @@
+/*
+Package oidc implements sign in with external OpenID Connect identity providers using
+the authorization code flow with PKCE. Providers are discovered from their issuer URL
+and ID tokens are verified against the provider's published signing keys; the package
+does not issue cosmos tokens itself, it only returns the verified external identity.
+*/
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"golang.org/x/sync/singleflight"
+)
+
+const discoveryPath = "/.well-known/openid-configuration"
+
+// MinKeyRefreshInterval limits how often the signing keys are fetched from the provider
+// so that ID tokens with unknown key ids cannot be used to flood the provider.
+const MinKeyRefreshInterval = time.Minute
+
+// DefaultScopes are requested if no scopes are configured for the provider.
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+// IdentityProvider is implemented by external identity providers so that alternative
+// providers (or stubs in tests) can be plugged into the server.
+type IdentityProvider interface {
+	// Name of the provider as used in routes and to link external identities.
+	Name() string
+
+	// AuthCodeURL returns the URL to redirect the user to in order to authenticate.
+	AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error)
+
+	// Identify exchanges the authorization code for tokens and returns the verified
+	// identity of the user from the ID token.
+	Identify(ctx context.Context, code, verifier, nonce string) (*Identity, error)
+}
+
+// Identity is the verified identity of a user asserted by an external provider.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Config describes how to connect to an OpenID Connect provider.
+type Config struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Metadata is the subset of the provider's discovery document used by the client.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an IdentityProvider for any OpenID Connect compliant issuer. Metadata and
+// signing keys are fetched lazily and cached; keys are refreshed if an ID token is
+// signed with a key that has not been seen before to support key rotation, at most once
+// per MinKeyRefreshInterval; concurrent refreshes share a single request.
+type Provider struct {
+	sync.RWMutex
+	conf      Config
+	client    *http.Client
+	metadata  *Metadata
+	keys      map[string]*rsa.PublicKey
+	refreshed time.Time
+	refresh   singleflight.Group
+}
+
+var _ IdentityProvider = &Provider{}
+
+// New creates a provider from the configuration; no network requests are made until the
+// provider is used so that the server can start if the provider is unavailable.
+func New(conf Config) (_ *Provider, err error) {
+	if conf.Issuer == "" {
+		return nil, ErrNoIssuer
+	}
+
+	if conf.ClientID == "" {
+		return nil, ErrNoClientID
+	}
+
+	if len(conf.Scopes) == 0 {
+		conf.Scopes = DefaultScopes
+	}
+
+	return &Provider{
+		conf:   conf,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Name of the provider.
+func (p *Provider) Name() string {
+	return p.conf.Name
+}
+
+// AuthCodeURL returns the authorization endpoint URL with the authorization request
+// parameters including the S256 code challenge of the PKCE verifier.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, challenge string) (_ string, err error) {
+	var meta *Metadata
+	if meta, err = p.Discover(ctx); err != nil {
+		return "", err
+	}
+
+	var u *url.URL
+	if u, err = url.Parse(meta.AuthorizationEndpoint); err != nil {
+		return "", fmt.Errorf("could not parse authorization endpoint: %w", err)
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.conf.ClientID)
+	q.Set("redirect_uri", p.conf.RedirectURL)
+	q.Set("scope", strings.Join(p.conf.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", challenge)
+	q.Set("code_challenge_method", ChallengeMethod)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Identify exchanges the code for tokens and verifies the returned ID token.
+func (p *Provider) Identify(ctx context.Context, code, verifier, nonce string) (_ *Identity, err error) {
+	var idToken string
+	if idToken, err = p.Exchange(ctx, code, verifier); err != nil {
+		return nil, err
+	}
+	return p.Verify(ctx, idToken, nonce)
+}
+
+// Discover fetches and caches the provider's discovery document.
+func (p *Provider) Discover(ctx context.Context) (_ *Metadata, err error) {
+	p.RLock()
+	meta := p.metadata
+	p.RUnlock()
+
+	if meta != nil {
+		return meta, nil
+	}
+
+	meta = &Metadata{}
+	if err = p.get(ctx, strings.TrimSuffix(p.conf.Issuer, "/")+discoveryPath, meta); err != nil {
+		return nil, fmt.Errorf("could not discover provider metadata: %w", err)
+	}
+
+	if meta.Issuer != p.conf.Issuer {
+		return nil, ErrIssuerMismatch
+	}
+
+	p.Lock()
+	p.metadata = meta
+	p.Unlock()
+	return meta, nil
+}
+
+// tokenReply is the successful response from the token endpoint.
+type tokenReply struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+}
+
+// tokenError is the error response from the token endpoint (RFC 6749 Section 5.2).
+type tokenError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// Exchange the authorization code and PKCE verifier for tokens at the token endpoint,
+// returning the raw ID token. The client authenticates with client_secret_basic if it
+// has a secret, otherwise it is treated as a public client.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (_ string, err error) {
+	var meta *Metadata
+	if meta, err = p.Discover(ctx); err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.conf.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.conf.ClientID)
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode())); err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if p.conf.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.conf.ClientID), url.QueryEscape(p.conf.ClientSecret))
+	}
+
+	var rep *http.Response
+	if rep, err = p.client.Do(req); err != nil {
+		return "", fmt.Errorf("could not exchange authorization code: %w", err)
+	}
+	defer rep.Body.Close()
+
+	if rep.StatusCode != http.StatusOK {
+		terr := &tokenError{}
+		if err = json.NewDecoder(io.LimitReader(rep.Body, 1<<16)).Decode(terr); err == nil && terr.Error != "" {
+			return "", fmt.Errorf("could not exchange authorization code: %s: %s", terr.Error, terr.Description)
+		}
+		return "", fmt.Errorf("could not exchange authorization code: %s", rep.Status)
+	}
+
+	out := &tokenReply{}
+	if err = json.NewDecoder(io.LimitReader(rep.Body, 1<<20)).Decode(out); err != nil {
+		return "", fmt.Errorf("could not decode token response: %w", err)
+	}
+
+	if out.IDToken == "" {
+		return "", ErrNoIDToken
+	}
+	return out.IDToken, nil
+}
+
+// idTokenClaims are the claims of the ID token used to identify the user.
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// Verify the signature and claims of the ID token and return the identity it asserts.
+func (p *Provider) Verify(ctx context.Context, idToken, nonce string) (_ *Identity, err error) {
+	var meta *Metadata
+	if meta, err = p.Discover(ctx); err != nil {
+		return nil, err
+	}
+
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
+	claims := &idTokenClaims{}
+	if _, err = parser.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.signingKey(ctx, kid)
+	}); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIDToken, err)
+	}
+
+	if !claims.VerifyIssuer(meta.Issuer, true) {
+		return nil, ErrInvalidIssuer
+	}
+
+	if !claims.VerifyAudience(p.conf.ClientID, true) {
+		return nil, ErrInvalidAudience
+	}
+
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, ErrInvalidNonce
+	}
+
+	if claims.Subject == "" {
+		return nil, ErrNoSubject
+	}
+
+	return &Identity{
+		Provider:      p.conf.Name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}, nil
+}
+
+// signingKey returns the public key with the specified key id, refreshing the key set
+// from the provider if the key is not in the cache and the keys were not just refreshed.
+func (p *Provider) signingKey(ctx context.Context, kid string) (_ *rsa.PublicKey, err error) {
+	p.RLock()
+	key, ok := p.keys[kid]
+	recent := time.Since(p.refreshed) < MinKeyRefreshInterval
+	p.RUnlock()
+
+	if ok {
+		return key, nil
+	}
+
+	if recent {
+		return nil, ErrUnknownSigningKey
+	}
+
+	if _, err, _ = p.refresh.Do("keys", func() (interface{}, error) {
+		return nil, p.refreshKeys(ctx)
+	}); err != nil {
+		return nil, err
+	}
+
+	p.RLock()
+	key, ok = p.keys[kid]
+	p.RUnlock()
+
+	if !ok {
+		return nil, ErrUnknownSigningKey
+	}
+	return key, nil
+}
+
+// jwks is the JSON web key set published by the provider (RFC 7517).
+type jwks struct {
+	Keys []struct {
+		KeyID   string `json:"kid"`
+		KeyType string `json:"kty"`
+		Use     string `json:"use"`
+		N       string `json:"n"`
+		E       string `json:"e"`
+	} `json:"keys"`
+}
+
+func (p *Provider) refreshKeys(ctx context.Context) (err error) {
+	var meta *Metadata
+	if meta, err = p.Discover(ctx); err != nil {
+		return err
+	}
+
+	set := &jwks{}
+	if err = p.get(ctx, meta.JWKSURI, set); err != nil {
+		return fmt.Errorf("could not fetch provider signing keys: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		// Only RSA signing keys are supported, other keys are ignored
+		if jwk.KeyType != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+
+		var n, e []byte
+		if n, err = base64.RawURLEncoding.DecodeString(jwk.N); err != nil {
+			return fmt.Errorf("could not decode modulus of key %q: %w", jwk.KeyID, err)
+		}
+
+		if e, err = base64.RawURLEncoding.DecodeString(jwk.E); err != nil {
+			return fmt.Errorf("could not decode exponent of key %q: %w", jwk.KeyID, err)
+		}
+
+		keys[jwk.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	p.Lock()
+	p.keys = keys
+	p.refreshed = time.Now()
+	p.Unlock()
+	return nil
+}
+
+// get makes a GET request to the url and decodes the JSON response into out.
+func (p *Provider) get(ctx context.Context, url string, out interface{}) (err error) {
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, url, nil); err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	var rep *http.Response
+	if rep, err = p.client.Do(req); err != nil {
+		return err
+	}
+	defer rep.Body.Close()
+
+	if rep.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status from %s: %s", url, rep.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(rep.Body, 1<<20)).Decode(out)
+}
@@ -0,0 +1,221 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/oidc"
+	"github.com/bbengfort/cosmos/pkg/oidc/oidctest"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURL = "http://localhost:8888/v1/oidc/stub/callback"
+
+func TestPKCE(t *testing.T) {
+	// Test vector from RFC 7636 Appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	require.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", oidc.Challenge(verifier))
+
+	verifier, err := oidc.NewVerifier()
+	require.NoError(t, err)
+	require.Len(t, verifier, 43, "verifier must be between 43 and 128 characters")
+
+	other, err := oidc.NewVerifier()
+	require.NoError(t, err)
+	require.NotEqual(t, verifier, other)
+
+	state, err := oidc.NewState()
+	require.NoError(t, err)
+	require.Len(t, state, 32)
+}
+
+func TestNew(t *testing.T) {
+	_, err := oidc.New(oidc.Config{Name: "stub", ClientID: "client"})
+	require.ErrorIs(t, err, oidc.ErrNoIssuer)
+
+	_, err = oidc.New(oidc.Config{Name: "stub", Issuer: "http://localhost"})
+	require.ErrorIs(t, err, oidc.ErrNoClientID)
+}
+
+func TestProvider(t *testing.T) {
+	for _, secret := range []string{"supersecret", ""} {
+		stub, err := oidctest.New("cosmos", secret)
+		require.NoError(t, err, "could not start stub provider")
+		defer stub.Close()
+
+		provider, err := oidc.New(stub.Config("stub", redirectURL))
+		require.NoError(t, err)
+		require.Equal(t, "stub", provider.Name())
+
+		identity, err := login(t, stub, provider)
+		require.NoError(t, err, "could not login with stub provider")
+		require.Equal(t, &oidc.Identity{
+			Provider:      "stub",
+			Subject:       "1234567890",
+			Email:         "jane@example.com",
+			EmailVerified: true,
+			Name:          "Jane Doe",
+		}, identity)
+	}
+}
+
+func TestProviderIdentity(t *testing.T) {
+	stub, err := oidctest.New("cosmos", "supersecret")
+	require.NoError(t, err, "could not start stub provider")
+	defer stub.Close()
+
+	provider, err := oidc.New(stub.Config("stub", redirectURL))
+	require.NoError(t, err)
+
+	stub.SetIdentity(oidctest.Identity{Subject: "abc", Email: "bob@example.com"})
+	identity, err := login(t, stub, provider)
+	require.NoError(t, err, "could not login with stub provider")
+	require.Equal(t, "abc", identity.Subject)
+	require.Equal(t, "bob@example.com", identity.Email)
+	require.False(t, identity.EmailVerified)
+}
+
+func TestProviderErrors(t *testing.T) {
+	stub, err := oidctest.New("cosmos", "supersecret")
+	require.NoError(t, err, "could not start stub provider")
+	defer stub.Close()
+
+	ctx := context.Background()
+	provider, err := oidc.New(stub.Config("stub", redirectURL))
+	require.NoError(t, err)
+
+	verifier, _ := oidc.NewVerifier()
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", oidc.Challenge(verifier))
+	require.NoError(t, err)
+
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+	require.Equal(t, oidc.ChallengeMethod, u.Query().Get("code_challenge_method"))
+	require.Equal(t, redirectURL, u.Query().Get("redirect_uri"))
+	require.Equal(t, "openid email profile", u.Query().Get("scope"))
+
+	// The wrong verifier cannot be used to exchange the code
+	code, state, err := stub.Authorize(authURL)
+	require.NoError(t, err)
+	require.Equal(t, "state", state)
+
+	other, _ := oidc.NewVerifier()
+	_, err = provider.Identify(ctx, code, other, "nonce")
+	require.ErrorContains(t, err, "invalid_grant")
+
+	// The code cannot be reused
+	_, err = provider.Identify(ctx, code, verifier, "nonce")
+	require.ErrorContains(t, err, "invalid_grant")
+
+	// The nonce must match the authorization request
+	code, _, err = stub.Authorize(authURL)
+	require.NoError(t, err)
+	_, err = provider.Identify(ctx, code, verifier, "othernonce")
+	require.ErrorIs(t, err, oidc.ErrInvalidNonce)
+
+	// The ID token must be issued for the client
+	wrongClient, err := oidc.New(oidc.Config{Name: "stub", Issuer: stub.Issuer(), ClientID: "other", RedirectURL: redirectURL})
+	require.NoError(t, err)
+
+	idToken := exchange(t, stub, provider, authURL, verifier)
+	_, err = wrongClient.Verify(ctx, idToken, "nonce")
+	require.ErrorIs(t, err, oidc.ErrInvalidAudience)
+
+	// Tampered ID tokens are rejected
+	_, err = provider.Verify(ctx, idToken+"a", "nonce")
+	require.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+
+	// The discovered issuer must match the configured issuer
+	mismatch, err := oidc.New(oidc.Config{Name: "stub", Issuer: stub.Issuer() + "/", ClientID: "cosmos"})
+	require.NoError(t, err)
+	_, err = mismatch.Discover(ctx)
+	require.ErrorIs(t, err, oidc.ErrIssuerMismatch)
+}
+
+func TestProviderKeyRefresh(t *testing.T) {
+	stub, err := oidctest.New("cosmos", "supersecret")
+	require.NoError(t, err, "could not start stub provider")
+	defer stub.Close()
+
+	provider, err := oidc.New(stub.Config("stub", redirectURL))
+	require.NoError(t, err)
+
+	// Create an ID token signed with a key that the provider has not published
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+		Issuer:    stub.Issuer(),
+		Subject:   "1234567890",
+		Audience:  jwt.ClaimStrings{"cosmos"},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(5 * time.Minute)),
+	})
+	token.Header["kid"] = "unknown"
+	idToken, err := token.SignedString(key)
+	require.NoError(t, err)
+
+	// Concurrent verifications share a single refresh of the signing keys
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = provider.Verify(context.Background(), idToken, "nonce")
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		require.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+		require.ErrorContains(t, err, oidc.ErrUnknownSigningKey.Error())
+	}
+	require.Equal(t, 1, stub.KeyRequests())
+
+	// Unknown keys do not refresh the signing keys again until the interval has passed
+	_, err = provider.Verify(context.Background(), idToken, "nonce")
+	require.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+	require.Equal(t, 1, stub.KeyRequests())
+
+	// Known keys are verified from the cache
+	_, err = login(t, stub, provider)
+	require.NoError(t, err, "could not login with stub provider")
+	require.Equal(t, 1, stub.KeyRequests())
+}
+
+// login executes the complete authorization code flow with PKCE.
+func login(t *testing.T, stub *oidctest.Server, provider oidc.IdentityProvider) (*oidc.Identity, error) {
+	ctx := context.Background()
+	state, err := oidc.NewState()
+	require.NoError(t, err)
+
+	nonce, err := oidc.NewState()
+	require.NoError(t, err)
+
+	verifier, err := oidc.NewVerifier()
+	require.NoError(t, err)
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, oidc.Challenge(verifier))
+	require.NoError(t, err)
+
+	code, returnedState, err := stub.Authorize(authURL)
+	require.NoError(t, err)
+	require.Equal(t, state, returnedState)
+
+	return provider.Identify(ctx, code, verifier, nonce)
+}
+
+func exchange(t *testing.T, stub *oidctest.Server, provider *oidc.Provider, authURL, verifier string) string {
+	code, _, err := stub.Authorize(authURL)
+	require.NoError(t, err)
+
+	idToken, err := provider.Exchange(context.Background(), code, verifier)
+	require.NoError(t, err)
+	return idToken
+}
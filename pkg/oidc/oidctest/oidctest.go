@@ -0,0 +1,290 @@
+/*
+Package oidctest provides a stub OpenID Connect provider for testing sign in with
+external identity providers without network access. The stub implements discovery,
+the authorization endpoint (which immediately authenticates the configured identity),
+the token endpoint with PKCE verification, and publishes its signing key as a JWKS.
+*/
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/oidc"
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+const keyID = "oidctest"
+
+// Identity is the user that the stub provider authenticates.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Server is a stub OpenID Connect provider running on a local httptest server.
+type Server struct {
+	sync.Mutex
+	srv          *httptest.Server
+	key          *rsa.PrivateKey
+	clientID     string
+	clientSecret string
+	identity     Identity
+	codes        map[string]*authRequest
+	keyRequests  int
+}
+
+type authRequest struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+	identity    Identity
+}
+
+// New starts a stub provider for the specified client credentials; if the secret is
+// empty then the client is treated as a public client. The server should be closed
+// when the test is complete.
+func New(clientID, clientSecret string) (s *Server, err error) {
+	s = &Server{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		identity:     Identity{Subject: "1234567890", Email: "jane@example.com", EmailVerified: true, Name: "Jane Doe"},
+		codes:        make(map[string]*authRequest),
+	}
+
+	if s.key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/jwks", s.jwks)
+
+	s.srv = httptest.NewServer(mux)
+	return s, nil
+}
+
+// Close the stub provider.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Issuer returns the issuer URL of the stub provider.
+func (s *Server) Issuer() string {
+	return s.srv.URL
+}
+
+// Config returns an oidc configuration for connecting to the stub provider.
+func (s *Server) Config(name, redirectURL string) oidc.Config {
+	return oidc.Config{
+		Name:         name,
+		Issuer:       s.Issuer(),
+		ClientID:     s.clientID,
+		ClientSecret: s.clientSecret,
+		RedirectURL:  redirectURL,
+	}
+}
+
+// SetIdentity sets the user that is authenticated by subsequent authorization requests.
+func (s *Server) SetIdentity(identity Identity) {
+	s.Lock()
+	s.identity = identity
+	s.Unlock()
+}
+
+// KeyRequests returns the number of times the signing keys have been fetched.
+func (s *Server) KeyRequests() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.keyRequests
+}
+
+// Authorize acts as the user agent: it makes the request to the authorization URL and
+// returns the code and state from the redirect back to the client.
+func (s *Server) Authorize(authURL string) (code, state string, err error) {
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	var rep *http.Response
+	if rep, err = client.Get(authURL); err != nil {
+		return "", "", err
+	}
+	defer rep.Body.Close()
+
+	if rep.StatusCode != http.StatusFound {
+		return "", "", fmt.Errorf("unexpected authorization response: %s", rep.Status)
+	}
+
+	var location *url.URL
+	if location, err = rep.Location(); err != nil {
+		return "", "", err
+	}
+
+	q := location.Query()
+	if errcode := q.Get("error"); errcode != "" {
+		return "", "", errors.New(errcode)
+	}
+	return q.Get("code"), q.Get("state"), nil
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, &oidc.Metadata{
+		Issuer:                s.Issuer(),
+		AuthorizationEndpoint: s.Issuer() + "/authorize",
+		TokenEndpoint:         s.Issuer() + "/token",
+		JWKSURI:               s.Issuer() + "/jwks",
+	})
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != s.clientID || q.Get("response_type") != "code" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.String() == "" {
+		http.Error(w, "invalid redirect uri", http.StatusBadRequest)
+		return
+	}
+
+	params := redirect.Query()
+	params.Set("state", q.Get("state"))
+
+	// PKCE is required by the stub provider
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != oidc.ChallengeMethod {
+		params.Set("error", "invalid_request")
+	} else {
+		code, _ := oidc.NewState()
+		s.Lock()
+		s.codes[code] = &authRequest{
+			challenge:   q.Get("code_challenge"),
+			nonce:       q.Get("nonce"),
+			redirectURI: q.Get("redirect_uri"),
+			identity:    s.identity,
+		}
+		s.Unlock()
+		params.Set("code", code)
+	}
+
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	// Authenticate the client
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+	}
+
+	if clientID != s.clientID || secret != s.clientSecret {
+		tokenError(w, "invalid_client")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	// Codes can only be used once
+	s.Lock()
+	req, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.Unlock()
+
+	if !ok || req.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	if oidc.Challenge(r.PostForm.Get("code_verifier")) != req.challenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            s.Issuer(),
+		"sub":            req.identity.Subject,
+		"aud":            s.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          req.nonce,
+		"email":          req.identity.Email,
+		"email_verified": req.identity.EmailVerified,
+		"name":           req.identity.Name,
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+
+	idToken, err := token.SignedString(s.key)
+	if err != nil {
+		tokenError(w, "server_error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "oidctest",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	s.keyRequests++
+	s.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kid": keyID,
+				"kty": "RSA",
+				"use": "sig",
+				"alg": jwt.SigningMethodRS256.Alg(),
+				"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+			},
+		},
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
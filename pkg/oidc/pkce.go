@@ -0,0 +1,41 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// PKCE (RFC 7636) binds the authorization code to the client that requested it so that
+// an intercepted code cannot be exchanged for tokens by another party.
+const (
+	ChallengeMethod = "S256"
+	verifierLen     = 32 // number of random bytes in a code verifier (43 base64 characters)
+	stateLen        = 24 // number of random bytes in state and nonce values
+)
+
+// NewVerifier returns a random PKCE code verifier.
+func NewVerifier() (string, error) {
+	return randomString(verifierLen)
+}
+
+// Challenge returns the S256 code challenge for the specified verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// NewState returns a random value suitable for the state or nonce parameters of an
+// authorization request.
+func NewState() (string, error) {
+	return randomString(stateLen)
+}
+
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("could not generate random string: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
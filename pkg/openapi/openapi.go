@@ -0,0 +1,321 @@
+/*
+Package openapi generates an OpenAPI 3 document that describes the routes of a gin
+server. Each route is described by the Go types of its request, query, and reply; the
+JSON schemas of the types are generated by reflection from their json, form, and
+validate struct tags so that the document is always consistent with the API types.
+*/
+package openapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const Version = "3.0.3"
+
+var (
+	ErrDuplicateRoute = errors.New("route has already been added to the document")
+	ErrUnknownMethod  = errors.New("unsupported http method")
+)
+
+// Route describes an endpoint of the API. The path is the gin path of the route, e.g.
+// /v1/galaxy/:id; path parameters are converted to OpenAPI templates. Request is the
+// type of the JSON request body and Query is a struct with form tags that describes the
+// query parameters. Reply is the type of the successful response, which is returned
+// with the status (200 by default) as JSON unless another content type is specified,
+// e.g. text/event-stream for streams of JSON events. If security is specified, the
+// request must be authenticated by one of the named security schemes and the
+// permissions are the permissions the authenticated user must have. Headers are the
+// names of optional request headers that the route accepts. Deprecated routes are still
+// served but clients should migrate to their replacement.
+type Route struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Tag         string
+	Request     interface{}
+	Query       interface{}
+	Reply       interface{}
+	Status      int
+	ContentType string
+	Security    []string
+	Permissions []string
+	Headers     []string
+	Deprecated  bool
+}
+
+// Document is the root of an OpenAPI 3 document.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Servers    []*Server            `json:"servers,omitempty"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+	Tags       []*Tag               `json:"tags,omitempty"`
+
+	errorReply *Schema
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Server struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Name         string `json:"name,omitempty"`
+	In           string `json:"in,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+type PathItem struct {
+	Get        *Operation   `json:"get,omitempty"`
+	Put        *Operation   `json:"put,omitempty"`
+	Post       *Operation   `json:"post,omitempty"`
+	Delete     *Operation   `json:"delete,omitempty"`
+	Patch      *Operation   `json:"patch,omitempty"`
+	Parameters []*Parameter `json:"parameters,omitempty"`
+}
+
+// Operation describes a single API operation on a path. The permissions required by the
+// operation are included with the x-permissions extension since OpenAPI 3.0 security
+// requirements can only list scopes for OAuth2 schemes.
+type Operation struct {
+	OperationID string                `json:"operationId,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Permissions []string              `json:"x-permissions,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Explode  *bool   `json:"explode,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of JSON schema used to describe the API types.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// New creates an empty document for the API.
+func New(info Info) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   make(map[string]*PathItem),
+		Components: Components{
+			Schemas:         make(map[string]*Schema),
+			SecuritySchemes: make(map[string]*SecurityScheme),
+		},
+	}
+}
+
+// ErrorReply sets the type of the reply of unsuccessful requests, which is documented
+// as the default response of every operation.
+func (d *Document) ErrorReply(reply interface{}) {
+	d.errorReply = d.Schema(reflect.TypeOf(reply))
+}
+
+// Add the route to the document, generating the schemas of its types.
+func (d *Document) Add(route Route) (err error) {
+	path, params := PathTemplate(route.Path)
+	item, ok := d.Paths[path]
+	if !ok {
+		item = &PathItem{Parameters: params}
+		d.Paths[path] = item
+	}
+
+	var slot **Operation
+	switch route.Method {
+	case http.MethodGet:
+		slot = &item.Get
+	case http.MethodPut:
+		slot = &item.Put
+	case http.MethodPost:
+		slot = &item.Post
+	case http.MethodDelete:
+		slot = &item.Delete
+	case http.MethodPatch:
+		slot = &item.Patch
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownMethod, route.Method)
+	}
+
+	if *slot != nil {
+		return fmt.Errorf("%w: %s %s", ErrDuplicateRoute, route.Method, route.Path)
+	}
+
+	op := &Operation{
+		OperationID: OperationID(route.Method, route.Path),
+		Summary:     route.Summary,
+		Description: route.Description,
+		Responses:   make(map[string]*Response),
+		Permissions: route.Permissions,
+		Deprecated:  route.Deprecated,
+	}
+
+	if route.Tag != "" {
+		op.Tags = []string{route.Tag}
+	}
+
+	if route.Query != nil {
+		op.Parameters = d.QueryParameters(reflect.TypeOf(route.Query))
+	}
+
+	for _, header := range route.Headers {
+		op.Parameters = append(op.Parameters, &Parameter{Name: header, In: "header", Schema: &Schema{Type: "string"}})
+	}
+
+	if route.Request != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  jsonContent(d.Schema(reflect.TypeOf(route.Request))),
+		}
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	success := &Response{Description: http.StatusText(status)}
+	if route.Reply != nil {
+		success.Content = jsonContent(d.Schema(reflect.TypeOf(route.Reply)))
+		if route.ContentType != "" {
+			success.Content = map[string]*MediaType{route.ContentType: success.Content["application/json"]}
+		}
+	}
+	op.Responses[fmt.Sprint(status)] = success
+
+	if d.errorReply != nil {
+		op.Responses["default"] = &Response{Description: "Error", Content: jsonContent(d.errorReply)}
+	}
+
+	for _, scheme := range route.Security {
+		op.Security = append(op.Security, map[string][]string{scheme: {}})
+	}
+
+	*slot = op
+	return nil
+}
+
+// Operations returns the method and gin path of every operation in the document, e.g.
+// "GET /v1/galaxy/:id", sorted by path and method.
+func (d *Document) Operations() []string {
+	ops := make([]string, 0, len(d.Paths))
+	for path, item := range d.Paths {
+		path = GinPath(path)
+		for method, op := range map[string]*Operation{
+			http.MethodGet:    item.Get,
+			http.MethodPut:    item.Put,
+			http.MethodPost:   item.Post,
+			http.MethodDelete: item.Delete,
+			http.MethodPatch:  item.Patch,
+		} {
+			if op != nil {
+				ops = append(ops, method+" "+path)
+			}
+		}
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+func jsonContent(schema *Schema) map[string]*MediaType {
+	return map[string]*MediaType{"application/json": {Schema: schema}}
+}
+
+// PathTemplate converts a gin path into an OpenAPI path template and returns the path
+// parameters, e.g. /v1/galaxy/:id becomes /v1/galaxy/{id}.
+func PathTemplate(path string) (string, []*Parameter) {
+	var params []*Parameter
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			name := segment[1:]
+			segments[i] = "{" + name + "}"
+			params = append(params, &Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// GinPath converts an OpenAPI path template back into a gin path.
+func GinPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			segments[i] = ":" + segment[1:len(segment)-1]
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// OperationID creates a unique identifier for the operation from its method and path,
+// e.g. GET /v1/galaxy/:id/players becomes getV1GalaxyIdPlayers.
+func OperationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, segment := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '-' || r == '_' }) {
+		segment = strings.TrimLeft(segment, ":*")
+		if segment != "" {
+			id += strings.ToUpper(segment[:1]) + segment[1:]
+		}
+	}
+	return id
+}
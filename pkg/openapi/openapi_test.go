@@ -0,0 +1,94 @@
+package openapi_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bbengfort/cosmos/pkg/openapi"
+	"github.com/stretchr/testify/require"
+)
+
+type Reply struct {
+	Success bool     `json:"success"`
+	Error   string   `json:"error,omitempty"`
+	Fields  []*Field `json:"fields,omitempty"`
+}
+
+type Field struct {
+	Name string `json:"field"`
+}
+
+type CreateRequest struct {
+	Name    string   `json:"name" validate:"required,max=255"`
+	Email   string   `json:"email,omitempty" validate:"omitempty,mailaddr"`
+	Size    string   `json:"size,omitempty" validate:"omitempty,oneof=small large"`
+	Tags    []string `json:"tags" validate:"required,min=1,dive,required"`
+	Count   int64    `json:"count"`
+	Ignored string   `json:"-"`
+}
+
+type Query struct {
+	State    []string `form:"state" validate:"dive,oneof=pending playing"`
+	PageSize int      `form:"page_size" validate:"gte=0"`
+}
+
+func TestDocument(t *testing.T) {
+	doc := openapi.New(openapi.Info{Title: "Test API", Version: "1.0"})
+	doc.ErrorReply(Reply{})
+
+	require.NoError(t, doc.Add(openapi.Route{Method: http.MethodPost, Path: "/v1/things", Request: CreateRequest{}, Reply: Reply{}, Status: http.StatusCreated, Security: []string{"bearer"}, Permissions: []string{"things:create"}, Headers: []string{"Idempotency-Key"}}))
+	require.NoError(t, doc.Add(openapi.Route{Method: http.MethodGet, Path: "/v1/things", Query: Query{}, Reply: Reply{}}))
+	require.NoError(t, doc.Add(openapi.Route{Method: http.MethodDelete, Path: "/v1/things/:id", Reply: Reply{}, Deprecated: true}))
+	require.ErrorIs(t, doc.Add(openapi.Route{Method: http.MethodGet, Path: "/v1/things"}), openapi.ErrDuplicateRoute)
+	require.ErrorIs(t, doc.Add(openapi.Route{Method: http.MethodOptions, Path: "/v1/things"}), openapi.ErrUnknownMethod)
+
+	require.Equal(t, []string{"DELETE /v1/things/:id", "GET /v1/things", "POST /v1/things"}, doc.Operations())
+
+	// Named structs are components that are referenced by the operations
+	create := doc.Paths["/v1/things"].Post
+	require.Equal(t, "postV1Things", create.OperationID)
+	require.Equal(t, "#/components/schemas/CreateRequest", create.RequestBody.Content["application/json"].Schema.Ref)
+	require.Contains(t, create.Responses, "201")
+	require.Contains(t, create.Responses, "default")
+	require.Equal(t, []map[string][]string{{"bearer": {}}}, create.Security)
+	require.Equal(t, []string{"things:create"}, create.Permissions)
+	require.False(t, create.Deprecated)
+	require.Len(t, create.Parameters, 1)
+	require.Equal(t, "Idempotency-Key", create.Parameters[0].Name)
+	require.Equal(t, "header", create.Parameters[0].In)
+	require.True(t, doc.Paths["/v1/things/{id}"].Delete.Deprecated)
+
+	schema := doc.Components.Schemas["CreateRequest"]
+	require.Equal(t, []string{"name", "tags"}, schema.Required)
+	require.NotContains(t, schema.Properties, "-")
+	require.NotContains(t, schema.Properties, "Ignored")
+	require.Equal(t, 255, *schema.Properties["name"].MaxLength)
+	require.Equal(t, "email", schema.Properties["email"].Format)
+	require.Equal(t, []string{"small", "large"}, schema.Properties["size"].Enum)
+	require.Equal(t, 1, *schema.Properties["tags"].MinItems)
+	require.Equal(t, "integer", schema.Properties["count"].Type)
+	require.Equal(t, "#/components/schemas/Field", doc.Components.Schemas["Reply"].Properties["fields"].Items.Ref)
+
+	// Query parameters are described by form tags
+	params := doc.Paths["/v1/things"].Get.Parameters
+	require.Len(t, params, 2)
+	require.Equal(t, "state", params[0].Name)
+	require.Equal(t, "array", params[0].Schema.Type)
+	require.Equal(t, []string{"pending", "playing"}, params[0].Schema.Items.Enum)
+	require.True(t, *params[0].Explode)
+	require.Equal(t, "page_size", params[1].Name)
+
+	// Path parameters are converted to templates
+	item := doc.Paths["/v1/things/{id}"]
+	require.NotNil(t, item)
+	require.Len(t, item.Parameters, 1)
+	require.Equal(t, "path", item.Parameters[0].In)
+}
+
+func TestPathTemplate(t *testing.T) {
+	path, params := openapi.PathTemplate("/v1/galaxy/:id/players/:player/role")
+	require.Equal(t, "/v1/galaxy/{id}/players/{player}/role", path)
+	require.Len(t, params, 2)
+	require.Equal(t, "/v1/galaxy/:id/players/:player/role", openapi.GinPath(path))
+	require.Equal(t, "putV1GalaxyIdPlayersPlayerRole", openapi.OperationID(http.MethodPut, "/v1/galaxy/:id/players/:player/role"))
+}
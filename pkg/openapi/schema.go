@@ -0,0 +1,161 @@
+package openapi
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Schema returns the JSON schema of the type. Named struct types are added to the
+// components of the document and a reference to the component is returned.
+func (d *Document) Schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		if _, ok := d.Components.Schemas[t.Name()]; !ok {
+			// Add a placeholder first in case the type refers to itself
+			d.Components.Schemas[t.Name()] = &Schema{}
+			*d.Components.Schemas[t.Name()] = *d.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	case t.Kind() == reflect.Struct:
+		return d.object(t)
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: d.Schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.Schema(t.Elem())}
+	default:
+		return &Schema{}
+	}
+}
+
+// object returns the schema of a struct, whose properties are named by their json tags.
+func (d *Document) object(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = field.Name
+		}
+
+		prop, required := d.field(field)
+		schema.Properties[name] = prop
+		if required {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+// QueryParameters returns the query parameters described by the form tags of a struct.
+func (d *Document) QueryParameters(t reflect.Type) []*Parameter {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	params := make([]*Parameter, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("form"), ",")
+		if name == "" || name == "-" || !field.IsExported() {
+			continue
+		}
+
+		schema, required := d.field(field)
+		param := &Parameter{Name: name, In: "query", Required: required, Schema: schema}
+		if schema.Type == "array" {
+			explode := true
+			param.Explode = &explode
+		}
+		params = append(params, param)
+	}
+	return params
+}
+
+// field returns the schema of a struct field, constrained by the rules in its validate
+// tag, and if the field is required.
+func (d *Document) field(field reflect.StructField) (schema *Schema, required bool) {
+	schema = d.Schema(field.Type)
+	if schema.Ref != "" {
+		return schema, false
+	}
+
+	// Rules after dive apply to the items of a slice
+	rules := strings.Split(field.Tag.Get("validate"), ",")
+	for i, rule := range rules {
+		if rule == "dive" {
+			if schema.Items != nil && schema.Items.Ref == "" {
+				constrain(schema.Items, rules[i+1:])
+			}
+			rules = rules[:i]
+			break
+		}
+	}
+	return schema, constrain(schema, rules)
+}
+
+// constrain adds the validation rules to the schema and returns true if the field is
+// required. Rules that cannot be described by the schema are ignored.
+func constrain(schema *Schema, rules []string) (required bool) {
+	for _, rule := range rules {
+		tag, param, _ := strings.Cut(rule, "=")
+		switch tag {
+		case "required":
+			required = true
+		case "oneof":
+			schema.Enum = strings.Fields(param)
+		case "mailaddr":
+			schema.Format = "email"
+		case "future":
+			schema.Format = "date-time"
+		case "http_url":
+			schema.Format = "uri"
+		case "min", "max":
+			n, err := strconv.Atoi(param)
+			if err != nil {
+				continue
+			}
+
+			switch {
+			case schema.Type == "string" && tag == "min":
+				schema.MinLength = &n
+			case schema.Type == "string" && tag == "max":
+				schema.MaxLength = &n
+			case schema.Type == "array" && tag == "min":
+				schema.MinItems = &n
+			}
+		}
+	}
+	return required
+}
@@ -0,0 +1,132 @@
+/*
+Package otp implements time-based one-time passwords (TOTP) as described by RFC 6238
+for use as a second authentication factor, along with single-use recovery codes.
+*/
+package otp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits     = 6                // the number of digits in a generated code
+	Period     = 30 * time.Second // the amount of time each code is valid for
+	Skew       = 1                // the number of periods before and after now that are accepted
+	SecretSize = 20               // the number of random bytes in a secret (160 bits per RFC 4226)
+	Algorithm  = "SHA1"           // the hmac algorithm, used for the provisioning uri
+)
+
+var (
+	ErrInvalidSecret = errors.New("invalid otp secret")
+	ErrInvalidCode   = errors.New("invalid otp code")
+)
+
+// Secrets are encoded in base32 without padding for compatibility with authenticators.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates a random base32 encoded secret to share with an authenticator.
+func NewSecret() (_ string, err error) {
+	key := make([]byte, SecretSize)
+	if _, err = rand.Read(key); err != nil {
+		return "", fmt.Errorf("could not generate otp secret: %w", err)
+	}
+	return encoding.EncodeToString(key), nil
+}
+
+// Generate the TOTP code for the secret at the specified time.
+func Generate(secret string, ts time.Time) (_ string, err error) {
+	var key []byte
+	if key, err = decodeSecret(secret); err != nil {
+		return "", err
+	}
+	return HOTP(key, Counter(ts), Digits), nil
+}
+
+// Validate the TOTP code for the secret at the specified time, allowing for clock skew
+// between the server and the authenticator. If the code is valid, the time step counter
+// that matched the code is returned so that callers can prevent the code from being
+// replayed by rejecting codes at or before the last used counter.
+func Validate(secret, code string, ts time.Time) (counter uint64, err error) {
+	var key []byte
+	if key, err = decodeSecret(secret); err != nil {
+		return 0, err
+	}
+
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != Digits {
+		return 0, ErrInvalidCode
+	}
+
+	now := Counter(ts)
+	for i := -Skew; i <= Skew; i++ {
+		counter = uint64(int64(now) + int64(i))
+		if subtle.ConstantTimeCompare([]byte(HOTP(key, counter, Digits)), []byte(code)) == 1 {
+			return counter, nil
+		}
+	}
+	return 0, ErrInvalidCode
+}
+
+// Counter returns the TOTP time step for the specified time.
+func Counter(ts time.Time) uint64 {
+	return uint64(ts.Unix() / int64(Period/time.Second))
+}
+
+// HOTP computes the HMAC-based one-time password for the key and counter as described
+// in RFC 4226, truncated to the specified number of digits.
+func HOTP(key []byte, counter uint64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+// ProvisioningURI returns the otpauth:// uri that can be encoded as a QR code so that
+// the secret can be easily added to an authenticator app.
+// See: https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func ProvisioningURI(secret, issuer, account string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", Algorithm)
+	params.Set("digits", fmt.Sprintf("%d", Digits))
+	params.Set("period", fmt.Sprintf("%d", int(Period/time.Second)))
+
+	uri := &url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: params.Encode(),
+	}
+	return uri.String()
+}
+
+func decodeSecret(secret string) (key []byte, err error) {
+	secret = strings.ToUpper(strings.TrimRight(strings.TrimSpace(secret), "="))
+	if key, err = encoding.DecodeString(secret); err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+	return key, nil
+}
@@ -0,0 +1,118 @@
+package otp_test
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/otp"
+	"github.com/stretchr/testify/require"
+)
+
+// Test vectors from RFC 4226 Appendix D and RFC 6238 Appendix B (SHA1).
+var rfcSecret = []byte("12345678901234567890")
+
+func TestHOTP(t *testing.T) {
+	expected := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+
+	for i, code := range expected {
+		require.Equal(t, code, otp.HOTP(rfcSecret, uint64(i), 6), "test case %d failed", i)
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(rfcSecret)
+
+	testCases := []struct {
+		ts       int64
+		expected string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for i, tc := range testCases {
+		ts := time.Unix(tc.ts, 0)
+		require.Equal(t, tc.expected, otp.HOTP(rfcSecret, otp.Counter(ts), 8), "test case %d failed", i)
+
+		// The six digit code is the suffix of the eight digit code
+		code, err := otp.Generate(secret, ts)
+		require.NoError(t, err, "test case %d failed", i)
+		require.Equal(t, tc.expected[2:], code, "test case %d failed", i)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := otp.NewSecret()
+	require.NoError(t, err, "could not create secret")
+	require.Len(t, secret, 32)
+
+	now := time.Now()
+	code, err := otp.Generate(secret, now)
+	require.NoError(t, err, "could not generate code")
+
+	counter, err := otp.Validate(secret, code, now)
+	require.NoError(t, err, "could not validate code")
+	require.Equal(t, otp.Counter(now), counter)
+
+	// Codes are valid within the allowed skew
+	counter, err = otp.Validate(secret, code, now.Add(otp.Period))
+	require.NoError(t, err, "code should be valid in the next period")
+	require.Equal(t, otp.Counter(now), counter)
+
+	_, err = otp.Validate(secret, code, now.Add(-1*otp.Period))
+	require.NoError(t, err, "code should be valid in the previous period")
+
+	// Codes are not valid outside of the allowed skew
+	_, err = otp.Validate(secret, code, now.Add(3*otp.Period))
+	require.ErrorIs(t, err, otp.ErrInvalidCode)
+
+	// Malformed codes and secrets are rejected
+	_, err = otp.Validate(secret, "12345", now)
+	require.ErrorIs(t, err, otp.ErrInvalidCode)
+
+	_, err = otp.Validate("not a secret!", code, now)
+	require.ErrorIs(t, err, otp.ErrInvalidSecret)
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri, err := url.Parse(otp.ProvisioningURI("JBSWY3DPEHPK3PXP", "Cosmos", "jdoe@example.com"))
+	require.NoError(t, err, "could not parse provisioning uri")
+	require.Equal(t, "otpauth", uri.Scheme)
+	require.Equal(t, "totp", uri.Host)
+	require.Equal(t, "/Cosmos:jdoe@example.com", uri.Path)
+
+	params := uri.Query()
+	require.Equal(t, "JBSWY3DPEHPK3PXP", params.Get("secret"))
+	require.Equal(t, "Cosmos", params.Get("issuer"))
+	require.Equal(t, "6", params.Get("digits"))
+	require.Equal(t, "30", params.Get("period"))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := otp.NewRecoveryCodes(otp.RecoveryCodes)
+	require.NoError(t, err, "could not generate recovery codes")
+	require.Len(t, codes, otp.RecoveryCodes)
+
+	seen := make(map[string]struct{})
+	for _, code := range codes {
+		require.Len(t, code, 11)
+		require.Equal(t, byte('-'), code[5])
+
+		hash := otp.HashRecoveryCode(code)
+		require.Len(t, hash, 64)
+		require.NotContains(t, seen, hash, "duplicate recovery code generated")
+		seen[hash] = struct{}{}
+	}
+
+	// Hashes are computed on the normalized code
+	require.Equal(t, otp.HashRecoveryCode("ABCDE-12345"), otp.HashRecoveryCode(" abcde12345 "))
+}
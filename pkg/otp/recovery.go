@@ -0,0 +1,43 @@
+package otp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	RecoveryCodes   = 10 // the number of recovery codes generated on enrollment
+	recoveryCodeLen = 10 // the number of characters in a recovery code
+)
+
+// Recovery codes use a human friendly alphabet that omits I and O.
+var recoveryEncoding = base32.NewEncoding("0123456789ABCDEFGHJKLMNPQRSTUVWX").WithPadding(base32.NoPadding)
+
+// NewRecoveryCodes generates n random single-use recovery codes of the form XXXXX-XXXXX
+// that can be used in place of a TOTP code if the user loses their authenticator.
+func NewRecoveryCodes(n int) (codes []string, err error) {
+	codes = make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		data := make([]byte, 8)
+		if _, err = rand.Read(data); err != nil {
+			return nil, fmt.Errorf("could not generate recovery code: %w", err)
+		}
+
+		code := recoveryEncoding.EncodeToString(data)[:recoveryCodeLen]
+		codes = append(codes, code[:recoveryCodeLen/2]+"-"+code[recoveryCodeLen/2:])
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode returns the hex encoded SHA-256 hash of the normalized recovery
+// code for storage. Recovery codes are random with high entropy, so a fast hash is
+// sufficient and avoids running the derived key algorithm for every stored code.
+func HashRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,165 @@
+/*
+Package pagination implements cursor based pagination for list endpoints. Cursors are
+serialized as opaque page tokens that encode the sort key and ID of the last item on a
+page so that the next page can be fetched with a keyset query, which unlike offsets is
+efficient for deep pages and stable when items are added or removed between requests.
+*/
+package pagination
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+var (
+	ErrInvalidPageSize  = errors.New("page size must be a positive number")
+	ErrInvalidPageToken = errors.New("could not parse page token")
+	ErrTokenMismatch    = errors.New("page token does not match the order or filters of the query")
+	ErrInvalidOrder     = errors.New("unknown order by field")
+)
+
+// Cursor identifies the position of the last item on a page in a sorted list. The order
+// and the fingerprint of the filters of the query are included so that a page token
+// cannot be used with a different query.
+type Cursor struct {
+	Order  string `json:"o"`
+	Key    string `json:"k"`
+	ID     int64  `json:"i"`
+	Filter string `json:"f,omitempty"`
+}
+
+// New creates a cursor for the item with the specified sort key and ID.
+func New(order Order, key string, id int64, filter string) *Cursor {
+	return &Cursor{Order: order.String(), Key: key, ID: id, Filter: filter}
+}
+
+// Parse a page token created by Cursor.Token. An empty token returns a nil cursor.
+func Parse(token string) (cursor *Cursor, err error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	var data []byte
+	if data, err = base64.RawURLEncoding.DecodeString(token); err != nil {
+		return nil, ErrInvalidPageToken
+	}
+
+	cursor = &Cursor{}
+	if err = json.Unmarshal(data, cursor); err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	return cursor, nil
+}
+
+// Token serializes the cursor as an opaque page token.
+func (c *Cursor) Token() (_ string, err error) {
+	var data []byte
+	if data, err = json.Marshal(c); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Check that the cursor was created for a query with the same order and filters.
+func (c *Cursor) Check(order Order, filter string) error {
+	if c.Order != order.String() || c.Filter != filter {
+		return ErrTokenMismatch
+	}
+	return nil
+}
+
+// PageSize returns the number of items to return for the requested page size. If the
+// page size is zero the default is returned; the maximum page size is never exceeded.
+func PageSize(size int) (int, error) {
+	switch {
+	case size < 0:
+		return 0, ErrInvalidPageSize
+	case size == 0:
+		return DefaultPageSize, nil
+	case size > MaxPageSize:
+		return MaxPageSize, nil
+	default:
+		return size, nil
+	}
+}
+
+// Filter returns a fingerprint of the filters of a query that is stored in the cursor.
+// The order of the values does not matter.
+func Filter(values ...string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	sorted := make([]string, len(values))
+	copy(sorted, values)
+	sort.Strings(sorted)
+
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Order is the field that items are sorted by; items with the same sort key are sorted
+// by their ID in the same direction.
+type Order struct {
+	Field      string
+	Descending bool
+}
+
+// ParseOrder parses an order by field, which is descending if it is prefixed with a
+// minus sign, e.g. "-created". The field must be one of the allowed fields; if the
+// order by field is empty the first allowed field is returned in ascending order.
+func ParseOrder(orderBy string, allowed ...string) (order Order, err error) {
+	orderBy = strings.ToLower(strings.TrimSpace(orderBy))
+	if orderBy == "" {
+		if len(allowed) == 0 {
+			return order, ErrInvalidOrder
+		}
+		return Order{Field: allowed[0]}, nil
+	}
+
+	if strings.HasPrefix(orderBy, "-") {
+		order.Descending = true
+		orderBy = orderBy[1:]
+	}
+
+	for _, field := range allowed {
+		if field == orderBy {
+			order.Field = field
+			return order, nil
+		}
+	}
+	return Order{}, ErrInvalidOrder
+}
+
+func (o Order) String() string {
+	if o.Descending {
+		return "-" + o.Field
+	}
+	return o.Field
+}
+
+// Direction returns the SQL sort direction of the order.
+func (o Order) Direction() string {
+	if o.Descending {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// Comparison returns the SQL operator used to select the items after the cursor.
+func (o Order) Comparison() string {
+	if o.Descending {
+		return "<"
+	}
+	return ">"
+}
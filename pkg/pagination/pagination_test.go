@@ -0,0 +1,99 @@
+package pagination_test
+
+import (
+	"testing"
+
+	"github.com/bbengfort/cosmos/pkg/pagination"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursor(t *testing.T) {
+	order := pagination.Order{Field: "created", Descending: true}
+	filter := pagination.Filter("state:pending", "size:small")
+	cursor := pagination.New(order, "2023-10-01T12:00:00.123456Z", 42, filter)
+
+	token, err := cursor.Token()
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+
+	parsed, err := pagination.Parse(token)
+	require.NoError(t, err)
+	require.Equal(t, cursor, parsed)
+	require.NoError(t, parsed.Check(order, pagination.Filter("size:small", "state:pending")))
+
+	// The cursor cannot be used with a different query
+	require.ErrorIs(t, parsed.Check(pagination.Order{Field: "created"}, filter), pagination.ErrTokenMismatch)
+	require.ErrorIs(t, parsed.Check(order, pagination.Filter("state:playing")), pagination.ErrTokenMismatch)
+	require.ErrorIs(t, parsed.Check(order, ""), pagination.ErrTokenMismatch)
+
+	// An empty token is the first page
+	parsed, err = pagination.Parse("")
+	require.NoError(t, err)
+	require.Nil(t, parsed)
+
+	for _, token := range []string{"foo!", "Zm9v", "e30"} {
+		if parsed, err = pagination.Parse(token); err == nil {
+			// An empty JSON object is parseable but matches no query
+			require.ErrorIs(t, parsed.Check(order, filter), pagination.ErrTokenMismatch)
+			continue
+		}
+		require.ErrorIs(t, err, pagination.ErrInvalidPageToken)
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	testCases := []struct {
+		size     int
+		expected int
+		err      error
+	}{
+		{0, pagination.DefaultPageSize, nil},
+		{1, 1, nil},
+		{100, 100, nil},
+		{pagination.MaxPageSize, pagination.MaxPageSize, nil},
+		{pagination.MaxPageSize + 1, pagination.MaxPageSize, nil},
+		{-1, 0, pagination.ErrInvalidPageSize},
+	}
+
+	for i, tc := range testCases {
+		size, err := pagination.PageSize(tc.size)
+		require.ErrorIs(t, err, tc.err, "test case %d failed", i)
+		require.Equal(t, tc.expected, size, "test case %d failed", i)
+	}
+}
+
+func TestParseOrder(t *testing.T) {
+	allowed := []string{"created", "name"}
+	testCases := []struct {
+		in       string
+		expected pagination.Order
+		err      error
+	}{
+		{"", pagination.Order{Field: "created"}, nil},
+		{"created", pagination.Order{Field: "created"}, nil},
+		{"-created", pagination.Order{Field: "created", Descending: true}, nil},
+		{" Name ", pagination.Order{Field: "name"}, nil},
+		{"-name", pagination.Order{Field: "name", Descending: true}, nil},
+		{"id", pagination.Order{}, pagination.ErrInvalidOrder},
+		{"--name", pagination.Order{}, pagination.ErrInvalidOrder},
+	}
+
+	for i, tc := range testCases {
+		order, err := pagination.ParseOrder(tc.in, allowed...)
+		require.ErrorIs(t, err, tc.err, "test case %d failed", i)
+		require.Equal(t, tc.expected, order, "test case %d failed", i)
+	}
+
+	order := pagination.Order{Field: "name", Descending: true}
+	require.Equal(t, "-name", order.String())
+	require.Equal(t, "DESC", order.Direction())
+	require.Equal(t, "<", order.Comparison())
+
+	order.Descending = false
+	require.Equal(t, "name", order.String())
+	require.Equal(t, "ASC", order.Direction())
+	require.Equal(t, ">", order.Comparison())
+
+	_, err := pagination.ParseOrder("")
+	require.ErrorIs(t, err, pagination.ErrInvalidOrder)
+}
@@ -0,0 +1,123 @@
+/*
+Package pubsub notifies the subscribers of a topic when something new has been published
+to it. Notifications carry no data: subscribers are expected to read what changed from
+the database, which is the source of truth. This allows publishers on any replica to
+notify subscribers on all replicas via Postgres LISTEN/NOTIFY without extra
+infrastructure, and since notifications are coalesced, a slow subscriber never blocks
+the publisher or misses changes; it simply reads several changes at once.
+*/
+package pubsub
+
+import "sync"
+
+// All is the topic that receives the notifications of every topic.
+const All int64 = 0
+
+// Broker notifies the subscribers of topics, which are identified by integer IDs.
+type Broker struct {
+	sync.Mutex
+	subs   map[int64]map[*Subscription]struct{}
+	closed bool
+}
+
+// Subscription receives a value on C when its topic is notified. C has a buffer of one
+// so multiple notifications received before C is read are coalesced. C is closed when
+// the broker is closed.
+type Subscription struct {
+	C      <-chan struct{}
+	c      chan struct{}
+	topic  int64
+	broker *Broker
+}
+
+func New() *Broker {
+	return &Broker{subs: make(map[int64]map[*Subscription]struct{})}
+}
+
+// Subscribe to the notifications of the topic; subscribe to All to receive the
+// notifications of every topic. The subscription must be closed when it is no longer
+// needed. If the broker is closed, the channel of the subscription is already closed.
+func (b *Broker) Subscribe(topic int64) *Subscription {
+	c := make(chan struct{}, 1)
+	sub := &Subscription{C: c, c: c, topic: topic, broker: b}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.closed {
+		close(c)
+		return sub
+	}
+
+	if _, ok := b.subs[topic]; !ok {
+		b.subs[topic] = make(map[*Subscription]struct{})
+	}
+	b.subs[topic][sub] = struct{}{}
+	return sub
+}
+
+// Close the subscription, it will no longer receive notifications.
+func (s *Subscription) Close() {
+	s.broker.Lock()
+	defer s.broker.Unlock()
+
+	if subs, ok := s.broker.subs[s.topic]; ok {
+		if _, ok := subs[s]; ok {
+			delete(subs, s)
+			close(s.c)
+		}
+
+		if len(subs) == 0 {
+			delete(s.broker.subs, s.topic)
+		}
+	}
+}
+
+// Notify the subscribers of the topic and the subscribers of all topics.
+func (b *Broker) Notify(topic int64) {
+	b.Lock()
+	defer b.Unlock()
+
+	for sub := range b.subs[topic] {
+		sub.notify()
+	}
+
+	if topic != All {
+		for sub := range b.subs[All] {
+			sub.notify()
+		}
+	}
+}
+
+// NotifyAll notifies every subscriber, e.g. after notifications may have been missed.
+func (b *Broker) NotifyAll() {
+	b.Lock()
+	defer b.Unlock()
+
+	for _, subs := range b.subs {
+		for sub := range subs {
+			sub.notify()
+		}
+	}
+}
+
+// Close the broker, closing the channels of all subscriptions.
+func (b *Broker) Close() {
+	b.Lock()
+	defer b.Unlock()
+
+	for topic, subs := range b.subs {
+		for sub := range subs {
+			close(sub.c)
+		}
+		delete(b.subs, topic)
+	}
+	b.closed = true
+}
+
+func (s *Subscription) notify() {
+	select {
+	case s.c <- struct{}{}:
+	default:
+	}
+}
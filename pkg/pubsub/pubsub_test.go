@@ -0,0 +1,57 @@
+package pubsub_test
+
+import (
+	"testing"
+
+	"github.com/bbengfort/cosmos/pkg/pubsub"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroker(t *testing.T) {
+	broker := pubsub.New()
+	a := broker.Subscribe(1)
+	b := broker.Subscribe(2)
+	all := broker.Subscribe(pubsub.All)
+
+	// Notifications are coalesced until they are received
+	broker.Notify(1)
+	broker.Notify(1)
+	requireNotified(t, a, true)
+	requireNotified(t, a, false)
+	requireNotified(t, b, false)
+	requireNotified(t, all, true)
+	requireNotified(t, all, false)
+
+	broker.NotifyAll()
+	requireNotified(t, a, true)
+	requireNotified(t, b, true)
+	requireNotified(t, all, true)
+
+	// Closed subscriptions are not notified
+	a.Close()
+	a.Close()
+	_, ok := <-a.C
+	require.False(t, ok, "expected the subscription channel to be closed")
+	broker.Notify(1)
+	requireNotified(t, all, true)
+
+	// Closing the broker closes all subscriptions
+	broker.Close()
+	_, ok = <-b.C
+	require.False(t, ok)
+	_, ok = <-all.C
+	require.False(t, ok)
+	b.Close()
+
+	_, ok = <-broker.Subscribe(1).C
+	require.False(t, ok, "expected subscriptions to a closed broker to be closed")
+}
+
+func requireNotified(t *testing.T, sub *pubsub.Subscription, notified bool) {
+	select {
+	case <-sub.C:
+		require.True(t, notified, "subscription was unexpectedly notified")
+	default:
+		require.False(t, notified, "subscription was not notified")
+	}
+}
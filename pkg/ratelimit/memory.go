@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/config"
+)
+
+// Memory stores the buckets in memory so the quotas apply to each replica separately.
+type Memory struct {
+	sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	expires time.Time // the bucket is full and can be deleted
+}
+
+var _ Store = &Memory{}
+
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]*bucket)}
+}
+
+// Take removes a token from the bucket of the key if one is available.
+func (m *Memory) Take(_ context.Context, key string, limit config.RateLimit) (result Result, err error) {
+	now := time.Now()
+
+	m.Lock()
+	defer m.Unlock()
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		m.buckets[key] = b
+	}
+
+	b.tokens, result = take(limit, b.tokens, b.updated, now)
+	b.updated = now
+	b.expires = now.Add(result.Reset)
+	return result, nil
+}
+
+// Run deletes full buckets at the interval until the context is done.
+func (m *Memory) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Sweep()
+		}
+	}
+}
+
+// Sweep deletes the buckets that have refilled.
+func (m *Memory) Sweep() {
+	now := time.Now()
+
+	m.Lock()
+	defer m.Unlock()
+	for key, b := range m.buckets {
+		if !b.expires.After(now) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// Len returns the number of buckets in memory.
+func (m *Memory) Len() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.buckets)
+}
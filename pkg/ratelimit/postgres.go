@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/rs/zerolog/log"
+)
+
+// Postgres stores the buckets in the database so that the quotas are shared by all of
+// the replicas of the API. Each request locks its bucket so concurrent requests of the
+// same user are counted one at a time by any replica.
+type Postgres struct{}
+
+var _ Store = &Postgres{}
+
+func NewPostgres() *Postgres {
+	return &Postgres{}
+}
+
+// Take removes a token from the bucket of the key if one is available.
+func (p *Postgres) Take(ctx context.Context, key string, limit config.RateLimit) (result Result, err error) {
+	now := time.Now()
+	err = models.UpdateRateLimitBucket(ctx, key, float64(limit.Burst), func(b *models.RateLimitBucket) {
+		b.Tokens, result = take(limit, b.Tokens, b.Updated, now)
+		b.Updated = now
+		b.Expires = now.Add(result.Reset)
+	})
+
+	if err != nil {
+		return Result{}, err
+	}
+	return result, nil
+}
+
+// Run deletes full buckets at the interval until the context is done; every replica
+// deletes the full buckets but deletes are cheap since the expires column is indexed.
+func (p *Postgres) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if deleted, err := models.DeleteExpiredRateLimitBuckets(ctx, time.Now()); err != nil {
+				if ctx.Err() == nil {
+					log.Warn().Err(err).Msg("could not delete full rate limit buckets")
+				}
+			} else if deleted > 0 {
+				log.Debug().Int64("deleted", deleted).Msg("deleted full rate limit buckets")
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Package ratelimit limits the rate of requests to the API with token buckets. Every route
+group has its own quota and each user (or client IP address for unauthenticated
+requests) has a bucket per route group. Authenticated route groups are also limited by
+client IP address before credentials are verified. Buckets are stored in memory by each
+replica or in Postgres so that the quotas are shared by all replicas.
+*/
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// Headers of rate limited responses as described by the IETF RateLimit header fields
+// draft; Retry-After is only set when the request is rejected.
+const (
+	HeaderLimit      = "RateLimit-Limit"
+	HeaderRemaining  = "RateLimit-Remaining"
+	HeaderReset      = "RateLimit-Reset"
+	HeaderPolicy     = "RateLimit-Policy"
+	HeaderRetryAfter = "Retry-After"
+)
+
+// SweepInterval is how often stores delete the buckets that have refilled.
+const SweepInterval = time.Minute
+
+// Store takes tokens from the buckets of keys. Buckets that do not exist are full.
+type Store interface {
+	// Take removes a token from the bucket of the key if one is available.
+	Take(ctx context.Context, key string, limit config.RateLimit) (Result, error)
+
+	// Run deletes full buckets at the interval until the context is done.
+	Run(ctx context.Context, interval time.Duration)
+}
+
+// Result describes the bucket of a key after a token has been taken.
+type Result struct {
+	Allowed    bool          // a token was taken and the request is allowed
+	Limit      int           // the maximum number of tokens in the bucket
+	Remaining  int           // the number of whole tokens left in the bucket
+	Reset      time.Duration // the amount of time until the bucket is full
+	RetryAfter time.Duration // the amount of time until a token is available if not allowed
+}
+
+// take removes a token from a bucket that had the tokens at the updated time, returning
+// the tokens in the bucket at now and the result.
+func take(limit config.RateLimit, tokens float64, updated, now time.Time) (float64, Result) {
+	burst := float64(limit.Burst)
+	if elapsed := now.Sub(updated).Seconds(); elapsed > 0 {
+		tokens = math.Min(burst, tokens+elapsed*limit.Rate)
+	}
+
+	result := Result{Limit: limit.Burst}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - tokens) / limit.Rate)
+	}
+
+	result.Remaining = int(math.Floor(tokens))
+	result.Reset = seconds((burst - tokens) / limit.Rate)
+	return tokens, result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Limit returns middleware that limits the requests of the route group. The middleware
+// must be added after the authentication middleware of the group so that requests are
+// limited by user rather than by client address. Requests are allowed if the store
+// cannot be reached so that the API is not unavailable when the database is slow.
+func Limit(store Store, group string, limit config.RateLimit) gin.HandlerFunc {
+	return limiter(store, group, limit, Key)
+}
+
+// LimitClient returns middleware that limits the requests of the route group by client
+// IP address. The middleware must be added before the authentication middleware of the
+// group so that requests with invalid credentials, which are rejected before the
+// requests are limited by user, are also limited.
+func LimitClient(store Store, group string, limit config.RateLimit) gin.HandlerFunc {
+	return limiter(store, group, limit, ClientKey)
+}
+
+func limiter(store Store, group string, limit config.RateLimit, key func(*gin.Context) string) gin.HandlerFunc {
+	policy := strconv.Itoa(limit.Burst) + ";w=" + strconv.Itoa(ceil(seconds(float64(limit.Burst)/limit.Rate)))
+	return func(c *gin.Context) {
+		result, err := store.Take(c.Request.Context(), group+":"+key(c), limit)
+		if err != nil {
+			log.Error().Err(err).Str("group", group).Msg("could not take rate limit token")
+			c.Next()
+			return
+		}
+
+		c.Header(HeaderLimit, strconv.Itoa(result.Limit))
+		c.Header(HeaderRemaining, strconv.Itoa(result.Remaining))
+		c.Header(HeaderReset, strconv.Itoa(ceil(result.Reset)))
+		c.Header(HeaderPolicy, policy)
+
+		if !result.Allowed {
+			c.Header(HeaderRetryAfter, strconv.Itoa(max(1, ceil(result.RetryAfter))))
+			api.Error(c, http.StatusTooManyRequests, api.ErrRateLimited)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Key identifies the user of authenticated requests or the client IP address of
+// unauthenticated requests.
+func Key(c *gin.Context) string {
+	if claims, err := auth.GetClaims(c); err == nil && claims.Subject != "" {
+		return "user:" + claims.Subject
+	}
+	return ClientKey(c)
+}
+
+// ClientKey identifies the client IP address of the request.
+func ClientKey(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+func ceil(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,146 @@
+package ratelimit_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/bbengfort/cosmos/pkg/ratelimit"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	store := ratelimit.NewMemory()
+	limit := config.RateLimit{Rate: 20, Burst: 3}
+	ctx := context.Background()
+
+	// The burst can be used immediately
+	for i := 2; i >= 0; i-- {
+		result, err := store.Take(ctx, "user:1", limit)
+		require.NoError(t, err)
+		require.True(t, result.Allowed)
+		require.Equal(t, 3, result.Limit)
+		require.Equal(t, i, result.Remaining)
+		require.Zero(t, result.RetryAfter)
+	}
+
+	result, err := store.Take(ctx, "user:1", limit)
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	require.Equal(t, 0, result.Remaining)
+	require.Greater(t, result.RetryAfter, time.Duration(0))
+	require.LessOrEqual(t, result.RetryAfter, 50*time.Millisecond)
+	require.LessOrEqual(t, result.Reset, 150*time.Millisecond)
+
+	// Other keys have their own buckets
+	result, err = store.Take(ctx, "user:2", limit)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+
+	// Tokens are added to the bucket at the rate
+	time.Sleep(result.Reset + 5*time.Millisecond)
+	result, err = store.Take(ctx, "user:1", limit)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+
+	// Buckets are deleted once they are full
+	require.Equal(t, 2, store.Len())
+	time.Sleep(160 * time.Millisecond)
+	store.Sweep()
+	require.Equal(t, 0, store.Len())
+}
+
+func TestLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := ratelimit.NewMemory()
+	limit := config.RateLimit{Rate: 0.5, Burst: 2}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-User"); user != "" {
+			claims := &auth.Claims{}
+			claims.Subject = user
+			c.Set(auth.ContextUserClaims, claims)
+		}
+	})
+	router.GET("/", ratelimit.Limit(store, "test", limit), func(c *gin.Context) {
+		c.JSON(http.StatusOK, api.Reply{Success: true})
+	})
+
+	request := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if user != "" {
+			req.Header.Set("X-User", user)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("7")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "2", w.Header().Get(ratelimit.HeaderLimit))
+	require.Equal(t, "1", w.Header().Get(ratelimit.HeaderRemaining))
+	require.Equal(t, "2", w.Header().Get(ratelimit.HeaderReset))
+	require.Equal(t, "2;w=4", w.Header().Get(ratelimit.HeaderPolicy))
+	require.Empty(t, w.Header().Get(ratelimit.HeaderRetryAfter))
+
+	require.Equal(t, http.StatusOK, request("7").Code)
+
+	// The quota of the user is exhausted
+	w = request("7")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "0", w.Header().Get(ratelimit.HeaderRemaining))
+	require.Equal(t, "2", w.Header().Get(ratelimit.HeaderRetryAfter))
+
+	rep := api.Reply{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rep))
+	require.Equal(t, api.CodeRateLimited, rep.Code)
+
+	// Other users and unauthenticated clients have their own quotas
+	require.Equal(t, http.StatusOK, request("8").Code)
+	require.Equal(t, http.StatusOK, request("").Code)
+	require.Equal(t, http.StatusOK, request("").Code)
+	require.Equal(t, http.StatusTooManyRequests, request("").Code)
+}
+
+func TestLimitClient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := ratelimit.NewMemory()
+	limit := config.RateLimit{Rate: 0.5, Burst: 2}
+
+	// Requests are limited before they are authenticated so that invalid credentials are
+	// limited by client address
+	router := gin.New()
+	router.GET("/", ratelimit.LimitClient(store, "client", limit), func(c *gin.Context) {
+		if c.GetHeader("X-User") == "" {
+			api.Error(c, http.StatusUnauthorized, "invalid credentials")
+			return
+		}
+		c.JSON(http.StatusOK, api.Reply{Success: true})
+	})
+
+	request := func(user, addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = addr
+		if user != "" {
+			req.Header.Set("X-User", user)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusUnauthorized, request("", "192.0.2.1:4000").Code)
+	require.Equal(t, http.StatusUnauthorized, request("", "192.0.2.1:4001").Code)
+	require.Equal(t, http.StatusTooManyRequests, request("", "192.0.2.1:4002").Code)
+	require.Equal(t, http.StatusTooManyRequests, request("7", "192.0.2.1:4003").Code, "users share the quota of their client address")
+	require.Equal(t, http.StatusOK, request("7", "192.0.2.2:4000").Code)
+}
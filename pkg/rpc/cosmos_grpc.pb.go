@@ -0,0 +1,369 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: cosmos.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Auth_Authenticate_FullMethodName = "/cosmos.v1.Auth/Authenticate"
+)
+
+// AuthClient is the client API for Auth service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Auth exchanges credentials for access tokens. Users log in with the v1 API; the gRPC
+// API is intended for bots and service accounts that use API keys.
+type AuthClient interface {
+	// Authenticate exchanges API key credentials for an access token.
+	Authenticate(ctx context.Context, in *APIKeyLoginRequest, opts ...grpc.CallOption) (*LoginReply, error)
+}
+
+type authClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAuthClient(cc grpc.ClientConnInterface) AuthClient {
+	return &authClient{cc}
+}
+
+func (c *authClient) Authenticate(ctx context.Context, in *APIKeyLoginRequest, opts ...grpc.CallOption) (*LoginReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LoginReply)
+	err := c.cc.Invoke(ctx, Auth_Authenticate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AuthServer is the server API for Auth service.
+// All implementations must embed UnimplementedAuthServer
+// for forward compatibility.
+//
+// Auth exchanges credentials for access tokens. Users log in with the v1 API; the gRPC
+// API is intended for bots and service accounts that use API keys.
+type AuthServer interface {
+	// Authenticate exchanges API key credentials for an access token.
+	Authenticate(context.Context, *APIKeyLoginRequest) (*LoginReply, error)
+	mustEmbedUnimplementedAuthServer()
+}
+
+// UnimplementedAuthServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAuthServer struct{}
+
+func (UnimplementedAuthServer) Authenticate(context.Context, *APIKeyLoginRequest) (*LoginReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Authenticate not implemented")
+}
+func (UnimplementedAuthServer) mustEmbedUnimplementedAuthServer() {}
+func (UnimplementedAuthServer) testEmbeddedByValue()              {}
+
+// UnsafeAuthServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AuthServer will
+// result in compilation errors.
+type UnsafeAuthServer interface {
+	mustEmbedUnimplementedAuthServer()
+}
+
+func RegisterAuthServer(s grpc.ServiceRegistrar, srv AuthServer) {
+	// If the following call pancis, it indicates UnimplementedAuthServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Auth_ServiceDesc, srv)
+}
+
+func _Auth_Authenticate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(APIKeyLoginRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServer).Authenticate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Auth_Authenticate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServer).Authenticate(ctx, req.(*APIKeyLoginRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Auth_ServiceDesc is the grpc.ServiceDesc for Auth service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Auth_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cosmos.v1.Auth",
+	HandlerType: (*AuthServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Authenticate",
+			Handler:    _Auth_Authenticate_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "cosmos.proto",
+}
+
+const (
+	Galaxies_ListGalaxies_FullMethodName = "/cosmos.v1.Galaxies/ListGalaxies"
+	Galaxies_GetGalaxy_FullMethodName    = "/cosmos.v1.Galaxies/GetGalaxy"
+	Galaxies_ListPlayers_FullMethodName  = "/cosmos.v1.Galaxies/ListPlayers"
+	Galaxies_StreamEvents_FullMethodName = "/cosmos.v1.Galaxies/StreamEvents"
+)
+
+// GalaxiesClient is the client API for Galaxies service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Galaxies provides the galaxies the user is playing in and their events. Game orders
+// are not part of the v1 API yet and will be added to this service with it.
+type GalaxiesClient interface {
+	// ListGalaxies returns a page of the galaxies the user is playing in.
+	ListGalaxies(ctx context.Context, in *GalaxyQuery, opts ...grpc.CallOption) (*GalaxyList, error)
+	// GetGalaxy returns the galaxy if the user is a player in the galaxy.
+	GetGalaxy(ctx context.Context, in *GalaxyRequest, opts ...grpc.CallOption) (*Galaxy, error)
+	// ListPlayers returns the players in the galaxy and their galaxy roles.
+	ListPlayers(ctx context.Context, in *GalaxyRequest, opts ...grpc.CallOption) (*PlayerList, error)
+	// StreamEvents streams the events of the galaxy that are visible to the user. The
+	// stream ends when the access token expires, when the player leaves the galaxy, or
+	// when the server shuts down; the client should resume the stream with the ID of
+	// the last event it received.
+	StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[GalaxyEvent], error)
+}
+
+type galaxiesClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewGalaxiesClient(cc grpc.ClientConnInterface) GalaxiesClient {
+	return &galaxiesClient{cc}
+}
+
+func (c *galaxiesClient) ListGalaxies(ctx context.Context, in *GalaxyQuery, opts ...grpc.CallOption) (*GalaxyList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GalaxyList)
+	err := c.cc.Invoke(ctx, Galaxies_ListGalaxies_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *galaxiesClient) GetGalaxy(ctx context.Context, in *GalaxyRequest, opts ...grpc.CallOption) (*Galaxy, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Galaxy)
+	err := c.cc.Invoke(ctx, Galaxies_GetGalaxy_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *galaxiesClient) ListPlayers(ctx context.Context, in *GalaxyRequest, opts ...grpc.CallOption) (*PlayerList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PlayerList)
+	err := c.cc.Invoke(ctx, Galaxies_ListPlayers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *galaxiesClient) StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[GalaxyEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Galaxies_ServiceDesc.Streams[0], Galaxies_StreamEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamEventsRequest, GalaxyEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Galaxies_StreamEventsClient = grpc.ServerStreamingClient[GalaxyEvent]
+
+// GalaxiesServer is the server API for Galaxies service.
+// All implementations must embed UnimplementedGalaxiesServer
+// for forward compatibility.
+//
+// Galaxies provides the galaxies the user is playing in and their events. Game orders
+// are not part of the v1 API yet and will be added to this service with it.
+type GalaxiesServer interface {
+	// ListGalaxies returns a page of the galaxies the user is playing in.
+	ListGalaxies(context.Context, *GalaxyQuery) (*GalaxyList, error)
+	// GetGalaxy returns the galaxy if the user is a player in the galaxy.
+	GetGalaxy(context.Context, *GalaxyRequest) (*Galaxy, error)
+	// ListPlayers returns the players in the galaxy and their galaxy roles.
+	ListPlayers(context.Context, *GalaxyRequest) (*PlayerList, error)
+	// StreamEvents streams the events of the galaxy that are visible to the user. The
+	// stream ends when the access token expires, when the player leaves the galaxy, or
+	// when the server shuts down; the client should resume the stream with the ID of
+	// the last event it received.
+	StreamEvents(*StreamEventsRequest, grpc.ServerStreamingServer[GalaxyEvent]) error
+	mustEmbedUnimplementedGalaxiesServer()
+}
+
+// UnimplementedGalaxiesServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedGalaxiesServer struct{}
+
+func (UnimplementedGalaxiesServer) ListGalaxies(context.Context, *GalaxyQuery) (*GalaxyList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListGalaxies not implemented")
+}
+func (UnimplementedGalaxiesServer) GetGalaxy(context.Context, *GalaxyRequest) (*Galaxy, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetGalaxy not implemented")
+}
+func (UnimplementedGalaxiesServer) ListPlayers(context.Context, *GalaxyRequest) (*PlayerList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPlayers not implemented")
+}
+func (UnimplementedGalaxiesServer) StreamEvents(*StreamEventsRequest, grpc.ServerStreamingServer[GalaxyEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamEvents not implemented")
+}
+func (UnimplementedGalaxiesServer) mustEmbedUnimplementedGalaxiesServer() {}
+func (UnimplementedGalaxiesServer) testEmbeddedByValue()                  {}
+
+// UnsafeGalaxiesServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to GalaxiesServer will
+// result in compilation errors.
+type UnsafeGalaxiesServer interface {
+	mustEmbedUnimplementedGalaxiesServer()
+}
+
+func RegisterGalaxiesServer(s grpc.ServiceRegistrar, srv GalaxiesServer) {
+	// If the following call pancis, it indicates UnimplementedGalaxiesServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Galaxies_ServiceDesc, srv)
+}
+
+func _Galaxies_ListGalaxies_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GalaxyQuery)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GalaxiesServer).ListGalaxies(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Galaxies_ListGalaxies_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GalaxiesServer).ListGalaxies(ctx, req.(*GalaxyQuery))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Galaxies_GetGalaxy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GalaxyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GalaxiesServer).GetGalaxy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Galaxies_GetGalaxy_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GalaxiesServer).GetGalaxy(ctx, req.(*GalaxyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Galaxies_ListPlayers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GalaxyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GalaxiesServer).ListPlayers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Galaxies_ListPlayers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GalaxiesServer).ListPlayers(ctx, req.(*GalaxyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Galaxies_StreamEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GalaxiesServer).StreamEvents(m, &grpc.GenericServerStream[StreamEventsRequest, GalaxyEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Galaxies_StreamEventsServer = grpc.ServerStreamingServer[GalaxyEvent]
+
+// Galaxies_ServiceDesc is the grpc.ServiceDesc for Galaxies service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Galaxies_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cosmos.v1.Galaxies",
+	HandlerType: (*GalaxiesServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListGalaxies",
+			Handler:    _Galaxies_ListGalaxies_Handler,
+		},
+		{
+			MethodName: "GetGalaxy",
+			Handler:    _Galaxies_GetGalaxy_Handler,
+		},
+		{
+			MethodName: "ListPlayers",
+			Handler:    _Galaxies_ListPlayers_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamEvents",
+			Handler:       _Galaxies_StreamEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "cosmos.proto",
+}
@@ -0,0 +1,244 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Policy describes how calls to a method are authenticated and authorized.
+type Policy struct {
+	Public      bool     // the method can be called without an access token
+	Permissions []string // the global permissions required to call the method
+}
+
+// Authenticator verifies the bearer access token of calls with the claims issuer of the
+// v1 API and authorizes them according to the policy of the method. Calls to methods
+// without a policy are denied so that new methods are not accidentally public.
+type Authenticator struct {
+	issuer   *auth.ClaimsIssuer
+	policies map[string]Policy
+}
+
+// NewAuthenticator creates an authenticator with the policies of the full method names.
+func NewAuthenticator(issuer *auth.ClaimsIssuer, policies map[string]Policy) *Authenticator {
+	return &Authenticator{issuer: issuer, policies: policies}
+}
+
+// Unary returns an interceptor that authenticates unary calls.
+func (a *Authenticator) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (_ interface{}, err error) {
+		if ctx, err = a.authenticate(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns an interceptor that authenticates streaming calls.
+func (a *Authenticator) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		var ctx context.Context
+		if ctx, err = a.authenticate(stream.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// authenticate returns a context with the claims of the call or a status error. Like
+// the Authorize middleware of the v1 API, calls whose claims have stale permissions
+// are rejected as unauthenticated so that the client reauthenticates.
+func (a *Authenticator) authenticate(ctx context.Context, method string) (_ context.Context, err error) {
+	policy, ok := a.policies[method]
+	if !ok {
+		log.Warn().Str("method", method).Msg("no authorization policy for rpc method")
+		return nil, status.Error(codes.PermissionDenied, auth.ErrNotAuthorized.Error())
+	}
+
+	if policy.Public {
+		return ctx, nil
+	}
+
+	var token string
+	if token, err = AccessToken(ctx); err != nil {
+		log.Debug().Err(err).Msg("no access token in authenticated call")
+		return nil, status.Error(codes.Unauthenticated, auth.ErrAuthRequired.Error())
+	}
+
+	var claims *auth.Claims
+	if claims, err = a.issuer.Verify(token); err != nil {
+		log.Warn().Err(err).Msg("invalid access token in call")
+		return nil, status.Error(codes.Unauthenticated, auth.ErrAuthRequired.Error())
+	}
+
+	// Log the administrator acting on behalf of the user like the v1 request logs
+	if claims.Actor != nil {
+		if call, ok := ctx.Value(callLogKey{}).(*callLog); ok {
+			call.actor = claims.Actor.Subject
+		}
+	}
+
+	// Claims restricted to enrolling a second factor cannot be used to call methods
+	if claims.Enrollment {
+		log.Debug().Str("subject", claims.Subject).Msg("mfa enrollment token used in call")
+		return nil, status.Error(codes.PermissionDenied, auth.ErrMFARequired.Error())
+	}
+
+	if len(policy.Permissions) > 0 {
+		if err = auth.CheckVersions(ctx, a.issuer.Versions(), claims); err != nil {
+			if errors.Is(err, auth.ErrStalePermissions) {
+				log.Debug().Str("subject", claims.Subject).Msg("stale permissions in claims")
+				return nil, status.Error(codes.Unauthenticated, auth.ErrStalePermissions.Error())
+			}
+
+			log.Error().Err(err).Msg("could not check permission versions")
+			return nil, status.Error(codes.Internal, "could not authorize call")
+		}
+
+		if !claims.HasAllPermissions(policy.Permissions...) {
+			log.Warn().Str("method", method).Msg("user does not have required permissions")
+			return nil, status.Error(codes.PermissionDenied, auth.ErrNotAuthorized.Error())
+		}
+	}
+
+	return ContextWithClaims(ctx, claims), nil
+}
+
+// AccessToken returns the bearer token in the authorization metadata of the call.
+func AccessToken(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", auth.ErrNoAuthorization
+	}
+
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return "", auth.ErrNoAuthorization
+	}
+
+	scheme, token, ok := strings.Cut(strings.TrimSpace(values[0]), " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") || strings.TrimSpace(token) == "" {
+		return "", auth.ErrParseBearer
+	}
+	return strings.TrimSpace(token), nil
+}
+
+// contextStream replaces the context of a server stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// UnaryLogger logs unary calls in the same format as the request logs of the v1 API.
+// Like the gin logger it should be the outermost interceptor to record the latency of
+// the call and it also recovers from panics in handlers so the panic can be logged.
+func UnaryLogger(server string) grpc.UnaryServerInterceptor {
+	version := pkg.Version()
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (rep interface{}, err error) {
+		started := time.Now()
+		call := &callLog{}
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(r)
+			}
+			logCall(ctx, call, server, version, info.FullMethod, started, err)
+		}()
+		return handler(context.WithValue(ctx, callLogKey{}, call), req)
+	}
+}
+
+// StreamLogger logs streaming calls when the stream ends; see UnaryLogger.
+func StreamLogger(server string) grpc.StreamServerInterceptor {
+	version := pkg.Version()
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		started := time.Now()
+		call := &callLog{}
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(r)
+			}
+			logCall(stream.Context(), call, server, version, info.FullMethod, started, err)
+		}()
+		return handler(srv, &contextStream{ServerStream: stream, ctx: context.WithValue(stream.Context(), callLogKey{}, call)})
+	}
+}
+
+func recovered(r interface{}) error {
+	log.Error().Str("panic", fmt.Sprint(r)).Bytes("stack", debug.Stack()).Msg("rpc handler panicked")
+	return status.Error(codes.Internal, "an internal error occurred")
+}
+
+// callLog collects the fields of the log of a call that are only known once the call
+// has been authenticated by an inner interceptor.
+type callLog struct {
+	actor string // the subject of an administrator impersonating the user
+}
+
+type callLogKey struct{}
+
+func logCall(ctx context.Context, call *callLog, server, version, method string, started time.Time, err error) {
+	code := status.Code(err)
+	logctx := log.With().
+		Str("ser_name", server).
+		Str("version", version).
+		Str("method", method).
+		Str("code", code.String()).
+		Dur("resp_time", time.Since(started))
+
+	if ip := ClientIP(ctx); ip != "" {
+		logctx = logctx.Str("client_ip", ip)
+	}
+
+	if call.actor != "" {
+		logctx = logctx.Str("actor", call.actor)
+	}
+
+	logger := logctx.Logger()
+	var event *zerolog.Event
+	switch code {
+	case codes.OK, codes.Canceled:
+		event = logger.Info()
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable, codes.DeadlineExceeded:
+		event = logger.Error()
+	default:
+		event = logger.Warn()
+	}
+
+	if err != nil {
+		event = event.Err(err)
+	}
+	event.Msg(fmt.Sprintf("%s %s %s", server, method, code))
+}
+
+// ClientIP returns the IP address of the peer of the call without its port.
+func ClientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,32 @@
+/*
+Package rpc describes the gRPC API of Cosmos, which mirrors the resources of the v1 REST
+API for clients such as game bots that prefer long-lived connections and streaming.
+
+The services and messages are defined in cosmos.proto and the code in the .pb.go files
+is generated by protoc-gen-go and protoc-gen-go-grpc; run go generate in this package
+after changing the definitions. Messages mirror the types of the v1 API and are
+converted to and from them by the functions in this package so that both APIs are
+served by the same handlers.
+*/
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative cosmos.proto
+
+import (
+	"context"
+
+	"github.com/bbengfort/cosmos/pkg/auth"
+)
+
+type claimsKey struct{}
+
+// ContextWithClaims returns a context that carries the claims of an authenticated call.
+func ContextWithClaims(ctx context.Context, claims *auth.Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims of an authenticated call, if any.
+func ClaimsFromContext(ctx context.Context) (claims *auth.Claims, ok bool) {
+	claims, ok = ctx.Value(claimsKey{}).(*auth.Claims)
+	return claims, ok && claims != nil
+}
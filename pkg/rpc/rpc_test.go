@@ -0,0 +1,248 @@
+package rpc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/auth"
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/bbengfort/cosmos/pkg/rpc"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestRPC(t *testing.T) {
+	issuer, err := auth.NewIssuer(config.AuthConfig{
+		Keys:           map[string]string{"01GE62EXXR0X0561XD53RDFBQJ": "../auth/testdata/01GE62EXXR0X0561XD53RDFBQJ.pem"},
+		Audience:       "http://localhost:3000",
+		Issuer:         "http://localhost:3000",
+		AccessTokenTTL: time.Hour,
+	})
+	require.NoError(t, err)
+
+	client := newClient(t, issuer)
+	ctx := context.Background()
+
+	// Public methods can be called without an access token
+	login, err := client.auth.Authenticate(ctx, &rpc.APIKeyLoginRequest{ClientId: "bot", ClientSecret: "secret"})
+	require.NoError(t, err)
+	require.Equal(t, "token for bot", login.AccessToken)
+
+	// Other methods require a valid access token
+	_, err = client.galaxies.ListGalaxies(ctx, &rpc.GalaxyQuery{})
+	requireCode(t, codes.Unauthenticated, err)
+
+	_, err = client.galaxies.ListGalaxies(ctx, &rpc.GalaxyQuery{}, rpc.WithAccessToken("notatoken"))
+	requireCode(t, codes.Unauthenticated, err)
+
+	player := accessToken(t, issuer, "galaxy:play")
+	observer := accessToken(t, issuer, "galaxy:observe")
+
+	galaxies, err := client.galaxies.ListGalaxies(ctx, &rpc.GalaxyQuery{State: []string{"playing"}, PageSize: 10}, rpc.WithAccessToken(player))
+	require.NoError(t, err)
+	require.Len(t, galaxies.Galaxies, 1)
+	require.Equal(t, "playing", galaxies.Galaxies[0].State)
+	require.Equal(t, "next", galaxies.NextPageToken)
+
+	// Methods are authorized with the global permissions of their policy
+	_, err = client.galaxies.ListGalaxies(ctx, &rpc.GalaxyQuery{}, rpc.WithAccessToken(observer))
+	requireCode(t, codes.PermissionDenied, err)
+
+	// Methods without a policy are denied
+	_, err = client.galaxies.ListPlayers(ctx, &rpc.GalaxyRequest{GalaxyId: 1}, rpc.WithAccessToken(player))
+	requireCode(t, codes.PermissionDenied, err)
+
+	// Handlers receive the claims of the call
+	galaxy, err := client.galaxies.GetGalaxy(ctx, &rpc.GalaxyRequest{GalaxyId: 42}, rpc.WithAccessToken(observer))
+	require.NoError(t, err)
+	require.Equal(t, int64(42), galaxy.Id)
+	require.Equal(t, "user 7", galaxy.Name)
+
+	// Panics in handlers are recovered
+	_, err = client.galaxies.GetGalaxy(ctx, &rpc.GalaxyRequest{GalaxyId: 0}, rpc.WithAccessToken(observer))
+	requireCode(t, codes.Internal, err)
+
+	// Streams are authenticated and receive every event sent by the server
+	stream, err := client.galaxies.StreamEvents(ctx, &rpc.StreamEventsRequest{GalaxyId: 42}, rpc.WithAccessToken(observer))
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	requireCode(t, codes.PermissionDenied, err)
+
+	lastID := int64(2)
+	stream, err = client.galaxies.StreamEvents(ctx, &rpc.StreamEventsRequest{GalaxyId: 42, LastEventId: &lastID}, rpc.WithAccessToken(player))
+	require.NoError(t, err)
+
+	for _, expected := range []int64{3, 4, 5} {
+		event, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, expected, event.Id)
+		require.Equal(t, int64(42), event.GalaxyId)
+		require.Equal(t, api.EventTurnAdvanced, event.Type)
+		require.JSONEq(t, `{"turn": 12}`, string(event.Data))
+	}
+
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestLogActor(t *testing.T) {
+	issuer, err := auth.NewIssuer(config.AuthConfig{
+		Keys:           map[string]string{"01GE62EXXR0X0561XD53RDFBQJ": "../auth/testdata/01GE62EXXR0X0561XD53RDFBQJ.pem"},
+		Audience:       "http://localhost:3000",
+		Issuer:         "http://localhost:3000",
+		AccessTokenTTL: time.Hour,
+	})
+	require.NoError(t, err)
+
+	logs := &bytes.Buffer{}
+	prev := log.Logger
+	log.Logger = zerolog.New(logs)
+	t.Cleanup(func() { log.Logger = prev })
+
+	client := newClient(t, issuer)
+	ctx := context.Background()
+
+	// Calls made with an impersonation token are logged with the administrator
+	claims := &auth.Claims{Permissions: []string{"galaxy:play"}, Actor: &auth.Actor{Subject: "1"}}
+	claims.SetSubjectID(7)
+	impersonated := signClaims(t, issuer, claims)
+
+	_, err = client.galaxies.GetGalaxy(ctx, &rpc.GalaxyRequest{GalaxyId: 42}, rpc.WithAccessToken(impersonated))
+	require.NoError(t, err)
+
+	stream, err := client.galaxies.StreamEvents(ctx, &rpc.StreamEventsRequest{GalaxyId: 42}, rpc.WithAccessToken(impersonated))
+	require.NoError(t, err)
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	require.ErrorIs(t, err, io.EOF)
+
+	// Calls made by the user are not logged with an actor
+	_, err = client.galaxies.GetGalaxy(ctx, &rpc.GalaxyRequest{GalaxyId: 42}, rpc.WithAccessToken(accessToken(t, issuer)))
+	require.NoError(t, err)
+
+	// Only the calls are parsed from the logs, other messages are skipped
+	var actors []interface{}
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		entry := make(map[string]interface{})
+		require.NoError(t, json.Unmarshal(line, &entry))
+		if _, ok := entry["code"]; ok {
+			actors = append(actors, entry["actor"])
+		}
+	}
+	require.Equal(t, []interface{}{"1", "1", nil}, actors)
+}
+
+type testClient struct {
+	auth     rpc.AuthClient
+	galaxies rpc.GalaxiesClient
+}
+
+// newClient serves the test services over an in-memory connection.
+func newClient(t *testing.T, issuer *auth.ClaimsIssuer) *testClient {
+	authenticator := rpc.NewAuthenticator(issuer, map[string]rpc.Policy{
+		rpc.AuthenticateMethod: {Public: true},
+		rpc.ListGalaxiesMethod: {Permissions: []string{"galaxy:play"}},
+		rpc.GetGalaxyMethod:    {},
+		rpc.StreamEventsMethod: {Permissions: []string{"galaxy:play"}},
+	})
+
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(rpc.UnaryLogger("test"), authenticator.Unary()),
+		grpc.ChainStreamInterceptor(rpc.StreamLogger("test"), authenticator.Stream()),
+	)
+
+	svc := &testService{}
+	rpc.RegisterAuthServer(srv, svc)
+	rpc.RegisterGalaxiesServer(srv, svc)
+
+	sock := bufconn.Listen(1024 * 1024)
+	go srv.Serve(sock)
+	t.Cleanup(srv.Stop)
+
+	cc, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return sock.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { cc.Close() })
+
+	return &testClient{auth: rpc.NewAuthClient(cc), galaxies: rpc.NewGalaxiesClient(cc)}
+}
+
+func accessToken(t *testing.T, issuer *auth.ClaimsIssuer, permissions ...string) string {
+	claims := &auth.Claims{Permissions: permissions}
+	claims.SetSubjectID(7)
+	return signClaims(t, issuer, claims)
+}
+
+func signClaims(t *testing.T, issuer *auth.ClaimsIssuer, claims *auth.Claims) string {
+
+	token, err := issuer.CreateAccessToken(claims)
+	require.NoError(t, err)
+
+	tks, err := issuer.Sign(token)
+	require.NoError(t, err)
+	return tks
+}
+
+func requireCode(t *testing.T, code codes.Code, err error) {
+	require.Error(t, err)
+	require.Equal(t, code, status.Code(err), "unexpected status %s", err)
+}
+
+type testService struct {
+	rpc.UnimplementedAuthServer
+	rpc.UnimplementedGalaxiesServer
+}
+
+func (testService) Authenticate(_ context.Context, in *rpc.APIKeyLoginRequest) (*rpc.LoginReply, error) {
+	return &rpc.LoginReply{AccessToken: "token for " + in.ClientId}, nil
+}
+
+func (testService) ListGalaxies(_ context.Context, in *rpc.GalaxyQuery) (*rpc.GalaxyList, error) {
+	if len(in.State) != 1 || in.PageSize != 10 {
+		return nil, status.Error(codes.InvalidArgument, "query was not decoded")
+	}
+	return &rpc.GalaxyList{Galaxies: []*rpc.Galaxy{{Id: 1, State: in.State[0]}}, NextPageToken: "next"}, nil
+}
+
+func (testService) GetGalaxy(ctx context.Context, in *rpc.GalaxyRequest) (*rpc.Galaxy, error) {
+	if in.GalaxyId == 0 {
+		panic("no galaxy")
+	}
+
+	claims, ok := rpc.ClaimsFromContext(ctx)
+	if !ok {
+		return nil, errors.New("no claims")
+	}
+	return &rpc.Galaxy{Id: in.GalaxyId, Name: "user " + claims.Subject}, nil
+}
+
+func (testService) ListPlayers(context.Context, *rpc.GalaxyRequest) (*rpc.PlayerList, error) {
+	return &rpc.PlayerList{}, nil
+}
+
+func (testService) StreamEvents(in *rpc.StreamEventsRequest, stream rpc.Galaxies_StreamEventsServer) error {
+	for id := in.GetLastEventId() + 1; id <= 5; id++ {
+		if err := stream.Send(&rpc.GalaxyEvent{Id: id, GalaxyId: in.GalaxyId, Type: api.EventTurnAdvanced, Data: []byte(`{"turn": 12}`)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
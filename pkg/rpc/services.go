@@ -0,0 +1,91 @@
+package rpc
+
+import (
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+)
+
+// Service and full method names of the gRPC API.
+// NOTE: the full method names are used to look up the authorization policy of a call.
+const (
+	AuthService     = "cosmos.v1.Auth"
+	GalaxiesService = "cosmos.v1.Galaxies"
+
+	AuthenticateMethod = Auth_Authenticate_FullMethodName
+	ListGalaxiesMethod = Galaxies_ListGalaxies_FullMethodName
+	GetGalaxyMethod    = Galaxies_GetGalaxy_FullMethodName
+	ListPlayersMethod  = Galaxies_ListPlayers_FullMethodName
+	StreamEventsMethod = Galaxies_StreamEvents_FullMethodName
+)
+
+// APIKeyLogin returns the v1 login request of the credentials.
+func (x *APIKeyLoginRequest) APIKeyLogin() *api.APIKeyLoginRequest {
+	return &api.APIKeyLoginRequest{ClientID: x.GetClientId(), ClientSecret: x.GetClientSecret()}
+}
+
+// Query returns the v1 galaxy query of the request.
+func (x *GalaxyQuery) Query() *api.GalaxyQuery {
+	return &api.GalaxyQuery{
+		State:     x.GetState(),
+		Size:      x.GetSize(),
+		OrderBy:   x.GetOrderBy(),
+		PageSize:  int(x.GetPageSize()),
+		PageToken: x.GetPageToken(),
+	}
+}
+
+// NewLoginReply converts the tokens of a v1 login reply.
+func NewLoginReply(in *api.LoginReply) *LoginReply {
+	return &LoginReply{AccessToken: in.AccessToken, RefreshToken: in.RefreshToken}
+}
+
+// NewGalaxyList converts a page of v1 galaxies.
+func NewGalaxyList(in *api.GalaxyList) *GalaxyList {
+	out := &GalaxyList{Galaxies: make([]*Galaxy, 0, len(in.Galaxies)), NextPageToken: in.NextPageToken}
+	for _, galaxy := range in.Galaxies {
+		out.Galaxies = append(out.Galaxies, NewGalaxy(galaxy))
+	}
+	return out
+}
+
+// NewGalaxy converts a v1 galaxy.
+func NewGalaxy(in *api.Galaxy) *Galaxy {
+	return &Galaxy{
+		Id:         in.ID,
+		Name:       in.Name,
+		Turn:       in.Turn,
+		Size:       in.Size,
+		MaxPlayers: int32(in.MaxPlayers),
+		MaxTurns:   in.MaxTurns,
+		JoinCode:   in.JoinCode,
+		State:      in.State,
+		Created:    in.Created,
+		Modified:   in.Modified,
+	}
+}
+
+// NewPlayerList converts the v1 players of a galaxy.
+func NewPlayerList(in *api.PlayerList) *PlayerList {
+	out := &PlayerList{Players: make([]*Player, 0, len(in.Players))}
+	for _, player := range in.Players {
+		out.Players = append(out.Players, &Player{
+			UserId:    player.UserID,
+			Name:      player.Name,
+			Role:      player.Role,
+			Faction:   player.Faction,
+			Character: player.Character,
+			Joined:    player.Joined,
+		})
+	}
+	return out
+}
+
+// NewGalaxyEvent converts a v1 galaxy event.
+func NewGalaxyEvent(in *api.GalaxyEvent) *GalaxyEvent {
+	return &GalaxyEvent{
+		Id:       in.ID,
+		GalaxyId: in.GalaxyID,
+		Type:     in.Type,
+		Data:     in.Data,
+		Created:  in.Created,
+	}
+}
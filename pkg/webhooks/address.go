@@ -0,0 +1,112 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"syscall"
+)
+
+var (
+	ErrInvalidURL        = errors.New("webhook url must be an absolute http or https url")
+	ErrForbiddenAddress  = errors.New("webhook url must not resolve to a loopback, link-local, private or unspecified address")
+	ErrUnresolvedAddress = errors.New("webhook url host could not be resolved")
+)
+
+// Address ranges that are not routable on the public internet but are not reported by
+// the netip address methods: "this network", carrier-grade NAT shared address space and
+// benchmarking networks.
+var forbiddenPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+}
+
+// IPv6 prefixes of translation mechanisms that embed an IPv4 address, which is checked
+// since the translated requests are delivered to the embedded address.
+var (
+	nat64Prefix     = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFourPrefix = netip.MustParsePrefix("2002::/16")
+)
+
+// PublicAddress returns an error unless the IP address is routable on the public
+// internet, so that webhooks cannot be used to send requests to the services inside
+// of the network of the API servers, e.g. the cloud metadata service.
+func PublicAddress(ip netip.Addr) error {
+	ip = ip.Unmap()
+	switch {
+	case !ip.IsValid(),
+		ip.IsUnspecified(),
+		ip.IsLoopback(),
+		ip.IsPrivate(),
+		ip.IsLinkLocalUnicast(),
+		ip.IsLinkLocalMulticast(),
+		ip.IsInterfaceLocalMulticast(),
+		ip.IsMulticast():
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, ip)
+	}
+
+	for _, prefix := range forbiddenPrefixes {
+		if prefix.Contains(ip) {
+			return fmt.Errorf("%w: %s", ErrForbiddenAddress, ip)
+		}
+	}
+
+	// The embedded IPv4 address is the last 4 bytes of NAT64 addresses and follows the
+	// 2002::/16 prefix of 6to4 addresses.
+	var embedded [4]byte
+	switch addr := ip.As16(); {
+	case nat64Prefix.Contains(ip):
+		copy(embedded[:], addr[12:16])
+	case sixToFourPrefix.Contains(ip):
+		copy(embedded[:], addr[2:6])
+	default:
+		return nil
+	}
+
+	if err := PublicAddress(netip.AddrFrom4(embedded)); err != nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, ip)
+	}
+	return nil
+}
+
+// CheckURL returns an error if the webhook URL is not an http or https URL or if its
+// host resolves to an address that is not public. The resolved addresses may change
+// after the webhook is created, so the addresses are checked again when deliveries are
+// dialed.
+func CheckURL(ctx context.Context, rawURL string) (err error) {
+	var u *url.URL
+	if u, err = url.Parse(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrInvalidURL
+	}
+
+	if ip, err := netip.ParseAddr(u.Hostname()); err == nil {
+		return PublicAddress(ip)
+	}
+
+	var addrs []netip.Addr
+	if addrs, err = net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname()); err != nil || len(addrs) == 0 {
+		return ErrUnresolvedAddress
+	}
+
+	for _, addr := range addrs {
+		if err = PublicAddress(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dialControl rejects connections to addresses that are not public. It is called after
+// the host of a delivery is resolved, so hosts that are rebound to an internal address
+// after the webhook is created cannot be dialed.
+func dialControl(network, address string, _ syscall.RawConn) error {
+	addr, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, address)
+	}
+	return PublicAddress(addr.Addr())
+}
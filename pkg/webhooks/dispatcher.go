@@ -0,0 +1,214 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg"
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/rs/zerolog/log"
+)
+
+// The response body of receivers is read so the connection can be reused but is ignored
+const maxResponseBody = 64 * 1024
+
+// Dispatcher delivers the webhook deliveries that are queued in the database.
+type Dispatcher struct {
+	conf   config.WebhooksConfig
+	client *http.Client
+}
+
+func NewDispatcher(conf config.WebhooksConfig) *Dispatcher {
+	// Receivers are dialed directly rather than through an environment proxy so that
+	// the resolved address of every connection is checked before it is made.
+	dialer := &net.Dialer{Timeout: conf.Timeout}
+	if !conf.AllowPrivate {
+		dialer.Control = dialControl
+	}
+
+	return &Dispatcher{
+		conf: conf,
+		client: &http.Client{
+			Timeout:   conf.Timeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext, MaxIdleConnsPerHost: conf.Workers, TLSHandshakeTimeout: conf.Timeout},
+			// Redirects are not followed since the receiver is specified by the user
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Run delivers the deliveries that are due until the context is canceled or the wake
+// channel is closed. The queue is checked when the wake channel receives a value, e.g.
+// when new galaxy events are recorded, and at the poll interval for retries.
+func (d *Dispatcher) Run(ctx context.Context, wake <-chan struct{}) {
+	ticker := time.NewTicker(d.conf.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.process(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case _, ok := <-wake:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// process claims and delivers batches of deliveries until no deliveries are due.
+func (d *Dispatcher) process(ctx context.Context) {
+	for ctx.Err() == nil {
+		deliveries, err := models.ClaimWebhookDeliveries(ctx, d.conf.BatchSize, d.lease())
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Warn().Err(err).Msg("could not claim webhook deliveries")
+			}
+			return
+		}
+
+		var wg sync.WaitGroup
+		workers := make(chan struct{}, d.conf.Workers)
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			workers <- struct{}{}
+			go func(delivery *models.PendingDelivery) {
+				defer func() {
+					<-workers
+					wg.Done()
+				}()
+				d.attempt(ctx, delivery)
+			}(delivery)
+		}
+		wg.Wait()
+
+		if len(deliveries) < d.conf.BatchSize {
+			return
+		}
+	}
+}
+
+// attempt to deliver the delivery and record the outcome in the delivery log. If the
+// server is shutting down the outcome is not recorded; the delivery is retried when
+// its claim expires.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *models.PendingDelivery) {
+	status, err := d.Deliver(ctx, delivery)
+	if ctx.Err() != nil {
+		return
+	}
+
+	outcome := &models.WebhookAttempt{
+		DeliveryID:     delivery.ID,
+		ResponseStatus: sql.NullInt64{Valid: status > 0, Int64: int64(status)},
+	}
+
+	switch {
+	case err == nil:
+		outcome.Status = models.DeliveryDelivered
+		outcome.Delivered = sql.NullTime{Valid: true, Time: time.Now()}
+		outcome.NextAttempt = outcome.Delivered.Time
+	case delivery.Attempts >= d.conf.MaxAttempts:
+		outcome.Status = models.DeliveryFailed
+		outcome.Error = sql.NullString{Valid: true, String: err.Error()}
+		outcome.NextAttempt = time.Now()
+	default:
+		outcome.Status = models.DeliveryPending
+		outcome.Error = sql.NullString{Valid: true, String: err.Error()}
+		outcome.NextAttempt = time.Now().Add(d.Backoff(delivery.Attempts))
+	}
+
+	log.Debug().
+		Int64("delivery_id", delivery.ID).
+		Int64("webhook_id", delivery.WebhookID).
+		Int("attempts", delivery.Attempts).
+		Int("response_status", status).
+		Str("status", outcome.Status).
+		Msg("webhook delivery attempted")
+
+	if err = models.RecordWebhookAttempt(ctx, outcome); err != nil {
+		log.Error().Err(err).Int64("delivery_id", delivery.ID).Msg("could not record webhook delivery attempt")
+	}
+}
+
+// Deliver the event to the webhook receiver, returning the status code of the response
+// if the receiver responded. An error is returned unless the receiver responded with a
+// 2xx status code.
+func (d *Dispatcher) Deliver(ctx context.Context, delivery *models.PendingDelivery) (status int, err error) {
+	var body []byte
+	if body, err = json.Marshal(Payload(delivery)); err != nil {
+		return 0, err
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body)); err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Cosmos-Webhooks/"+pkg.Version())
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(WebhookHeader, strconv.FormatInt(delivery.WebhookID, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, time.Now(), body))
+
+	var rep *http.Response
+	if rep, err = d.client.Do(req); err != nil {
+		return 0, err
+	}
+	defer rep.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(rep.Body, maxResponseBody))
+
+	if rep.StatusCode < 200 || rep.StatusCode >= 300 {
+		return rep.StatusCode, fmt.Errorf("%w: %s", ErrUnsuccessful, rep.Status)
+	}
+	return rep.StatusCode, nil
+}
+
+// Backoff returns the delay before the next attempt after the specified number of
+// failed attempts, which doubles with every attempt up to the maximum backoff.
+func (d *Dispatcher) Backoff(attempts int) time.Duration {
+	backoff := d.conf.Backoff
+	for i := 1; i < attempts && backoff < d.conf.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > d.conf.MaxBackoff {
+		return d.conf.MaxBackoff
+	}
+	return backoff
+}
+
+// lease is how long deliveries are claimed for, which allows every delivery in a batch
+// to be attempted before the claims expire.
+func (d *Dispatcher) lease() time.Duration {
+	rounds := (d.conf.BatchSize + d.conf.Workers - 1) / d.conf.Workers
+	return time.Duration(rounds+1) * d.conf.Timeout
+}
+
+// Payload returns the body of a delivery, which is the galaxy event in the same format
+// as the events of the galaxy event stream.
+func Payload(delivery *models.PendingDelivery) *api.GalaxyEvent {
+	return &api.GalaxyEvent{
+		ID:       delivery.EventID,
+		GalaxyID: delivery.GalaxyID,
+		Type:     delivery.EventType,
+		Data:     json.RawMessage(delivery.Data),
+		Created:  delivery.EventCreated.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Package webhooks delivers galaxy events to the webhooks of users. Deliveries are queued
+in Postgres by a database trigger when events are recorded and are claimed by the
+dispatcher of any API server, so deliveries survive restarts and are shared between
+replicas. Failed deliveries are retried with exponential backoff.
+
+Every delivery is signed with the secret of the webhook so that receivers can verify
+that it was sent by Cosmos. The signature header contains the unix timestamp of the
+delivery and the hex encoded HMAC-SHA256 of the timestamp and the body joined by a
+period, e.g. "t=1700000000,v1=5257a869...". Receivers should reject deliveries whose
+timestamp is too old to prevent replays.
+*/
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of webhook deliveries.
+const (
+	SignatureHeader = "X-Cosmos-Signature"
+	EventHeader     = "X-Cosmos-Event"
+	DeliveryHeader  = "X-Cosmos-Delivery"
+	WebhookHeader   = "X-Cosmos-Webhook"
+)
+
+const (
+	secretPrefix = "whsec_"
+	secretLen    = 32
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrExpiredSignature = errors.New("webhook signature timestamp is outside of the tolerance")
+	ErrUnsuccessful     = errors.New("webhook receiver did not respond with a 2xx status")
+)
+
+// NewSecret generates a random secret to sign the deliveries of a new webhook.
+func NewSecret() (string, error) {
+	secret := make([]byte, secretLen)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("could not generate webhook secret: %w", err)
+	}
+	return secretPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// Sign the body of a delivery sent at the specified time, returning the value of the
+// signature header.
+func Sign(secret string, ts time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + signature(secret, timestamp, body)
+}
+
+// Verify the signature header of a delivery. If the tolerance is greater than zero the
+// timestamp of the signature must be within the tolerance of the current time.
+func Verify(secret, header string, body []byte, tolerance time.Duration) error {
+	var timestamp, sig string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			sig = value
+		}
+	}
+
+	if timestamp == "" || sig == "" {
+		return ErrInvalidSignature
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, timestamp, body))) {
+		return ErrInvalidSignature
+	}
+
+	if tolerance > 0 {
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return ErrInvalidSignature
+		}
+
+		if age := time.Since(time.Unix(ts, 0)); age > tolerance || age < -tolerance {
+			return ErrExpiredSignature
+		}
+	}
+	return nil
+}
+
+func signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
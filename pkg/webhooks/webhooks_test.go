@@ -0,0 +1,219 @@
+package webhooks_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bbengfort/cosmos/pkg/api/v1"
+	"github.com/bbengfort/cosmos/pkg/config"
+	"github.com/bbengfort/cosmos/pkg/db/models"
+	"github.com/bbengfort/cosmos/pkg/webhooks"
+	"github.com/stretchr/testify/require"
+)
+
+var testConfig = config.WebhooksConfig{
+	Workers:      2,
+	BatchSize:    10,
+	PollInterval: time.Minute,
+	Timeout:      time.Second,
+	MaxAttempts:  5,
+	Backoff:      30 * time.Second,
+	MaxBackoff:   5 * time.Minute,
+	AllowPrivate: true,
+}
+
+func TestSignature(t *testing.T) {
+	secret, err := webhooks.NewSecret()
+	require.NoError(t, err)
+	require.Regexp(t, `^whsec_[A-Za-z0-9_-]{43}$`, secret)
+
+	body := []byte(`{"id":1}`)
+	header := webhooks.Sign(secret, time.Now(), body)
+	require.NoError(t, webhooks.Verify(secret, header, body, time.Minute))
+
+	require.ErrorIs(t, webhooks.Verify("whsec_other", header, body, time.Minute), webhooks.ErrInvalidSignature)
+	require.ErrorIs(t, webhooks.Verify(secret, header, []byte(`{"id":2}`), time.Minute), webhooks.ErrInvalidSignature)
+	require.ErrorIs(t, webhooks.Verify(secret, "v1=abc", body, time.Minute), webhooks.ErrInvalidSignature)
+
+	old := webhooks.Sign(secret, time.Now().Add(-10*time.Minute), body)
+	require.ErrorIs(t, webhooks.Verify(secret, old, body, time.Minute), webhooks.ErrExpiredSignature)
+	require.NoError(t, webhooks.Verify(secret, old, body, 0), "the timestamp is not checked without a tolerance")
+}
+
+func TestBackoff(t *testing.T) {
+	dispatcher := webhooks.NewDispatcher(testConfig)
+	require.Equal(t, 30*time.Second, dispatcher.Backoff(1))
+	require.Equal(t, time.Minute, dispatcher.Backoff(2))
+	require.Equal(t, 4*time.Minute, dispatcher.Backoff(4))
+	require.Equal(t, 5*time.Minute, dispatcher.Backoff(5))
+	require.Equal(t, 5*time.Minute, dispatcher.Backoff(100))
+}
+
+func TestDeliver(t *testing.T) {
+	secret, err := webhooks.NewSecret()
+	require.NoError(t, err)
+
+	status := http.StatusNoContent
+	received := make(chan *api.GalaxyEvent, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		if err = webhooks.Verify(secret, r.Header.Get(webhooks.SignatureHeader), body, time.Minute); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.Equal(t, api.EventTurnAdvanced, r.Header.Get(webhooks.EventHeader))
+		require.Equal(t, "42", r.Header.Get(webhooks.DeliveryHeader))
+		require.Equal(t, "7", r.Header.Get(webhooks.WebhookHeader))
+
+		event := &api.GalaxyEvent{}
+		require.NoError(t, json.Unmarshal(body, event))
+		received <- event
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	delivery := &models.PendingDelivery{
+		ID:           42,
+		WebhookID:    7,
+		Attempts:     1,
+		URL:          receiver.URL + "/hooks/cosmos",
+		Secret:       secret,
+		EventID:      1337,
+		GalaxyID:     3,
+		EventType:    api.EventTurnAdvanced,
+		Data:         `{"turn": 12}`,
+		EventCreated: time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC),
+	}
+
+	dispatcher := webhooks.NewDispatcher(testConfig)
+	code, err := dispatcher.Deliver(context.Background(), delivery)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, code)
+
+	event := <-received
+	require.Equal(t, int64(1337), event.ID)
+	require.Equal(t, int64(3), event.GalaxyID)
+	require.Equal(t, api.EventTurnAdvanced, event.Type)
+	require.Equal(t, "2023-11-14T22:13:20Z", event.Created)
+
+	data := &api.TurnAdvancedEvent{}
+	require.NoError(t, json.Unmarshal(event.Data, data))
+	require.Equal(t, int64(12), data.Turn)
+
+	// Unsuccessful responses are errors that include the status code
+	status = http.StatusServiceUnavailable
+	code, err = dispatcher.Deliver(context.Background(), delivery)
+	require.ErrorIs(t, err, webhooks.ErrUnsuccessful)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	<-received
+
+	// Deliveries with the wrong secret are rejected by the receiver
+	delivery.Secret = "whsec_other"
+	code, err = dispatcher.Deliver(context.Background(), delivery)
+	require.ErrorIs(t, err, webhooks.ErrUnsuccessful)
+	require.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestDeliverRedirect(t *testing.T) {
+	followed := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed = true
+	}))
+	defer target.Close()
+
+	receiver := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer receiver.Close()
+
+	delivery := &models.PendingDelivery{ID: 1, URL: receiver.URL, Secret: "whsec_secret", Data: "{}"}
+	code, err := webhooks.NewDispatcher(testConfig).Deliver(context.Background(), delivery)
+	require.ErrorIs(t, err, webhooks.ErrUnsuccessful)
+	require.Equal(t, http.StatusTemporaryRedirect, code)
+	require.False(t, followed, "redirects should not be followed")
+}
+
+func TestDeliverUnreachable(t *testing.T) {
+	receiver := httptest.NewServer(http.NotFoundHandler())
+	receiver.Close()
+
+	delivery := &models.PendingDelivery{ID: 1, URL: receiver.URL, Secret: "whsec_secret", Data: "{}"}
+	code, err := webhooks.NewDispatcher(testConfig).Deliver(context.Background(), delivery)
+	require.Error(t, err)
+	require.Zero(t, code, "no status code when the receiver does not respond")
+}
+
+func TestDeliverLoopback(t *testing.T) {
+	received := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+	}))
+	defer receiver.Close()
+
+	conf := testConfig
+	conf.AllowPrivate = false
+
+	delivery := &models.PendingDelivery{ID: 1, URL: receiver.URL, Secret: "whsec_secret", Data: "{}"}
+	code, err := webhooks.NewDispatcher(conf).Deliver(context.Background(), delivery)
+	require.ErrorIs(t, err, webhooks.ErrForbiddenAddress)
+	require.Zero(t, code)
+	require.False(t, received, "loopback receivers should not be dialed")
+
+	// Names that resolve to a loopback address are also rejected when dialed
+	delivery.URL = strings.Replace(receiver.URL, "127.0.0.1", "localhost", 1)
+	_, err = webhooks.NewDispatcher(conf).Deliver(context.Background(), delivery)
+	require.ErrorIs(t, err, webhooks.ErrForbiddenAddress)
+	require.False(t, received, "loopback receivers should not be dialed")
+}
+
+func TestCheckURL(t *testing.T) {
+	testCases := []struct {
+		url string
+		err error
+	}{
+		{"http://127.0.0.1:8080/hook", webhooks.ErrForbiddenAddress},
+		{"http://localhost/hook", webhooks.ErrForbiddenAddress},
+		{"http://[::1]/hook", webhooks.ErrForbiddenAddress},
+		{"http://169.254.169.254/latest/meta-data/", webhooks.ErrForbiddenAddress},
+		{"http://10.0.0.1/hook", webhooks.ErrForbiddenAddress},
+		{"http://172.16.4.2/hook", webhooks.ErrForbiddenAddress},
+		{"https://192.168.1.1/hook", webhooks.ErrForbiddenAddress},
+		{"http://0.0.0.0/hook", webhooks.ErrForbiddenAddress},
+		{"http://[::ffff:127.0.0.1]/hook", webhooks.ErrForbiddenAddress},
+		{"http://[fe80::1]/hook", webhooks.ErrForbiddenAddress},
+		{"http://0.1.2.3/hook", webhooks.ErrForbiddenAddress},
+		{"http://100.64.0.1/hook", webhooks.ErrForbiddenAddress},
+		{"http://100.127.255.254/hook", webhooks.ErrForbiddenAddress},
+		{"http://198.18.0.1/hook", webhooks.ErrForbiddenAddress},
+		{"http://198.19.255.254/hook", webhooks.ErrForbiddenAddress},
+		{"http://[64:ff9b::a9fe:a9fe]/hook", webhooks.ErrForbiddenAddress},
+		{"http://[64:ff9b::10.0.0.1]/hook", webhooks.ErrForbiddenAddress},
+		{"http://[2002:7f00:1::]/hook", webhooks.ErrForbiddenAddress},
+		{"http://[2002:c0a8:101::1]/hook", webhooks.ErrForbiddenAddress},
+		{"ftp://example.com/hook", webhooks.ErrInvalidURL},
+		{"/hook", webhooks.ErrInvalidURL},
+		{"https://93.184.216.34/hook", nil},
+		{"https://100.128.0.1/hook", nil},
+		{"https://[64:ff9b::5db8:d822]/hook", nil},
+		{"https://[2002:5db8:d822::1]/hook", nil},
+		{"https://[2606:2800:220:1:248:1893:25c8:1946]/hook", nil},
+	}
+
+	for _, tc := range testCases {
+		err := webhooks.CheckURL(context.Background(), tc.url)
+		if tc.err == nil {
+			require.NoError(t, err, tc.url)
+		} else {
+			require.ErrorIs(t, err, tc.err, tc.url)
+		}
+	}
+}